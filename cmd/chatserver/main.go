@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,12 +14,15 @@ import (
 	"github.com/jaydenbeard/messaging-app/internal/auth"
 	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/geoip"
 	"github.com/jaydenbeard/messaging-app/internal/handlers"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
 	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/push"
 	"github.com/jaydenbeard/messaging-app/internal/registry"
 	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/jaydenbeard/messaging-app/internal/services"
+	"github.com/jaydenbeard/messaging-app/internal/tracing"
 	"github.com/jaydenbeard/messaging-app/internal/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
@@ -38,6 +42,12 @@ func main() {
 
 	log.Printf("🚀 Starting Chat Server: %s", cfg.ServerID)
 
+	// Initialize OpenTelemetry tracing (OTLP exporter configured via OTEL_* env vars)
+	shutdownTracing, err := tracing.Init(context.Background(), "chatserver", cfg.ServerID)
+	if err != nil {
+		log.Printf("Warning: failed to initialize tracing: %v", err)
+	}
+
 	// Initialize database connection
 	database, err := db.NewPostgresDB(cfg.PostgresURL)
 	if err != nil {
@@ -49,6 +59,17 @@ func main() {
 		}
 	}()
 
+	database.SetSlowQueryThreshold(cfg.DBSlowQueryThreshold)
+	database.SetQueryTimeout(cfg.DBQueryTimeout)
+	database.SetMaxGroupMembers(cfg.GroupMaxMembers)
+
+	// Serve read-heavy queries from the replica if one is configured
+	if cfg.PostgresReplicaURL != "" {
+		if err := database.ConnectReplica(cfg.PostgresReplicaURL); err != nil {
+			log.Printf("Warning: failed to connect to read replica, using primary for all queries: %v", err)
+		}
+	}
+
 	// Initialize Redis connection
 	redisClient, err := pubsub.NewRedisClient(cfg.RedisURL)
 	if err != nil {
@@ -66,7 +87,11 @@ func main() {
 		log.Fatalf("Failed to connect to Consul: %v", err)
 	}
 
-	// Register this server with service discovery
+	// Register this server with service discovery. Heartbeats report it
+	// healthy only while Postgres and Redis answer.
+	serviceRegistry.SetReadinessCheck(func() error {
+		return handlers.CheckReadiness(context.Background(), database, redisClient)
+	})
 	if err := serviceRegistry.Register(); err != nil {
 		log.Fatalf("Failed to register service: %v", err)
 	}
@@ -88,14 +113,35 @@ func main() {
 	// Start automatic key rotation
 	keyRotationScheduler.Start()
 
+	// Only trust forwarding headers from our own proxies
+	if len(cfg.TrustedProxies) > 0 {
+		if err := security.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXY_CIDRS: %v", err)
+		}
+	}
+
 	// Initialize audit logger for security event tracking
 	auditLogger := security.NewAuditLogger(database.GetDB())
+	if cfg.GeoIPDBPath != "" {
+		geoReader, err := geoip.Open(cfg.GeoIPDBPath)
+		if err != nil {
+			log.Printf("Warning: Failed to load GeoIP database: %v", err)
+		} else {
+			auditLogger.SetGeoLocator(geoReader)
+			log.Printf("🌍 GeoIP database loaded from %s", cfg.GeoIPDBPath)
+		}
+	}
 
 	// Initialize auth service with secure JWT secret management
 	authService, err := auth.NewAuthService(database, config.GetCurrentSecret())
 	if err != nil {
 		log.Fatalf("Failed to initialize auth service: %v", err)
 	}
+	authService.SetVerifyLockoutPolicy(auth.VerifyLockoutPolicy{
+		MaxFailures: cfg.VerifyMaxFailures,
+		Window:      cfg.VerifyFailureWindow,
+		Lockout:     cfg.VerifyLockoutDuration,
+	})
 
 	// Initialize APNs push notification service
 	var pushService *push.PushService
@@ -126,18 +172,53 @@ func main() {
 	// Initialize WebSocket hub with HMAC secret for message authentication
 	hmacSecret := os.Getenv("HMAC_SECRET")
 	hub := websocket.NewHub(cfg.ServerID, redisClient, database, hmacSecret, auditLogger)
+	hub.SetInboxLimits(cfg.InboxTTL, cfg.InboxMaxSize)
+	hub.SetMessageLimits(cfg.MaxCiphertextBytes, cfg.MaxMediaCiphertextBytes)
+	hub.SetConnectionLimits(cfg.WSMaxConnectionsPerUser, cfg.WSMaxTotalConnections)
+	log.Printf("WebSocket connection limits: %d per user, %d total", cfg.WSMaxConnectionsPerUser, cfg.WSMaxTotalConnections)
+	hub.SetReadLimit(cfg.WSMaxMessageBytes)
+	hub.SetMaxInFlight(cfg.WSMaxInFlight)
+	hub.SetIdleTimeout(cfg.WSIdleTimeout)
+	hub.SetAckTimeout(cfg.WSAckTimeout)
+	hub.SetMaxClockSkew(cfg.WSMaxClockSkew)
+	hub.SetKeepalive(cfg.WSPingInterval, cfg.WSPongTimeout)
+	hub.SetClusterMode(cfg.ClusterMode)
+	hub.SetGroupFanoutThreshold(cfg.GroupFanoutThreshold)
+	hub.SetRebalance(cfg.WSRebalanceHighWaterPercent, cfg.WSRebalanceHintPercent, cfg.WSRebalanceMinConnections)
+	hub.SetPresenceOfflineGrace(cfg.PresenceOfflineGrace)
+	hub.SetRequireOneTimePrekey(cfg.RequireOneTimePrekey)
+	if cfg.InternalServiceToken != "" {
+		// Presence and group lookups go through the services found in Consul,
+		// falling back to Redis/Postgres when they can't be reached
+		hub.SetInternalServices(
+			services.NewPresenceClient(serviceRegistry, cfg.InternalServiceToken),
+			services.NewGroupClient(serviceRegistry, cfg.InternalServiceToken),
+		)
+		log.Printf("Presence and group lookups routed through %s and %s", registry.PresenceService, registry.GroupService)
+	}
+	if !cfg.ClusterMode {
+		log.Printf("Cluster mode off: cross-server fan-out disabled, run a single chat server")
+	}
 	go hub.Run()
+	if cfg.GroupFanoutThreshold > 0 {
+		go hub.StartGroupFanoutWorker()
+	}
 
-	// Subscribe to cross-server messages and presence updates
+	// Subscribe to cross-server messages, presence updates, kicks and broadcasts
 	go redisClient.SubscribeToMessages(hub)
 	go redisClient.SubscribeToServerMessages(cfg.ServerID, hub)
 	go redisClient.SubscribeToPresenceUpdates(hub)
+	go redisClient.SubscribeToKicks(hub)
+	go redisClient.SubscribeToSystemBroadcasts(hub)
 
 	// Setup HTTP router
 	router := mux.NewRouter()
+	router.Use(middleware.TracingMiddleware)
+	router.Use(auditLogger.GeoContextMiddleware)
 
 	// Health check endpoint (for load balancer)
 	router.HandleFunc("/health", handlers.HealthCheck).Methods("GET")
+	router.HandleFunc("/health/ready", handlers.ReadinessCheck(database, redisClient)).Methods("GET")
 
 	// Prometheus metrics endpoint
 	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
@@ -193,62 +274,86 @@ func main() {
 	// Search endpoints - prevent scraping
 	enhancedRateLimiter.SetEndpointStrictMode("GET /api/v1/users/search", true)
 
-	// Auth routes (no auth required, but rate limited)
-	api.Handle("/auth/request-code", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.RequestVerificationCode(authService, auditLogger)))).Methods("POST")
-	api.Handle("/auth/verify", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.VerifyCode(authService, database)))).Methods("POST")
-	api.Handle("/auth/register", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.Register(authService, database)))).Methods("POST")
-	api.Handle("/auth/login", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.Login(authService, database)))).Methods("POST")
-	api.HandleFunc("/auth/refresh", handlers.RefreshToken(authService)).Methods("POST")
+	// Auth routes (no auth required, but rate limited). Apps below their
+	// platform's minimum version get 426 instead of a session.
+	versionGate := middleware.RequireSupportedVersion(redisClient.CheckClientVersion)
+	api.Handle("/auth/request-code", versionGate(enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.RequestVerificationCode(authService, auditLogger))))).Methods("POST")
+	api.Handle("/auth/verify", versionGate(enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.VerifyCode(authService, database, auditLogger))))).Methods("POST")
+	api.Handle("/auth/register", versionGate(enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.Register(authService, database, auditLogger))))).Methods("POST")
+	api.Handle("/auth/login", versionGate(enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.Login(authService, database, redisClient, auditLogger, cfg.GeoIPStepUpTOTP))))).Methods("POST")
+	api.Handle("/auth/refresh", versionGate(handlers.RefreshToken(authService))).Methods("POST")
+
+	// Media download links carry their own token (see GetMediaURL)
+	api.Handle("/media/download/{token}", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.DownloadWithToken(redisClient, cfg)))).Methods("GET")
 
 	// Protected routes
 	protected := api.PathPrefix("").Subrouter()
 	protected.Use(middleware.AuthMiddleware(authService, nil))
 
+	// Destructive actions need a recent PIN re-entry (POST /pin/verify)
+	pinStepUp := middleware.RequirePINStepUp(authService)
+
+	// WebSocket ticket (keeps the JWT out of /ws URLs)
+	protected.HandleFunc("/ws-ticket", handlers.IssueWebSocketTicket(redisClient)).Methods("POST")
+
 	// User routes
 	protected.HandleFunc("/users/me", handlers.GetCurrentUser(database)).Methods("GET")
 	protected.HandleFunc("/users/me", handlers.UpdateUser(database)).Methods("PUT", "PATCH")
-	protected.HandleFunc("/users/me", handlers.DeleteUser(database)).Methods("DELETE")
+	protected.Handle("/users/me", pinStepUp(handlers.DeleteUser(database, cfg))).Methods("DELETE")
+	protected.Handle("/users/me/export", pinStepUp(handlers.ExportUserData(database, auditLogger))).Methods("GET")
 	protected.HandleFunc("/users/me/prekeys", handlers.UploadPrekeys(database)).Methods("POST")
-	protected.HandleFunc("/users/{userId}/keys", handlers.GetUserKeys(database)).Methods("GET")
-	protected.HandleFunc("/users/keys", handlers.UpdateKeys(database, hub)).Methods("POST")
+	protected.HandleFunc("/users/me/avatar", handlers.UploadAvatar(database, cfg, nil)).Methods("POST")
+	protected.HandleFunc("/avatars/{userId}/{avatarId}", handlers.GetAvatar(cfg)).Methods("GET")
+	protected.HandleFunc("/users/{userId}/keys", handlers.GetUserKeys(database, redisClient, auditLogger, cfg.RequireOneTimePrekey)).Methods("GET")
+	protected.HandleFunc("/users/keys", handlers.UpdateKeys(database, hub, redisClient, auditLogger)).Methods("POST")
+	protected.HandleFunc("/users/me/rotate-keys", handlers.RotateKeys(database, hub, authService, redisClient, auditLogger)).Methods("POST")
 	protected.HandleFunc("/users/{userId}/profile", handlers.GetUserProfile(database, redisClient)).Methods("GET")
 	protected.HandleFunc("/users/check-username/{username}", handlers.CheckUsername(database)).Methods("GET")
 	protected.Handle("/users/search", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.SearchUsers(database)))).Methods("GET")
 
 	// Device routes
 	protected.HandleFunc("/devices", handlers.GetDevices(database)).Methods("GET")
-	protected.HandleFunc("/devices/{deviceId}", handlers.RemoveDevice(database)).Methods("DELETE")
+	protected.HandleFunc("/devices/presence", handlers.GetDevicePresence(redisClient)).Methods("GET")
+	protected.Handle("/devices/remove-others", pinStepUp(handlers.RemoveOtherDevices(database, authService, redisClient, auditLogger))).Methods("POST")
+	protected.Handle("/devices/{deviceId}", pinStepUp(handlers.RemoveDevice(database))).Methods("DELETE")
 	protected.HandleFunc("/devices/{deviceId}/primary", handlers.SetPrimaryDevice(database)).Methods("PUT")
 
 	// PIN routes (server-side sync)
 	protected.HandleFunc("/pin", handlers.GetPIN(database)).Methods("GET")
-	protected.HandleFunc("/pin", handlers.SetPIN(database)).Methods("POST")
-	protected.HandleFunc("/pin", handlers.DeletePIN(database)).Methods("DELETE")
+	protected.Handle("/pin", pinStepUp(handlers.SetPIN(database))).Methods("POST")
+	protected.Handle("/pin", pinStepUp(handlers.DeletePIN(database))).Methods("DELETE")
+	protected.HandleFunc("/pin/verify", handlers.VerifyPIN(database, authService, auditLogger)).Methods("POST")
+	protected.Handle("/step-up/verify", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.VerifyStepUp(database, authService, auditLogger)))).Methods("POST")
 
 	// Privacy settings routes
 	protected.HandleFunc("/privacy", handlers.GetPrivacySettings(database)).Methods("GET")
 	protected.HandleFunc("/privacy", handlers.UpdatePrivacySetting(database, hub)).Methods("POST")
+	protected.HandleFunc("/privacy", handlers.UpdatePrivacySettings(database, hub)).Methods("PUT")
 
 	// Block user routes
 	protected.HandleFunc("/users/blocked", handlers.GetBlockedUsers(database)).Methods("GET")
 	protected.HandleFunc("/users/block", handlers.BlockUser(database, hub)).Methods("POST")
-	protected.HandleFunc("/users/unblock", handlers.UnblockUser(database)).Methods("POST")
+	protected.HandleFunc("/users/unblock", handlers.UnblockUser(database, hub)).Methods("POST")
 	protected.HandleFunc("/users/{userId}/blocked", handlers.IsBlocked(database)).Methods("GET")
+	protected.HandleFunc("/users/{userId}/relationship", handlers.GetRelationship(database)).Methods("GET")
 
 	// Friend routes
 	protected.HandleFunc("/friends", handlers.GetFriends(database)).Methods("GET")
 	protected.HandleFunc("/friends/requests", handlers.GetFriendRequests(database)).Methods("GET")
-	protected.HandleFunc("/friends/request", handlers.SendFriendRequest(database)).Methods("POST")
-	protected.HandleFunc("/friends/accept", handlers.AcceptFriendRequest(database)).Methods("POST")
+	protected.HandleFunc("/friends/request", handlers.SendFriendRequest(database, hub, redisClient, auditLogger)).Methods("POST")
+	protected.HandleFunc("/friends/accept", handlers.AcceptFriendRequest(database, hub)).Methods("POST")
 	protected.HandleFunc("/friends/decline", handlers.DeclineFriendRequest(database)).Methods("POST")
 	protected.HandleFunc("/friends/cancel", handlers.CancelFriendRequest(database)).Methods("POST")
-	protected.HandleFunc("/friends/{userId}", handlers.RemoveFriend(database)).Methods("DELETE")
+	protected.HandleFunc("/friends/{userId}", handlers.RemoveFriend(database, hub)).Methods("DELETE")
 	protected.HandleFunc("/friends/{userId}/status", handlers.GetFriendshipStatus(database)).Methods("GET")
 
 	// Device approval routes (secure device linking)
 	// NOTE: request/verify/status endpoints are intentionally public because new devices
 	// don't have auth tokens yet. Rate limiting applied to prevent enumeration.
-	router.Handle("/api/v1/device-approval/request", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.RequestDeviceApproval(database, hub)))).Methods("POST")
+	router.Handle("/api/v1/device-approval/request", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.RequestDeviceApproval(database, hub, handlers.DeviceApprovalPolicy{
+		SkipRecognized: cfg.DeviceApprovalSkipRecognized,
+		SkipTypes:      cfg.DeviceApprovalSkipTypes,
+	})))).Methods("POST")
 	router.Handle("/api/v1/device-approval/verify", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.VerifyApprovalCode(database)))).Methods("POST")
 	router.Handle("/api/v1/device-approval/{requestId}/status", enhancedRateLimiter.Middleware(http.HandlerFunc(handlers.CheckApprovalStatus(database)))).Methods("GET")
 	protected.HandleFunc("/device-approval/pending", handlers.GetPendingApprovals(database)).Methods("GET")
@@ -257,26 +362,40 @@ func main() {
 
 	// Message routes
 	protected.HandleFunc("/messages", handlers.GetMessages(database)).Methods("GET")
-	protected.HandleFunc("/messages/{messageId}/status", handlers.UpdateMessageStatus(database, hub)).Methods("PUT")
+	protected.HandleFunc("/messages/unread", handlers.GetUnreadCounts(database)).Methods("GET")
+	protected.HandleFunc("/messages/search", handlers.SearchMessages(database)).Methods("GET")
+	protected.HandleFunc("/messages/status", handlers.UpdateMessageStatuses(database, hub, auditLogger)).Methods("PUT")
+	protected.HandleFunc("/messages/{messageId}/status", handlers.UpdateMessageStatus(database, hub, auditLogger)).Methods("PUT")
+	protected.HandleFunc("/conversations", handlers.GetConversations(database)).Methods("GET")
+
+	// Abuse reports (evidence is submitted by the reporter; messages stay encrypted)
+	protected.HandleFunc("/reports", handlers.CreateReport(database, redisClient, auditLogger, handlers.ReportPolicy{
+		PerHour:          cfg.ReportsPerHour,
+		MaxEvidenceBytes: cfg.ReportEvidenceMaxBytes,
+	})).Methods("POST")
 
 	// Group routes
 	protected.HandleFunc("/groups", handlers.CreateGroup(database)).Methods("POST")
 	protected.HandleFunc("/groups/{groupId}", handlers.GetGroup(database)).Methods("GET")
 	protected.HandleFunc("/groups/{groupId}/members", handlers.AddGroupMember(database)).Methods("POST")
 	protected.HandleFunc("/groups/{groupId}/members/{userId}", handlers.RemoveGroupMember(database)).Methods("DELETE")
+	protected.HandleFunc("/groups/{groupId}/rekey", handlers.RekeyGroup(database, hub)).Methods("POST")
+	protected.HandleFunc("/groups/{groupId}/key", handlers.GetGroupKey(database)).Methods("GET")
 
 	// NOTE: Conversation sync happens device-to-device for security.
 	// Server never stores conversation metadata (who talks to whom).
 	// See WebSocket sync_request/sync_data messages for multi-device sync.
 
 	// Media routes
-	protected.HandleFunc("/media/upload-url", handlers.GetUploadURL(cfg)).Methods("POST")
-	protected.HandleFunc("/media/upload", handlers.GetUploadURL(cfg)).Methods("POST") // Alias
-	protected.HandleFunc("/media/download-url/{mediaId}", handlers.GetMediaURL(database, cfg)).Methods("GET")
-	protected.HandleFunc("/media/{mediaId}", handlers.GetMediaURL(database, cfg)).Methods("GET")
+	protected.HandleFunc("/media/upload-url", handlers.GetUploadURL(redisClient, cfg)).Methods("POST")
+	protected.HandleFunc("/media/upload", handlers.GetUploadURL(redisClient, cfg)).Methods("POST") // Alias
+	protected.HandleFunc("/media/uploads", handlers.StartMediaUpload(database, cfg)).Methods("POST")
+	protected.HandleFunc("/media/uploads/{uploadId}", handlers.GetMediaUpload(database, cfg)).Methods("GET")
+	protected.HandleFunc("/media/uploads/{uploadId}/complete", handlers.CompleteMediaUpload(database, redisClient, cfg)).Methods("POST")
+	protected.HandleFunc("/media/download-url/{mediaId}", handlers.GetMediaURL(redisClient, cfg)).Methods("GET")
+	protected.HandleFunc("/media/{mediaId}", handlers.GetMediaURL(redisClient, cfg)).Methods("GET")
 	// Proxy endpoints (to avoid mixed content errors)
 	protected.HandleFunc("/media/upload-proxy/{mediaId}", handlers.UploadProxy(cfg)).Methods("PUT", "POST")
-	protected.HandleFunc("/media/download-proxy/{mediaId}", handlers.DownloadProxy(cfg)).Methods("GET")
 
 	// WebRTC routes
 	protected.HandleFunc("/rtc/turn-credentials", handlers.GetTurnCredentials()).Methods("GET")
@@ -290,19 +409,41 @@ func main() {
 		protected.HandleFunc("/devices/test-push", pushHandler.TestPush).Methods("POST")
 	}
 
-	// WebSocket endpoint (requires auth via query param or header)
-	router.HandleFunc("/ws", handlers.WebSocketHandler(hub, authService)).Methods("GET")
+	// Admin routes (restricted to ADMIN_USER_IDS)
+	admin := protected.PathPrefix("/admin").Subrouter()
+	admin.Use(middleware.RequireAdmin(cfg.AdminUserIDs))
+	admin.HandleFunc("/audit", handlers.QueryAuditLog(auditLogger)).Methods("GET")
+	admin.HandleFunc("/audit/reprocess", handlers.ReprocessAuditDeadLetters(auditLogger)).Methods("POST")
+	admin.HandleFunc("/audit/verify", handlers.VerifyAuditChain(auditLogger)).Methods("GET")
+	admin.HandleFunc("/audit/health", handlers.GetAuditHealth(auditLogger)).Methods("GET")
+	admin.HandleFunc("/analytics/daily", handlers.GetDailyAnalytics(database)).Methods("GET")
+	admin.HandleFunc("/clients/versions", handlers.GetClientVersions(database)).Methods("GET")
+	admin.HandleFunc("/clients/version-policy", handlers.GetVersionPolicy(redisClient)).Methods("GET")
+	admin.HandleFunc("/clients/version-policy", handlers.SetVersionPolicy(redisClient, auditLogger)).Methods("PUT")
+	admin.HandleFunc("/users/{userId}/connections", handlers.GetUserConnections(redisClient, auditLogger)).Methods("GET")
+	admin.HandleFunc("/users/{userId}/ban", handlers.BanUser(authService, redisClient, auditLogger)).Methods("POST")
+	admin.HandleFunc("/users/{userId}/unban", handlers.UnbanUser(authService, auditLogger)).Methods("POST")
+	admin.HandleFunc("/reports", handlers.ListReports(database, auditLogger)).Methods("GET")
+	admin.HandleFunc("/reports/{reportId}", handlers.GetReport(database, auditLogger)).Methods("GET")
+	admin.HandleFunc("/reports/{reportId}/resolve", handlers.ResolveReport(database, hub, authService, redisClient, auditLogger)).Methods("POST")
+	admin.HandleFunc("/broadcast", handlers.SendSystemBroadcast(hub, auditLogger)).Methods("POST")
+	admin.HandleFunc("/maintenance", handlers.GetMaintenanceMode(redisClient)).Methods("GET")
+	admin.HandleFunc("/maintenance", handlers.SetMaintenanceMode(redisClient, auditLogger)).Methods("PUT")
 
-	// CORS configuration - restrict to known origins in production
+	// WebSocket endpoint (requires auth via query param or header)
+	router.HandleFunc("/ws", handlers.WebSocketHandler(hub, authService, redisClient, websocket.CompressionConfig{
+		Enabled: cfg.WSCompression,
+		Level:   cfg.WSCompressionLevel,
+		MinSize: cfg.WSCompressionMinBytes,
+	})).Methods("GET")
+
+	// CORS configuration - restrict to known origins (CORS_ORIGINS) in production
+	log.Printf("CORS allowed origins: %s", strings.Join(cfg.CORSOrigins, ", "))
 	corsHandler := cors.New(cors.Options{
-		AllowedOrigins: []string{
-			"http://localhost:3000",
-			"http://localhost:5173",
-			"https://silentrelay.com.au",
-			"https://www.silentrelay.com.au",
-		},
+		AllowedOrigins:   cfg.CORSOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Device-ID"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Device-ID", "Range", "If-Range", middleware.PINStepUpHeader, middleware.PlatformHeader, middleware.AppVersionHeader},
+		ExposedHeaders:   []string{"X-Next-Cursor", "Content-Range", "Accept-Ranges", middleware.UpgradeRecommendedHeader},
 		AllowCredentials: true,
 	})
 
@@ -370,6 +511,11 @@ func main() {
 	log.Println("⏹️ Stopping key rotation scheduler...")
 	keyRotationScheduler.Stop()
 
+	// Flush any buffered trace spans
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Warning: failed to flush traces: %v", err)
+	}
+
 	// Wait for HTTP server to finish
 	<-serverShutdownDone
 
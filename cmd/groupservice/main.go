@@ -16,13 +16,15 @@ import (
 	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/registry"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 // GroupService handles group membership and message fan-out
 type GroupService struct {
-	redis *redis.Client
+	redis redis.UniversalClient
 	db    *db.PostgresDB
 }
 
@@ -69,11 +71,8 @@ func main() {
 		log.Fatalf("FATAL: JWT secret validation failed: %v", err)
 	}
 
-	// Connect to Redis with optional password
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: os.Getenv("REDIS_PASSWORD"), // Empty string if not set
-	})
+	// Connect to Redis with optional password (standalone, Sentinel or Cluster)
+	rdb := redis.NewUniversalClient(pubsub.UniversalOptions(redisURL))
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -89,6 +88,13 @@ func main() {
 		}
 	}()
 
+	// Serve read-heavy queries from the replica if one is configured
+	if cfg.PostgresReplicaURL != "" {
+		if err := database.ConnectReplica(cfg.PostgresReplicaURL); err != nil {
+			log.Printf("Warning: failed to connect to read replica, using primary for all queries: %v", err)
+		}
+	}
+
 	// Initialize auth service with secure JWT secret management
 	authService, err := auth.NewAuthService(database, config.GetCurrentSecret())
 	if err != nil {
@@ -104,8 +110,10 @@ func main() {
 	router := mux.NewRouter()
 
 	// Apply authentication middleware to all routes, but skip /health and /metrics
+	// Chat servers call in with the internal service token instead of a JWT
 	skipAuth := func(r *http.Request) bool {
-		return r.URL.Path == "/health" || r.URL.Path == "/metrics"
+		return r.URL.Path == "/health" || r.URL.Path == "/metrics" ||
+			middleware.IsInternalRequest(r, cfg.InternalServiceToken)
 	}
 	router.Use(middleware.AuthMiddleware(authService, skipAuth))
 
@@ -140,6 +148,32 @@ func main() {
 		}
 	}()
 
+	// Make this instance discoverable by chat servers
+	if cfg.InternalServiceToken != "" {
+		hostname, _ := os.Hostname()
+		serviceRegistry, err := registry.NewServiceRegistry(cfg.ConsulURL, registry.GroupService, registry.GroupService+"-"+hostname+"-"+port, port)
+		if err == nil {
+			serviceRegistry.SetReadinessCheck(func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				if err := rdb.Ping(ctx).Err(); err != nil {
+					return err
+				}
+				return database.Ping(ctx)
+			})
+			err = serviceRegistry.Register()
+		}
+		if err != nil {
+			log.Printf("Warning: failed to register with Consul, chat servers will use direct access: %v", err)
+		} else {
+			defer func() {
+				if err := serviceRegistry.Deregister(); err != nil {
+					log.Printf("Warning: failed to deregister service: %v", err)
+				}
+			}()
+		}
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -163,7 +197,7 @@ func (s *GroupService) GetGroupMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	members, err := s.db.GetGroupMembers(groupID)
+	members, err := s.db.GetGroupMembers(r.Context(), groupID)
 	if err != nil {
 		http.Error(w, "Failed to get group members", http.StatusInternalServerError)
 		return
@@ -197,7 +231,7 @@ func (s *GroupService) GetFanOutInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get all group members
-	members, err := s.db.GetGroupMembers(groupID)
+	members, err := s.db.GetGroupMembers(r.Context(), groupID)
 	if err != nil {
 		http.Error(w, "Failed to get group members", http.StatusInternalServerError)
 		return
@@ -213,65 +247,64 @@ func (s *GroupService) GetFanOutInfo(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
-	// Check each member's status
-	for _, member := range members {
-		// Check presence
-		presenceKey := "presence:" + member.UserID.String()
-		val, err := s.redis.Get(ctx, presenceKey).Result()
+	userIDs := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
 
-		if err != nil || val != "online" {
-			// User is offline
+	// Round-trip 1: presence for every member
+	online, err := s.batchPresence(ctx, userIDs)
+	if err != nil {
+		http.Error(w, "Failed to get member presence", http.StatusInternalServerError)
+		return
+	}
+
+	onlineIDs := make([]uuid.UUID, 0, len(userIDs))
+	for i, userID := range userIDs {
+		if online[i] {
+			onlineIDs = append(onlineIDs, userID)
+		} else {
 			result.OfflineMembers = append(result.OfflineMembers, MemberStatus{
-				UserID:   member.UserID,
+				UserID:   userID,
 				IsOnline: false,
 			})
-			continue
 		}
+	}
 
-		// User is online - find their server(s)
-		connectionKey := "connections:" + member.UserID.String()
-		servers, err := s.redis.HGetAll(ctx, connectionKey).Result()
+	// Round-trip 2: connection hashes for the online members only
+	connections, err := s.batchConnections(ctx, onlineIDs)
+	if err != nil {
+		http.Error(w, "Failed to get member connections", http.StatusInternalServerError)
+		return
+	}
 
-		if err != nil || len(servers) == 0 {
+	for i, userID := range onlineIDs {
+		servers := connections[i]
+		if len(servers) == 0 {
 			// No active connections found
 			result.OfflineMembers = append(result.OfflineMembers, MemberStatus{
-				UserID:   member.UserID,
+				UserID:   userID,
 				IsOnline: false,
 			})
 			continue
 		}
 
-		// Get unique servers for this user
+		// Group users by server for parallel delivery (once per server)
 		serverSet := make(map[string]bool)
+		var firstServer string
 		for _, serverID := range servers {
-			serverSet[serverID] = true
-
-			// Group users by server for parallel delivery
-			if result.ServerGroups[serverID] == nil {
-				result.ServerGroups[serverID] = make([]uuid.UUID, 0)
+			if serverSet[serverID] {
+				continue
 			}
-			// Only add once per server
-			found := false
-			for _, uid := range result.ServerGroups[serverID] {
-				if uid == member.UserID {
-					found = true
-					break
-				}
-			}
-			if !found {
-				result.ServerGroups[serverID] = append(result.ServerGroups[serverID], member.UserID)
+			serverSet[serverID] = true
+			if firstServer == "" {
+				firstServer = serverID
 			}
-		}
-
-		// Pick first server for status
-		var firstServer string
-		for s := range serverSet {
-			firstServer = s
-			break
+			result.ServerGroups[serverID] = append(result.ServerGroups[serverID], userID)
 		}
 
 		result.OnlineMembers = append(result.OnlineMembers, MemberStatus{
-			UserID:   member.UserID,
+			UserID:   userID,
 			IsOnline: true,
 			ServerID: firstServer,
 		})
@@ -294,7 +327,7 @@ func (s *GroupService) GetOnlineMembers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	members, err := s.db.GetGroupMembers(groupID)
+	members, err := s.db.GetGroupMembers(r.Context(), groupID)
 	if err != nil {
 		http.Error(w, "Failed to get group members", http.StatusInternalServerError)
 		return
@@ -303,11 +336,19 @@ func (s *GroupService) GetOnlineMembers(w http.ResponseWriter, r *http.Request)
 	ctx := context.Background()
 	onlineUsers := make([]uuid.UUID, 0)
 
-	for _, member := range members {
-		presenceKey := "presence:" + member.UserID.String()
-		val, _ := s.redis.Get(ctx, presenceKey).Result()
-		if val == "online" {
-			onlineUsers = append(onlineUsers, member.UserID)
+	userIDs := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
+
+	online, err := s.batchPresence(ctx, userIDs)
+	if err != nil {
+		http.Error(w, "Failed to get member presence", http.StatusInternalServerError)
+		return
+	}
+	for i, userID := range userIDs {
+		if online[i] {
+			onlineUsers = append(onlineUsers, userID)
 		}
 	}
 
@@ -321,3 +362,49 @@ func (s *GroupService) GetOnlineMembers(w http.ResponseWriter, r *http.Request)
 		log.Printf("Warning: failed to encode response: %v", err)
 	}
 }
+
+// batchPresence checks presence for all users with a single MGET.
+// The result is index-aligned with userIDs.
+func (s *GroupService) batchPresence(ctx context.Context, userIDs []uuid.UUID) ([]bool, error) {
+	online := make([]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = "presence:" + userID.String()
+	}
+
+	values, err := pubsub.MGet(ctx, s.redis, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		online[i] = v == "online"
+	}
+	return online, nil
+}
+
+// batchConnections fetches the device -> server hash for all users in one
+// pipeline, without the per-device activity fields. The result is index-aligned with userIDs; missing entries are empty.
+func (s *GroupService) batchConnections(ctx context.Context, userIDs []uuid.UUID) ([]map[string]string, error) {
+	connections := make([]map[string]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return connections, nil
+	}
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.HGetAll(ctx, "connections:"+userID.String())
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		connections[i] = pubsub.DeviceConnections(cmd.Val())
+	}
+	return connections, nil
+}
@@ -7,17 +7,19 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 // NotificationService handles push notifications
 type NotificationService struct {
-	redis *redis.Client
+	redis redis.UniversalClient
 }
 
 type PushNotification struct {
@@ -25,7 +27,8 @@ type PushNotification struct {
 	Title     string          `json:"title"`
 	Body      string          `json:"body"`
 	Data      json.RawMessage `json:"data,omitempty"`
-	Priority  string          `json:"priority"` // high, normal
+	Priority  string          `json:"priority"`        // high, normal
+	Badge     *int            `json:"badge,omitempty"` // Total unread count for the app icon badge
 	Timestamp time.Time       `json:"timestamp"`
 }
 
@@ -40,11 +43,8 @@ func main() {
 		redisURL = "localhost:6379"
 	}
 
-	// Connect to Redis with optional password
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: os.Getenv("REDIS_PASSWORD"), // Empty string if not set
-	})
+	// Connect to Redis with optional password (standalone, Sentinel or Cluster)
+	rdb := redis.NewUniversalClient(pubsub.UniversalOptions(redisURL))
 
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
@@ -114,14 +114,26 @@ func (s *NotificationService) subscribeToNotifications() {
 			log.Printf("Failed to parse notification: %v", err)
 			continue
 		}
+		// Events published by the chat server carry the user only in the channel name
+		if notification.UserID == "" {
+			notification.UserID = strings.TrimPrefix(msg.Channel, "notifications:")
+		}
 
 		s.deliverNotification(&notification)
 	}
 }
 
 func (s *NotificationService) deliverNotification(notification *PushNotification) {
-	// Get user's push tokens
 	ctx := context.Background()
+
+	// Do not disturb keeps the socket live but mutes pushes; the badge
+	// catches up with the next push after DND ends
+	if status, _ := s.redis.Get(ctx, "presence_status:"+notification.UserID).Result(); status == "dnd" {
+		log.Printf("Skipping push for user %s: do not disturb", notification.UserID)
+		return
+	}
+
+	// Get user's push tokens
 	key := "push_tokens:" + notification.UserID
 
 	tokens, err := s.redis.SMembers(ctx, key).Result()
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -15,19 +16,24 @@ import (
 	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/registry"
+	"github.com/jaydenbeard/messaging-app/internal/services"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
 // PresenceService tracks user online/offline status
 type PresenceService struct {
-	redis       *redis.Client
+	redis       redis.UniversalClient
 	authService *auth.AuthService
 }
 
 type PresenceResponse struct {
 	UserID   string    `json:"user_id"`
 	IsOnline bool      `json:"is_online"`
+	Status   string    `json:"status"` // online, away, dnd or offline
 	LastSeen time.Time `json:"last_seen,omitempty"`
 }
 
@@ -58,11 +64,8 @@ func main() {
 		log.Fatalf("FATAL: JWT secret validation failed: %v", err)
 	}
 
-	// Connect to Redis with optional password
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: os.Getenv("REDIS_PASSWORD"), // Empty string if not set
-	})
+	// Connect to Redis with optional password (standalone, Sentinel or Cluster)
+	rdb := redis.NewUniversalClient(pubsub.UniversalOptions(redisURL))
 
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
@@ -94,8 +97,10 @@ func main() {
 	router := mux.NewRouter()
 
 	// Apply authentication middleware to all routes, but skip /health and /metrics
+	// Chat servers call in with the internal service token instead of a JWT
 	skipAuth := func(r *http.Request) bool {
-		return r.URL.Path == "/health" || r.URL.Path == "/metrics"
+		return r.URL.Path == "/health" || r.URL.Path == "/metrics" ||
+			middleware.IsInternalRequest(r, cfg.InternalServiceToken)
 	}
 	router.Use(middleware.AuthMiddleware(authService, skipAuth))
 
@@ -129,6 +134,32 @@ func main() {
 		}
 	}()
 
+	// Make this instance discoverable by chat servers
+	if cfg.InternalServiceToken != "" {
+		hostname, _ := os.Hostname()
+		serviceRegistry, err := registry.NewServiceRegistry(cfg.ConsulURL, registry.PresenceService, registry.PresenceService+"-"+hostname+"-"+port, port)
+		if err == nil {
+			serviceRegistry.SetReadinessCheck(func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				if err := rdb.Ping(ctx).Err(); err != nil {
+					return err
+				}
+				return database.Ping(ctx)
+			})
+			err = serviceRegistry.Register()
+		}
+		if err != nil {
+			log.Printf("Warning: failed to register with Consul, chat servers will use direct access: %v", err)
+		} else {
+			defer func() {
+				if err := serviceRegistry.Deregister(); err != nil {
+					log.Printf("Warning: failed to deregister service: %v", err)
+				}
+			}()
+		}
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -163,9 +194,16 @@ func (s *PresenceService) GetBatchPresence(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	results := make([]PresenceResponse, len(req.UserIDs))
-	for i, userID := range req.UserIDs {
-		results[i] = s.getUserPresence(userID)
+	if len(req.UserIDs) > services.MaxPresenceBatch {
+		http.Error(w, fmt.Sprintf("At most %d user IDs per request", services.MaxPresenceBatch), http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.batchPresence(r.Context(), req.UserIDs)
+	if err != nil {
+		log.Printf("Warning: batch presence lookup failed: %v", err)
+		http.Error(w, "Failed to get presence", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -175,37 +213,61 @@ func (s *PresenceService) GetBatchPresence(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *PresenceService) getUserPresence(userID string) PresenceResponse {
-	ctx := context.Background()
-	key := "presence:" + userID
+	results, err := s.batchPresence(context.Background(), []string{userID})
+	if err != nil {
+		return PresenceResponse{UserID: userID, IsOnline: false, Status: models.PresenceOffline}
+	}
+	return results[0]
+}
 
-	val, err := s.redis.Get(ctx, key).Result()
+// batchPresence looks up presence for the users in two round trips: one for
+// presence, one for the away/DND status of those online
+func (s *PresenceService) batchPresence(ctx context.Context, userIDs []string) ([]PresenceResponse, error) {
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = "presence:" + userID
+	}
+	values, err := pubsub.MGet(ctx, s.redis, keys...)
 	if err != nil {
-		return PresenceResponse{
-			UserID:   userID,
-			IsOnline: false,
-		}
+		return nil, err
 	}
 
-	if val == "online" {
-		return PresenceResponse{
-			UserID:   userID,
-			IsOnline: true,
-			LastSeen: time.Now().UTC(),
+	results := make([]PresenceResponse, len(userIDs))
+	var online []int
+	for i, userID := range userIDs {
+		results[i] = PresenceResponse{UserID: userID, IsOnline: false, Status: models.PresenceOffline}
+		val, _ := values[i].(string)
+		if val == "online" {
+			online = append(online, i)
+			continue
+		}
+		// Otherwise the value is the last seen time
+		if lastSeen, err := time.Parse(time.RFC3339, val); err == nil {
+			results[i].LastSeen = lastSeen
 		}
 	}
+	if len(online) == 0 {
+		return results, nil
+	}
 
-	// Parse last seen time
-	lastSeen, err := time.Parse(time.RFC3339, val)
+	// Away/DND are stored separately and only apply while connected
+	statusKeys := make([]string, len(online))
+	for j, i := range online {
+		statusKeys[j] = "presence_status:" + userIDs[i]
+	}
+	statuses, err := pubsub.MGet(ctx, s.redis, statusKeys...)
 	if err != nil {
-		return PresenceResponse{
-			UserID:   userID,
-			IsOnline: false,
-		}
+		return nil, err
 	}
-
-	return PresenceResponse{
-		UserID:   userID,
-		IsOnline: false,
-		LastSeen: lastSeen,
+	now := time.Now().UTC()
+	for j, i := range online {
+		status, _ := statuses[j].(string)
+		if status == "" {
+			status = models.PresenceOnline
+		}
+		results[i].IsOnline = true
+		results[i].Status = status
+		results[i].LastSeen = now
 	}
+	return results, nil
 }
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jaydenbeard/messaging-app/internal/config"
-	_ "github.com/lib/pq"
+	"github.com/jaydenbeard/messaging-app/internal/inbox"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -22,6 +30,10 @@ import (
 // - Key rotation reminders
 // - Pre-key replenishment checks
 // - Rate limit cleanup
+// - Offline inbox TTL pruning
+// - Message retention purge (when MESSAGE_RETENTION_DAYS is set)
+// - Audit log retention purge (when AUDIT_RETENTION_DAYS is set)
+// - Abandoned multipart upload cleanup (when MINIO_URL is set)
 func main() {
 	postgresURL := os.Getenv("POSTGRES_URL")
 	if postgresURL == "" {
@@ -44,11 +56,8 @@ func main() {
 		}
 	}()
 
-	// Connect to Redis with optional password
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: os.Getenv("REDIS_PASSWORD"), // Empty string if not set
-	})
+	// Connect to Redis with optional password (standalone, Sentinel or Cluster)
+	rdb := redis.NewUniversalClient(pubsub.UniversalOptions(redisURL))
 	defer func() {
 		if err := rdb.Close(); err != nil {
 			log.Printf("Failed to close Redis: %v", err)
@@ -60,14 +69,58 @@ func main() {
 
 	log.Println("🕐 Scheduler started")
 
-	// Start scheduled jobs
-	go runDisappearingMessagesCleanup(ctx, db)
-	go runExpiredMediaCleanup(ctx, db)
-	go runKeyRotationCheck(ctx, db, rdb)
-	go runJWTSecretRotation(ctx)
-	go runPreKeyReplenishmentCheck(ctx, db, rdb)
-	go runRateLimitCleanup(ctx, db)
-	go runVerificationCodeCleanup(ctx, db)
+	redisInbox := inbox.NewRedisInbox(rdb)
+	redisInbox.SetTTL(config.InboxTTLFromEnv())
+
+	// Start scheduled jobs. Exclusive jobs are safe to run on several
+	// scheduler instances at once: only one runs them per interval.
+	scheduler := NewScheduler(rdb)
+	jobs := []Job{
+		{Name: "disappearing_messages_cleanup", Interval: 1 * time.Minute, Exclusive: true,
+			Run: func(ctx context.Context) { cleanupDisappearingMessages(ctx, db) }},
+		{Name: "expired_media_cleanup", Interval: 5 * time.Minute, Exclusive: true,
+			Run: func(ctx context.Context) { cleanupExpiredMedia(ctx, db) }},
+		{Name: "key_rotation_check", Interval: 1 * time.Hour, Exclusive: true,
+			Run: func(ctx context.Context) { checkKeyRotation(ctx, db, rdb) }},
+		// JWT secret rotation acts on this process's key manager, so every instance runs it
+		{Name: "jwt_secret_rotation", Interval: 1 * time.Hour, Exclusive: false,
+			Run: func(ctx context.Context) { rotateJWTSecret() }},
+		{Name: "prekey_replenishment_check", Interval: 30 * time.Minute, Exclusive: true,
+			Run: func(ctx context.Context) { checkPreKeyReplenishment(ctx, db, rdb) }},
+		{Name: "rate_limit_cleanup", Interval: 10 * time.Minute, Exclusive: true,
+			Run: func(ctx context.Context) { cleanupRateLimits(ctx, db) }},
+		{Name: "verification_code_cleanup", Interval: 5 * time.Minute, Exclusive: true,
+			Run: func(ctx context.Context) { cleanupVerificationCodes(ctx, db) }},
+		{Name: "inbox_prune", Interval: 1 * time.Hour, Exclusive: true,
+			Run: func(ctx context.Context) { pruneInboxes(ctx, redisInbox) }},
+	}
+	if retention := config.MessageRetentionFromEnv(); retention > 0 {
+		jobs = append(jobs, Job{Name: "message_retention_purge", Interval: 1 * time.Hour, Exclusive: true,
+			Run: func(ctx context.Context) { purgeRetainedMessages(ctx, db, retention) }})
+	}
+	if auditRetention := config.AuditRetentionFromEnv(); auditRetention.DefaultDays > 0 {
+		var archive security.AuditArchiver
+		if dir := os.Getenv("AUDIT_ARCHIVE_DIR"); dir != "" {
+			archive = archiveAuditEvents(dir)
+		}
+		jobs = append(jobs, Job{Name: "audit_retention_purge", Interval: 1 * time.Hour, Exclusive: true,
+			Run: func(ctx context.Context) { purgeExpiredAuditEvents(ctx, db, auditRetention, archive) }})
+	}
+	storage, bucket, err := newMediaStorage()
+	if err != nil {
+		log.Fatalf("Failed to connect to MinIO: %v", err)
+	}
+	if storage != nil {
+		jobs = append(jobs, Job{Name: "abandoned_upload_cleanup", Interval: 1 * time.Hour, Exclusive: true,
+			Run: func(ctx context.Context) { abortAbandonedUploads(ctx, db, storage, bucket) }})
+	}
+	if err := ConfigureIntervals(jobs); err != nil {
+		log.Fatalf("Invalid scheduler configuration: %v", err)
+	}
+	for _, job := range jobs {
+		log.Printf("⏱️ Job %s runs every %s (exclusive=%t)", job.Name, job.Interval, job.Exclusive)
+		go scheduler.Start(ctx, job)
+	}
 
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
@@ -78,216 +131,337 @@ func main() {
 	cancel()
 }
 
-// runDisappearingMessagesCleanup deletes expired messages every minute
-func runDisappearingMessagesCleanup(ctx context.Context, db *sql.DB) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			var deletedCount int
-			err := db.QueryRowContext(ctx, "SELECT cleanup_expired_messages()").Scan(&deletedCount)
-			if err != nil {
-				log.Printf("Error cleaning up expired messages: %v", err)
-				continue
-			}
-			if deletedCount > 0 {
-				log.Printf("🗑️ Cleaned up %d expired messages", deletedCount)
-			}
-		}
+// cleanupDisappearingMessages deletes expired messages
+func cleanupDisappearingMessages(ctx context.Context, db *sql.DB) {
+	var deletedCount int
+	err := db.QueryRowContext(ctx, "SELECT cleanup_expired_messages()").Scan(&deletedCount)
+	if err != nil {
+		log.Printf("Error cleaning up expired messages: %v", err)
+		return
+	}
+	if deletedCount > 0 {
+		log.Printf("🗑️ Cleaned up %d expired messages", deletedCount)
 	}
 }
 
-// runExpiredMediaCleanup deletes expired media every 5 minutes
-func runExpiredMediaCleanup(ctx context.Context, db *sql.DB) {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			var deletedCount int
-			err := db.QueryRowContext(ctx, "SELECT cleanup_expired_media()").Scan(&deletedCount)
-			if err != nil {
-				log.Printf("Error cleaning up expired media: %v", err)
-				continue
-			}
-			if deletedCount > 0 {
-				log.Printf("🗑️ Cleaned up %d expired media files", deletedCount)
-			}
-		}
+// cleanupExpiredMedia deletes expired media
+func cleanupExpiredMedia(ctx context.Context, db *sql.DB) {
+	var deletedCount int
+	err := db.QueryRowContext(ctx, "SELECT cleanup_expired_media()").Scan(&deletedCount)
+	if err != nil {
+		log.Printf("Error cleaning up expired media: %v", err)
+		return
+	}
+	if deletedCount > 0 {
+		log.Printf("🗑️ Cleaned up %d expired media files", deletedCount)
 	}
 }
 
-// runKeyRotationCheck checks for users who need key rotation (weekly)
-func runKeyRotationCheck(ctx context.Context, db *sql.DB, rdb *redis.Client) {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// Find users whose signed pre-key is older than 7 days
-			rows, err := db.QueryContext(ctx, `
-				SELECT user_id FROM users 
-				WHERE signed_prekey_updated_at < NOW() - INTERVAL '7 days'
-				AND is_active = true
-				LIMIT 100
-			`)
-			if err != nil {
-				log.Printf("Error checking key rotation: %v", err)
-				continue
-			}
+// checkKeyRotation notifies users whose signed pre-key is older than 7 days
+func checkKeyRotation(ctx context.Context, db *sql.DB, rdb redis.UniversalClient) {
+	// Find users whose signed pre-key is older than 7 days
+	matched, notified, skipped, err := remindUserPages(ctx, db, rdb, "key_rotation_needed", `
+		SELECT user_id FROM users
+		WHERE signed_prekey_updated_at < NOW() - INTERVAL '7 days'
+		AND is_active = true
+		AND user_id > $1
+		ORDER BY user_id
+		LIMIT $2
+	`)
+	if err != nil {
+		log.Printf("Error checking key rotation: %v", err)
+	}
+	if matched > 0 {
+		log.Printf("🔑 %d users need key rotation (notified=%d, skipped=%d)", matched, notified, skipped)
+	}
+}
 
-			var usersNeedingRotation []string
-			for rows.Next() {
-				var userID string
-				if err := rows.Scan(&userID); err != nil {
-					continue
-				}
-				usersNeedingRotation = append(usersNeedingRotation, userID)
-			}
-			if err := rows.Close(); err != nil {
-				log.Printf("Warning: failed to close rows: %v", err)
-			}
+// checkPreKeyReplenishment finds users low on pre-keys
+func checkPreKeyReplenishment(ctx context.Context, db *sql.DB, rdb redis.UniversalClient) {
+	// Find users with less than 20 unused pre-keys
+	matched, notified, skipped, err := remindUserPages(ctx, db, rdb, "prekey_replenishment_needed", `
+		SELECT u.user_id
+		FROM users u
+		LEFT JOIN prekeys p ON u.user_id = p.user_id AND p.used_at IS NULL
+		WHERE u.is_active = true
+		AND u.user_id > $1
+		GROUP BY u.user_id
+		HAVING COUNT(p.id) < 20
+		ORDER BY u.user_id
+		LIMIT $2
+	`)
+	if err != nil {
+		log.Printf("Error checking pre-key counts: %v", err)
+	}
+	if matched > 0 {
+		log.Printf("🔐 %d users need pre-key replenishment (notified=%d, skipped=%d)", matched, notified, skipped)
+	}
+}
 
-			if len(usersNeedingRotation) > 0 {
-				log.Printf("🔑 %d users need key rotation", len(usersNeedingRotation))
+// reminderPageSize bounds each reminder query. Users are paged by user_id so
+// every matching user is reached on each run, not just the first page.
+const reminderPageSize = 100
+
+// remindUserPages runs query page by page and sends notificationType to each
+// user it returns. The query selects user_id only, takes the last user_id of
+// the previous page as $1 and the page size as $2, and orders by user_id.
+func remindUserPages(ctx context.Context, db *sql.DB, rdb redis.UniversalClient, notificationType, query string) (matched, notified, skipped int, err error) {
+	cursor := uuid.Nil.String()
+	for ctx.Err() == nil {
+		userIDs, err := queryUserIDPage(ctx, db, query, cursor)
+		if err != nil {
+			return matched, notified, skipped, err
+		}
+		matched += len(userIDs)
+		n, s := sendReminders(ctx, rdb, notificationType, userIDs)
+		notified += n
+		skipped += s
+		if len(userIDs) < reminderPageSize {
+			break
+		}
+		cursor = userIDs[len(userIDs)-1]
+	}
+	return matched, notified, skipped, nil
+}
 
-				// Publish notification to each user to rotate keys
-				for _, userID := range usersNeedingRotation {
-					rdb.Publish(ctx, "notifications:"+userID, `{"type":"key_rotation_needed"}`)
-				}
-			}
+// queryUserIDPage reads one page of user IDs after cursor
+func queryUserIDPage(ctx context.Context, db *sql.DB, query, cursor string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, cursor, reminderPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
 		}
+	}()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
 	}
+	return userIDs, rows.Err()
 }
 
-// runPreKeyReplenishmentCheck finds users low on pre-keys
-func runPreKeyReplenishmentCheck(ctx context.Context, db *sql.DB, rdb *redis.Client) {
-	ticker := time.NewTicker(30 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// Find users with less than 20 unused pre-keys
-			rows, err := db.QueryContext(ctx, `
-				SELECT u.user_id, COUNT(p.id) as prekey_count
-				FROM users u
-				LEFT JOIN prekeys p ON u.user_id = p.user_id AND p.used_at IS NULL
-				WHERE u.is_active = true
-				GROUP BY u.user_id
-				HAVING COUNT(p.id) < 20
-				LIMIT 100
-			`)
-			if err != nil {
-				log.Printf("Error checking pre-key counts: %v", err)
-				continue
-			}
+// reminderInterval is the minimum time between two reminders of the same type to a user
+const reminderInterval = 24 * time.Hour
+
+// sendReminders publishes a notification of the given type to each user who
+// hasn't received one in the last reminderInterval. Returns how many users
+// were notified and how many were skipped as recently reminded.
+func sendReminders(ctx context.Context, rdb redis.UniversalClient, notificationType string, userIDs []string) (notified, skipped int) {
+	for _, userID := range userIDs {
+		key := "reminder:" + notificationType + ":" + userID
+		first, err := rdb.SetNX(ctx, key, time.Now().UTC().Unix(), reminderInterval).Result()
+		if err != nil {
+			log.Printf("Warning: failed to check %s reminder for %s: %v", notificationType, userID, err)
+			continue
+		}
+		if !first {
+			skipped++
+			continue
+		}
+		if err := rdb.Publish(ctx, "notifications:"+userID, `{"type":"`+notificationType+`"}`).Err(); err != nil {
+			// Allow a retry on the next run instead of waiting a full day
+			rdb.Del(ctx, key)
+			log.Printf("Warning: failed to publish %s reminder for %s: %v", notificationType, userID, err)
+			continue
+		}
+		notified++
+	}
+	return notified, skipped
+}
 
-			var usersNeedingPrekeys []string
-			for rows.Next() {
-				var userID string
-				var count int
-				if err := rows.Scan(&userID, &count); err != nil {
-					continue
-				}
-				usersNeedingPrekeys = append(usersNeedingPrekeys, userID)
-			}
-			if err := rows.Close(); err != nil {
-				log.Printf("Warning: failed to close rows: %v", err)
-			}
+// cleanupRateLimits cleans up old rate limit entries
+func cleanupRateLimits(ctx context.Context, db *sql.DB) {
+	_, err := db.ExecContext(ctx, "SELECT cleanup_rate_limits()")
+	if err != nil {
+		log.Printf("Error cleaning up rate limits: %v", err)
+	}
+}
 
-			if len(usersNeedingPrekeys) > 0 {
-				log.Printf("🔐 %d users need pre-key replenishment", len(usersNeedingPrekeys))
+// cleanupVerificationCodes cleans up expired verification codes
+func cleanupVerificationCodes(ctx context.Context, db *sql.DB) {
+	_, err := db.ExecContext(ctx, "SELECT cleanup_expired_codes()")
+	if err != nil {
+		log.Printf("Error cleaning up verification codes: %v", err)
+	}
+}
 
-				for _, userID := range usersNeedingPrekeys {
-					rdb.Publish(ctx, "notifications:"+userID, `{"type":"prekey_replenishment_needed"}`)
-				}
-			}
-		}
+// pruneInboxes trims offline inbox entries older than the inbox TTL.
+// The messages table keeps its copy for compliance/cleanup jobs.
+func pruneInboxes(ctx context.Context, redisInbox *inbox.RedisInbox) {
+	removed, err := redisInbox.PruneAllExpired(ctx)
+	if err != nil {
+		log.Printf("Error pruning expired inbox entries: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("📭 Pruned %d expired inbox entries", removed)
 	}
 }
 
-// runRateLimitCleanup cleans up old rate limit entries
-func runRateLimitCleanup(ctx context.Context, db *sql.DB) {
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			_, err := db.ExecContext(ctx, "SELECT cleanup_rate_limits()")
-			if err != nil {
-				log.Printf("Error cleaning up rate limits: %v", err)
-			}
+// messageRetentionBatchSize bounds each purge transaction so locks on the
+// messages table are held briefly
+const messageRetentionBatchSize = 1000
+
+// purgeRetainedMessages deletes delivered and read messages older than the
+// retention period, in batches. Messages with a disappearing timer are left to
+// that timer, and undelivered ones to the inbox. Reactions, group read
+// receipts and message_inbox rows are removed by cascade.
+func purgeRetainedMessages(ctx context.Context, db *sql.DB, retention time.Duration) {
+	cutoff := time.Now().Add(-retention)
+	var total int
+	for ctx.Err() == nil {
+		deleted, err := purgeRetainedBatch(ctx, db, cutoff)
+		if err != nil {
+			log.Printf("Error purging messages past retention: %v", err)
+			break
 		}
+		total += deleted
+		if deleted < messageRetentionBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("🗑️ Purged %d messages older than %d days (retention)", total, int(retention.Hours()/24))
 	}
 }
 
-// runVerificationCodeCleanup cleans up expired verification codes
-func runVerificationCodeCleanup(ctx context.Context, db *sql.DB) {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			_, err := db.ExecContext(ctx, "SELECT cleanup_expired_codes()")
-			if err != nil {
-				log.Printf("Error cleaning up verification codes: %v", err)
-			}
+// purgeRetainedBatch deletes up to messageRetentionBatchSize messages sent
+// before cutoff in one transaction. Returns how many were deleted.
+func purgeRetainedBatch(ctx context.Context, db *sql.DB, cutoff time.Time) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT message_id FROM messages
+		WHERE timestamp < $1 AND status IN ('delivered', 'read') AND expires_at IS NULL
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`, cutoff, messageRetentionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return 0, err
 		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// reply_to_id has no ON DELETE action; unlink replies so the delete succeeds
+	if _, err := tx.ExecContext(ctx, `UPDATE messages SET reply_to_id = NULL WHERE reply_to_id = ANY($1::uuid[])`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE message_id = ANY($1::uuid[])`, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(deleted), nil
+}
+
+// purgeExpiredAuditEvents deletes security audit events past their retention.
+// High and critical events are kept at least as long as the compliance floors.
+func purgeExpiredAuditEvents(ctx context.Context, db *sql.DB, retention config.AuditRetention, archive security.AuditArchiver) {
+	purged, err := security.PurgeExpiredAuditEvents(ctx, db, retention, archive)
+	if err != nil {
+		log.Printf("Error purging audit events past retention: %v", err)
+	}
+	if purged > 0 {
+		log.Printf("🗑️ Purged %d audit events past retention (default %d days)", purged, retention.DefaultDays)
 	}
 }
 
-// runJWTSecretRotation automatically rotates JWT secrets based on configured interval
-func runJWTSecretRotation(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Hour) // Check every hour
-	defer ticker.Stop()
-
-	log.Println("🔄 JWT secret rotation scheduler started")
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// Check if rotation is needed
-			if config.ShouldRotate() {
-				log.Println("🔄 JWT secret rotation triggered - generating new secret")
-
-				// Generate a new cryptographically secure secret
-				newSecretBytes := make([]byte, 64) // 512 bits
-				if _, err := rand.Read(newSecretBytes); err != nil {
-					log.Printf("Error generating new JWT secret: %v", err)
-					continue
-				}
-				newSecret := hex.EncodeToString(newSecretBytes)
-
-				// Rotate the secret
-				if err := config.RotateSecret(newSecret); err != nil {
-					log.Printf("Error rotating JWT secret: %v", err)
-					continue
-				}
-
-				log.Println("✅ JWT secret rotation completed successfully")
-				log.Println("ℹ️  Transition period active - both old and new secrets will be accepted")
+// archiveAuditEvents writes each purge batch to dir as gzipped JSON lines,
+// one file per batch, for shipping to cold storage. The file is synced
+// before the batch is deleted; if the delete then fails the rows are archived
+// again by the next run.
+func archiveAuditEvents(dir string) security.AuditArchiver {
+	return func(ctx context.Context, firstSeq, lastSeq int64, rows []json.RawMessage) error {
+		name := filepath.Join(dir, fmt.Sprintf("audit-%012d-%012d.jsonl.gz", firstSeq, lastSeq))
+		tmp, err := os.CreateTemp(dir, ".audit-archive-*")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = os.Remove(tmp.Name())
+		}()
+
+		zw := gzip.NewWriter(tmp)
+		for _, row := range rows {
+			if _, err := zw.Write(append(row, '\n')); err != nil {
+				_ = tmp.Close()
+				return err
 			}
 		}
+		if err := zw.Close(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), name)
+	}
+}
+
+// rotateJWTSecret automatically rotates JWT secrets based on configured interval
+func rotateJWTSecret() {
+	// Check if rotation is needed
+	if !config.ShouldRotate() {
+		return
+	}
+	log.Println("🔄 JWT secret rotation triggered - generating new secret")
+
+	// Generate a new cryptographically secure secret
+	newSecretBytes := make([]byte, 64) // 512 bits
+	if _, err := rand.Read(newSecretBytes); err != nil {
+		log.Printf("Error generating new JWT secret: %v", err)
+		return
 	}
+	newSecret := hex.EncodeToString(newSecretBytes)
+
+	// Rotate the secret
+	if err := config.RotateSecret(newSecret); err != nil {
+		log.Printf("Error rotating JWT secret: %v", err)
+		return
+	}
+
+	log.Println("✅ JWT secret rotation completed successfully")
+	log.Println("ℹ️  Transition period active - both old and new secrets will be accepted")
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Job is one periodic scheduler task
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Exclusive jobs run on only one scheduler instance per interval. Jobs that
+	// act on process-local state (e.g. JWT secret rotation) must not be exclusive.
+	Exclusive bool
+	Run       func(ctx context.Context)
+}
+
+// Scheduler runs jobs on jittered tickers, using Redis locks so redundant
+// scheduler instances don't duplicate work
+type Scheduler struct {
+	rdb        redis.UniversalClient
+	instanceID string
+}
+
+// NewScheduler creates a scheduler identified by hostname plus a random suffix
+func NewScheduler(rdb redis.UniversalClient) *Scheduler {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return &Scheduler{
+		rdb:        rdb,
+		instanceID: fmt.Sprintf("%s-%s", host, hex.EncodeToString(suffix)),
+	}
+}
+
+// Start runs the job until ctx is cancelled. The first tick is delayed by a
+// random fraction of the interval so instances started together don't align.
+func (s *Scheduler) Start(ctx context.Context, job Job) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(randomDuration(job.Interval)):
+	}
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if job.Exclusive && !s.acquire(ctx, job) {
+				continue
+			}
+			job.Run(ctx)
+		}
+	}
+}
+
+// acquire takes the job's lock for most of one interval. The lock is not
+// released after the run: it marks this interval's run as done, so other
+// instances ticking later in the same interval skip it. If the holder dies,
+// the lock expires and another instance takes over next interval.
+func (s *Scheduler) acquire(ctx context.Context, job Job) bool {
+	ttl := job.Interval * 9 / 10
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	ok, err := s.rdb.SetNX(ctx, "scheduler:lock:"+job.Name, s.instanceID, ttl).Result()
+	if err != nil {
+		// Fail closed: skipping one run is safer than duplicating it everywhere
+		log.Printf("Warning: failed to acquire lock for job %s: %v", job.Name, err)
+		return false
+	}
+	return ok
+}
+
+// minJobInterval guards against typos like "1ms" hammering the database
+const minJobInterval = 10 * time.Second
+
+// intervalEnvKey returns the env var overriding a job's interval,
+// e.g. SCHEDULER_KEY_ROTATION_CHECK_INTERVAL
+func intervalEnvKey(jobName string) string {
+	return "SCHEDULER_" + strings.ToUpper(jobName) + "_INTERVAL"
+}
+
+// ConfigureIntervals applies per-job interval overrides from the environment.
+// Values are Go durations (e.g. "90s", "2h") and must be at least minJobInterval.
+func ConfigureIntervals(jobs []Job) error {
+	for i := range jobs {
+		key := intervalEnvKey(jobs[i].Name)
+		value := os.Getenv(key)
+		if value == "" {
+			continue
+		}
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s=%q: %w", key, value, err)
+		}
+		if interval < minJobInterval {
+			return fmt.Errorf("invalid %s=%q: must be at least %s", key, value, minJobInterval)
+		}
+		jobs[i].Interval = interval
+	}
+	return nil
+}
+
+// randomDuration returns a uniformly random duration in [0, max)
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(n.Int64())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// abandonedUploadAge is how long a multipart upload may stay unfinished
+// before its parts are discarded
+const abandonedUploadAge = 24 * time.Hour
+
+// abandonedUploadBatch bounds how many uploads one run aborts
+const abandonedUploadBatch = 500
+
+// newMediaStorage connects to MinIO from the same environment as the chat
+// server. Returns nil if MINIO_URL is not set.
+func newMediaStorage() (*minio.Core, string, error) {
+	endpoint := os.Getenv("MINIO_URL")
+	if endpoint == "" {
+		return nil, "", nil
+	}
+	bucket := os.Getenv("MINIO_BUCKET")
+	if bucket == "" {
+		bucket = "encrypted-media"
+	}
+
+	useSSL := strings.HasPrefix(endpoint, "https://")
+	endpoint = strings.TrimPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("MINIO_ACCESS_KEY"), os.Getenv("MINIO_SECRET_KEY"), ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &minio.Core{Client: client}, bucket, nil
+}
+
+// abortAbandonedUploads discards the parts of multipart uploads left
+// unfinished for abandonedUploadAge and deletes their media_uploads rows,
+// which also frees the users' open upload slots
+func abortAbandonedUploads(ctx context.Context, db *sql.DB, storage *minio.Core, bucket string) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT upload_id, 'media/' || media_id, storage_upload_id FROM media_uploads
+		WHERE completed_at IS NULL AND created_at < $1
+		ORDER BY created_at
+		LIMIT $2`, time.Now().Add(-abandonedUploadAge), abandonedUploadBatch)
+	if err != nil {
+		log.Printf("Error finding abandoned uploads: %v", err)
+		return
+	}
+
+	type abandoned struct{ uploadID, objectName, storageUploadID string }
+	var uploads []abandoned
+	for rows.Next() {
+		var u abandoned
+		if err := rows.Scan(&u.uploadID, &u.objectName, &u.storageUploadID); err != nil {
+			log.Printf("Error reading abandoned upload: %v", err)
+			continue
+		}
+		uploads = append(uploads, u)
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Failed to close rows: %v", err)
+	}
+
+	aborted := 0
+	for _, u := range uploads {
+		err := storage.AbortMultipartUpload(ctx, bucket, u.objectName, u.storageUploadID)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+			log.Printf("Error aborting upload %s: %v", u.uploadID, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM media_uploads WHERE upload_id = $1 AND completed_at IS NULL`, u.uploadID); err != nil {
+			log.Printf("Error deleting abandoned upload %s: %v", u.uploadID, err)
+			continue
+		}
+		aborted++
+	}
+	if aborted > 0 {
+		log.Printf("🗑️ Aborted %d abandoned media uploads", aborted)
+	}
+}
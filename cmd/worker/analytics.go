@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/queue"
+	"github.com/redis/go-redis/v9"
+)
+
+// activeUsersKeyTTL keeps the per-day HyperLogLog around long enough for
+// late (redelivered) events from the previous day
+const activeUsersKeyTTL = 48 * time.Hour
+
+// AnalyticsAggregator turns message events into daily counters.
+// Only event metadata is used; message content never reaches the worker.
+type AnalyticsAggregator struct {
+	db    *db.PostgresDB
+	redis redis.UniversalClient
+}
+
+// NewAnalyticsAggregator creates an aggregator writing to analytics_daily
+func NewAnalyticsAggregator(database *db.PostgresDB, rdb redis.UniversalClient) *AnalyticsAggregator {
+	return &AnalyticsAggregator{db: database, redis: rdb}
+}
+
+// Record applies one queue event to the daily aggregates
+func (a *AnalyticsAggregator) Record(ctx context.Context, msg *queue.QueuedMessage) error {
+	day := msg.Timestamp.UTC()
+	if day.IsZero() {
+		day = time.Now().UTC()
+	}
+
+	if err := a.db.IncrementDailyAnalytics(day, msg.EventType); err != nil {
+		return err
+	}
+
+	// Sent events carry the sender; count distinct senders per day with a
+	// HyperLogLog so no per-user activity rows are stored
+	if msg.EventType == "archived" {
+		key := "analytics:active_users:" + day.Format("2006-01-02")
+		pipe := a.redis.TxPipeline()
+		pipe.PFAdd(ctx, key, msg.SenderID.String())
+		pipe.Expire(ctx, key, activeUsersKeyTTL)
+		count := pipe.PFCount(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		if err := a.db.SetDailyActiveUsers(day, count.Val()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
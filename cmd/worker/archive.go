@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/queue"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ArchivedMessage is the metadata kept in long-term storage for a message.
+// It never contains ciphertext - the worker only sees queue event metadata.
+type ArchivedMessage struct {
+	MessageID  uuid.UUID  `json:"message_id"`
+	SenderID   uuid.UUID  `json:"sender_id"`
+	ReceiverID *uuid.UUID `json:"receiver_id,omitempty"`
+	GroupID    *uuid.UUID `json:"group_id,omitempty"`
+	SentAt     time.Time  `json:"sent_at"`
+	ArchivedAt time.Time  `json:"archived_at"`
+}
+
+// ArchivalSink stores message metadata for long-term retention
+type ArchivalSink interface {
+	Archive(ctx context.Context, msg *ArchivedMessage) error
+	// Close flushes buffered records and releases resources
+	Close() error
+}
+
+// newArchivedMessage builds the archive record for an "archived" queue event
+func newArchivedMessage(msg *queue.QueuedMessage) *ArchivedMessage {
+	return &ArchivedMessage{
+		MessageID:  msg.MessageID,
+		SenderID:   msg.SenderID,
+		ReceiverID: msg.ReceiverID,
+		GroupID:    msg.GroupID,
+		SentAt:     msg.Timestamp.UTC(),
+		ArchivedAt: time.Now().UTC(),
+	}
+}
+
+// NewArchivalSinkFromEnv selects the sink with ARCHIVE_SINK: "none" (default), "file" or "s3"
+func NewArchivalSinkFromEnv(consumerName string) (ArchivalSink, error) {
+	switch sink := strings.ToLower(os.Getenv("ARCHIVE_SINK")); sink {
+	case "", "none":
+		return noopSink{}, nil
+	case "file":
+		dir := os.Getenv("ARCHIVE_DIR")
+		if dir == "" {
+			dir = "./archive"
+		}
+		return NewFileSink(dir)
+	case "s3":
+		return NewS3Sink(S3SinkConfig{
+			Endpoint:      os.Getenv("ARCHIVE_S3_ENDPOINT"),
+			AccessKey:     os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+			SecretKey:     os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+			Bucket:        os.Getenv("ARCHIVE_S3_BUCKET"),
+			Prefix:        os.Getenv("ARCHIVE_S3_PREFIX"),
+			UseSSL:        os.Getenv("ARCHIVE_S3_USE_SSL") != "false",
+			BatchSize:     int(getEnvInt64("ARCHIVE_BATCH_SIZE", 1000)),
+			FlushInterval: getEnvDuration("ARCHIVE_FLUSH_INTERVAL", time.Minute),
+			ObjectPrefix:  consumerName,
+		})
+	default:
+		return nil, fmt.Errorf("unknown ARCHIVE_SINK %q (expected none, file or s3)", sink)
+	}
+}
+
+// ================== No-op ==================
+
+// noopSink discards records (archival disabled)
+type noopSink struct{}
+
+func (noopSink) Archive(context.Context, *ArchivedMessage) error { return nil }
+func (noopSink) Close() error                                    { return nil }
+
+// ================== Local File ==================
+
+// FileSink appends records as JSON lines to one file per UTC day
+type FileSink struct {
+	dir  string
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+// NewFileSink creates a sink writing to dir
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	return &FileSink{dir: dir}, nil
+}
+
+// Archive appends one record to the current day's file
+func (s *FileSink) Archive(_ context.Context, msg *ArchivedMessage) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := msg.SentAt.Format("2006-01-02")
+	if s.file == nil || s.day != day {
+		if s.file != nil {
+			if err := s.file.Close(); err != nil {
+				log.Printf("Warning: failed to close archive file: %v", err)
+			}
+		}
+		path := filepath.Join(s.dir, "messages-"+day+".jsonl")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			s.file = nil
+			return fmt.Errorf("failed to open archive file: %w", err)
+		}
+		s.file, s.day = f, day
+	}
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write archive record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the current file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// ================== S3 ==================
+
+// S3SinkConfig configures the S3-compatible object storage sink
+type S3SinkConfig struct {
+	Endpoint      string
+	AccessKey     string
+	SecretKey     string
+	Bucket        string
+	Prefix        string // Key prefix inside the bucket (default "message-archive")
+	UseSSL        bool
+	BatchSize     int           // Records per object
+	FlushInterval time.Duration // Max time a record stays buffered
+	ObjectPrefix  string        // Distinguishes objects written by different workers
+}
+
+// S3Sink buffers records and uploads them as gzip-compressed JSON lines
+// objects, partitioned as <prefix>/dt=YYYY-MM-DD/ so query engines
+// (Athena, Spark, DuckDB) can read them directly or convert them to Parquet.
+// Records buffered when the process is killed without Close are lost; they
+// are bounded by BatchSize and FlushInterval.
+//
+// A batch's object key is derived from the message IDs it contains and the
+// batch is frozen once its first upload is attempted, so a retry after a
+// failed (or only apparently failed) upload overwrites the same object
+// instead of archiving the records twice.
+type S3Sink struct {
+	client *minio.Client
+	cfg    S3SinkConfig
+
+	mu       sync.Mutex
+	buffer   map[string][]*ArchivedMessage // day -> records not yet in a batch
+	sealed   []*archiveBatch               // batches awaiting a (re)upload
+	buffered map[uuid.UUID]struct{}        // message IDs buffered or sealed
+	pending  int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// archiveBatch is a fixed set of records bound to one object key
+type archiveBatch struct {
+	day     string
+	key     string
+	records []*ArchivedMessage
+}
+
+// NewS3Sink connects to the bucket and starts the periodic flusher
+func NewS3Sink(cfg S3SinkConfig) (*S3Sink, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("ARCHIVE_S3_ENDPOINT and ARCHIVE_S3_BUCKET are required for the s3 archive sink")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "message-archive"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Minute
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check archive bucket: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("archive bucket %s does not exist", cfg.Bucket)
+	}
+
+	s := &S3Sink{
+		client:   client,
+		cfg:      cfg,
+		buffer:   make(map[string][]*ArchivedMessage),
+		buffered: make(map[uuid.UUID]struct{}),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+// Archive buffers a record, uploading a batch once BatchSize is reached.
+// A redelivered event whose record is still buffered is not added again.
+func (s *S3Sink) Archive(ctx context.Context, msg *ArchivedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buffered[msg.MessageID]; !ok {
+		day := msg.SentAt.Format("2006-01-02")
+		s.buffer[day] = append(s.buffer[day], msg)
+		s.buffered[msg.MessageID] = struct{}{}
+		s.pending++
+	}
+
+	if s.pending >= s.cfg.BatchSize {
+		return s.flushLocked(ctx)
+	}
+	return nil
+}
+
+// Close uploads any buffered records
+func (s *S3Sink) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.flushLocked(ctx)
+}
+
+func (s *S3Sink) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := s.flushLocked(ctx); err != nil {
+				log.Printf("Warning: failed to flush message archive: %v", err)
+			}
+			cancel()
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// flushLocked seals each buffered day into a batch and uploads every sealed
+// batch. Batches that fail to upload are retried later under the same key.
+// Caller must hold s.mu.
+func (s *S3Sink) flushLocked(ctx context.Context) error {
+	for day, records := range s.buffer {
+		s.sealed = append(s.sealed, &archiveBatch{
+			day:     day,
+			key:     s.batchKey(day, records),
+			records: records,
+		})
+		delete(s.buffer, day)
+	}
+
+	var firstErr error
+	remaining := s.sealed[:0]
+	for _, batch := range s.sealed {
+		if err := s.upload(ctx, batch); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			remaining = append(remaining, batch)
+			continue
+		}
+		s.pending -= len(batch.records)
+		for _, r := range batch.records {
+			delete(s.buffered, r.MessageID)
+		}
+	}
+	s.sealed = remaining
+	return firstErr
+}
+
+// batchKey names a batch's object after a hash of its message IDs
+func (s *S3Sink) batchKey(day string, records []*ArchivedMessage) string {
+	h := sha256.New()
+	for _, r := range records {
+		h.Write(r.MessageID[:])
+	}
+	return fmt.Sprintf("%s/dt=%s/%s-%x.jsonl.gz", s.cfg.Prefix, day, s.cfg.ObjectPrefix, h.Sum(nil)[:16])
+}
+
+func (s *S3Sink) upload(ctx context.Context, batch *archiveBatch) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, r := range batch.records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, s.cfg.Bucket, batch.key, &buf, int64(buf.Len()), minio.PutObjectOptions{
+		ContentType:     "application/x-ndjson",
+		ContentEncoding: "gzip",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload archive object %s: %w", batch.key, err)
+	}
+	log.Printf("📁 Archived %d messages to s3://%s/%s", len(batch.records), s.cfg.Bucket, batch.key)
+	return nil
+}
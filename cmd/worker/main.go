@@ -1,15 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"context"
 
 	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/queue"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -35,11 +41,8 @@ func main() {
 		consumerName = "worker-1"
 	}
 
-	// Connect to Redis with optional password
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     redisURL,
-		Password: os.Getenv("REDIS_PASSWORD"), // Empty string if not set
-	})
+	// Connect to Redis with optional password (standalone, Sentinel or Cluster)
+	rdb := redis.NewUniversalClient(pubsub.UniversalOptions(redisURL))
 	if err := rdb.Ping(context.Background()).Err(); err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
@@ -57,26 +60,40 @@ func main() {
 
 	// Create message queue
 	mq := queue.NewMessageQueue(rdb, "message_events")
+	analytics := NewAnalyticsAggregator(database, rdb)
+
+	sink, err := NewArchivalSinkFromEnv(consumerName)
+	if err != nil {
+		log.Fatalf("Failed to create archival sink: %v", err)
+	}
 
 	log.Printf("🔄 Queue Worker started: group=%s, consumer=%s", consumerGroup, consumerName)
 
 	// Start processing in a goroutine
-	go mq.StartConsumer(consumerGroup, consumerName, func(msg *queue.QueuedMessage) error {
+	handler := func(msg *queue.QueuedMessage) error {
 		log.Printf("📦 Processing message event: %s (type: %s)", msg.MessageID, msg.EventType)
 
 		switch msg.EventType {
 		case "archived":
-			// Store in long-term archive
-			// In production, could write to a data warehouse
-			log.Printf("📁 Archiving message %s", msg.MessageID)
+			// Store message metadata (never content) in long-term archive
+			if err := sink.Archive(context.Background(), newArchivedMessage(msg)); err != nil {
+				return fmt.Errorf("failed to archive message: %w", err)
+			}
+			if err := analytics.Record(context.Background(), msg); err != nil {
+				return fmt.Errorf("failed to record send analytics: %w", err)
+			}
 
 		case "delivered":
-			// Update analytics
 			log.Printf("📊 Recording delivery for message %s", msg.MessageID)
+			if err := analytics.Record(context.Background(), msg); err != nil {
+				return fmt.Errorf("failed to record delivery analytics: %w", err)
+			}
 
 		case "read":
-			// Update analytics
 			log.Printf("📊 Recording read receipt for message %s", msg.MessageID)
+			if err := analytics.Record(context.Background(), msg); err != nil {
+				return fmt.Errorf("failed to record read analytics: %w", err)
+			}
 
 		case "pending_delivery":
 			// Could retry delivery or escalate
@@ -84,7 +101,34 @@ func main() {
 		}
 
 		return nil
-	})
+	}
+	// Delivery is at-least-once; skip events that were already processed so
+	// analytics counters and archival are not applied twice
+	handler = mq.WithIdempotency(getEnvDuration("IDEMPOTENCY_TTL", 7*24*time.Hour), handler)
+	go mq.StartConsumer(consumerGroup, consumerName, handler)
+
+	// Reprocess events stranded by crashed consumers and export group lag
+	claimMinIdle := getEnvDuration("CLAIM_MIN_IDLE", 5*time.Minute)
+	claimBatchSize := getEnvInt64("CLAIM_BATCH_SIZE", 100)
+	log.Printf("Auto-claim enabled: min_idle=%s, batch_size=%d", claimMinIdle, claimBatchSize)
+	go mq.StartAutoClaim(consumerGroup, consumerName, getEnvDuration("CLAIM_INTERVAL", 30*time.Second), claimMinIdle, claimBatchSize, handler)
+	go mq.StartLagMonitor(consumerGroup, 15*time.Second)
+
+	// Prometheus metrics endpoint
+	metricsPort := os.Getenv("METRICS_PORT")
+	if metricsPort == "" {
+		metricsPort = "8084"
+	}
+	metricsServer := &http.Server{
+		Addr:              ":" + metricsPort,
+		Handler:           promhttp.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
 
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
@@ -92,4 +136,35 @@ func main() {
 	<-quit
 
 	log.Println("🛑 Worker shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		log.Printf("Metrics server shutdown error: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		log.Printf("Failed to flush archival sink: %v", err)
+	}
+}
+
+// getEnvDuration parses a Go duration (e.g. "5m") from the environment
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := time.ParseDuration(value); err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("Warning: invalid %s=%q, using %s", key, value, defaultValue)
+	}
+	return defaultValue
+}
+
+// getEnvInt64 parses a positive integer from the environment
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("Warning: invalid %s=%q, using %d", key, value, defaultValue)
+	}
+	return defaultValue
 }
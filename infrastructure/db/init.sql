@@ -135,6 +135,7 @@ CREATE TABLE groups (
     description TEXT,
     avatar_url TEXT,
     group_key_encrypted TEXT,
+    key_version INTEGER NOT NULL DEFAULT 1,           -- Bumped on every rekey (membership change)
     disappearing_messages INTEGER,                    -- Seconds until messages expire (NULL = disabled)
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     created_by UUID NOT NULL REFERENCES users(user_id)
@@ -203,6 +204,11 @@ CREATE INDEX idx_messages_timestamp ON messages(timestamp DESC);
 CREATE INDEX idx_messages_unread ON messages(receiver_id, status) WHERE status != 'read';
 CREATE INDEX idx_messages_expiry ON messages(expires_at) WHERE expires_at IS NOT NULL;
 CREATE INDEX idx_messages_reply ON messages(reply_to_id) WHERE reply_to_id IS NOT NULL;
+-- Conversation listing (GetConversationSummaries) and metadata search
+-- (SearchMessages): latest messages per party
+CREATE INDEX idx_messages_sender_ts ON messages(sender_id, timestamp DESC) WHERE group_id IS NULL;
+CREATE INDEX idx_messages_receiver_ts ON messages(receiver_id, timestamp DESC) WHERE group_id IS NULL;
+CREATE INDEX idx_messages_group_ts ON messages(group_id, timestamp DESC) WHERE group_id IS NOT NULL;
 
 -- ============================================
 -- MESSAGE REACTIONS
@@ -217,6 +223,19 @@ CREATE TABLE message_reactions (
 
 CREATE INDEX idx_reactions_message ON message_reactions(message_id);
 
+-- ============================================
+-- GROUP MESSAGE READ RECEIPTS
+-- Per-member read state for group messages (messages.status is per message)
+-- ============================================
+CREATE TABLE group_message_reads (
+    message_id UUID NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_group_message_reads_user ON group_message_reads(user_id);
+
 -- ============================================
 -- MESSAGE INBOX (for efficient message retrieval)
 -- ============================================
@@ -266,6 +285,10 @@ CREATE TABLE devices (
     device_type VARCHAR(20) CHECK (device_type IN ('mobile', 'tablet', 'desktop', 'web')),
     public_device_key TEXT NOT NULL,
     is_primary BOOLEAN DEFAULT false,                 -- Primary device for key provisioning
+    trust_level VARCHAR(20) NOT NULL DEFAULT 'trusted'
+        CHECK (trust_level IN ('trusted', 'untrusted')),  -- 'untrusted' once removed by the user; re-linking needs approval
+    platform VARCHAR(20),                             -- Reported by the client on connect (ios, android, web, desktop)
+    app_version VARCHAR(40),                          -- Normalized MAJOR.MINOR.PATCH[-pre]
     registered_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     last_seen TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     is_active BOOLEAN DEFAULT true
@@ -327,6 +350,7 @@ CREATE TABLE friendships (
     requester_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
     addressee_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
     status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    decline_count INTEGER NOT NULL DEFAULT 0,         -- Times the addressee declined this requester
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     UNIQUE (requester_id, addressee_id),
@@ -356,6 +380,25 @@ CREATE TABLE media (
 CREATE INDEX idx_media_uploader ON media(uploader_id);
 CREATE INDEX idx_media_expiry ON media(expires_at) WHERE expires_at IS NOT NULL;
 
+-- Resumable multipart uploads: clients send the parts straight to the
+-- storage backend, which tracks them; these rows tie an upload to its user
+CREATE TABLE media_uploads (
+    upload_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    media_id UUID NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    storage_upload_id TEXT NOT NULL,                  -- S3 multipart upload ID
+    content_type VARCHAR(100) NOT NULL,
+    total_size BIGINT NOT NULL,
+    part_size BIGINT NOT NULL,
+    part_count INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_media_uploads_user ON media_uploads(user_id);
+-- Abandoned upload cleanup (scheduler)
+CREATE INDEX idx_media_uploads_open ON media_uploads(created_at) WHERE completed_at IS NULL;
+
 -- ============================================
 -- VERIFICATION CODES (for phone auth)
 -- ============================================
@@ -436,9 +479,9 @@ CREATE TABLE safety_verifications (
 -- ============================================
 CREATE TABLE security_audit_log (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    user_id UUID REFERENCES users(user_id),
+    user_id UUID,                                     -- No foreign key: rows are hash-chained and outlive the user
     session_id UUID,                                  -- Optional session reference
-    device_id UUID REFERENCES devices(device_id),     -- Optional device reference
+    device_id UUID,                                   -- Optional device reference, no foreign key either
     event_type VARCHAR(50) NOT NULL,                  -- login, pin_failed, key_rotated, etc.
     severity VARCHAR(10) DEFAULT 'info' CHECK (severity IN ('info', 'low', 'medium', 'high', 'critical')),
     result VARCHAR(20) DEFAULT 'unknown',             -- success, failure, unknown
@@ -460,7 +503,11 @@ CREATE TABLE security_audit_log (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     duration_ms INTEGER,                              -- Request duration
     compliance_flags TEXT[],                          -- Compliance tags (GDPR, HIPAA, etc.)
-    data_category VARCHAR(50)                         -- Data sensitivity level
+    data_category VARCHAR(50),                        -- Data sensitivity level
+    chain_seq BIGSERIAL,                              -- Insertion order of the hash chain
+    prev_hash CHAR(64),                               -- entry_hash of the previous row in the chain
+    entry_hash CHAR(64),                              -- SHA-256(prev_hash || canonical event), tamper evidence
+    retention_days INTEGER                            -- Per-event retention; NULL uses AUDIT_RETENTION_DAYS
 );
 
 CREATE INDEX idx_audit_user ON security_audit_log(user_id, created_at DESC);
@@ -469,6 +516,37 @@ CREATE INDEX idx_audit_session ON security_audit_log(session_id) WHERE session_i
 CREATE INDEX idx_audit_timestamp ON security_audit_log(timestamp DESC);
 CREATE INDEX idx_audit_severity ON security_audit_log(severity, created_at DESC) WHERE severity IN ('high', 'critical');
 CREATE INDEX idx_audit_resource ON security_audit_log(resource_type, resource_id) WHERE resource_id IS NOT NULL;
+CREATE INDEX idx_audit_created ON security_audit_log(created_at DESC, id DESC);  -- Keyset pagination for admin queries
+CREATE UNIQUE INDEX idx_audit_chain_seq ON security_audit_log(chain_seq);
+
+-- Hash chain links of audit rows purged for retention, so the chain can still
+-- be verified across the gaps. Links older than the oldest remaining row are dropped.
+-- A link is only trusted if a chained audit_purged event lists it.
+CREATE TABLE audit_chain_links (
+    chain_seq BIGINT PRIMARY KEY,                     -- security_audit_log.chain_seq of the purged row
+    prev_hash CHAR(64),
+    entry_hash CHAR(64) NOT NULL,
+    purged_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    listed_by BIGINT                                  -- chain_seq of the audit_purged event listing it
+);
+
+CREATE INDEX idx_audit_chain_links_listed_by ON audit_chain_links(listed_by);
+
+-- Audit events that exhausted write retries, kept for reprocessing
+CREATE TABLE audit_dead_letter (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,                    -- security_audit_log.id of the failed event
+    event_type VARCHAR(50) NOT NULL,
+    severity VARCHAR(10),
+    event JSONB NOT NULL,                             -- Full serialized AuditEvent
+    last_error TEXT,
+    attempts INTEGER DEFAULT 0,                       -- Reprocess attempts so far
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    reprocessed_at TIMESTAMP WITH TIME ZONE           -- NULL while pending
+);
+
+CREATE INDEX idx_audit_dead_letter_pending ON audit_dead_letter(created_at) WHERE reprocessed_at IS NULL;
 
 -- ============================================
 -- SEALED SENDER CERTIFICATES
@@ -541,6 +619,43 @@ CREATE TABLE security_incidents (
 CREATE INDEX idx_incidents_status ON security_incidents(status, created_at DESC);
 CREATE INDEX idx_incidents_user ON security_incidents(affected_user_id);
 
+-- ============================================
+-- DAILY ANALYTICS (aggregated by cmd/worker)
+-- Counters only - no message content or per-user rows
+-- ============================================
+CREATE TABLE analytics_daily (
+    day DATE PRIMARY KEY,
+    messages_sent BIGINT NOT NULL DEFAULT 0,
+    messages_delivered BIGINT NOT NULL DEFAULT 0,
+    messages_read BIGINT NOT NULL DEFAULT 0,
+    active_users BIGINT NOT NULL DEFAULT 0,            -- Distinct senders (HyperLogLog estimate)
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ============================================
+-- ABUSE REPORTS
+-- Evidence is what the reporter chose to submit from their own decrypted
+-- copy; the server never sees message plaintext otherwise
+-- ============================================
+CREATE TABLE reports (
+    report_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reporter_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    reported_user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    message_id UUID REFERENCES messages(message_id) ON DELETE SET NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'sexual', 'violence', 'other')),
+    evidence TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'actioned')),
+    action VARCHAR(20) CHECK (action IN ('dismiss', 'warn', 'ban')),
+    reviewed_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_reports_status ON reports(status, created_at DESC);
+CREATE INDEX idx_reports_reported_user ON reports(reported_user_id);
+-- One report per message per reporter
+CREATE UNIQUE INDEX idx_reports_reporter_message ON reports(reporter_id, message_id) WHERE message_id IS NOT NULL;
+
 -- ============================================
 -- Functions and Triggers
 -- ============================================
@@ -0,0 +1,153 @@
+-- ============================================
+-- SCHEMA UPGRADE
+-- Brings a database created from an older init.sql up to the current
+-- schema. Every statement is idempotent, so it is safe to run on every
+-- deploy and is a no-op on a database created from the current init.sql:
+--   psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f infrastructure/db/upgrade.sql
+-- Keep it in step with init.sql when changing the schema.
+-- ============================================
+
+BEGIN;
+
+-- ============================================
+-- AUDIT DEAD LETTERS
+-- ============================================
+CREATE TABLE IF NOT EXISTS audit_dead_letter (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,                    -- security_audit_log.id of the failed event
+    event_type VARCHAR(50) NOT NULL,
+    severity VARCHAR(10),
+    event JSONB NOT NULL,                             -- Full serialized AuditEvent
+    last_error TEXT,
+    attempts INTEGER DEFAULT 0,                       -- Reprocess attempts so far
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    reprocessed_at TIMESTAMP WITH TIME ZONE           -- NULL while pending
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_dead_letter_pending ON audit_dead_letter(created_at) WHERE reprocessed_at IS NULL;
+
+-- Keyset pagination for admin audit queries
+CREATE INDEX IF NOT EXISTS idx_audit_created ON security_audit_log(created_at DESC, id DESC);
+
+-- ============================================
+-- AUDIT HASH CHAIN
+-- Rows written before the upgrade get a chain_seq but no entry_hash, and are
+-- left out of chain verification
+-- ============================================
+ALTER TABLE security_audit_log
+    ADD COLUMN IF NOT EXISTS chain_seq BIGSERIAL,
+    ADD COLUMN IF NOT EXISTS prev_hash CHAR(64),
+    ADD COLUMN IF NOT EXISTS entry_hash CHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_chain_seq ON security_audit_log(chain_seq);
+
+-- Conversation listing (GetConversationSummaries) and metadata search
+-- (SearchMessages): latest messages per party
+CREATE INDEX IF NOT EXISTS idx_messages_sender_ts ON messages(sender_id, timestamp DESC) WHERE group_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_receiver_ts ON messages(receiver_id, timestamp DESC) WHERE group_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_group_ts ON messages(group_id, timestamp DESC) WHERE group_id IS NOT NULL;
+
+-- ============================================
+-- GROUP MESSAGE READ RECEIPTS
+-- ============================================
+CREATE TABLE IF NOT EXISTS group_message_reads (
+    message_id UUID NOT NULL REFERENCES messages(message_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    read_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_message_reads_user ON group_message_reads(user_id);
+
+-- ============================================
+-- DAILY ANALYTICS
+-- ============================================
+CREATE TABLE IF NOT EXISTS analytics_daily (
+    day DATE PRIMARY KEY,
+    messages_sent BIGINT NOT NULL DEFAULT 0,
+    messages_delivered BIGINT NOT NULL DEFAULT 0,
+    messages_read BIGINT NOT NULL DEFAULT 0,
+    active_users BIGINT NOT NULL DEFAULT 0,            -- Distinct senders (HyperLogLog estimate)
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Group key version, bumped on every rekey
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS key_version INTEGER NOT NULL DEFAULT 1;
+
+-- Device trust level: existing devices stay trusted
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS trust_level VARCHAR(20) NOT NULL DEFAULT 'trusted'
+    CHECK (trust_level IN ('trusted', 'untrusted'));
+
+-- Times the addressee declined a friend request from this requester
+ALTER TABLE friendships ADD COLUMN IF NOT EXISTS decline_count INTEGER NOT NULL DEFAULT 0;
+
+-- Audit rows are hash-chained and outlive the user and device they name,
+-- so they no longer reference either
+ALTER TABLE security_audit_log
+    DROP CONSTRAINT IF EXISTS security_audit_log_user_id_fkey,
+    DROP CONSTRAINT IF EXISTS security_audit_log_device_id_fkey;
+
+-- ============================================
+-- RESUMABLE MEDIA UPLOADS
+-- ============================================
+CREATE TABLE IF NOT EXISTS media_uploads (
+    upload_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    media_id UUID NOT NULL UNIQUE,
+    user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    storage_upload_id TEXT NOT NULL,                  -- S3 multipart upload ID
+    content_type VARCHAR(100) NOT NULL,
+    total_size BIGINT NOT NULL,
+    part_size BIGINT NOT NULL,
+    part_count INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_uploads_user ON media_uploads(user_id);
+CREATE INDEX IF NOT EXISTS idx_media_uploads_open ON media_uploads(created_at) WHERE completed_at IS NULL;
+
+-- ============================================
+-- ABUSE REPORTS
+-- ============================================
+CREATE TABLE IF NOT EXISTS reports (
+    report_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reporter_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    reported_user_id UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    message_id UUID REFERENCES messages(message_id) ON DELETE SET NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'sexual', 'violence', 'other')),
+    evidence TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'actioned')),
+    action VARCHAR(20) CHECK (action IN ('dismiss', 'warn', 'ban')),
+    reviewed_by UUID REFERENCES users(user_id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_reports_reported_user ON reports(reported_user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_reporter_message ON reports(reporter_id, message_id) WHERE message_id IS NOT NULL;
+
+-- Client platform and app version, reported on connect
+ALTER TABLE devices
+    ADD COLUMN IF NOT EXISTS platform VARCHAR(20),
+    ADD COLUMN IF NOT EXISTS app_version VARCHAR(40);
+
+-- ============================================
+-- AUDIT RETENTION
+-- ============================================
+ALTER TABLE security_audit_log ADD COLUMN IF NOT EXISTS retention_days INTEGER;
+
+CREATE TABLE IF NOT EXISTS audit_chain_links (
+    chain_seq BIGINT PRIMARY KEY,                     -- security_audit_log.chain_seq of the purged row
+    prev_hash CHAR(64),
+    entry_hash CHAR(64) NOT NULL,
+    purged_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    listed_by BIGINT                                  -- chain_seq of the audit_purged event listing it
+);
+
+ALTER TABLE audit_chain_links ADD COLUMN IF NOT EXISTS listed_by BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_chain_links_listed_by ON audit_chain_links(listed_by);
+
+COMMIT;
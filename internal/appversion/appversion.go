@@ -0,0 +1,160 @@
+// Package appversion validates and compares the dotted app versions and
+// platforms clients report ("2.14.0", "2.14.0-beta.1"), and checks them
+// against the per-platform minimum and recommended versions. Only the
+// numeric release part is compared; pre-release and build suffixes are
+// ignored.
+package appversion
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Platforms clients may report
+var Platforms = []string{"ios", "android", "web", "desktop"}
+
+// versionPattern accepts up to three numeric components with an optional
+// pre-release and build suffix
+var versionPattern = regexp.MustCompile(`^v?(\d{1,4})(?:\.(\d{1,4}))?(?:\.(\d{1,4}))?(-[0-9A-Za-z.]{1,20})?(\+[0-9A-Za-z.]{1,20})?$`)
+
+// Normalize returns v as MAJOR.MINOR.PATCH plus any lower-cased pre-release
+// suffix ("v2.14-RC1" becomes "2.14.0-rc1"); build metadata is dropped.
+// Returns false for anything else.
+func Normalize(v string) (string, bool) {
+	m := versionPattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return "", false
+	}
+	nums := [3]int{}
+	for i := range nums {
+		if m[i+1] != "" {
+			nums[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	return fmt.Sprintf("%d.%d.%d", nums[0], nums[1], nums[2]) + strings.ToLower(m[4]), true
+}
+
+// NormalizePlatform returns the platform lower-cased, or "" if it isn't one
+// of Platforms
+func NormalizePlatform(p string) string {
+	p = strings.ToLower(strings.TrimSpace(p))
+	if !slices.Contains(Platforms, p) {
+		return ""
+	}
+	return p
+}
+
+// parts returns the numeric components of a version. A component that isn't
+// a number ends the list.
+func parts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+
+	var out []int
+	for _, p := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			break
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// Compare returns -1, 0 or 1 as a is older than, the same as or newer than b.
+// Missing components count as 0, so "2.1" equals "2.1.0".
+func Compare(a, b string) int {
+	pa, pb := parts(a), parts(b)
+	for i := 0; i < max(len(pa), len(pb)); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Valid reports whether v starts with at least a major version number
+func Valid(v string) bool {
+	return len(parts(v)) > 0
+}
+
+// PlatformPolicy is the oldest app version still allowed on a platform and,
+// optionally, the version below which users are asked to upgrade.
+// RequireVersion treats a missing or unparseable version as below Minimum.
+type PlatformPolicy struct {
+	Minimum        string `json:"minimum,omitempty"`
+	Recommended    string `json:"recommended,omitempty"`
+	RequireVersion bool   `json:"require_version,omitempty"`
+}
+
+// Policy maps platforms to their version requirements
+type Policy map[string]PlatformPolicy
+
+// Status is where a client stands against a Policy
+type Status int
+
+const (
+	Supported          Status = iota // no action needed
+	UpgradeRecommended               // below the recommended version: nag
+	UpgradeRequired                  // below the minimum: refuse
+)
+
+// Validate checks every platform and version in the policy
+func (p Policy) Validate() error {
+	for platform, rule := range p {
+		if NormalizePlatform(platform) != platform {
+			return fmt.Errorf("unknown platform %q", platform)
+		}
+		if rule.Minimum != "" && !Valid(rule.Minimum) {
+			return fmt.Errorf("invalid minimum version %q for %s", rule.Minimum, platform)
+		}
+		if rule.Recommended != "" && !Valid(rule.Recommended) {
+			return fmt.Errorf("invalid recommended version %q for %s", rule.Recommended, platform)
+		}
+		if rule.Minimum != "" && rule.Recommended != "" && Compare(rule.Recommended, rule.Minimum) < 0 {
+			return fmt.Errorf("recommended version for %s is below the minimum", platform)
+		}
+		if rule.RequireVersion && rule.Minimum == "" {
+			return fmt.Errorf("require_version for %s needs a minimum version", platform)
+		}
+	}
+	return nil
+}
+
+// Check returns the client's status and the rule that applied. Clients that
+// didn't report a platform can't be judged and are Supported; so are those
+// without a valid version, unless the platform's rule has RequireVersion.
+func (p Policy) Check(platform, version string) (Status, PlatformPolicy) {
+	rule, ok := p[platform]
+	if !ok {
+		return Supported, rule
+	}
+	if !Valid(version) {
+		if rule.RequireVersion && rule.Minimum != "" {
+			return UpgradeRequired, rule
+		}
+		return Supported, rule
+	}
+	if rule.Minimum != "" && Compare(version, rule.Minimum) < 0 {
+		return UpgradeRequired, rule
+	}
+	if rule.Recommended != "" && Compare(version, rule.Recommended) < 0 {
+		return UpgradeRecommended, rule
+	}
+	return Supported, rule
+}
@@ -25,6 +25,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/security"
 	"github.com/jaydenbeard/messaging-app/internal/sms"
 	"github.com/redis/go-redis/v9"
@@ -43,19 +45,33 @@ var (
 	ErrSessionFixation    = errors.New("session fixation attempt detected")
 	ErrTokenCompromised   = errors.New("token appears to be compromised")
 	ErrBlacklistOperation = errors.New("failed to update token blacklist")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrDeviceSignedOut    = errors.New("device has been signed out")
+	ErrSMSDeliveryFailed  = errors.New("verification code could not be sent")
 )
 
+// ResendCooldownError is returned by RequestVerificationCode when the phone
+// number was sent a code too recently
+type ResendCooldownError struct {
+	RetryAt time.Time
+}
+
+func (e *ResendCooldownError) Error() string {
+	return fmt.Sprintf("verification code resend available at %s", e.RetryAt.Format(time.RFC3339))
+}
+
 // AuthService handles authentication with secure JWT secret management
 type AuthService struct {
 	db                *db.PostgresDB
-	smsService        *sms.ClickSendService
+	smsService        sms.SMSProvider
 	jwtSecret         []byte
 	previousJWTSecret []byte
 	secretLock        sync.RWMutex // Thread-safe access to JWT secret
 	rotationLogger    *log.Logger
-	redisClient       *redis.Client
+	redisClient       redis.UniversalClient
 	blacklistLock     sync.RWMutex // Thread-safe access to blacklist operations
 	securityLogger    *log.Logger
+	verifyLockout     VerifyLockoutPolicy
 }
 
 // Claims represents JWT claims
@@ -84,24 +100,27 @@ func NewAuthService(database *db.PostgresDB, jwtSecret string) (*AuthService, er
 	// Check environment mode for fail-fast behavior
 	nodeEnv := os.Getenv("NODE_ENV")
 
-	// Initialize SMS service
-	smsService, err := sms.NewClickSendService()
+	// Initialize SMS provider (SMS_PROVIDER: clicksend, twilio or log)
+	smsService, err := sms.NewSMSProvider(os.Getenv("SMS_PROVIDER"))
 	if err != nil {
 		if nodeEnv == "production" {
 			return nil, fmt.Errorf("failed to initialize SMS service in production: %w", err)
 		}
 		log.Printf("Warning: Failed to initialize SMS service: %v", err)
-		log.Printf("SMS verification codes will not be sent - check ClickSend configuration")
+		log.Printf("SMS verification codes will not be sent - check SMS provider configuration")
 		// Don't fail auth service creation, just log the warning
 	} else {
-		log.Printf("SMS service initialized successfully with ClickSend")
+		log.Printf("SMS service initialized successfully with %s", smsService.Name())
+		if smsService.Name() == "log" && nodeEnv == "production" {
+			log.Printf("Warning: SMS_PROVIDER=log in production - verification codes are not delivered")
+		}
 		// Perform health check to verify service is operational
 		if err := smsService.HealthCheck(); err != nil {
 			if nodeEnv == "production" {
 				return nil, fmt.Errorf("SMS service health check failed in production: %w", err)
 			}
 			log.Printf("Warning: SMS service health check failed: %v", err)
-			log.Printf("SMS service may not be fully operational - check ClickSend account status")
+			log.Printf("SMS service may not be fully operational - check %s account status", smsService.Name())
 		} else {
 			log.Printf("SMS service health check passed - service is operational")
 		}
@@ -116,11 +135,7 @@ func NewAuthService(database *db.PostgresDB, jwtSecret string) (*AuthService, er
 	if redisAddr == "" {
 		redisAddr = "localhost:6379" // Default fallback
 	}
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       0, // Use default DB
-	})
+	redisClient := redis.NewUniversalClient(pubsub.UniversalOptions(redisAddr))
 
 	// Test Redis connection
 	_, err = redisClient.Ping(context.Background()).Result()
@@ -146,6 +161,7 @@ func NewAuthService(database *db.PostgresDB, jwtSecret string) (*AuthService, er
 		rotationLogger:    log.New(os.Stdout, "[AUTH-ROTATION] ", log.Ldate|log.Ltime|log.LUTC),
 		redisClient:       redisClient,
 		securityLogger:    log.New(os.Stdout, "[AUTH-SECURITY] ", log.Ldate|log.Ltime|log.LUTC),
+		verifyLockout:     DefaultVerifyLockoutPolicy,
 	}, nil
 }
 
@@ -228,23 +244,32 @@ func (a *AuthService) RotateJWTSecret(newSecret string) error {
 	return nil
 }
 
-// RequestVerificationCode generates and stores a verification code
-func (a *AuthService) RequestVerificationCode(phoneNumber string) (string, error) {
+// RequestVerificationCode generates and stores a verification code and sends
+// it by SMS, returning the code and the earliest time another may be sent.
+// It returns a *ResendCooldownError if the previous code was sent too
+// recently, and ErrSMSDeliveryFailed if the provider rejects the message;
+// the stored code stays valid so a retry can still succeed.
+func (a *AuthService) RequestVerificationCode(phoneNumber string) (string, time.Time, error) {
 	// Validate phone number format
 	if !security.ValidatePhoneNumber(phoneNumber) {
-		return "", fmt.Errorf("invalid phone number format")
+		return "", time.Time{}, fmt.Errorf("invalid phone number format")
+	}
+
+	nextAllowed, err := a.reserveCodeResend(phoneNumber)
+	if err != nil {
+		return "", time.Time{}, err
 	}
 
 	// Generate 6-digit code
 	code, err := generateCode(6)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	// Store code with 5 minute expiry
 	expiresAt := time.Now().Add(5 * time.Minute)
 	if err := a.db.SaveVerificationCode(phoneNumber, code, expiresAt); err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	// Send SMS via ClickSend if service is available AND not in DEV_MODE
@@ -253,19 +278,65 @@ func (a *AuthService) RequestVerificationCode(phoneNumber string) (string, error
 		log.Printf("DEV_MODE: Skipping SMS send to %s - use code returned in API response", phoneNumber)
 	} else if a.smsService != nil {
 		if err := a.smsService.SendVerificationCode(phoneNumber, code); err != nil {
+			metrics.RecordSMSSend(a.smsService.Name(), false)
 			log.Printf("Failed to send SMS verification code to %s: %v", phoneNumber, err)
-			// Don't fail the request, just log the error
-			// User can still get the code via other means if needed
-		} else {
-			log.Printf("SMS verification code sent successfully to %s", phoneNumber)
+			return "", nextAllowed, fmt.Errorf("%w: %v", ErrSMSDeliveryFailed, err)
 		}
+		metrics.RecordSMSSend(a.smsService.Name(), true)
+		log.Printf("SMS verification code sent successfully to %s", phoneNumber)
 	} else {
 		log.Printf("SMS service not configured - verification code not sent to %s", phoneNumber)
 	}
 
 	// Return the code (for development/testing purposes)
 	// In production, you might want to remove this and only send via SMS
-	return code, nil
+	return code, nextAllowed, nil
+}
+
+// codeResendCooldowns is the wait after the 1st, 2nd and 3rd+ code sent to a
+// phone number. The count resets once no code has been sent for
+// codeResendResetAfter.
+var codeResendCooldowns = []time.Duration{30 * time.Second, 60 * time.Second, 5 * time.Minute}
+
+const codeResendResetAfter = 1 * time.Hour
+
+// resendScript checks and advances a phone number's resend cooldown.
+// KEYS: resend hash. ARGV: now (ms), reset ttl (s), cooldowns (ms)...
+// Returns {allowed, next allowed (ms)}.
+var resendScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local next = tonumber(redis.call('HGET', KEYS[1], 'next') or '0')
+if now < next then
+	return {0, next}
+end
+local count = redis.call('HINCRBY', KEYS[1], 'count', 1)
+local idx = math.min(count, #ARGV - 2)
+next = now + tonumber(ARGV[idx + 2])
+redis.call('HSET', KEYS[1], 'next', next)
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return {1, next}
+`)
+
+// reserveCodeResend claims the next send slot for phoneNumber. The slot is
+// taken before the SMS goes out so concurrent requests can't both send.
+// Fails open on Redis errors; the endpoint rate limiter still applies.
+func (a *AuthService) reserveCodeResend(phoneNumber string) (time.Time, error) {
+	args := []any{time.Now().UnixMilli(), int(codeResendResetAfter.Seconds())}
+	for _, d := range codeResendCooldowns {
+		args = append(args, d.Milliseconds())
+	}
+
+	res, err := resendScript.Run(context.Background(), a.redisClient, []string{"sms_resend:" + phoneNumber}, args...).Int64Slice()
+	if err != nil || len(res) != 2 {
+		a.securityLogger.Printf("Error checking resend cooldown: %v", err)
+		return time.Time{}, nil
+	}
+
+	nextAllowed := time.UnixMilli(res[1])
+	if res[0] == 0 {
+		return time.Time{}, &ResendCooldownError{RetryAt: nextAllowed}
+	}
+	return nextAllowed, nil
 }
 
 // CheckCode validates a verification code without marking it as verified (for pre-checking)
@@ -327,6 +398,12 @@ func (a *AuthService) GenerateTokens(userID, deviceID uuid.UUID) (accessToken, r
 		log.Printf("Warning: Failed to create session: %v", err)
 	}
 
+	// A device that signs in again after "sign out other devices" gets a
+	// fresh start
+	if err := a.redisClient.Del(context.Background(), "signed_out:"+deviceID.String()).Err(); err != nil {
+		log.Printf("Warning: Failed to clear sign-out marker: %v", err)
+	}
+
 	return accessToken, refreshToken, accessExpiry, nil
 }
 
@@ -335,6 +412,9 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	// Try validation with current secret first
 	token, err := a.validateTokenWithSecret(tokenString, a.GetJWTSecret())
 	if err == nil {
+		if err := a.checkRevoked(token); err != nil {
+			return nil, err
+		}
 		return token, nil
 	}
 
@@ -346,6 +426,9 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 		token, err = a.validateTokenWithSecret(tokenString, a.GetPreviousJWTSecret())
 		if err == nil {
 			a.rotationLogger.Printf("Token validated successfully with previous secret - transition period active")
+			if err := a.checkRevoked(token); err != nil {
+				return nil, err
+			}
 			return token, nil
 		}
 	}
@@ -357,6 +440,18 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, ErrInvalidToken
 }
 
+// checkRevoked rejects otherwise valid tokens of banned users, of devices
+// that were signed out remotely and of users who revoked all their sessions
+func (a *AuthService) checkRevoked(claims *Claims) error {
+	if a.IsUserBanned(claims.UserID) {
+		return ErrAccountDisabled
+	}
+	if a.IsDeviceSignedOut(claims.DeviceID) || a.isTokenRevoked(claims) {
+		return ErrDeviceSignedOut
+	}
+	return nil
+}
+
 // validateTokenWithSecret validates a JWT token using a specific secret
 func (a *AuthService) validateTokenWithSecret(tokenString string, secret []byte) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
@@ -391,6 +486,15 @@ func (a *AuthService) RefreshAccessToken(refreshTokenString string) (accessToken
 		return "", time.Time{}, err
 	}
 
+	// SECURITY: Banned accounts can't mint new access tokens
+	userActive, err := a.db.IsUserActive(claims.UserID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to verify account status: %w", err)
+	}
+	if !userActive {
+		return "", time.Time{}, ErrAccountDisabled
+	}
+
 	// SECURITY: Verify device is still active and belongs to user
 	isActive, err := a.db.IsDeviceActive(claims.UserID, claims.DeviceID)
 	if err != nil {
@@ -450,6 +554,316 @@ func (a *AuthService) RevokeAllUserTokens(userID uuid.UUID) error {
 	return a.db.RevokeAllUserSessions(userID)
 }
 
+// ============================================
+// VERIFICATION LOCKOUT
+// ============================================
+
+// VerifyLockoutPolicy locks a phone number out of code verification after
+// MaxFailures wrong codes within Window, counted across every code issued
+// to it. Each code is also limited to 5 attempts on its own.
+type VerifyLockoutPolicy struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+}
+
+// DefaultVerifyLockoutPolicy locks for 15 minutes after 10 failures in an hour
+var DefaultVerifyLockoutPolicy = VerifyLockoutPolicy{
+	MaxFailures: 10,
+	Window:      1 * time.Hour,
+	Lockout:     15 * time.Minute,
+}
+
+// SetVerifyLockoutPolicy replaces the verification lockout policy. A
+// MaxFailures of zero disables the lockout.
+func (a *AuthService) SetVerifyLockoutPolicy(policy VerifyLockoutPolicy) {
+	a.verifyLockout = policy
+}
+
+// verifyFailureScript counts a failed verification and starts the lockout
+// once the limit is reached.
+// KEYS: failure counter, lock marker. ARGV: window (s), max failures, lockout (ms).
+// Returns the lockout in ms, or 0 if the phone number is not locked.
+var verifyFailureScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+if n >= tonumber(ARGV[2]) then
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[3])
+	redis.call('DEL', KEYS[1])
+	return tonumber(ARGV[3])
+end
+return 0
+`)
+
+// verifyFailuresKey and verifyLockedKey share a hash tag so
+// verifyFailureScript can touch both on Redis Cluster
+func verifyFailuresKey(phoneNumber string) string {
+	return "verify_failures:{" + phoneNumber + "}"
+}
+
+func verifyLockedKey(phoneNumber string) string {
+	return "verify_locked:{" + phoneNumber + "}"
+}
+
+// Keys used before the hash tags were added. They are still read so failures
+// and lockouts recorded by the previous release carry over; remove once
+// those have expired.
+func legacyVerifyFailuresKey(phoneNumber string) string {
+	return "verify_failures:" + phoneNumber
+}
+
+func legacyVerifyLockedKey(phoneNumber string) string {
+	return "verify_locked:" + phoneNumber
+}
+
+// adoptLegacyVerifyFailures moves a failure count recorded under the legacy
+// key into the current one
+func (a *AuthService) adoptLegacyVerifyFailures(ctx context.Context, phoneNumber string, window time.Duration) {
+	legacy, err := a.redisClient.GetDel(ctx, legacyVerifyFailuresKey(phoneNumber)).Int64()
+	if err != nil || legacy <= 0 {
+		return
+	}
+	pipe := a.redisClient.Pipeline()
+	pipe.IncrBy(ctx, verifyFailuresKey(phoneNumber), legacy)
+	pipe.Expire(ctx, verifyFailuresKey(phoneNumber), window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.securityLogger.Printf("Error migrating verification failures: %v", err)
+	}
+}
+
+// RecordVerifyFailure counts a wrong verification code for phoneNumber and
+// returns the end of the lockout if this failure triggered one.
+func (a *AuthService) RecordVerifyFailure(phoneNumber string) (time.Time, bool) {
+	policy := a.verifyLockout
+	if policy.MaxFailures <= 0 {
+		return time.Time{}, false
+	}
+
+	ctx := context.Background()
+	a.adoptLegacyVerifyFailures(ctx, phoneNumber, policy.Window)
+
+	keys := []string{verifyFailuresKey(phoneNumber), verifyLockedKey(phoneNumber)}
+	lockMs, err := verifyFailureScript.Run(ctx, a.redisClient, keys,
+		int(policy.Window.Seconds()), policy.MaxFailures, policy.Lockout.Milliseconds()).Int64()
+	if err != nil {
+		a.securityLogger.Printf("Error recording verification failure: %v", err)
+		return time.Time{}, false
+	}
+	if lockMs == 0 {
+		return time.Time{}, false
+	}
+
+	until := time.Now().Add(time.Duration(lockMs) * time.Millisecond)
+	a.securityLogger.Printf("Phone number locked out of verification until %s", until.Format(time.RFC3339))
+	return until, true
+}
+
+// VerifyLockedUntil reports whether phoneNumber is locked out of code
+// verification, and until when. Fails open on Redis errors; the endpoint
+// rate limiter and per-code attempt cap still apply.
+func (a *AuthService) VerifyLockedUntil(phoneNumber string) (time.Time, bool) {
+	if a.verifyLockout.MaxFailures <= 0 {
+		return time.Time{}, false
+	}
+
+	// The keys are in different cluster slots, so pipeline rather than script
+	ctx := context.Background()
+	pipe := a.redisClient.Pipeline()
+	current := pipe.PTTL(ctx, verifyLockedKey(phoneNumber))
+	legacy := pipe.PTTL(ctx, legacyVerifyLockedKey(phoneNumber))
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.securityLogger.Printf("Error checking verification lockout: %v", err)
+		return time.Time{}, false
+	}
+	ttl := max(current.Val(), legacy.Val())
+	if ttl <= 0 {
+		return time.Time{}, false
+	}
+	return time.Now().Add(ttl), true
+}
+
+// ClearVerifyFailures resets the failure count after a successful
+// verification
+func (a *AuthService) ClearVerifyFailures(phoneNumber string) {
+	ctx := context.Background()
+	pipe := a.redisClient.Pipeline()
+	pipe.Del(ctx, verifyFailuresKey(phoneNumber))
+	pipe.Del(ctx, legacyVerifyFailuresKey(phoneNumber))
+	if _, err := pipe.Exec(ctx); err != nil {
+		a.securityLogger.Printf("Error clearing verification failures: %v", err)
+	}
+}
+
+// ============================================
+// PIN STEP-UP
+// ============================================
+
+// PINStepUpTTL is how long a PIN re-entry (or, for users without a PIN, a
+// re-authentication) authorizes sensitive actions
+const PINStepUpTTL = 5 * time.Minute
+
+// IssuePINStepUpToken records a successful PIN verification or
+// re-authentication for the device and returns a token the client sends
+// with sensitive requests
+func (a *AuthService) IssuePINStepUpToken(userID, deviceID uuid.UUID) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	key := "pin_stepup:" + hashTokenForBlacklist(token)
+	if err := a.redisClient.Set(context.Background(), key, userID.String()+":"+deviceID.String(), PINStepUpTTL).Err(); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store step-up token: %w", err)
+	}
+	return token, time.Now().Add(PINStepUpTTL), nil
+}
+
+// ValidatePINStepUpToken reports whether token was issued to this user and
+// device within PINStepUpTTL. Fails closed on Redis errors.
+func (a *AuthService) ValidatePINStepUpToken(userID, deviceID uuid.UUID, token string) bool {
+	if token == "" {
+		return false
+	}
+	owner, err := a.redisClient.Get(context.Background(), "pin_stepup:"+hashTokenForBlacklist(token)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			a.securityLogger.Printf("Error checking PIN step-up token: %v", err)
+		}
+		return false
+	}
+	return hmac.Equal([]byte(owner), []byte(userID.String()+":"+deviceID.String()))
+}
+
+// HasPIN reports whether the user has set a PIN
+func (a *AuthService) HasPIN(userID uuid.UUID) (bool, error) {
+	pinHash, _, err := a.db.GetUserPIN(userID)
+	if err != nil {
+		return false, err
+	}
+	return pinHash != "", nil
+}
+
+// ============================================
+// ACCOUNT BANS
+// ============================================
+
+// bannedMarkerTTL is how long the Redis ban marker lives. It only has to
+// outlive access tokens issued before the ban (1 hour); after that, refresh
+// is refused by the users.is_active check.
+const bannedMarkerTTL = 1 * time.Hour
+
+// BanUser disables a user account and revokes all of its sessions. Access
+// tokens already issued are rejected until they expire.
+func (a *AuthService) BanUser(userID uuid.UUID) error {
+	if err := a.db.SetUserActive(userID, false); err != nil {
+		return err
+	}
+	if err := a.RevokeAllUserTokens(userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := a.redisClient.Set(ctx, "banned:"+userID.String(), time.Now().UTC().Unix(), bannedMarkerTTL).Err(); err != nil {
+		return fmt.Errorf("failed to mark user as banned: %w", err)
+	}
+	a.securityLogger.Printf("User banned: %s", userID)
+	return nil
+}
+
+// UnbanUser re-enables a user account. The user has to sign in again since
+// their sessions were revoked by the ban.
+func (a *AuthService) UnbanUser(userID uuid.UUID) error {
+	if err := a.db.SetUserActive(userID, true); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := a.redisClient.Del(ctx, "banned:"+userID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to clear ban marker: %w", err)
+	}
+	a.securityLogger.Printf("User unbanned: %s", userID)
+	return nil
+}
+
+// IsUserBanned checks the Redis ban marker. Fails open on Redis errors;
+// a banned user still can't refresh their token.
+func (a *AuthService) IsUserBanned(userID uuid.UUID) bool {
+	n, err := a.redisClient.Exists(context.Background(), "banned:"+userID.String()).Result()
+	if err != nil {
+		a.securityLogger.Printf("Error checking ban marker: %v", err)
+		return false
+	}
+	return n > 0
+}
+
+// ============================================
+// REMOTE SIGN-OUT
+// ============================================
+
+// SignOutDevices marks devices as signed out so their access tokens stop
+// working immediately. Deactivating the devices already blocks refresh; the
+// marker covers tokens issued before, so it shares bannedMarkerTTL.
+func (a *AuthService) SignOutDevices(deviceIDs []uuid.UUID) error {
+	ctx := context.Background()
+	pipe := a.redisClient.Pipeline()
+	now := time.Now().UTC().Unix()
+	for _, deviceID := range deviceIDs {
+		pipe.Set(ctx, "signed_out:"+deviceID.String(), now, bannedMarkerTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to mark devices as signed out: %w", err)
+	}
+	a.securityLogger.Printf("Signed out %d devices", len(deviceIDs))
+	return nil
+}
+
+// tokensRevokedMarkerTTL outlives every token issued before a revocation:
+// refresh tokens last 30 days
+const tokensRevokedMarkerTTL = 30 * 24 * time.Hour
+
+// RevokeUserTokens rejects every access and refresh token issued to the
+// user so far, signing out all of their devices. Since connections sign
+// messages with their access token, this also retires every HMAC key in use.
+func (a *AuthService) RevokeUserTokens(userID uuid.UUID) error {
+	if err := a.RevokeAllUserTokens(userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	ctx := context.Background()
+	if err := a.redisClient.Set(ctx, "tokens_revoked:"+userID.String(), time.Now().UTC().Unix(), tokensRevokedMarkerTTL).Err(); err != nil {
+		return fmt.Errorf("failed to mark tokens as revoked: %w", err)
+	}
+	a.securityLogger.Printf("All tokens revoked for user: %s", userID)
+	return nil
+}
+
+// isTokenRevoked reports whether the token predates a RevokeUserTokens
+// call. Tokens issued in the same second as the revocation are kept so a
+// device can sign straight back in. Fails open like IsUserBanned.
+func (a *AuthService) isTokenRevoked(claims *Claims) bool {
+	revokedAt, err := a.redisClient.Get(context.Background(), "tokens_revoked:"+claims.UserID.String()).Int64()
+	if err != nil {
+		if err != redis.Nil {
+			a.securityLogger.Printf("Error checking token revocation marker: %v", err)
+		}
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Unix() < revokedAt
+}
+
+// IsDeviceSignedOut checks the Redis sign-out marker. Fails open like
+// IsUserBanned; a signed-out device still can't refresh its token.
+func (a *AuthService) IsDeviceSignedOut(deviceID uuid.UUID) bool {
+	n, err := a.redisClient.Exists(context.Background(), "signed_out:"+deviceID.String()).Result()
+	if err != nil {
+		a.securityLogger.Printf("Error checking sign-out marker: %v", err)
+		return false
+	}
+	return n > 0
+}
+
 // ============================================
 // TOKEN BLACKLISTING (Session Security)
 // ============================================
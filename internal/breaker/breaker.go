@@ -0,0 +1,155 @@
+// Package breaker stops callers from hammering a dependency that keeps
+// failing. After enough consecutive failures the breaker opens and calls fail
+// fast; once the cooldown passes a single trial call is let through, and its
+// result closes the breaker again or restarts the cooldown.
+package breaker
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+)
+
+// ErrOpen is returned instead of calling a dependency whose breaker is open
+var ErrOpen = errors.New("circuit breaker open")
+
+// Breaker defaults (overridable via New)
+const (
+	DefaultFailureThreshold = 5
+	DefaultCooldown         = 30 * time.Second
+)
+
+// State is the position of a breaker, exported as
+// messenger_circuit_breaker_state
+type State int
+
+const (
+	Closed   State = iota // calls go through
+	Open                  // calls fail fast with ErrOpen
+	HalfOpen              // one trial call is in flight
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a consecutive-failure circuit breaker, safe for concurrent use
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+// New creates a closed breaker that opens after threshold consecutive
+// failures and tries again after cooldown. Non-positive values use the
+// defaults.
+func New(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+	metrics.SetCircuitBreakerState(name, int(Closed))
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Name returns the dependency the breaker guards
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the breaker's current position
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Success or Failure.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			metrics.RecordCircuitBreakerRejection(b.name)
+			return ErrOpen
+		}
+		b.setStateLocked(HalfOpen)
+		return nil
+	case HalfOpen:
+		// The trial call decides; everyone else keeps failing fast
+		metrics.RecordCircuitBreakerRejection(b.name)
+		return ErrOpen
+	default:
+		return nil
+	}
+}
+
+// Success records a call that worked and closes the breaker
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.setStateLocked(Closed)
+}
+
+// Failure records a failed call, opening the breaker if it was the trial
+// call or the threshold has been reached
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		if b.state != Open {
+			log.Printf("Warning: circuit breaker %s opened after %d consecutive failures", b.name, b.failures)
+		}
+		b.setStateLocked(Open)
+		b.openedAt = time.Now()
+	}
+}
+
+// setStateLocked moves the breaker to state and exports it. b.mu must be held.
+func (b *Breaker) setStateLocked(state State) {
+	if b.state == state {
+		return
+	}
+	if state == Closed {
+		log.Printf("Circuit breaker %s closed", b.name)
+	}
+	b.state = state
+	metrics.SetCircuitBreakerState(b.name, int(state))
+}
+
+// Do runs fn if the breaker allows it and records the outcome
+func (b *Breaker) Do(fn func() error) error {
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		b.Failure()
+		return err
+	}
+	b.Success()
+	return nil
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -203,6 +206,137 @@ type Config struct {
 	MinioBucket string
 	RateLimits  *RateLimitConfig
 	MediaLimits *MediaLimitConfig
+
+	// MinioPublicURL is the storage address clients can reach, for presigned
+	// upload URLs; unset means MinioURL. MinioRegion is the bucket's region,
+	// so presigning needs no round trip to the storage backend.
+	MinioPublicURL string
+	MinioRegion    string
+
+	// MediaURLExpiry is how long a media download link from GetMediaURL
+	// stays valid
+	MediaURLExpiry time.Duration
+
+	// PostgresReplicaURL optionally points at a read replica that serves
+	// read-heavy queries; unset means everything runs on the primary
+	PostgresReplicaURL string
+
+	// DBSlowQueryThreshold logs database statements slower than this. 0 disables.
+	DBSlowQueryThreshold time.Duration
+
+	// DBQueryTimeout bounds context-aware database calls (message saves,
+	// key fetches, group member lookups). 0 leaves only request deadlines.
+	DBQueryTimeout time.Duration
+
+	// InternalServiceToken is the shared secret chat servers present to the
+	// presence and group services. Unset, chat servers read Redis and
+	// Postgres directly and the services accept only user JWTs.
+	InternalServiceToken string
+
+	// AdminUserIDs lists user IDs allowed to call /api/v1/admin endpoints
+	AdminUserIDs []string
+
+	// InboxTTL is how long undelivered messages stay in Redis offline inboxes
+	InboxTTL time.Duration
+	// InboxMaxSize caps messages per offline inbox; the oldest are evicted beyond it
+	InboxMaxSize int64
+
+	// MaxCiphertextBytes caps a message's ciphertext; MaxMediaCiphertextBytes
+	// applies instead when the message references a media_id
+	MaxCiphertextBytes      int
+	MaxMediaCiphertextBytes int
+
+	// CORSOrigins lists origins allowed to make credentialed cross-origin API requests
+	CORSOrigins []string
+
+	// RequireOneTimePrekey refuses key bundles without a one-time pre-key
+	// instead of letting sessions fall back to reduced forward secrecy
+	RequireOneTimePrekey bool
+
+	// GeoIPDBPath points at a MaxMind DB (e.g. GeoLite2-City.mmdb) used to add
+	// locations to audit events and detect logins from new countries
+	GeoIPDBPath string
+	// GeoIPStepUpTOTP requires a TOTP code for logins from a new country when
+	// the user has TOTP enabled
+	GeoIPStepUpTOTP bool
+
+	// TrustedProxies lists CIDRs allowed to set X-Forwarded-For/X-Real-IP.
+	// Empty keeps the default of loopback and private ranges.
+	TrustedProxies []string
+
+	// WebSocket permessage-deflate. Frames smaller than
+	// WSCompressionMinBytes are sent uncompressed.
+	WSCompression         bool
+	WSCompressionLevel    int
+	WSCompressionMinBytes int
+
+	// WSMaxConnectionsPerUser and WSMaxTotalConnections cap WebSocket
+	// connections per user and per chat server
+	WSMaxConnectionsPerUser int
+	WSMaxTotalConnections   int
+
+	// WSMaxMessageBytes is the largest frame a client may send; larger
+	// frames close the connection
+	WSMaxMessageBytes int
+
+	// WSMaxInFlight caps unacked deliver messages per connection; further
+	// messages wait in the inbox. 0 disables the window.
+	WSMaxInFlight int
+
+	// WSIdleTimeout disconnects clients that send nothing for this long
+	WSIdleTimeout time.Duration
+
+	// WSAckTimeout returns delivered-but-unacked messages to the inbox
+	WSAckTimeout time.Duration
+
+	// WSPingInterval and WSPongTimeout control protocol-level keepalive pings;
+	// a connection that misses a pong is closed as half-open
+	WSPingInterval time.Duration
+	WSPongTimeout  time.Duration
+
+	// WSMaxClockSkew rejects sends whose client timestamp is further than
+	// this from server time. 0 disables the check.
+	WSMaxClockSkew time.Duration
+
+	// ClusterMode publishes deliveries to other chat servers via Redis.
+	// Single-node deploys can turn it off to skip the publish overhead.
+	ClusterMode bool
+
+	// GroupFanoutThreshold is the member count above which group messages
+	// are fanned out by a queue worker instead of the hub loop. 0 disables.
+	GroupFanoutThreshold int
+
+	// GroupMaxMembers caps group size; adds beyond it are rejected. 0 disables.
+	GroupMaxMembers int
+
+	// PresenceOfflineGrace delays offline broadcasts so a quick reconnect
+	// doesn't flap the user's presence for contacts. 0 disables.
+	PresenceOfflineGrace time.Duration
+
+	// WSRebalanceHighWaterPercent is the share of the cluster's average
+	// connection count above which WSRebalanceHintPercent of new clients are
+	// asked to reconnect elsewhere, once this server holds at least
+	// WSRebalanceMinConnections. 0 disables the hints.
+	WSRebalanceHighWaterPercent int
+	WSRebalanceHintPercent      int
+	WSRebalanceMinConnections   int
+
+	// DeviceApprovalSkipRecognized lets previously linked devices re-link
+	// without approval from the primary device, limited to
+	// DeviceApprovalSkipTypes when that is set
+	DeviceApprovalSkipRecognized bool
+	DeviceApprovalSkipTypes      []string
+
+	// VerifyMaxFailures wrong verification codes for one phone number within
+	// VerifyFailureWindow lock it out for VerifyLockoutDuration. 0 disables.
+	VerifyMaxFailures     int
+	VerifyFailureWindow   time.Duration
+	VerifyLockoutDuration time.Duration
+
+	// ReportsPerHour caps abuse reports one user may file per hour, and
+	// ReportEvidenceMaxBytes the evidence attached to each
+	ReportsPerHour         int
+	ReportEvidenceMaxBytes int
 }
 
 // Load reads configuration from Vault or environment variables
@@ -246,6 +380,9 @@ func Load() *Config {
 		MinioKey:    getEnv("MINIO_ACCESS_KEY", "minioadmin"),
 		MinioSecret: getEnv("MINIO_SECRET_KEY", "minioadmin123"),
 		MinioBucket: getEnv("MINIO_BUCKET", "encrypted-media"),
+
+		MinioPublicURL: os.Getenv("MINIO_PUBLIC_URL"),
+		MinioRegion:    getEnv("MINIO_REGION", "us-east-1"),
 		RateLimits: &RateLimitConfig{
 			IPLimits:       make(map[string]*TieredLimitConfig),
 			UserLimits:     make(map[string]*TieredLimitConfig),
@@ -273,6 +410,119 @@ func Load() *Config {
 			MaxAudioSize: getEnvInt64("MAX_AUDIO_SIZE_MB", 50) * 1024 * 1024,  // 50MB default
 			MaxFileSize:  getEnvInt64("MAX_FILE_SIZE_MB", 50) * 1024 * 1024,   // 50MB default
 		},
+		MediaURLExpiry:       time.Duration(getEnvInt64("MEDIA_URL_EXPIRY_SECONDS", 3600)) * time.Second,
+		PostgresReplicaURL:   os.Getenv("POSTGRES_REPLICA_URL"),
+		DBSlowQueryThreshold: time.Duration(getEnvInt64("DB_SLOW_QUERY_MS", 500)) * time.Millisecond,
+		DBQueryTimeout:       time.Duration(getEnvInt64("DB_QUERY_TIMEOUT_SECONDS", 5)) * time.Second,
+
+		InternalServiceToken: os.Getenv("INTERNAL_SERVICE_TOKEN"),
+
+		AdminUserIDs: getEnvList("ADMIN_USER_IDS"),
+		InboxTTL:     InboxTTLFromEnv(),
+		InboxMaxSize: getEnvInt64("INBOX_MAX_SIZE", 10000),
+
+		MaxCiphertextBytes:      int(getEnvInt64("MAX_CIPHERTEXT_KB", 64)) * 1024,
+		MaxMediaCiphertextBytes: int(getEnvInt64("MAX_MEDIA_CIPHERTEXT_KB", 256)) * 1024,
+
+		RequireOneTimePrekey: os.Getenv("REQUIRE_ONETIME_PREKEY") == "true",
+
+		GeoIPDBPath:     os.Getenv("GEOIP_DB_PATH"),
+		GeoIPStepUpTOTP: os.Getenv("GEOIP_STEPUP_TOTP") == "true",
+
+		TrustedProxies: getEnvList("TRUSTED_PROXY_CIDRS"),
+
+		WSCompression:         os.Getenv("WS_COMPRESSION") == "true",
+		WSCompressionLevel:    int(getEnvInt64("WS_COMPRESSION_LEVEL", 1)),
+		WSCompressionMinBytes: int(getEnvInt64("WS_COMPRESSION_MIN_BYTES", 1024)),
+
+		WSMaxConnectionsPerUser: int(getEnvInt64("WS_MAX_CONNECTIONS_PER_USER", 10)),
+		WSMaxTotalConnections:   int(getEnvInt64("WS_MAX_TOTAL_CONNECTIONS", 10000)),
+
+		WSMaxMessageBytes: int(getEnvInt64("WS_MAX_MESSAGE_KB", 0)) * 1024,
+
+		WSMaxInFlight: int(getEnvInt64("WS_MAX_IN_FLIGHT", 64)),
+		WSIdleTimeout: time.Duration(getEnvInt64("WS_IDLE_TIMEOUT_SECONDS", 90)) * time.Second,
+		WSAckTimeout:  time.Duration(getEnvInt64("WS_ACK_TIMEOUT_SECONDS", 30)) * time.Second,
+
+		WSMaxClockSkew: time.Duration(getEnvInt64("WS_MAX_CLOCK_SKEW_SECONDS", 300)) * time.Second,
+		WSPingInterval: time.Duration(getEnvInt64("WS_PING_INTERVAL_SECONDS", 25)) * time.Second,
+		WSPongTimeout:  time.Duration(getEnvInt64("WS_PONG_TIMEOUT_SECONDS", 10)) * time.Second,
+
+		ClusterMode:          os.Getenv("CLUSTER_MODE") != "false",
+		GroupFanoutThreshold: int(getEnvInt64("GROUP_FANOUT_THRESHOLD", 200)),
+		GroupMaxMembers:      int(getEnvInt64("GROUP_MAX_MEMBERS", 1000)),
+		PresenceOfflineGrace: time.Duration(getEnvInt64("PRESENCE_OFFLINE_GRACE_SECONDS", 5)) * time.Second,
+
+		WSRebalanceHighWaterPercent: int(getEnvInt64("WS_REBALANCE_HIGH_WATER_PERCENT", 125)),
+		WSRebalanceHintPercent:      int(getEnvInt64("WS_REBALANCE_HINT_PERCENT", 5)),
+		WSRebalanceMinConnections:   int(getEnvInt64("WS_REBALANCE_MIN_CONNECTIONS", 500)),
+
+		DeviceApprovalSkipRecognized: os.Getenv("DEVICE_APPROVAL_SKIP_RECOGNIZED") != "false",
+		DeviceApprovalSkipTypes:      getEnvList("DEVICE_APPROVAL_SKIP_TYPES"),
+
+		VerifyMaxFailures:     int(getEnvInt64("VERIFY_MAX_FAILURES", 10)),
+		VerifyFailureWindow:   time.Duration(getEnvInt64("VERIFY_FAILURE_WINDOW_SECONDS", 3600)) * time.Second,
+		VerifyLockoutDuration: time.Duration(getEnvInt64("VERIFY_LOCKOUT_SECONDS", 900)) * time.Second,
+
+		ReportsPerHour:         int(getEnvInt64("REPORTS_PER_HOUR", 10)),
+		ReportEvidenceMaxBytes: int(getEnvInt64("REPORT_EVIDENCE_MAX_KB", 16)) * 1024,
+	}
+
+	if config.WSCompressionLevel < 1 || config.WSCompressionLevel > 9 {
+		log.Fatalf("FATAL: WS_COMPRESSION_LEVEL must be between 1 and 9, got %d", config.WSCompressionLevel)
+	}
+	// A frame carries the ciphertext base64-encoded (4/3 larger) inside the
+	// JSON envelope, so the frame limit has to leave room for both
+	minFrameBytes := (config.MaxMediaCiphertextBytes+2)/3*4 + wsEnvelopeAllowance
+	if config.WSMaxMessageBytes == 0 {
+		config.WSMaxMessageBytes = max(defaultWSMaxMessageBytes, minFrameBytes)
+	} else if config.WSMaxMessageBytes < minFrameBytes {
+		log.Printf("Warning: WS_MAX_MESSAGE_KB (%d) is below the %d KB needed for MAX_MEDIA_CIPHERTEXT_KB; large media messages will be rejected",
+			config.WSMaxMessageBytes/1024, (minFrameBytes+1023)/1024)
+	}
+	if config.WSMaxConnectionsPerUser < 1 {
+		log.Fatalf("FATAL: WS_MAX_CONNECTIONS_PER_USER must be at least 1, got %d", config.WSMaxConnectionsPerUser)
+	}
+	if config.WSMaxTotalConnections < config.WSMaxConnectionsPerUser || config.WSMaxTotalConnections > math.MaxInt32 {
+		log.Fatalf("FATAL: WS_MAX_TOTAL_CONNECTIONS must be between WS_MAX_CONNECTIONS_PER_USER (%d) and %d, got %d",
+			config.WSMaxConnectionsPerUser, math.MaxInt32, config.WSMaxTotalConnections)
+	}
+	if config.WSPingInterval <= 0 || config.WSPongTimeout <= 0 {
+		log.Fatalf("FATAL: WS_PING_INTERVAL_SECONDS and WS_PONG_TIMEOUT_SECONDS must be positive, got %s and %s",
+			config.WSPingInterval, config.WSPongTimeout)
+	}
+	if config.InternalServiceToken != "" && len(config.InternalServiceToken) < 32 {
+		log.Fatalf("FATAL: INTERNAL_SERVICE_TOKEN must be at least 32 characters, got %d", len(config.InternalServiceToken))
+	}
+	if config.GroupFanoutThreshold < 0 {
+		log.Fatalf("FATAL: GROUP_FANOUT_THRESHOLD must not be negative, got %d", config.GroupFanoutThreshold)
+	}
+	if config.PresenceOfflineGrace < 0 {
+		log.Fatalf("FATAL: PRESENCE_OFFLINE_GRACE_SECONDS must not be negative, got %s", config.PresenceOfflineGrace)
+	}
+	if config.GroupMaxMembers < 0 {
+		log.Fatalf("FATAL: GROUP_MAX_MEMBERS must not be negative, got %d", config.GroupMaxMembers)
+	}
+	if config.MediaURLExpiry <= 0 {
+		log.Fatalf("FATAL: MEDIA_URL_EXPIRY_SECONDS must be positive, got %s", config.MediaURLExpiry)
+	}
+	if config.WSRebalanceHighWaterPercent != 0 && config.WSRebalanceHighWaterPercent <= 100 {
+		log.Fatalf("FATAL: WS_REBALANCE_HIGH_WATER_PERCENT must be above 100 (or 0 to disable), got %d", config.WSRebalanceHighWaterPercent)
+	}
+	if config.WSRebalanceHintPercent < 0 || config.WSRebalanceHintPercent > 100 {
+		log.Fatalf("FATAL: WS_REBALANCE_HINT_PERCENT must be between 0 and 100, got %d", config.WSRebalanceHintPercent)
+	}
+	if config.ReportsPerHour < 1 || config.ReportEvidenceMaxBytes < 1 {
+		log.Fatalf("FATAL: REPORTS_PER_HOUR and REPORT_EVIDENCE_MAX_KB must be positive, got %d and %d",
+			config.ReportsPerHour, config.ReportEvidenceMaxBytes/1024)
+	}
+
+	config.CORSOrigins = getEnvList("CORS_ORIGINS")
+	if len(config.CORSOrigins) == 0 {
+		config.CORSOrigins = defaultCORSOrigins
+	}
+	if err := validateCORSOrigins(config.CORSOrigins); err != nil {
+		log.Fatalf("FATAL: Invalid CORS_ORIGINS: %v", err)
 	}
 
 	// Validate configuration for production
@@ -283,6 +533,42 @@ func Load() *Config {
 	return config
 }
 
+// defaultWSMaxMessageBytes is the frame limit when WS_MAX_MESSAGE_KB is not
+// set, unless MAX_MEDIA_CIPHERTEXT_KB needs more
+const defaultWSMaxMessageBytes = 256 * 1024
+
+// wsEnvelopeAllowance covers the JSON fields around a frame's ciphertext
+const wsEnvelopeAllowance = 16 * 1024
+
+// defaultCORSOrigins is used when CORS_ORIGINS is not set
+var defaultCORSOrigins = []string{
+	"http://localhost:3000",
+	"http://localhost:5173",
+	"https://silentrelay.com.au",
+	"https://www.silentrelay.com.au",
+}
+
+// validateCORSOrigins checks each origin is a bare http(s) scheme://host[:port].
+// The API always allows credentials, so a "*" wildcard origin is rejected.
+func validateCORSOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			return fmt.Errorf("wildcard origin is not allowed with credentials")
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host == "" {
+			return fmt.Errorf("origin %q is not a valid URL", origin)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("origin %q must use http or https", origin)
+		}
+		if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" || parsed.Fragment != "" {
+			return fmt.Errorf("origin %q must not include a path, query or fragment", origin)
+		}
+	}
+	return nil
+}
+
 // validateProductionSecrets checks for placeholder values in production
 func validateProductionSecrets(config *Config) error {
 	nodeEnv := getEnv("NODE_ENV", "development")
@@ -350,6 +636,75 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+// InboxTTLFromEnv returns the offline inbox TTL from INBOX_TTL_DAYS (default 30).
+// Shared by the chat server and the scheduler's prune job.
+func InboxTTLFromEnv() time.Duration {
+	days := getEnvInt64("INBOX_TTL_DAYS", 30)
+	if days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// MessageRetentionFromEnv returns how long delivered messages are kept, from
+// MESSAGE_RETENTION_DAYS. Zero (the default) keeps them indefinitely.
+func MessageRetentionFromEnv() time.Duration {
+	days := getEnvInt64("MESSAGE_RETENTION_DAYS", 0)
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Compliance floors for audit retention: high and critical events are kept at
+// least this long, whatever the configuration or the event's own RetentionDays
+const (
+	MinAuditRetentionHighDays     = 365
+	MinAuditRetentionCriticalDays = 7 * 365
+)
+
+// AuditRetention is how long security audit events are kept, in days
+type AuditRetention struct {
+	DefaultDays  int // events without their own RetentionDays; 0 keeps everything
+	HighDays     int // minimum for high-severity events
+	CriticalDays int // minimum for critical events
+}
+
+// AuditRetentionFromEnv returns the audit retention policy from
+// AUDIT_RETENTION_DAYS (default 0, keep forever), AUDIT_RETENTION_HIGH_DAYS
+// and AUDIT_RETENTION_CRITICAL_DAYS. Values below the compliance floors are
+// raised to them.
+func AuditRetentionFromEnv() AuditRetention {
+	r := AuditRetention{
+		DefaultDays:  int(getEnvInt64("AUDIT_RETENTION_DAYS", 0)),
+		HighDays:     int(getEnvInt64("AUDIT_RETENTION_HIGH_DAYS", MinAuditRetentionHighDays)),
+		CriticalDays: int(getEnvInt64("AUDIT_RETENTION_CRITICAL_DAYS", MinAuditRetentionCriticalDays)),
+	}
+	if r.DefaultDays < 0 {
+		r.DefaultDays = 0
+	}
+	if r.HighDays < MinAuditRetentionHighDays {
+		log.Printf("Warning: AUDIT_RETENTION_HIGH_DAYS=%d is below the compliance minimum, using %d", r.HighDays, MinAuditRetentionHighDays)
+		r.HighDays = MinAuditRetentionHighDays
+	}
+	if r.CriticalDays < MinAuditRetentionCriticalDays {
+		log.Printf("Warning: AUDIT_RETENTION_CRITICAL_DAYS=%d is below the compliance minimum, using %d", r.CriticalDays, MinAuditRetentionCriticalDays)
+		r.CriticalDays = MinAuditRetentionCriticalDays
+	}
+	return r
+}
+
+// getEnvList parses a comma-separated environment variable, skipping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 // MustGetEnv retrieves an environment variable or fails if not set
 func MustGetEnv(key string) string {
 	value := os.Getenv(key)
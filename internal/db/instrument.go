@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+)
+
+// DefaultSlowQueryThreshold is how long a statement may take before it is
+// logged (overridable via SetSlowQueryThreshold)
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// DefaultQueryTimeout bounds the statements of context-aware methods when the
+// caller's context has no earlier deadline (overridable via SetQueryTimeout)
+const DefaultQueryTimeout = 5 * time.Second
+
+// queryTimer records latency and errors for every statement, labelled with
+// the PostgresDB method that issued it, and logs the slow ones
+type queryTimer struct {
+	slowThreshold time.Duration
+	timeout       time.Duration
+}
+
+// withTimeout derives the context a context-aware method runs its
+// statements under. The method must call cancel once it is done with rows.
+func (q *queryTimer) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, q.timeout)
+}
+
+// queryDB is a *sql.DB whose Query, QueryRow, Exec and transactions are timed
+type queryDB struct {
+	*sql.DB
+	*queryTimer
+}
+
+// queryTx is a *sql.Tx whose statements are timed like queryDB's
+type queryTx struct {
+	*sql.Tx
+	*queryTimer
+}
+
+func (d *queryDB) Query(query string, args ...any) (*sql.Rows, error) {
+	name, start := queryName(), time.Now()
+	rows, err := d.DB.Query(query, args...)
+	d.observe(name, start, err)
+	return rows, err
+}
+
+func (d *queryDB) QueryRow(query string, args ...any) *sql.Row {
+	name, start := queryName(), time.Now()
+	row := d.DB.QueryRow(query, args...)
+	d.observe(name, start, row.Err())
+	return row
+}
+
+func (d *queryDB) Exec(query string, args ...any) (sql.Result, error) {
+	name, start := queryName(), time.Now()
+	result, err := d.DB.Exec(query, args...)
+	d.observe(name, start, err)
+	return result, err
+}
+
+func (d *queryDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	name, start := queryName(), time.Now()
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	d.observe(name, start, err)
+	return rows, err
+}
+
+func (d *queryDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	name, start := queryName(), time.Now()
+	row := d.DB.QueryRowContext(ctx, query, args...)
+	d.observe(name, start, row.Err())
+	return row
+}
+
+func (d *queryDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	name, start := queryName(), time.Now()
+	result, err := d.DB.ExecContext(ctx, query, args...)
+	d.observe(name, start, err)
+	return result, err
+}
+
+func (d *queryDB) Begin() (*queryTx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &queryTx{Tx: tx, queryTimer: d.queryTimer}, nil
+}
+
+func (t *queryTx) Query(query string, args ...any) (*sql.Rows, error) {
+	name, start := queryName(), time.Now()
+	rows, err := t.Tx.Query(query, args...)
+	t.observe(name, start, err)
+	return rows, err
+}
+
+func (t *queryTx) QueryRow(query string, args ...any) *sql.Row {
+	name, start := queryName(), time.Now()
+	row := t.Tx.QueryRow(query, args...)
+	t.observe(name, start, row.Err())
+	return row
+}
+
+func (t *queryTx) Exec(query string, args ...any) (sql.Result, error) {
+	name, start := queryName(), time.Now()
+	result, err := t.Tx.Exec(query, args...)
+	t.observe(name, start, err)
+	return result, err
+}
+
+func (t *queryTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	name, start := queryName(), time.Now()
+	row := t.Tx.QueryRowContext(ctx, query, args...)
+	t.observe(name, start, row.Err())
+	return row
+}
+
+func (t *queryTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	name, start := queryName(), time.Now()
+	result, err := t.Tx.ExecContext(ctx, query, args...)
+	t.observe(name, start, err)
+	return result, err
+}
+
+// observe records one statement. sql.ErrNoRows is an answer, not a failure.
+func (q *queryTimer) observe(name string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	metrics.RecordDBQuery(name, elapsed, err != nil && !errors.Is(err, sql.ErrNoRows))
+
+	if q.slowThreshold > 0 && elapsed > q.slowThreshold {
+		log.Printf("[SlowQuery] %s took %s", name, elapsed.Round(time.Millisecond))
+	}
+}
+
+// queryName returns the name of the method that called the timed wrapper,
+// e.g. "GetMessage" for (*PostgresDB).GetMessage or a closure inside it.
+// SQL text is never used as a label: it is unbounded and may embed user data.
+func queryName() string {
+	pc := make([]uintptr, 1)
+	// Skip runtime.Callers, queryName and the wrapper itself
+	if runtime.Callers(3, pc) == 0 {
+		return "unknown"
+	}
+	frame, _ := runtime.CallersFrames(pc).Next()
+
+	name := frame.Function
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ")."); i >= 0 {
+		name = name[i+2:]
+	} else if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		return "unknown"
+	}
+	return name
+}
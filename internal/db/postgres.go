@@ -1,22 +1,37 @@
 package db
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jaydenbeard/messaging-app/internal/security"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PostgresDB wraps the database connection
 type PostgresDB struct {
-	db *sql.DB
+	db *queryDB
+
+	// Optional read replica for read-heavy queries that tolerate replication
+	// lag; nil means everything runs on the primary
+	replica *queryDB
+
+	// Statement timing shared by both connections
+	timer *queryTimer
+
+	// Max members per group (0 = unlimited)
+	maxGroupMembers int
 }
 
 // Message represents a stored message
@@ -59,26 +74,118 @@ func NewPostgresDB(connStr string) (*PostgresDB, error) {
 		return nil, err
 	}
 
-	return &PostgresDB{db: db}, nil
+	timer := &queryTimer{slowThreshold: DefaultSlowQueryThreshold, timeout: DefaultQueryTimeout}
+	return &PostgresDB{
+		db:              &queryDB{DB: db, queryTimer: timer},
+		timer:           timer,
+		maxGroupMembers: DefaultMaxGroupMembers,
+	}, nil
+}
+
+// SetSlowQueryThreshold sets how long a statement may take before it is
+// logged. Zero disables the log; latency metrics are always recorded.
+func (p *PostgresDB) SetSlowQueryThreshold(d time.Duration) {
+	p.timer.slowThreshold = d
+}
+
+// SetQueryTimeout bounds each call to a context-aware method (those taking a
+// ctx) unless the caller's context expires first. Zero leaves only the
+// caller's deadline.
+func (p *PostgresDB) SetQueryTimeout(d time.Duration) {
+	p.timer.timeout = d
+}
+
+// ConnectReplica opens a connection to a read replica. Searches, group
+// member lists, contact lookups and conversation lists are then served from
+// it; writes and reads that must see them stay on the primary.
+func (p *PostgresDB) ConnectReplica(connStr string) error {
+	replica, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+
+	replica.SetMaxOpenConns(25)
+	replica.SetMaxIdleConns(5)
+	replica.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := replica.Ping(); err != nil {
+		_ = replica.Close()
+		return err
+	}
+
+	p.replica = &queryDB{DB: replica, queryTimer: p.timer}
+	return nil
 }
 
-// Close closes the database connection
+// reader returns the connection for lag-tolerant reads: the replica if one
+// is connected, otherwise the primary
+func (p *PostgresDB) reader() *queryDB {
+	if p.replica != nil {
+		return p.replica
+	}
+	return p.db
+}
+
+// Close closes the database connections
 func (p *PostgresDB) Close() error {
+	if p.replica != nil {
+		if err := p.replica.Close(); err != nil {
+			log.Printf("Warning: failed to close replica connection: %v", err)
+		}
+	}
 	return p.db.Close()
 }
 
+// Ping checks that the primary is reachable (for readiness checks)
+func (p *PostgresDB) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
 // GetDB returns the underlying *sql.DB connection (for audit logging)
 func (p *PostgresDB) GetDB() *sql.DB {
-	return p.db
+	return p.db.DB
 }
 
-// SaveMessage stores an encrypted message
-func (p *PostgresDB) SaveMessage(msg *Message) error {
+// WithTx runs fn in a transaction. It commits if fn returns nil and rolls
+// back otherwise, so fn just returns the first error it hits.
+func (p *PostgresDB) WithTx(fn func(*sql.Tx) error) error {
+	return p.withTx(func(tx *queryTx) error {
+		return fn(tx.Tx)
+	})
+}
+
+// withTx is WithTx for methods in this package; statements run through tx
+// are timed like any other
+func (p *PostgresDB) withTx(fn func(*queryTx) error) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveMessage stores an encrypted message. The message ID doubles as the
+// sender's idempotency key: storing it again returns ErrDuplicateMessage
+// instead of a second copy.
+func (p *PostgresDB) SaveMessage(ctx context.Context, msg *Message) error {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO messages (message_id, sender_id, receiver_id, group_id, ciphertext, message_type, media_id, media_type, timestamp, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (message_id) DO NOTHING`
 
-	_, err := p.db.Exec(query,
+	result, err := p.db.ExecContext(ctx, query,
 		msg.MessageID,
 		msg.SenderID,
 		msg.ReceiverID,
@@ -90,9 +197,38 @@ func (p *PostgresDB) SaveMessage(msg *Message) error {
 		msg.Timestamp,
 		msg.Status,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	// The ID is taken: a retry of a message this sender already stored, or
+	// a collision with someone else's message, which is refused
+	var senderID uuid.UUID
+	var timestamp time.Time
+	err = p.db.QueryRowContext(ctx, `
+		SELECT sender_id, timestamp FROM messages WHERE message_id = $1`,
+		msg.MessageID).Scan(&senderID, &timestamp)
+	if err != nil {
+		return err
+	}
+	if senderID != msg.SenderID {
+		return ErrMessageIDTaken
+	}
+	msg.Timestamp = timestamp
+	return ErrDuplicateMessage
 }
 
+// ErrDuplicateMessage is returned by SaveMessage when the sender already
+// stored a message with this ID. msg.Timestamp is set to the stored one.
+var ErrDuplicateMessage = errors.New("message already stored")
+
+// ErrMessageIDTaken is returned by SaveMessage when another sender's message
+// already has this ID
+var ErrMessageIDTaken = errors.New("message ID already in use")
+
 // GetMessage retrieves a message by ID
 func (p *PostgresDB) GetMessage(messageID uuid.UUID) (*Message, error) {
 	query := `
@@ -162,6 +298,139 @@ func (p *PostgresDB) GetPendingMessages(userID uuid.UUID) ([]*Message, error) {
 	return messages, nil
 }
 
+// MessageSearchFilter narrows SearchMessages. Zero fields match everything.
+type MessageSearchFilter struct {
+	SenderID  *uuid.UUID
+	GroupID   *uuid.UUID
+	MediaType string // e.g. "image"; implies HasMedia
+	HasMedia  bool
+	Since     time.Time // inclusive
+	Until     time.Time // exclusive
+	Limit     int
+	Cursor    string // from the previous page
+}
+
+// MessageMetadata is a message without its ciphertext
+type MessageMetadata struct {
+	MessageID   uuid.UUID  `json:"message_id"`
+	SenderID    uuid.UUID  `json:"sender_id"`
+	ReceiverID  *uuid.UUID `json:"receiver_id,omitempty"`
+	GroupID     *uuid.UUID `json:"group_id,omitempty"`
+	MessageType string     `json:"message_type"`
+	MediaID     *uuid.UUID `json:"media_id,omitempty"`
+	MediaType   string     `json:"media_type,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+	Status      string     `json:"status"`
+}
+
+// messageCursor is the keyset position of the last row of a search page
+type messageCursor struct {
+	Timestamp time.Time `json:"t"`
+	MessageID uuid.UUID `json:"id"`
+}
+
+// SearchMessages returns a page of metadata for messages the user can see,
+// newest first, and the cursor for the next page (empty on the last page).
+// Content is end-to-end encrypted, so only metadata can be searched; the
+// client fetches and decrypts the matches itself. Visible messages are the
+// user's direct messages and those of groups they belong to, sent since they
+// joined. Deleted and expired messages are left out.
+func (p *PostgresDB) SearchMessages(ctx context.Context, userID uuid.UUID, filter MessageSearchFilter) ([]MessageMetadata, string, error) {
+	var after *messageCursor
+	if filter.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(filter.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = &messageCursor{}
+		if err := json.Unmarshal(raw, after); err != nil || after.MessageID == uuid.Nil {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	var since, until, afterTime sql.NullTime
+	if !filter.Since.IsZero() {
+		since = sql.NullTime{Time: filter.Since, Valid: true}
+	}
+	if !filter.Until.IsZero() {
+		until = sql.NullTime{Time: filter.Until, Valid: true}
+	}
+	afterID := uuid.Nil
+	if after != nil {
+		afterTime = sql.NullTime{Time: after.Timestamp, Valid: true}
+		afterID = after.MessageID
+	}
+
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	// One branch per way a message can be visible, each walking its own
+	// (party, timestamp) index newest first, so a page reads about Limit rows
+	// per branch however much history the user has
+	filters := `
+		  AND m.is_deleted = false
+		  AND (m.expires_at IS NULL OR m.expires_at > NOW())
+		  AND ($2::uuid IS NULL OR m.sender_id = $2)
+		  AND ($3::uuid IS NULL OR m.group_id = $3)
+		  AND ($4::text = '' OR m.media_type = $4)
+		  AND (NOT $5::boolean OR m.media_id IS NOT NULL)
+		  AND ($6::timestamptz IS NULL OR m.timestamp >= $6)
+		  AND ($7::timestamptz IS NULL OR m.timestamp < $7)
+		  AND ($8::timestamptz IS NULL OR (m.timestamp, m.message_id) < ($8::timestamptz, $9::uuid))
+		ORDER BY m.timestamp DESC, m.message_id DESC
+		LIMIT $10`
+	columns := `m.message_id, m.sender_id, m.receiver_id, m.group_id, m.message_type,
+		       m.media_id, m.media_type, m.timestamp, m.status`
+
+	rows, err := p.reader().QueryContext(ctx, `
+		SELECT * FROM (
+			(SELECT `+columns+` FROM messages m
+			 WHERE m.group_id IS NULL AND m.sender_id = $1 `+filters+`)
+			UNION ALL
+			(SELECT `+columns+` FROM messages m
+			 WHERE m.group_id IS NULL AND m.receiver_id = $1 AND m.sender_id <> $1 `+filters+`)
+			UNION ALL
+			(SELECT `+columns+` FROM messages m
+			 JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = $1
+			 WHERE m.group_id IS NOT NULL AND m.timestamp >= gm.joined_at `+filters+`)
+		) visible
+		ORDER BY visible.timestamp DESC, visible.message_id DESC
+		LIMIT $10
+	`, userID, filter.SenderID, filter.GroupID, filter.MediaType, filter.HasMedia || filter.MediaType != "",
+		since, until, afterTime, afterID, filter.Limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	messages := []MessageMetadata{}
+	for rows.Next() {
+		var m MessageMetadata
+		var mediaType sql.NullString
+		if err := rows.Scan(&m.MessageID, &m.SenderID, &m.ReceiverID, &m.GroupID, &m.MessageType,
+			&m.MediaID, &mediaType, &m.Timestamp, &m.Status); err != nil {
+			return nil, "", err
+		}
+		m.MediaType = mediaType.String
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(messages) == filter.Limit {
+		last := messages[len(messages)-1]
+		raw, _ := json.Marshal(messageCursor{Timestamp: last.Timestamp, MessageID: last.MessageID})
+		next = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return messages, next, nil
+}
+
 // UpdateMessageStatus updates the delivery status of a message
 func (p *PostgresDB) UpdateMessageStatus(messageID uuid.UUID, status string, timestamp time.Time) error {
 	var query string
@@ -179,6 +448,153 @@ func (p *PostgresDB) UpdateMessageStatus(messageID uuid.UUID, status string, tim
 	return err
 }
 
+// ErrNotMessageRecipient is returned when a user updates the status of a
+// message they didn't receive (or that doesn't exist)
+var ErrNotMessageRecipient = errors.New("not a recipient of the message")
+
+// UpdateMessageStatuses marks messages delivered or read on behalf of a
+// recipient, in one transaction. The user must be a recipient of every
+// message: the receiver of a direct message, or a group member other than
+// the sender. Otherwise nothing changes and ErrNotMessageRecipient is
+// returned. Statuses never move backwards. Returns the messages whose status
+// changed, so receipts are sent to their senders only once. A group message
+// counts as read once per member.
+func (p *PostgresDB) UpdateMessageStatuses(userID uuid.UUID, messageIDs []uuid.UUID, status string, at time.Time) ([]*Message, error) {
+	var update string
+	switch status {
+	case "delivered":
+		update = `UPDATE messages SET status = 'delivered', delivered_at = $2 WHERE message_id = ANY($1::uuid[]) AND status = 'sent' RETURNING message_id`
+	case "read":
+		update = `UPDATE messages SET status = 'read', read_at = $2 WHERE message_id = ANY($1::uuid[]) AND status <> 'read' RETURNING message_id`
+	default:
+		return nil, fmt.Errorf("invalid message status: %s", status)
+	}
+
+	ids := make([]string, len(messageIDs))
+	for i, id := range messageIDs {
+		ids[i] = id.String()
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	rows, err := tx.Query(`
+		SELECT m.message_id, m.sender_id, m.receiver_id, m.group_id,
+			m.group_id IS NOT NULL AND EXISTS (
+				SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = $2
+			)
+		FROM messages m
+		WHERE m.message_id = ANY($1::uuid[]) AND m.is_deleted = false
+		FOR UPDATE OF m`, pq.Array(ids), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(messageIDs))
+	seen := make(map[uuid.UUID]bool, len(messageIDs))
+	for rows.Next() {
+		var msg Message
+		var isMember bool
+		if err := rows.Scan(&msg.MessageID, &msg.SenderID, &msg.ReceiverID, &msg.GroupID, &isMember); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		recipient := msg.SenderID != userID &&
+			((msg.GroupID == nil && msg.ReceiverID != nil && *msg.ReceiverID == userID) || (msg.GroupID != nil && isMember))
+		if !recipient {
+			_ = rows.Close()
+			return nil, ErrNotMessageRecipient
+		}
+		seen[msg.MessageID] = true
+		messages = append(messages, &msg)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range messageIDs {
+		if !seen[id] {
+			return nil, ErrNotMessageRecipient
+		}
+	}
+
+	changed := make(map[uuid.UUID]bool, len(messageIDs))
+	updatedRows, err := tx.Query(update, pq.Array(ids), at)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanMessageIDs(updatedRows, changed); err != nil {
+		return nil, err
+	}
+
+	// Group messages share one status row; track each member's read state separately
+	if status == "read" {
+		readRows, err := tx.Query(`
+			INSERT INTO group_message_reads (message_id, user_id, read_at)
+			SELECT message_id, $2, $3 FROM messages
+			WHERE message_id = ANY($1::uuid[]) AND group_id IS NOT NULL
+			ON CONFLICT (message_id, user_id) DO NOTHING
+			RETURNING message_id`, pq.Array(ids), userID, at)
+		if err != nil {
+			return nil, err
+		}
+		if err := scanMessageIDs(readRows, changed); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	updated := messages[:0]
+	for _, msg := range messages {
+		if changed[msg.MessageID] {
+			updated = append(updated, msg)
+		}
+	}
+	return updated, nil
+}
+
+// scanMessageIDs reads a single message_id column into set and closes rows
+func scanMessageIDs(rows *sql.Rows, set map[uuid.UUID]bool) error {
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		set[id] = true
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// MarkMessageDeleted soft-deletes a message, only on behalf of its sender.
+// Returns false if the message doesn't exist or belongs to someone else.
+func (p *PostgresDB) MarkMessageDeleted(messageID, senderID uuid.UUID) (bool, error) {
+	result, err := p.db.Exec(`UPDATE messages SET is_deleted = true WHERE message_id = $1 AND sender_id = $2`, messageID, senderID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
 // GetMessagedUsers returns all user IDs who have exchanged messages with the given user
 // This is used for targeted presence broadcasting (privacy-first: only send presence to contacts)
 func (p *PostgresDB) GetMessagedUsers(userID uuid.UUID) ([]uuid.UUID, error) {
@@ -194,7 +610,7 @@ func (p *PostgresDB) GetMessagedUsers(userID uuid.UUID) ([]uuid.UUID, error) {
 			AND group_id IS NULL
 		ORDER BY contact_id`
 
-	rows, err := p.db.Query(query, userID)
+	rows, err := p.reader().Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -215,11 +631,151 @@ func (p *PostgresDB) GetMessagedUsers(userID uuid.UUID) ([]uuid.UUID, error) {
 	return contacts, nil
 }
 
-// GetGroupMembers returns all members of a group
-func (p *PostgresDB) GetGroupMembers(groupID uuid.UUID) ([]GroupMember, error) {
+// ConversationSummary is the metadata needed to render one row of the inbox.
+// It never includes ciphertext.
+type ConversationSummary struct {
+	ConversationID   uuid.UUID `json:"conversation_id"`   // Peer user ID or group ID
+	ConversationType string    `json:"conversation_type"` // "direct" or "group"
+	LastMessageID    uuid.UUID `json:"last_message_id"`
+	LastMessageAt    time.Time `json:"last_message_at"`
+	UnreadCount      int       `json:"unread_count"`
+}
+
+// GetConversationSummaries returns the user's conversations, most recent first,
+// with the latest message and the number of unread incoming messages for each.
+// Each branch of the UNION is served by one of the (party, timestamp) indexes.
+func (p *PostgresDB) GetConversationSummaries(userID uuid.UUID, limit int) ([]ConversationSummary, error) {
+	query := `
+		WITH conv AS (
+			SELECT receiver_id AS conversation_id, 'direct' AS conversation_type,
+			       message_id, timestamp, false AS is_unread
+			FROM messages
+			WHERE sender_id = $1 AND group_id IS NULL AND receiver_id IS NOT NULL AND is_deleted = false
+			UNION ALL
+			SELECT sender_id, 'direct', message_id, timestamp, status <> 'read'
+			FROM messages
+			WHERE receiver_id = $1 AND group_id IS NULL AND is_deleted = false
+			UNION ALL
+			SELECT m.group_id, 'group', m.message_id, m.timestamp,
+			       m.sender_id <> $1 AND m.timestamp >= gm.joined_at AND NOT EXISTS (
+			           SELECT 1 FROM group_message_reads r WHERE r.message_id = m.message_id AND r.user_id = $1)
+			FROM messages m
+			JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = $1
+			WHERE m.is_deleted = false
+		),
+		ranked AS (
+			SELECT conversation_id, conversation_type, message_id, timestamp,
+			       ROW_NUMBER() OVER (PARTITION BY conversation_id ORDER BY timestamp DESC, message_id DESC) AS rn,
+			       COUNT(*) FILTER (WHERE is_unread) OVER (PARTITION BY conversation_id) AS unread
+			FROM conv
+		)
+		SELECT conversation_id, conversation_type, message_id, timestamp, unread
+		FROM ranked
+		WHERE rn = 1
+		ORDER BY timestamp DESC
+		LIMIT $2`
+
+	rows, err := p.reader().Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	summaries := make([]ConversationSummary, 0)
+	for rows.Next() {
+		var s ConversationSummary
+		if err := rows.Scan(&s.ConversationID, &s.ConversationType, &s.LastMessageID, &s.LastMessageAt, &s.UnreadCount); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// UnreadCounts is a user's unread message badge state
+type UnreadCounts struct {
+	Total         int               `json:"total"`
+	Conversations map[uuid.UUID]int `json:"conversations"` // Peer user ID or group ID -> unread count
+}
+
+// GetUnreadCounts returns the number of unread incoming messages, in total and
+// per conversation. Direct messages are unread until their status is 'read';
+// group messages are unread until the user has a row in group_message_reads.
+func (p *PostgresDB) GetUnreadCounts(userID uuid.UUID) (*UnreadCounts, error) {
+	query := `
+		SELECT sender_id, COUNT(*)
+		FROM messages
+		WHERE receiver_id = $1 AND group_id IS NULL AND status <> 'read' AND is_deleted = false
+		GROUP BY sender_id
+		UNION ALL
+		SELECT m.group_id, COUNT(*)
+		FROM messages m
+		JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = $1
+		WHERE m.sender_id <> $1
+			AND m.timestamp >= gm.joined_at
+			AND m.is_deleted = false
+			AND NOT EXISTS (
+				SELECT 1 FROM group_message_reads r WHERE r.message_id = m.message_id AND r.user_id = $1
+			)
+		GROUP BY m.group_id`
+
+	rows, err := p.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	counts := &UnreadCounts{Conversations: make(map[uuid.UUID]int)}
+	for rows.Next() {
+		var conversationID uuid.UUID
+		var count int
+		if err := rows.Scan(&conversationID, &count); err != nil {
+			return nil, err
+		}
+		counts.Conversations[conversationID] = count
+		counts.Total += count
+	}
+	return counts, rows.Err()
+}
+
+// MarkGroupMessageRead records that a group member has read a group message
+func (p *PostgresDB) MarkGroupMessageRead(messageID, userID uuid.UUID, readAt time.Time) error {
+	query := `
+		INSERT INTO group_message_reads (message_id, user_id, read_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id, user_id) DO NOTHING`
+	_, err := p.db.Exec(query, messageID, userID, readAt)
+	return err
+}
+
+// GetGroupMembers returns all members of a group from the primary. Use it
+// for delivery and membership checks, which must see changes immediately.
+func (p *PostgresDB) GetGroupMembers(ctx context.Context, groupID uuid.UUID) ([]GroupMember, error) {
+	return p.queryGroupMembers(ctx, p.db, groupID)
+}
+
+// ListGroupMembers returns all members of a group for display. It reads from
+// the replica when there is one, so a membership change can take replication
+// lag to show.
+func (p *PostgresDB) ListGroupMembers(ctx context.Context, groupID uuid.UUID) ([]GroupMember, error) {
+	return p.queryGroupMembers(ctx, p.reader(), groupID)
+}
+
+func (p *PostgresDB) queryGroupMembers(ctx context.Context, q *queryDB, groupID uuid.UUID) ([]GroupMember, error) {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT user_id, role, joined_at FROM group_members WHERE group_id = $1`
 
-	rows, err := p.db.Query(query, groupID)
+	rows, err := q.QueryContext(ctx, query, groupID)
 	if err != nil {
 		return nil, err
 	}
@@ -342,7 +898,10 @@ func (p *PostgresDB) GetUserByID(userID uuid.UUID) (map[string]interface{}, erro
 }
 
 // GetUserKeys retrieves a user's public keys for E2EE session establishment
-func (p *PostgresDB) GetUserKeys(userID uuid.UUID) (map[string]interface{}, error) {
+func (p *PostgresDB) GetUserKeys(ctx context.Context, userID uuid.UUID) (map[string]interface{}, error) {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
 	// Get identity and signed pre-key, plus display name for UI
 	query := `
 		SELECT public_identity_key, public_signed_prekey, signed_prekey_signature,
@@ -351,7 +910,7 @@ func (p *PostgresDB) GetUserKeys(userID uuid.UUID) (map[string]interface{}, erro
 		FROM users WHERE user_id = $1`
 
 	var identityKey, signedPrekey, signedPrekeySig, displayName, username string
-	err := p.db.QueryRow(query, userID).Scan(&identityKey, &signedPrekey, &signedPrekeySig, &displayName, &username)
+	err := p.db.QueryRowContext(ctx, query, userID).Scan(&identityKey, &signedPrekey, &signedPrekeySig, &displayName, &username)
 	if err != nil {
 		return nil, err
 	}
@@ -378,23 +937,48 @@ func (p *PostgresDB) GetUserKeys(userID uuid.UUID) (map[string]interface{}, erro
 
 	var prekeyID int
 	var prekeyPublic string
-	err = p.db.QueryRow(prekeyQuery, userID).Scan(&prekeyID, &prekeyPublic)
+	err = p.db.QueryRowContext(ctx, prekeyQuery, userID).Scan(&prekeyID, &prekeyPublic)
 	if err == nil {
 		result["onetime_prekey_id"] = prekeyID
 		result["onetime_prekey"] = prekeyPublic
 	}
-	// If no one-time pre-key available, session still works (just less forward secrecy)
+	// If no one-time pre-key available, session still works (just less forward secrecy).
+	// Flag it so the requesting client can decide whether to proceed or wait.
+	result["prekey_available"] = err == nil
+
+	remaining, err := p.countUnusedPreKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	result["prekeys_remaining"] = remaining
 
 	return result, nil
 }
 
+// CountUnusedPreKeys returns how many one-time pre-keys a user has left
+func (p *PostgresDB) CountUnusedPreKeys(userID uuid.UUID) (int, error) {
+	return p.countUnusedPreKeys(context.Background(), userID)
+}
+
+func (p *PostgresDB) countUnusedPreKeys(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	err := p.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM prekeys WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// GetIdentityKey returns a user's current public identity key
+func (p *PostgresDB) GetIdentityKey(userID uuid.UUID) (string, error) {
+	var identityKey string
+	err := p.db.QueryRow(`SELECT public_identity_key FROM users WHERE user_id = $1`, userID).Scan(&identityKey)
+	return identityKey, err
+}
+
 // UpdateUserKeys updates a user's public cryptographic keys
 // Returns true if the identity key changed (triggers security notification)
 // This is used when a user sets up encryption on a new device
 func (p *PostgresDB) UpdateUserKeys(userID uuid.UUID, identityKey, signedPrekey, signedPrekeySig string) (bool, error) {
 	// First, get the current identity key to check if it changed
-	var currentIdentityKey string
-	err := p.db.QueryRow(`SELECT public_identity_key FROM users WHERE user_id = $1`, userID).Scan(&currentIdentityKey)
+	currentIdentityKey, err := p.GetIdentityKey(userID)
 	if err != nil {
 		return false, fmt.Errorf("failed to get current identity key: %w", err)
 	}
@@ -428,20 +1012,75 @@ func (p *PostgresDB) UpdateUserKeys(userID uuid.UUID, identityKey, signedPrekey,
 	return identityKeyChanged, nil
 }
 
-// SavePreKeys stores a batch of one-time pre-keys
+// SavePreKeys stores a batch of one-time pre-keys atomically.
+// Pre-key IDs the user already has are skipped, so a retried upload is safe.
+// Returns the number of pre-keys actually inserted.
 func (p *PostgresDB) SavePreKeys(userID uuid.UUID, prekeys []struct {
 	ID        int
 	PublicKey string
-}) error {
-	query := `INSERT INTO prekeys (user_id, prekey_id, public_key) VALUES ($1, $2, $3)`
+}) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
 
+	query := `
+		INSERT INTO prekeys (user_id, prekey_id, public_key) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, prekey_id) DO NOTHING`
+
+	inserted := 0
 	for _, pk := range prekeys {
-		_, err := p.db.Exec(query, userID, pk.ID, pk.PublicKey)
+		result, err := tx.Exec(query, userID, pk.ID, pk.PublicKey)
 		if err != nil {
-			return err
+			return 0, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
 		}
+		inserted += int(rows)
 	}
-	return nil
+
+	// A fresh upload counts as key maintenance for the scheduler's rotation check
+	_, err = tx.Exec(`UPDATE users SET signed_prekey_updated_at = NOW() WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+// ErrUsernameTaken is returned when another user already has the username
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// isUniqueViolation reports whether err is a unique violation on constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// ClaimUsername sets a user's username. The unique index on LOWER(username)
+// makes the availability check and the write a single atomic step, so of two
+// users racing for the same name exactly one gets it; the other gets
+// ErrUsernameTaken. CheckUsernameAvailable is only a hint for the UI.
+func (p *PostgresDB) ClaimUsername(userID uuid.UUID, username string) error {
+	if !security.ValidateUsername(username) {
+		return fmt.Errorf("invalid username format")
+	}
+
+	_, err := p.db.Exec(`UPDATE users SET username = $2 WHERE user_id = $1`, userID, username)
+	if isUniqueViolation(err, "idx_users_username") {
+		return ErrUsernameTaken
+	}
+	return err
 }
 
 // CheckUsernameAvailable checks if a username is available
@@ -509,58 +1148,228 @@ func (p *PostgresDB) UpdateUser(userID uuid.UUID, fields map[string]interface{})
 		strings.Join(setClauses, ", "), i)
 
 	_, err := p.db.Exec(query, args...)
+	if isUniqueViolation(err, "idx_users_username") {
+		return ErrUsernameTaken
+	}
 	return err
 }
 
-// DeleteUser permanently deletes a user and all associated data
-func (p *PostgresDB) DeleteUser(userID uuid.UUID) error {
-	// First, get the user's phone number (outside transaction)
-	var phoneNumber string
-	_ = p.db.QueryRow("SELECT phone_number FROM users WHERE user_id = $1", userID).Scan(&phoneNumber)
+// SetUserActive enables or disables a user account. Returns sql.ErrNoRows if
+// the user doesn't exist.
+func (p *PostgresDB) SetUserActive(userID uuid.UUID, active bool) error {
+	result, err := p.db.Exec(`UPDATE users SET is_active = $2 WHERE user_id = $1`, userID, active)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	// Pre-cleanup: delete data from tables that might not have proper CASCADE
-	// These are done outside the main transaction so they don't abort it
+// IsUserActive checks if a user account exists and is enabled
+func (p *PostgresDB) IsUserActive(userID uuid.UUID) (bool, error) {
+	var active bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1 AND is_active = true)
+	`, userID).Scan(&active)
+	return active, err
+}
+
+// DeleteUser permanently deletes a user and all associated data. Everything
+// happens in one transaction: if any step fails nothing is deleted and the
+// error is returned. Groups the user created pass to a remaining member, or
+// are deleted with their messages if none are left.
+//
+// Media storage is not transactional, so the user's stored objects are
+// returned for the caller to remove once the rows are gone.
+func (p *PostgresDB) DeleteUser(userID uuid.UUID) (*UserStorage, error) {
+	storage := &UserStorage{PendingUploads: make(map[string]string)}
+	err := p.withTx(func(tx *queryTx) error {
+		var phoneNumber string
+		err := tx.QueryRow("SELECT phone_number FROM users WHERE user_id = $1 FOR UPDATE", userID).Scan(&phoneNumber)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found: %s", userID)
+		}
+		if err != nil {
+			return err
+		}
 
-	// Delete messages (might not exist)
-	_, _ = p.db.Exec("DELETE FROM messages WHERE sender_id = $1 OR receiver_id = $1", userID)
+		if err := collectUserStorage(tx, userID, storage); err != nil {
+			return fmt.Errorf("list stored media: %w", err)
+		}
 
-	// Delete group memberships
-	_, _ = p.db.Exec("DELETE FROM group_members WHERE user_id = $1", userID)
+		// Ordered so every row is gone (or unlinked) before the row it
+		// references. Tables with ON DELETE CASCADE are still listed where
+		// older schemas may lack the cascade.
+		steps := []struct {
+			query string
+			arg   any
+		}{
+			// Hand admin to the longest-standing member of groups where the
+			// user is the only admin, so the group isn't left unmanaged
+			{`UPDATE group_members SET role = 'admin'
+				WHERE (group_id, user_id) IN (
+					SELECT DISTINCT ON (m.group_id) m.group_id, m.user_id
+					FROM group_members m
+					JOIN group_members me ON me.group_id = m.group_id AND me.user_id = $1 AND me.role = 'admin'
+					WHERE m.user_id <> $1
+					AND NOT EXISTS (
+						SELECT 1 FROM group_members a
+						WHERE a.group_id = m.group_id AND a.role = 'admin' AND a.user_id <> $1
+					)
+					ORDER BY m.group_id, m.joined_at
+				)`, userID},
+			{"DELETE FROM group_members WHERE user_id = $1", userID},
+
+			// Messages the user sent or received, and those in groups the
+			// user created that now have no members, are deleted; replies to
+			// them from surviving messages lose their reply link
+			{`UPDATE messages SET reply_to_id = NULL
+				WHERE reply_to_id IN (
+					SELECT message_id FROM messages
+					WHERE sender_id = $1 OR receiver_id = $1
+					OR group_id IN (
+						SELECT group_id FROM groups g
+						WHERE g.created_by = $1
+						AND NOT EXISTS (SELECT 1 FROM group_members m WHERE m.group_id = g.group_id)
+					)
+				)`, userID},
+			{`DELETE FROM messages
+				WHERE sender_id = $1 OR receiver_id = $1
+				OR group_id IN (
+					SELECT group_id FROM groups g
+					WHERE g.created_by = $1
+					AND NOT EXISTS (SELECT 1 FROM group_members m WHERE m.group_id = g.group_id)
+				)`, userID},
+			{`DELETE FROM groups g
+				WHERE g.created_by = $1
+				AND NOT EXISTS (SELECT 1 FROM group_members m WHERE m.group_id = g.group_id)`, userID},
+			// Groups that still have members pass to their senior admin
+			{`UPDATE groups g SET created_by = (
+					SELECT m.user_id FROM group_members m
+					WHERE m.group_id = g.group_id
+					ORDER BY (m.role = 'admin') DESC, m.joined_at
+					LIMIT 1
+				)
+				WHERE g.created_by = $1`, userID},
+
+			{"DELETE FROM media WHERE uploader_id = $1", userID},
+
+			{"DELETE FROM media_uploads WHERE user_id = $1", userID},
+
+			// Other users' records and the security logs outlive the account.
+			// security_audit_log keeps its user and device IDs unchanged (it
+			// has no foreign keys to them) since they are covered by the
+			// audit hash chain.
+			{"UPDATE user_contacts SET matched_user_id = NULL WHERE matched_user_id = $1", userID},
+			{"UPDATE security_incidents SET affected_user_id = NULL WHERE affected_user_id = $1", userID},
+			{"DELETE FROM key_transparency_log WHERE user_id = $1", userID},
+
+			{"DELETE FROM friendships WHERE requester_id = $1 OR addressee_id = $1", userID},
+			{"DELETE FROM blocked_users WHERE blocker_id = $1 OR blocked_id = $1", userID},
+			{"DELETE FROM sealed_sender_certificates WHERE user_id = $1", userID},
+			{"DELETE FROM privacy_settings WHERE user_id = $1", userID},
+			{"DELETE FROM verification_codes WHERE phone_number = $1", phoneNumber},
+
+			// Everything referencing the user's devices, then the devices
+			{"DELETE FROM device_approval_requests WHERE user_id = $1", userID},
+			{"DELETE FROM sessions WHERE user_id = $1", userID},
+			{"DELETE FROM user_settings_sync WHERE user_id = $1", userID},
+			{"DELETE FROM devices WHERE user_id = $1", userID},
+
+			{"DELETE FROM user_pins WHERE user_id = $1", userID},
+			{"DELETE FROM prekeys WHERE user_id = $1", userID},
+		}
+		for _, step := range steps {
+			if _, err := tx.Exec(step.query, step.arg); err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(strings.Fields(step.query), " "), err)
+			}
+		}
 
-	// Delete device approval requests
-	_, _ = p.db.Exec("DELETE FROM device_approval_requests WHERE user_id = $1", userID)
+		// device_tokens is created by the push service on startup and
+		// may not exist in deployments without push
+		var hasDeviceTokens bool
+		if err := tx.QueryRow("SELECT to_regclass('device_tokens') IS NOT NULL").Scan(&hasDeviceTokens); err != nil {
+			return fmt.Errorf("check device_tokens: %w", err)
+		}
+		if hasDeviceTokens {
+			if _, err := tx.Exec("DELETE FROM device_tokens WHERE user_id = $1", userID.String()); err != nil {
+				return fmt.Errorf("delete device_tokens: %w", err)
+			}
+		}
 
-	// Delete verification codes if we have the phone number
-	if phoneNumber != "" {
-		_, _ = p.db.Exec("DELETE FROM verification_codes WHERE phone_number = $1", phoneNumber)
+		if _, err := tx.Exec("DELETE FROM users WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("delete users: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to delete user %s: %v", userID, err)
+		return nil, fmt.Errorf("failed to delete user: %w", err)
 	}
 
-	// Delete devices (in case CASCADE isn't set up)
-	_, _ = p.db.Exec("DELETE FROM devices WHERE user_id = $1", userID)
-
-	// Delete user pins
-	_, _ = p.db.Exec("DELETE FROM user_pins WHERE user_id = $1", userID)
+	log.Printf("Successfully deleted user %s and all associated data", userID)
+	return storage, nil
+}
 
-	// Delete prekeys
-	_, _ = p.db.Exec("DELETE FROM prekeys WHERE user_id = $1", userID)
+// UserStorage lists what a deleted user left in media storage. Avatars are
+// not listed; they live under the user's own avatars/ prefix.
+type UserStorage struct {
+	ObjectKeys     []string          // Uploaded media and thumbnails
+	PendingUploads map[string]string // Unfinished multipart uploads, object key to storage upload ID
+}
 
-	// Now delete the user record itself
-	result, err := p.db.Exec("DELETE FROM users WHERE user_id = $1", userID)
+// collectUserStorage fills storage with the objects the user uploaded
+func collectUserStorage(tx *queryTx, userID uuid.UUID, storage *UserStorage) error {
+	rows, err := tx.Query(`
+		SELECT blob_key FROM media WHERE uploader_id = $1
+		UNION
+		SELECT thumbnail_blob_key FROM media WHERE uploader_id = $1 AND thumbnail_blob_key IS NOT NULL
+		UNION
+		SELECT 'media/' || media_id FROM media_uploads WHERE user_id = $1 AND completed_at IS NOT NULL`, userID)
 	if err != nil {
-		log.Printf("Failed to delete user %s: %v", userID, err)
-		return fmt.Errorf("failed to delete user: %w", err)
+		return err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		storage.ObjectKeys = append(storage.ObjectKeys, key)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	if err := rows.Close(); err != nil {
+		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	rows, err = tx.Query(`
+		SELECT 'media/' || media_id, storage_upload_id FROM media_uploads
+		WHERE user_id = $1 AND completed_at IS NULL`, userID)
 	if err != nil {
-		log.Printf("Warning: Failed to get rows affected: %v", err)
+		return err
 	}
-	if rowsAffected == 0 {
-		return fmt.Errorf("user not found: %s", userID)
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		var key, uploadID string
+		if err := rows.Scan(&key, &uploadID); err != nil {
+			return err
+		}
+		storage.PendingUploads[key] = uploadID
 	}
-
-	log.Printf("Successfully deleted user %s and all associated data", userID)
-	return nil
+	return rows.Err()
 }
 
 // ============================================
@@ -588,6 +1397,7 @@ func (p *PostgresDB) RegisterDevice(userID uuid.UUID, deviceID uuid.UUID, device
 			device_name = $3,
 			last_seen = NOW(),
 			is_active = true,
+			trust_level = 'trusted',
 			is_primary = CASE WHEN $6 = true THEN $6 ELSE devices.is_primary END`
 	_, err := p.db.Exec(query, deviceID, userID, deviceName, deviceType, publicKey, isPrimary)
 	return err
@@ -625,6 +1435,67 @@ func (p *PostgresDB) GetUserDevices(userID uuid.UUID) ([]Device, error) {
 	return devices, nil
 }
 
+// GetDeviceType returns a device's type, defaulting to web like GetUserDevices
+func (p *PostgresDB) GetDeviceType(userID, deviceID uuid.UUID) (string, error) {
+	var deviceType string
+	err := p.db.QueryRow(`
+		SELECT COALESCE(device_type, 'web') FROM devices
+		WHERE device_id = $1 AND user_id = $2`, deviceID, userID).Scan(&deviceType)
+	return deviceType, err
+}
+
+// UpdateDeviceClientInfo records the platform and app version a device
+// reported. Empty values leave the stored ones unchanged.
+func (p *PostgresDB) UpdateDeviceClientInfo(userID, deviceID uuid.UUID, platform, appVersion string) error {
+	_, err := p.db.Exec(`
+		UPDATE devices SET
+			platform = COALESCE(NULLIF($3, ''), platform),
+			app_version = COALESCE(NULLIF($4, ''), app_version)
+		WHERE device_id = $1 AND user_id = $2`,
+		deviceID, userID, platform, appVersion)
+	return err
+}
+
+// ClientVersionCount is how many devices run one app version on one platform.
+// Empty fields are devices that never reported them.
+type ClientVersionCount struct {
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+	Devices    int64  `json:"devices"`
+}
+
+// GetClientVersionDistribution counts active devices seen since the given
+// time by platform and app version, most common first
+func (p *PostgresDB) GetClientVersionDistribution(ctx context.Context, since time.Time) ([]ClientVersionCount, error) {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.reader().QueryContext(ctx, `
+		SELECT COALESCE(platform, ''), COALESCE(app_version, ''), COUNT(*)
+		FROM devices
+		WHERE is_active = true AND last_seen >= $1
+		GROUP BY 1, 2
+		ORDER BY 3 DESC, 1, 2`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	counts := []ClientVersionCount{}
+	for rows.Next() {
+		var c ClientVersionCount
+		if err := rows.Scan(&c.Platform, &c.AppVersion, &c.Devices); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
 // UpdateDeviceLastSeen updates the last seen time for a device
 func (p *PostgresDB) UpdateDeviceLastSeen(deviceID uuid.UUID) error {
 	query := `UPDATE devices SET last_seen = NOW() WHERE device_id = $1`
@@ -632,13 +1503,43 @@ func (p *PostgresDB) UpdateDeviceLastSeen(deviceID uuid.UUID) error {
 	return err
 }
 
-// RemoveDevice deactivates a device
+// RemoveDevice deactivates a device. The user chose to remove it, so it is no
+// longer recognized and must be approved again to re-link.
 func (p *PostgresDB) RemoveDevice(userID, deviceID uuid.UUID) error {
-	query := `UPDATE devices SET is_active = false WHERE device_id = $1 AND user_id = $2`
+	query := `UPDATE devices SET is_active = false, trust_level = 'untrusted' WHERE device_id = $1 AND user_id = $2`
 	_, err := p.db.Exec(query, deviceID, userID)
 	return err
 }
 
+// DeactivateOtherDevices deactivates all of a user's devices except keepID
+// and returns the IDs that were deactivated. They also lose primary status so
+// a later re-link can't leave the user with two primaries, and their trust so
+// the re-link needs approval.
+func (p *PostgresDB) DeactivateOtherDevices(userID, keepID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := p.db.Query(`
+		UPDATE devices SET is_active = false, is_primary = false, trust_level = 'untrusted'
+		WHERE user_id = $1 AND device_id <> $2 AND is_active = true
+		RETURNING device_id`, userID, keepID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	var removed []uuid.UUID
+	for rows.Next() {
+		var deviceID uuid.UUID
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		removed = append(removed, deviceID)
+	}
+	return removed, rows.Err()
+}
+
 // ============================================
 // PIN MANAGEMENT (Server-side sync)
 // ============================================
@@ -677,6 +1578,77 @@ func (p *PostgresDB) DeleteUserPIN(userID uuid.UUID) error {
 	return err
 }
 
+// ErrNoPIN is returned by VerifyUserPIN when the user has not set a PIN
+var ErrNoPIN = errors.New("no PIN set")
+
+// PINVerification is the outcome of a server-side PIN check
+type PINVerification struct {
+	Valid          bool
+	FailedAttempts int
+	LockedUntil    *time.Time // Set while the PIN is locked
+}
+
+// VerifyUserPIN checks pin against the stored Argon2id hash. Failures are
+// counted and lock the PIN with escalating backoff (handle_pin_attempt);
+// while locked the PIN is not checked at all. The row is locked for the
+// duration so concurrent guesses can't share an attempt.
+func (p *PostgresDB) VerifyUserPIN(userID uuid.UUID, pin string) (*PINVerification, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	var pinHash string
+	var failed int
+	var lockedUntil sql.NullTime
+	err = tx.QueryRow(`
+		SELECT pin_hash, COALESCE(failed_attempts, 0), locked_until
+		FROM user_pins WHERE user_id = $1
+		FOR UPDATE`, userID).Scan(&pinHash, &failed, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoPIN
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return &PINVerification{FailedAttempts: failed, LockedUntil: &lockedUntil.Time}, nil
+	}
+
+	valid, err := security.NewArgon2Hasher().VerifyPassword(pin, pinHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check PIN: %w", err)
+	}
+
+	if _, err := tx.Exec(`SELECT handle_pin_attempt($1, $2)`, userID, valid); err != nil {
+		return nil, err
+	}
+
+	result := &PINVerification{Valid: valid}
+	if !valid {
+		err = tx.QueryRow(`
+			SELECT failed_attempts, locked_until FROM user_pins WHERE user_id = $1`,
+			userID).Scan(&result.FailedAttempts, &lockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		if lockedUntil.Valid {
+			result.LockedUntil = &lockedUntil.Time
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // ============================================
 // TOTP SECRET MANAGEMENT
 // ============================================
@@ -848,6 +1820,37 @@ func (p *PostgresDB) WasDeviceEverLinked(userID, deviceID uuid.UUID) (bool, erro
 	return exists, err
 }
 
+// Device trust levels used to decide whether linking needs approval
+const (
+	DeviceTrustTrusted    = "trusted"    // Linked and active
+	DeviceTrustRecognized = "recognized" // Previously linked, inactive, not removed by the user
+	DeviceTrustUntrusted  = "untrusted"  // Never linked, or removed by the user
+)
+
+// GetDeviceTrustLevel returns the trust level of a device for this user,
+// along with its stored device type (empty if the device was never linked)
+func (p *PostgresDB) GetDeviceTrustLevel(userID, deviceID uuid.UUID) (string, string, error) {
+	var isActive bool
+	var trustLevel, deviceType string
+	err := p.db.QueryRow(`
+		SELECT is_active, trust_level, COALESCE(device_type, 'web') FROM devices
+		WHERE user_id = $1 AND device_id = $2`, userID, deviceID).Scan(&isActive, &trustLevel, &deviceType)
+	if err == sql.ErrNoRows {
+		return DeviceTrustUntrusted, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case trustLevel == DeviceTrustUntrusted:
+		return DeviceTrustUntrusted, deviceType, nil
+	case isActive:
+		return DeviceTrustTrusted, deviceType, nil
+	default:
+		return DeviceTrustRecognized, deviceType, nil
+	}
+}
+
 // HasLinkedDevices checks if user has any linked devices (for first device case)
 func (p *PostgresDB) HasLinkedDevices(userID uuid.UUID) (bool, error) {
 	query := `SELECT EXISTS(SELECT 1 FROM devices WHERE user_id = $1 AND is_active = true)`
@@ -987,6 +1990,76 @@ func (p *PostgresDB) GetPrivacySettings(userID uuid.UUID) (map[string]interface{
 	}, nil
 }
 
+// GetUsersHidingOnlineStatus returns which of the given users have turned off
+// show_online_status (ghost mode), in one query
+func (p *PostgresDB) GetUsersHidingOnlineStatus(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	hidden := make(map[uuid.UUID]bool)
+	if len(userIDs) == 0 {
+		return hidden, nil
+	}
+
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := p.db.Query(`
+		SELECT user_id FROM privacy_settings
+		WHERE user_id = ANY($1::uuid[]) AND show_online_status = false`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+	return hidden, rows.Err()
+}
+
+// GetUsersHidingTypingIndicator returns which of the given users have turned
+// off show_typing_indicator, in one query
+func (p *PostgresDB) GetUsersHidingTypingIndicator(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	hidden := make(map[uuid.UUID]bool)
+	if len(userIDs) == 0 {
+		return hidden, nil
+	}
+
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := p.db.Query(`
+		SELECT user_id FROM privacy_settings
+		WHERE user_id = ANY($1::uuid[]) AND show_typing_indicator = false`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		hidden[id] = true
+	}
+	return hidden, rows.Err()
+}
+
 // UpdatePrivacySetting updates a specific privacy setting
 func (p *PostgresDB) UpdatePrivacySetting(userID uuid.UUID, setting string, value bool) error {
 	// First ensure row exists
@@ -1017,6 +2090,77 @@ func (p *PostgresDB) UpdatePrivacySetting(userID uuid.UUID, setting string, valu
 	return err
 }
 
+// ErrUnknownPrivacySetting is returned for a setting outside privacySettingNames
+var ErrUnknownPrivacySetting = errors.New("unknown privacy setting")
+
+// privacySettingNames whitelists the settings clients may change. Each name
+// is also its privacy_settings column, so it is safe to build SQL from.
+var privacySettingNames = []string{"show_read_receipts", "show_online_status", "show_last_seen", "show_typing_indicator"}
+
+// UpdatePrivacySettings applies several privacy settings in one transaction
+// so readers never see a partial update. Returns the settings as they were
+// before the update.
+func (p *PostgresDB) UpdatePrivacySettings(userID uuid.UUID, settings map[string]bool) (map[string]bool, error) {
+	for name := range settings {
+		if !slices.Contains(privacySettingNames, name) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownPrivacySetting, name)
+		}
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	_, err = tx.Exec(`
+		INSERT INTO privacy_settings (user_id, show_read_receipts, show_online_status, show_last_seen, show_typing_indicator)
+		VALUES ($1, true, true, true, true)
+		ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var showReadReceipts, showOnlineStatus, showLastSeen, showTypingIndicator bool
+	err = tx.QueryRow(`
+		SELECT show_read_receipts, show_online_status, show_last_seen, show_typing_indicator
+		FROM privacy_settings WHERE user_id = $1 FOR UPDATE`, userID).
+		Scan(&showReadReceipts, &showOnlineStatus, &showLastSeen, &showTypingIndicator)
+	if err != nil {
+		return nil, err
+	}
+	previous := map[string]bool{
+		"show_read_receipts":    showReadReceipts,
+		"show_online_status":    showOnlineStatus,
+		"show_last_seen":        showLastSeen,
+		"show_typing_indicator": showTypingIndicator,
+	}
+
+	if len(settings) > 0 {
+		sets := make([]string, 0, len(settings))
+		args := []interface{}{userID}
+		for _, name := range privacySettingNames {
+			if value, ok := settings[name]; ok {
+				args = append(args, value)
+				sets = append(sets, fmt.Sprintf("%s = $%d", name, len(args)))
+			}
+		}
+		query := `UPDATE privacy_settings SET ` + strings.Join(sets, ", ") + `, updated_at = NOW() WHERE user_id = $1`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
 // ExpireOldApprovalRequests expires old pending requests
 func (p *PostgresDB) ExpireOldApprovalRequests() error {
 	query := `UPDATE device_approval_requests SET status = 'expired' WHERE status = 'pending' AND expires_at < NOW()`
@@ -1111,28 +2255,133 @@ func (p *PostgresDB) CreateGroup(name string, creatorID uuid.UUID) (*uuid.UUID,
 		INSERT INTO group_members (group_id, user_id, role, encrypted_group_key)
 		VALUES ($1, $2, 'admin', '')`, groupID, creatorID)
 	if err != nil {
-		return nil, err
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &groupID, nil
+}
+
+// DefaultMaxGroupMembers caps group size (overridable via SetMaxGroupMembers)
+const DefaultMaxGroupMembers = 1000
+
+// ErrGroupFull is returned when adding a member would exceed the group size cap
+var ErrGroupFull = errors.New("group is full")
+
+// SetMaxGroupMembers sets how many members a group may have. Zero removes the cap.
+func (p *PostgresDB) SetMaxGroupMembers(n int) {
+	p.maxGroupMembers = n
+}
+
+// AddGroupMember adds a user to a group. The group row is locked while the
+// members are counted so concurrent adds can't overshoot the size cap.
+func (p *PostgresDB) AddGroupMember(groupID, userID uuid.UUID, encryptedKey string) error {
+	return p.withTx(func(tx *queryTx) error {
+		if _, err := tx.Exec(`SELECT 1 FROM groups WHERE group_id = $1 FOR UPDATE`, groupID); err != nil {
+			return err
+		}
+
+		if p.maxGroupMembers > 0 {
+			var memberCount int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = $1`, groupID).Scan(&memberCount); err != nil {
+				return err
+			}
+			if memberCount >= p.maxGroupMembers {
+				return ErrGroupFull
+			}
+		}
+
+		query := `INSERT INTO group_members (group_id, user_id, role, encrypted_group_key) VALUES ($1, $2, 'member', $3)`
+		_, err := tx.Exec(query, groupID, userID, encryptedKey)
+		return err
+	})
+}
+
+// RemoveGroupMember removes a user from a group
+func (p *PostgresDB) RemoveGroupMember(groupID, userID uuid.UUID) error {
+	query := `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`
+	_, err := p.db.Exec(query, groupID, userID)
+	return err
+}
+
+// ErrRekeyMembersMismatch is returned when a rekey doesn't cover exactly the current group members
+var ErrRekeyMembersMismatch = errors.New("rekey keys do not match current group members")
+
+// RekeyGroup replaces every member's encrypted group key and bumps the group's
+// key version in one transaction. keys must contain exactly the current members
+// so nobody is left on the old key and removed members don't receive the new one.
+// Returns the new key version.
+func (p *PostgresDB) RekeyGroup(groupID uuid.UUID, keys map[uuid.UUID]string) (int, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	// Lock the group row so concurrent membership changes and rekeys serialize
+	var keyVersion int
+	err = tx.QueryRow(`SELECT key_version FROM groups WHERE group_id = $1 FOR UPDATE`, groupID).Scan(&keyVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	var memberCount int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = $1`, groupID).Scan(&memberCount); err != nil {
+		return 0, err
+	}
+	if memberCount != len(keys) {
+		return 0, ErrRekeyMembersMismatch
+	}
+
+	for userID, encryptedKey := range keys {
+		result, err := tx.Exec(`
+			UPDATE group_members SET encrypted_group_key = $3
+			WHERE group_id = $1 AND user_id = $2`, groupID, userID, encryptedKey)
+		if err != nil {
+			return 0, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if rows == 0 {
+			return 0, ErrRekeyMembersMismatch
+		}
+	}
+
+	err = tx.QueryRow(`
+		UPDATE groups SET key_version = key_version + 1
+		WHERE group_id = $1
+		RETURNING key_version`, groupID).Scan(&keyVersion)
+	if err != nil {
+		return 0, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	return &groupID, nil
+	return keyVersion, nil
 }
 
-// AddGroupMember adds a user to a group
-func (p *PostgresDB) AddGroupMember(groupID, userID uuid.UUID, encryptedKey string) error {
-	query := `INSERT INTO group_members (group_id, user_id, role, encrypted_group_key) VALUES ($1, $2, 'member', $3)`
-	_, err := p.db.Exec(query, groupID, userID, encryptedKey)
-	return err
-}
+// GetGroupKey returns a member's encrypted copy of the group key and the group's key version
+func (p *PostgresDB) GetGroupKey(groupID, userID uuid.UUID) (string, int, error) {
+	query := `
+		SELECT gm.encrypted_group_key, g.key_version
+		FROM group_members gm
+		JOIN groups g ON g.group_id = gm.group_id
+		WHERE gm.group_id = $1 AND gm.user_id = $2`
 
-// RemoveGroupMember removes a user from a group
-func (p *PostgresDB) RemoveGroupMember(groupID, userID uuid.UUID) error {
-	query := `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2`
-	_, err := p.db.Exec(query, groupID, userID)
-	return err
+	var encryptedKey string
+	var keyVersion int
+	err := p.db.QueryRow(query, groupID, userID).Scan(&encryptedKey, &keyVersion)
+	return encryptedKey, keyVersion, err
 }
 
 // IsGroupAdmin checks if a user is an admin of a group
@@ -1243,7 +2492,7 @@ func (p *PostgresDB) SearchUsers(query string, limit int) ([]map[string]interfac
 			username ASC
 		LIMIT $3`
 
-	rows, err := p.db.Query(sqlQuery, "%"+searchQuery+"%", searchQuery, limit)
+	rows, err := p.reader().Query(sqlQuery, "%"+searchQuery+"%", searchQuery, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -1282,8 +2531,21 @@ func (p *PostgresDB) SearchUsers(query string, limit int) ([]map[string]interfac
 	return users, nil
 }
 
+// UserSearchOptions controls paging and filtering for SearchUsersExcludingBlockers
+type UserSearchOptions struct {
+	// AfterUsername is the keyset cursor: the last username of the previous page
+	AfterUsername string
+	// ExcludeFriends drops users the searcher is already friends with
+	ExcludeFriends bool
+	// IncludeBlocked keeps users the searcher has blocked (excluded by default)
+	IncludeBlocked bool
+}
+
 // SearchUsersExcludingBlockers searches for users but excludes those who have blocked the searcher
-func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.UUID, limit int) ([]map[string]interface{}, error) {
+// and, unless opts.IncludeBlocked is set, those the searcher has blocked.
+// The exact username match (if any) comes first on the first page; later pages
+// continue alphabetically after opts.AfterUsername.
+func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.UUID, limit int, opts UserSearchOptions) ([]map[string]interface{}, error) {
 	// Only search by username - phone numbers stay private
 	// Strip @ prefix if user included it
 	searchQuery := query
@@ -1291,22 +2553,47 @@ func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.
 		searchQuery = searchQuery[1:]
 	}
 
+	// When paging, the exact match was already returned on the first page. If the
+	// cursor is the exact match itself, the alphabetical listing starts from the top.
+	// The searcher's friendship status with each result is resolved in the same
+	// query so the add-contact UI can render Add/Pending/Friends per row.
 	sqlQuery := `
-		SELECT user_id, username, display_name, avatar_url
-		FROM users
-		WHERE is_active = true
-			AND username IS NOT NULL
-			AND username ILIKE $1
-			AND user_id != $4
-			AND user_id NOT IN (
+		SELECT u.user_id, u.username, u.display_name, u.avatar_url,
+			CASE
+				WHEN f.status = 'accepted' THEN 'friends'
+				WHEN f.status = 'pending' AND f.requester_id = $4 THEN 'pending_sent'
+				WHEN f.status = 'pending' THEN 'pending_received'
+				ELSE 'none'
+			END
+		FROM users u
+		LEFT JOIN LATERAL (
+			SELECT requester_id, status FROM friendships
+			WHERE (requester_id = $4 AND addressee_id = u.user_id)
+			   OR (requester_id = u.user_id AND addressee_id = $4)
+			ORDER BY status = 'accepted' DESC, status = 'pending' DESC
+			LIMIT 1
+		) f ON true
+		WHERE u.is_active = true
+			AND u.username IS NOT NULL
+			AND u.username ILIKE $1
+			AND u.user_id != $4
+			AND u.user_id NOT IN (
 				SELECT blocker_id FROM blocked_users WHERE blocked_id = $4
 			)
+			AND ($7::boolean OR u.user_id NOT IN (
+				SELECT blocked_id FROM blocked_users WHERE blocker_id = $4
+			))
+			AND ($5::text = '' OR (
+				LOWER(u.username) != LOWER($2)
+				AND (LOWER($5::text) = LOWER($2) OR u.username > $5::text)
+			))
+			AND (NOT $6::boolean OR f.status IS DISTINCT FROM 'accepted')
 		ORDER BY
-			CASE WHEN LOWER(username) = LOWER($2) THEN 0 ELSE 1 END,
-			username ASC
+			CASE WHEN LOWER(u.username) = LOWER($2) THEN 0 ELSE 1 END,
+			u.username ASC
 		LIMIT $3`
 
-	rows, err := p.db.Query(sqlQuery, "%"+searchQuery+"%", searchQuery, limit, searcherID)
+	rows, err := p.reader().Query(sqlQuery, "%"+searchQuery+"%", searchQuery, limit, searcherID, opts.AfterUsername, opts.ExcludeFriends, opts.IncludeBlocked)
 	if err != nil {
 		return nil, err
 	}
@@ -1320,8 +2607,9 @@ func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.
 	for rows.Next() {
 		var userID uuid.UUID
 		var username, displayName, avatarURL sql.NullString
+		var friendshipStatus string
 
-		if err := rows.Scan(&userID, &username, &displayName, &avatarURL); err != nil {
+		if err := rows.Scan(&userID, &username, &displayName, &avatarURL, &friendshipStatus); err != nil {
 			return nil, err
 		}
 
@@ -1331,8 +2619,9 @@ func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.
 		}
 
 		user := map[string]interface{}{
-			"user_id":  userID,
-			"username": username.String,
+			"user_id":           userID,
+			"username":          username.String,
+			"friendship_status": friendshipStatus,
 		}
 		if displayName.Valid {
 			user["display_name"] = displayName.String
@@ -1342,7 +2631,7 @@ func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.
 		}
 		users = append(users, user)
 	}
-	return users, nil
+	return users, rows.Err()
 }
 
 // Note: Conversation metadata is now synced device-to-device, not stored server-side.
@@ -1352,14 +2641,47 @@ func (p *PostgresDB) SearchUsersExcludingBlockers(query string, searcherID uuid.
 // BLOCKED USERS
 // ============================================
 
-// BlockUser blocks a user
-func (p *PostgresDB) BlockUser(blockerID, blockedID uuid.UUID) error {
-	_, err := p.db.Exec(`
+// BlockUser blocks a user. Any friendship or pending friend request between
+// the two is removed in the same transaction. Returns true if a friendship or
+// request was removed.
+func (p *PostgresDB) BlockUser(blockerID, blockedID uuid.UUID) (bool, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	_, err = tx.Exec(`
 		INSERT INTO blocked_users (blocker_id, blocked_id)
 		VALUES ($1, $2)
 		ON CONFLICT DO NOTHING
 	`, blockerID, blockedID)
-	return err
+	if err != nil {
+		return false, err
+	}
+
+	// Remove friendship and pending requests in either direction
+	result, err := tx.Exec(`
+		DELETE FROM friendships
+		WHERE (requester_id = $1 AND addressee_id = $2)
+		   OR (requester_id = $2 AND addressee_id = $1)
+	`, blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return removed > 0, nil
 }
 
 // UnblockUser unblocks a user
@@ -1371,17 +2693,70 @@ func (p *PostgresDB) UnblockUser(blockerID, blockedID uuid.UUID) error {
 	return err
 }
 
-// GetBlockedUsers returns list of users blocked by a user
-func (p *PostgresDB) GetBlockedUsers(blockerID uuid.UUID) ([]map[string]interface{}, error) {
+// ErrInvalidCursor is returned when a contact-list or message search cursor
+// cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// contactCursor is the keyset position of the last row of a contact-list
+// page. Blocked users page on (BlockedAt, UserID); friends page on their
+// name ordering, with the null flags keeping NULL names sorted last.
+type contactCursor struct {
+	BlockedAt       time.Time `json:"t,omitempty"`
+	DisplayNameNull bool      `json:"dn,omitempty"`
+	DisplayName     string    `json:"d,omitempty"`
+	UsernameNull    bool      `json:"un,omitempty"`
+	Username        string    `json:"u,omitempty"`
+	UserID          uuid.UUID `json:"id"`
+}
+
+// encodeContactCursor builds an opaque cursor for the next page
+func encodeContactCursor(c contactCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeContactCursor parses a cursor produced by encodeContactCursor. An
+// empty cursor means the first page.
+func decodeContactCursor(cursor string) (*contactCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c contactCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.UserID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// GetBlockedUsers returns a page of users blocked by a user, most recently
+// blocked first, and the cursor for the next page (empty on the last page)
+func (p *PostgresDB) GetBlockedUsers(blockerID uuid.UUID, limit int, cursor string) ([]map[string]interface{}, string, error) {
+	after, err := decodeContactCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	var afterTime sql.NullTime
+	afterID := uuid.Nil
+	if after != nil {
+		afterTime = sql.NullTime{Time: after.BlockedAt, Valid: true}
+		afterID = after.UserID
+	}
+
 	rows, err := p.db.Query(`
 		SELECT u.user_id, u.username, u.display_name, u.avatar_url, bu.blocked_at
 		FROM blocked_users bu
 		JOIN users u ON bu.blocked_id = u.user_id
 		WHERE bu.blocker_id = $1
-		ORDER BY bu.blocked_at DESC
-	`, blockerID)
+		  AND ($2::timestamptz IS NULL OR (bu.blocked_at, bu.blocked_id) < ($2::timestamptz, $3::uuid))
+		ORDER BY bu.blocked_at DESC, bu.blocked_id DESC
+		LIMIT $4
+	`, blockerID, afterTime, afterID, limit)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -1390,6 +2765,8 @@ func (p *PostgresDB) GetBlockedUsers(blockerID uuid.UUID) ([]map[string]interfac
 	}()
 
 	var users []map[string]interface{}
+	var last contactCursor
+	rowCount := 0
 	for rows.Next() {
 		var userID uuid.UUID
 		var username, displayName, avatarURL sql.NullString
@@ -1397,6 +2774,8 @@ func (p *PostgresDB) GetBlockedUsers(blockerID uuid.UUID) ([]map[string]interfac
 		if err := rows.Scan(&userID, &username, &displayName, &avatarURL, &blockedAt); err != nil {
 			continue
 		}
+		rowCount++
+		last = contactCursor{BlockedAt: blockedAt, UserID: userID}
 		user := map[string]interface{}{
 			"user_id":    userID.String(),
 			"blocked_at": blockedAt.Format(time.RFC3339),
@@ -1412,7 +2791,12 @@ func (p *PostgresDB) GetBlockedUsers(blockerID uuid.UUID) ([]map[string]interfac
 		}
 		users = append(users, user)
 	}
-	return users, nil
+
+	var next string
+	if rowCount == limit {
+		next = encodeContactCursor(last)
+	}
+	return users, next, nil
 }
 
 // IsBlocked checks if a user is blocked by another user
@@ -1427,6 +2811,19 @@ func (p *PostgresDB) IsBlocked(blockerID, blockedID uuid.UUID) (bool, error) {
 	return isBlocked, err
 }
 
+// AreEitherBlocked checks if either user has blocked the other
+func (p *PostgresDB) AreEitherBlocked(userA, userB uuid.UUID) (bool, error) {
+	var blocked bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM blocked_users
+			WHERE (blocker_id = $1 AND blocked_id = $2)
+			   OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`, userA, userB).Scan(&blocked)
+	return blocked, err
+}
+
 // ============================================
 // FRIENDSHIPS (Facebook-style friend requests)
 // ============================================
@@ -1483,32 +2880,44 @@ func (p *PostgresDB) SendFriendRequest(requesterID, addresseeID uuid.UUID) error
 	return err
 }
 
-// AcceptFriendRequest accepts a pending friend request
+// AcceptFriendRequest accepts a pending friend request. The request is
+// locked first so a concurrent cancel or block either wins outright or waits.
+// A request between users where either has blocked the other is treated as
+// not found.
 func (p *PostgresDB) AcceptFriendRequest(addresseeID, requesterID uuid.UUID) error {
-	result, err := p.db.Exec(`
-		UPDATE friendships 
-		SET status = 'accepted', updated_at = NOW()
-		WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'
-	`, requesterID, addresseeID)
-	if err != nil {
-		return err
-	}
+	return p.withTx(func(tx *queryTx) error {
+		var blocked bool
+		err := tx.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1 FROM blocked_users
+				WHERE (blocker_id = $1 AND blocked_id = $2)
+				   OR (blocker_id = $2 AND blocked_id = $1)
+			)
+			FROM friendships
+			WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'
+			FOR UPDATE OF friendships
+		`, requesterID, addresseeID).Scan(&blocked)
+		if err == sql.ErrNoRows || blocked {
+			return fmt.Errorf("no pending friend request found")
+		}
+		if err != nil {
+			return err
+		}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
+		_, err = tx.Exec(`
+			UPDATE friendships
+			SET status = 'accepted', updated_at = NOW()
+			WHERE requester_id = $1 AND addressee_id = $2
+		`, requesterID, addresseeID)
 		return err
-	}
-	if rows == 0 {
-		return fmt.Errorf("no pending friend request found")
-	}
-	return nil
+	})
 }
 
 // DeclineFriendRequest declines a pending friend request
 func (p *PostgresDB) DeclineFriendRequest(addresseeID, requesterID uuid.UUID) error {
 	result, err := p.db.Exec(`
 		UPDATE friendships 
-		SET status = 'declined', updated_at = NOW()
+		SET status = 'declined', decline_count = decline_count + 1, updated_at = NOW()
 		WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'
 	`, requesterID, addresseeID)
 	if err != nil {
@@ -1525,18 +2934,68 @@ func (p *PostgresDB) DeclineFriendRequest(addresseeID, requesterID uuid.UUID) er
 	return nil
 }
 
-// RemoveFriend removes a friendship (unfriend)
+// RemoveFriend removes a friendship (unfriend). Declined requests are kept so
+// the requester can't reset their decline count by removing them.
 func (p *PostgresDB) RemoveFriend(userID, friendID uuid.UUID) error {
 	_, err := p.db.Exec(`
 		DELETE FROM friendships 
-		WHERE (requester_id = $1 AND addressee_id = $2)
-		   OR (requester_id = $2 AND addressee_id = $1)
+		WHERE ((requester_id = $1 AND addressee_id = $2)
+		   OR (requester_id = $2 AND addressee_id = $1))
+		  AND status <> 'declined'
 	`, userID, friendID)
 	return err
 }
 
-// GetFriends returns all friends of a user
-func (p *PostgresDB) GetFriends(userID uuid.UUID) ([]FriendInfo, error) {
+// CancelFriendRequest withdraws a pending request from requester to addressee
+func (p *PostgresDB) CancelFriendRequest(requesterID, addresseeID uuid.UUID) error {
+	_, err := p.db.Exec(`
+		DELETE FROM friendships
+		WHERE requester_id = $1 AND addressee_id = $2 AND status = 'pending'
+	`, requesterID, addresseeID)
+	return err
+}
+
+// CountPendingSentFriendRequests returns how many of a user's outgoing
+// friend requests are still pending
+func (p *PostgresDB) CountPendingSentFriendRequests(userID uuid.UUID) (int, error) {
+	var count int
+	err := p.db.QueryRow(`
+		SELECT COUNT(*) FROM friendships
+		WHERE requester_id = $1 AND status = 'pending'
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// GetFriendRequestDeclineCount returns how many times addressee has declined
+// requests from requester
+func (p *PostgresDB) GetFriendRequestDeclineCount(requesterID, addresseeID uuid.UUID) (int, error) {
+	var count int
+	err := p.db.QueryRow(`
+		SELECT decline_count FROM friendships
+		WHERE requester_id = $1 AND addressee_id = $2
+	`, requesterID, addresseeID).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
+
+// GetFriends returns a page of a user's friends ordered by display name, then
+// username, and the cursor for the next page (empty on the last page)
+func (p *PostgresDB) GetFriends(userID uuid.UUID, limit int, cursor string) ([]FriendInfo, string, error) {
+	after, err := decodeContactCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	var afterID uuid.NullUUID
+	if after != nil {
+		afterID = uuid.NullUUID{UUID: after.UserID, Valid: true}
+	} else {
+		after = &contactCursor{}
+	}
+
+	// Sorting on (name IS NULL, COALESCE(name, '')) matches ORDER BY name,
+	// which puts NULLs last, while keeping the keyset comparison NULL-free
 	query := `
 		SELECT 
 			u.user_id, 
@@ -1545,7 +3004,9 @@ func (p *PostgresDB) GetFriends(userID uuid.UUID) ([]FriendInfo, error) {
 			COALESCE(u.avatar_url, '') as avatar_url,
 			u.is_active as is_online,
 			u.last_seen,
-			f.updated_at as friends_since
+			f.updated_at as friends_since,
+			u.display_name IS NULL,
+			u.username IS NULL
 		FROM friendships f
 		JOIN users u ON (
 			CASE 
@@ -1555,11 +3016,16 @@ func (p *PostgresDB) GetFriends(userID uuid.UUID) ([]FriendInfo, error) {
 		)
 		WHERE (f.requester_id = $1 OR f.addressee_id = $1)
 		  AND f.status = 'accepted'
-		ORDER BY u.display_name, u.username`
-
-	rows, err := p.db.Query(query, userID)
+		  AND ($2::uuid IS NULL
+		       OR (u.display_name IS NULL, COALESCE(u.display_name, ''), u.username IS NULL, COALESCE(u.username, ''), u.user_id)
+		        > ($3::boolean, $4::text, $5::boolean, $6::text, $2::uuid))
+		ORDER BY u.display_name IS NULL, COALESCE(u.display_name, ''), u.username IS NULL, COALESCE(u.username, ''), u.user_id
+		LIMIT $7`
+
+	rows, err := p.db.Query(query, userID, afterID, after.DisplayNameNull, after.DisplayName,
+		after.UsernameNull, after.Username, limit)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -1568,14 +3034,29 @@ func (p *PostgresDB) GetFriends(userID uuid.UUID) ([]FriendInfo, error) {
 	}()
 
 	var friends []FriendInfo
+	var last contactCursor
 	for rows.Next() {
 		var f FriendInfo
-		if err := rows.Scan(&f.UserID, &f.Username, &f.DisplayName, &f.AvatarURL, &f.IsOnline, &f.LastSeen, &f.FriendsSince); err != nil {
-			return nil, err
+		var displayNameNull, usernameNull bool
+		if err := rows.Scan(&f.UserID, &f.Username, &f.DisplayName, &f.AvatarURL, &f.IsOnline, &f.LastSeen, &f.FriendsSince,
+			&displayNameNull, &usernameNull); err != nil {
+			return nil, "", err
+		}
+		last = contactCursor{
+			DisplayNameNull: displayNameNull,
+			DisplayName:     f.DisplayName,
+			UsernameNull:    usernameNull,
+			Username:        f.Username,
+			UserID:          f.UserID,
 		}
 		friends = append(friends, f)
 	}
-	return friends, nil
+
+	var next string
+	if len(friends) == limit {
+		next = encodeContactCursor(last)
+	}
+	return friends, next, nil
 }
 
 // GetPendingFriendRequests returns incoming friend requests for a user
@@ -1697,6 +3178,20 @@ func (p *PostgresDB) GetFriendshipStatus(currentUserID, otherUserID uuid.UUID) (
 	return "none", nil
 }
 
+// HaveMessaged checks if two users have exchanged direct messages
+func (p *PostgresDB) HaveMessaged(userA, userB uuid.UUID) (bool, error) {
+	var exists bool
+	err := p.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM messages
+			WHERE ((sender_id = $1 AND receiver_id = $2)
+			    OR (sender_id = $2 AND receiver_id = $1))
+			  AND group_id IS NULL
+		)
+	`, userA, userB).Scan(&exists)
+	return exists, err
+}
+
 // GetFriendIDs returns just the user IDs of all friends (for efficient checks)
 func (p *PostgresDB) GetFriendIDs(userID uuid.UUID) ([]uuid.UUID, error) {
 	query := `
@@ -1709,7 +3204,7 @@ func (p *PostgresDB) GetFriendIDs(userID uuid.UUID) ([]uuid.UUID, error) {
 		WHERE (requester_id = $1 OR addressee_id = $1)
 		  AND status = 'accepted'`
 
-	rows, err := p.db.Query(query, userID)
+	rows, err := p.reader().Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1895,3 +3390,332 @@ func (p *PostgresDB) GetSealedSenderCertificateByUserAndKey(userID uuid.UUID, pu
 
 	return &cert, nil
 }
+
+// ================== Analytics ==================
+
+// DailyAnalytics holds the aggregated message counters for one UTC day
+type DailyAnalytics struct {
+	Day               time.Time `json:"day"`
+	MessagesSent      int64     `json:"messages_sent"`
+	MessagesDelivered int64     `json:"messages_delivered"`
+	MessagesRead      int64     `json:"messages_read"`
+	ActiveUsers       int64     `json:"active_users"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// IncrementDailyAnalytics bumps the counter for a message event on the given day.
+// eventType is a queue event type: "archived" (sent), "delivered" or "read".
+func (p *PostgresDB) IncrementDailyAnalytics(day time.Time, eventType string) error {
+	var column string
+	switch eventType {
+	case "archived":
+		column = "messages_sent"
+	case "delivered":
+		column = "messages_delivered"
+	case "read":
+		column = "messages_read"
+	default:
+		return fmt.Errorf("unsupported analytics event type: %s", eventType)
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO analytics_daily (day, %[1]s, updated_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (day) DO UPDATE
+		SET %[1]s = analytics_daily.%[1]s + 1, updated_at = NOW()`, column)
+
+	_, err := p.db.Exec(query, day.UTC().Format("2006-01-02"))
+	return err
+}
+
+// SetDailyActiveUsers records the distinct active user count for a day.
+// Counts only move forward so an out-of-date worker cannot lower them.
+func (p *PostgresDB) SetDailyActiveUsers(day time.Time, count int64) error {
+	query := `
+		INSERT INTO analytics_daily (day, active_users, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (day) DO UPDATE
+		SET active_users = GREATEST(analytics_daily.active_users, EXCLUDED.active_users), updated_at = NOW()`
+
+	_, err := p.db.Exec(query, day.UTC().Format("2006-01-02"), count)
+	return err
+}
+
+// GetDailyAnalytics returns daily aggregates for days in [from, to], oldest first
+func (p *PostgresDB) GetDailyAnalytics(from, to time.Time) ([]DailyAnalytics, error) {
+	query := `
+		SELECT day, messages_sent, messages_delivered, messages_read, active_users, updated_at
+		FROM analytics_daily
+		WHERE day >= $1 AND day <= $2
+		ORDER BY day`
+
+	rows, err := p.db.Query(query, from.UTC().Format("2006-01-02"), to.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	days := make([]DailyAnalytics, 0)
+	for rows.Next() {
+		var d DailyAnalytics
+		if err := rows.Scan(&d.Day, &d.MessagesSent, &d.MessagesDelivered, &d.MessagesRead, &d.ActiveUsers, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// ============================================
+// MEDIA UPLOADS
+// ============================================
+
+// ErrUploadNotFound is returned for an unknown multipart upload or one that
+// belongs to another user
+var ErrUploadNotFound = errors.New("upload not found")
+
+// ErrTooManyUploads is returned by CreateMediaUpload when the user already
+// has the maximum number of unfinished uploads
+var ErrTooManyUploads = errors.New("too many unfinished uploads")
+
+// MediaUpload is a resumable multipart upload of one media object. Clients
+// send the parts straight to the storage backend, which keeps track of the
+// parts received.
+type MediaUpload struct {
+	UploadID        uuid.UUID  `json:"upload_id"`
+	MediaID         uuid.UUID  `json:"media_id"`
+	UserID          uuid.UUID  `json:"-"`
+	StorageUploadID string     `json:"-"`
+	ContentType     string     `json:"content_type"`
+	TotalSize       int64      `json:"total_size"`
+	PartSize        int64      `json:"part_size"`
+	PartCount       int        `json:"part_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+}
+
+// CreateMediaUpload records a multipart upload started in the storage
+// backend, unless the user already has maxOpen unfinished uploads. The user
+// row is locked while the uploads are counted so concurrent starts can't
+// overshoot the cap.
+func (p *PostgresDB) CreateMediaUpload(ctx context.Context, upload *MediaUpload, maxOpen int) error {
+	return p.withTx(func(tx *queryTx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE user_id = $1 FOR UPDATE`, upload.UserID); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO media_uploads (upload_id, media_id, user_id, storage_upload_id, content_type, total_size, part_size, part_count)
+			SELECT $1, $2, $3, $4, $5, $6, $7, $8
+			WHERE (SELECT COUNT(*) FROM media_uploads WHERE user_id = $3 AND completed_at IS NULL) < $9
+			RETURNING created_at`,
+			upload.UploadID, upload.MediaID, upload.UserID, upload.StorageUploadID,
+			upload.ContentType, upload.TotalSize, upload.PartSize, upload.PartCount, maxOpen,
+		).Scan(&upload.CreatedAt)
+		if err == sql.ErrNoRows {
+			return ErrTooManyUploads
+		}
+		return err
+	})
+}
+
+// GetMediaUpload returns a user's upload. Returns ErrUploadNotFound if it is
+// not the user's.
+func (p *PostgresDB) GetMediaUpload(ctx context.Context, uploadID, userID uuid.UUID) (*MediaUpload, error) {
+	upload := &MediaUpload{}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT upload_id, media_id, user_id, storage_upload_id, content_type, total_size, part_size, part_count, created_at, completed_at
+		FROM media_uploads WHERE upload_id = $1 AND user_id = $2`,
+		uploadID, userID,
+	).Scan(&upload.UploadID, &upload.MediaID, &upload.UserID, &upload.StorageUploadID, &upload.ContentType,
+		&upload.TotalSize, &upload.PartSize, &upload.PartCount, &upload.CreatedAt, &upload.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// CompleteMediaUpload marks an upload assembled
+func (p *PostgresDB) CompleteMediaUpload(ctx context.Context, uploadID uuid.UUID) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE media_uploads SET completed_at = NOW() WHERE upload_id = $1`, uploadID)
+	return err
+}
+
+// ============================================
+// ABUSE REPORTS
+// ============================================
+
+// ErrReportNotFound is returned for an unknown report
+var ErrReportNotFound = errors.New("report not found")
+
+// ErrReportResolved is returned when resolving a report that was already reviewed
+var ErrReportResolved = errors.New("report already resolved")
+
+// ErrDuplicateReport is returned when the reporter already reported the message
+var ErrDuplicateReport = errors.New("message already reported")
+
+// Report is a user's report of another user, optionally about one message.
+// Evidence is whatever the reporter chose to submit from their own decrypted
+// copy of the conversation.
+type Report struct {
+	ReportID       uuid.UUID  `json:"report_id"`
+	ReporterID     uuid.UUID  `json:"reporter_id"`
+	ReportedUserID uuid.UUID  `json:"reported_user_id"`
+	MessageID      *uuid.UUID `json:"message_id,omitempty"`
+	Reason         string     `json:"reason"`
+	Evidence       string     `json:"evidence,omitempty"`
+	Status         string     `json:"status"`
+	Action         string     `json:"action,omitempty"`
+	ReviewedBy     *uuid.UUID `json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// reportCursor is the keyset position of the last row of a report page
+type reportCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ReportID  uuid.UUID `json:"id"`
+}
+
+const reportColumns = `report_id, reporter_id, reported_user_id, message_id, reason, COALESCE(evidence, ''),
+	status, COALESCE(action, ''), reviewed_by, reviewed_at, created_at`
+
+func scanReport(row interface{ Scan(...any) error }, r *Report) error {
+	return row.Scan(&r.ReportID, &r.ReporterID, &r.ReportedUserID, &r.MessageID, &r.Reason, &r.Evidence,
+		&r.Status, &r.Action, &r.ReviewedBy, &r.ReviewedAt, &r.CreatedAt)
+}
+
+// CreateReport stores a report. Returns ErrDuplicateReport if the reporter
+// already reported the same message.
+func (p *PostgresDB) CreateReport(ctx context.Context, report *Report) error {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	var evidence sql.NullString
+	if report.Evidence != "" {
+		evidence = sql.NullString{String: report.Evidence, Valid: true}
+	}
+	err := p.db.QueryRowContext(ctx, `
+		INSERT INTO reports (reporter_id, reported_user_id, message_id, reason, evidence)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING report_id, status, created_at`,
+		report.ReporterID, report.ReportedUserID, report.MessageID, report.Reason, evidence,
+	).Scan(&report.ReportID, &report.Status, &report.CreatedAt)
+	if isUniqueViolation(err, "idx_reports_reporter_message") {
+		return ErrDuplicateReport
+	}
+	return err
+}
+
+// GetReport returns a report by ID
+func (p *PostgresDB) GetReport(ctx context.Context, reportID uuid.UUID) (*Report, error) {
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	report := &Report{}
+	err := scanReport(p.db.QueryRowContext(ctx, `SELECT `+reportColumns+` FROM reports WHERE report_id = $1`, reportID), report)
+	if err == sql.ErrNoRows {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ListReports returns a page of reports, oldest first so the review queue is
+// worked in order, and the cursor for the next page (empty on the last page).
+// An empty status lists every report.
+func (p *PostgresDB) ListReports(ctx context.Context, status, cursor string, limit int) ([]Report, string, error) {
+	var afterTime sql.NullTime
+	afterID := uuid.Nil
+	if cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		var after reportCursor
+		if err := json.Unmarshal(raw, &after); err != nil || after.ReportID == uuid.Nil {
+			return nil, "", ErrInvalidCursor
+		}
+		afterTime = sql.NullTime{Time: after.CreatedAt, Valid: true}
+		afterID = after.ReportID
+	}
+
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := p.reader().QueryContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports
+		WHERE ($1::text = '' OR status = $1)
+		  AND ($2::timestamptz IS NULL OR (created_at, report_id) > ($2::timestamptz, $3::uuid))
+		ORDER BY created_at, report_id
+		LIMIT $4`,
+		status, afterTime, afterID, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		if err := scanReport(rows, &report); err != nil {
+			return nil, "", err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(reports) == limit {
+		last := reports[len(reports)-1]
+		raw, _ := json.Marshal(reportCursor{CreatedAt: last.CreatedAt, ReportID: last.ReportID})
+		next = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return reports, next, nil
+}
+
+// ResolveReport records an admin's decision on an open report: "dismiss"
+// closes it as dismissed, "warn" and "ban" as actioned. Returns
+// ErrReportNotFound or ErrReportResolved if it is not open.
+func (p *PostgresDB) ResolveReport(ctx context.Context, reportID, adminID uuid.UUID, action string) (*Report, error) {
+	status := "actioned"
+	if action == "dismiss" {
+		status = "dismissed"
+	}
+
+	ctx, cancel := p.timer.withTimeout(ctx)
+	defer cancel()
+
+	report := &Report{}
+	err := scanReport(p.db.QueryRowContext(ctx, `
+		UPDATE reports SET status = $2, action = $3, reviewed_by = $4, reviewed_at = NOW()
+		WHERE report_id = $1 AND status = 'open'
+		RETURNING `+reportColumns,
+		reportID, status, action, adminID), report)
+	if err == sql.ErrNoRows {
+		if _, err := p.GetReport(ctx, reportID); err != nil {
+			return nil, err
+		}
+		return nil, ErrReportResolved
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Reader looks up IP addresses in a MaxMind DB (.mmdb) file, such as
+// GeoLite2-City or GeoLite2-Country.
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+// Location is the part of a GeoIP record used for audit enrichment
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code, e.g. "US"
+	Region  string // First subdivision name, e.g. "California"
+	City    string
+}
+
+var (
+	ErrInvalidDatabase = errors.New("invalid MaxMind database")
+	ErrInvalidIP       = errors.New("invalid IP address")
+)
+
+// record is decoded from the City/Country database layout; fields missing
+// from a database (e.g. city in GeoLite2-Country) stay empty
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Open memory-maps a MaxMind DB file
+func Open(path string) (*Reader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	return &Reader{db: db}, nil
+}
+
+// FromBytes creates a Reader from the contents of a MaxMind DB file
+func FromBytes(buf []byte) (*Reader, error) {
+	db, err := maxminddb.FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	return &Reader{db: db}, nil
+}
+
+// Close releases the database
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
+
+// Lookup returns the location of an IP address. The second return value is
+// false if the address isn't in the database.
+func (r *Reader) Lookup(ip net.IP) (*Location, bool, error) {
+	if ip == nil || (ip.To4() == nil && r.db.Metadata.IPVersion == 4) {
+		return nil, false, ErrInvalidIP
+	}
+
+	var rec record
+	_, found, err := r.db.LookupNetwork(ip, &rec)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidDatabase, err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	loc := &Location{
+		Country: rec.Country.ISOCode,
+		City:    rec.City.Names["en"],
+	}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = rec.Subdivisions[0].Names["en"]
+	}
+	return loc, true, nil
+}
+
+// Locate resolves an IP address string to country code, region and city.
+// Unknown or unparseable addresses return empty strings.
+func (r *Reader) Locate(ip string) (country, region, city string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", ""
+	}
+	loc, found, err := r.Lookup(parsed)
+	if err != nil || !found {
+		return "", "", ""
+	}
+	return loc.Country, loc.Region, loc.City
+}
@@ -4,16 +4,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jaydenbeard/messaging-app/internal/auth"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/security"
 )
 
@@ -26,8 +29,9 @@ import (
 // @Param request body models.AuthRequest true "Phone number in E.164 format"
 // @Success 200 {object} map[string]interface{} "Verification code sent"
 // @Failure 400 {object} map[string]string "Invalid request body or phone number"
-// @Failure 429 {object} map[string]string "Account locked or rate limited"
+// @Failure 429 {object} map[string]string "Account locked, rate limited, or resend cooldown active"
 // @Failure 500 {object} map[string]string "Failed to send verification code"
+// @Failure 502 {object} map[string]string "SMS provider failed to deliver the code"
 // @Router /auth/request-code [post]
 func RequestVerificationCode(authService *auth.AuthService, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -44,16 +48,29 @@ func RequestVerificationCode(authService *auth.AuthService, auditLogger *securit
 		}
 
 		// Check if account is locked
-		if lockoutTracker.isLocked(req.PhoneNumber) {
-			locked, until := lockoutTracker.getLockoutInfo(req.PhoneNumber)
-			if locked && until != nil {
-				auditLogger.LogSecurityEvent(r.Context(), security.AuditEventBruteForceBlocked, security.AuditResultDenied, nil, "Account locked due to too many failed attempts", map[string]any{"phone_number": req.PhoneNumber})
-				http.Error(w, fmt.Sprintf("Account locked until %s", until.Format(time.RFC3339)), http.StatusTooManyRequests)
-				return
-			}
+		if rejectIfVerifyLocked(w, r, authService, auditLogger, req.PhoneNumber) {
+			return
 		}
 
-		code, err := authService.RequestVerificationCode(req.PhoneNumber)
+		code, nextAllowed, err := authService.RequestVerificationCode(req.PhoneNumber)
+		var cooldown *auth.ResendCooldownError
+		if errors.As(err, &cooldown) {
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventRateLimited, security.AuditResultDenied, nil, "Verification code resend too soon", map[string]any{"phone_number": req.PhoneNumber})
+			retryAfter := max(int(time.Until(cooldown.RetryAt).Seconds()+0.999), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			writeJSON(w, map[string]interface{}{
+				"error":           "Please wait before requesting another code",
+				"next_allowed_at": cooldown.RetryAt.UTC().Format(time.RFC3339),
+			})
+			return
+		}
+		if errors.Is(err, auth.ErrSMSDeliveryFailed) {
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventInvalidRequest, security.AuditResultError, nil, "Verification SMS delivery failed", map[string]any{"phone_number": req.PhoneNumber, "error": err.Error()})
+			http.Error(w, "Could not send verification code, please try again", http.StatusBadGateway)
+			return
+		}
 		if err != nil {
 			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventInvalidRequest, security.AuditResultError, nil, "Failed to send verification code", map[string]any{"phone_number": req.PhoneNumber, "error": err.Error()})
 			http.Error(w, "Failed to send verification code", http.StatusInternalServerError)
@@ -70,17 +87,48 @@ func RequestVerificationCode(authService *auth.AuthService, auditLogger *securit
 		if devMode {
 			// DEV MODE ONLY - Never enable in production
 			writeJSON(w, map[string]interface{}{
-				"message": "Verification code sent (DEV MODE)",
-				"code":    code,
+				"message":         "Verification code sent (DEV MODE)",
+				"code":            code,
+				"next_allowed_at": formatNextAllowed(nextAllowed),
 			})
 		} else {
 			writeJSON(w, map[string]interface{}{
-				"message": "Verification code sent",
+				"message":         "Verification code sent",
+				"next_allowed_at": formatNextAllowed(nextAllowed),
 			})
 		}
 	}
 }
 
+// rejectIfVerifyLocked answers 429 if the phone number is locked out after
+// too many wrong verification codes
+func rejectIfVerifyLocked(w http.ResponseWriter, r *http.Request, authService *auth.AuthService, auditLogger *security.AuditLogger, phone string) bool {
+	until, locked := authService.VerifyLockedUntil(phone)
+	if !locked {
+		return false
+	}
+	auditLogger.LogSecurityEvent(r.Context(), security.AuditEventBruteForceBlocked, security.AuditResultDenied, nil, "Account locked due to too many failed attempts", map[string]any{"phone_number": phone})
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(time.Until(until).Seconds()+0.999), 1)))
+	http.Error(w, fmt.Sprintf("Account locked until %s", until.UTC().Format(time.RFC3339)), http.StatusTooManyRequests)
+	return true
+}
+
+// recordVerifyFailure counts a wrong code toward the phone number's lockout
+func recordVerifyFailure(r *http.Request, authService *auth.AuthService, auditLogger *security.AuditLogger, phone string) {
+	if until, locked := authService.RecordVerifyFailure(phone); locked {
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventBruteForceBlocked, security.AuditResultDenied, nil, "Phone number locked after repeated failed verification codes", map[string]any{"phone_number": phone, "locked_until": until.UTC().Format(time.RFC3339)})
+	}
+}
+
+// formatNextAllowed renders a resend time for JSON, or nil if the cooldown
+// could not be checked
+func formatNextAllowed(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
 // VerifyCode godoc
 // @Summary Verify SMS code
 // @Description Validates the SMS verification code. Returns tokens for existing users.
@@ -93,7 +141,7 @@ func RequestVerificationCode(authService *auth.AuthService, auditLogger *securit
 // @Failure 401 {object} map[string]string "Invalid code"
 // @Failure 429 {object} map[string]string "Too many attempts"
 // @Router /auth/verify [post]
-func VerifyCode(authService *auth.AuthService, database *db.PostgresDB) http.HandlerFunc {
+func VerifyCode(authService *auth.AuthService, database *db.PostgresDB, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.AuthVerifyRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -101,6 +149,10 @@ func VerifyCode(authService *auth.AuthService, database *db.PostgresDB) http.Han
 			return
 		}
 
+		if rejectIfVerifyLocked(w, r, authService, auditLogger, req.PhoneNumber) {
+			return
+		}
+
 		// Check if user exists first
 		userID, exists, err := authService.GetUserByPhone(req.PhoneNumber)
 		if err != nil {
@@ -126,10 +178,11 @@ func VerifyCode(authService *auth.AuthService, database *db.PostgresDB) http.Han
 
 		if !valid {
 			// Record failed attempt for lockout protection
-			lockoutTracker.recordFailedAttempt(req.PhoneNumber)
+			recordVerifyFailure(r, authService, auditLogger, req.PhoneNumber)
 			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
 			return
 		}
+		authService.ClearVerifyFailures(req.PhoneNumber)
 
 		w.Header().Set("Content-Type", "application/json")
 
@@ -179,7 +232,7 @@ func VerifyCode(authService *auth.AuthService, database *db.PostgresDB) http.Han
 }
 
 // Register creates a new user account
-func Register(authService *auth.AuthService, database *db.PostgresDB) http.HandlerFunc {
+func Register(authService *auth.AuthService, database *db.PostgresDB, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.RegisterRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -204,6 +257,10 @@ func Register(authService *auth.AuthService, database *db.PostgresDB) http.Handl
 			return
 		}
 
+		if rejectIfVerifyLocked(w, r, authService, auditLogger, req.PhoneNumber) {
+			return
+		}
+
 		// SECURITY: Check code validity first WITHOUT marking as verified
 		// This prevents code consumption if user creation fails
 		log.Printf("[Register] Checking code %s for phone %s", req.Code, req.PhoneNumber)
@@ -215,6 +272,7 @@ func Register(authService *auth.AuthService, database *db.PostgresDB) http.Handl
 		}
 		if !valid {
 			log.Printf("[Register] Code check failed - code invalid, expired, or already used")
+			recordVerifyFailure(r, authService, auditLogger, req.PhoneNumber)
 			http.Error(w, "Invalid or expired verification code", http.StatusUnauthorized)
 			return
 		}
@@ -283,11 +341,11 @@ func Register(authService *auth.AuthService, database *db.PostgresDB) http.Handl
 					PublicKey: pk.PublicKey,
 				}
 			}
-			if err := database.SavePreKeys(*userID, prekeys); err != nil {
+			if inserted, err := database.SavePreKeys(*userID, prekeys); err != nil {
 				log.Printf("[Register] Warning: Failed to save prekeys: %v", err)
 				// Don't fail registration - prekeys can be uploaded later
 			} else {
-				log.Printf("[Register] Saved %d prekeys for user %s", len(prekeys), userID)
+				log.Printf("[Register] Saved %d prekeys for user %s", inserted, userID)
 			}
 		}
 
@@ -306,7 +364,7 @@ func Register(authService *auth.AuthService, database *db.PostgresDB) http.Handl
 }
 
 // Login handles returning user authentication on a new device
-func Login(authService *auth.AuthService, database *db.PostgresDB) http.HandlerFunc {
+func Login(authService *auth.AuthService, database *db.PostgresDB, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger, stepUpTOTP bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req models.LoginRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -334,6 +392,39 @@ func Login(authService *auth.AuthService, database *db.PostgresDB) http.HandlerF
 			return
 		}
 
+		// Logins from a country the user has never logged in from are flagged,
+		// and need a TOTP code if step-up is enabled and the user has TOTP
+		country := auditLogger.LocateRequest(r).Country
+		newCountry, err := auditLogger.IsNewLoginCountry(r.Context(), *userID, country)
+		if err != nil {
+			log.Printf("Warning: Failed to check login country for user %s: %v", userID, err)
+		}
+		if newCountry {
+			if stepUpTOTP {
+				totpSecret, err := database.GetTOTPSecret(*userID)
+				if err != nil {
+					log.Printf("Warning: Failed to check TOTP for user %s: %v", userID, err)
+				}
+				if totpSecret != "" && (req.TOTPCode == "" || !authService.ValidateTOTPCode(*userID, req.TOTPCode)) {
+					auditLogger.LogFromRequest(r, userID, security.AuditEventLoginFailed, map[string]any{
+						"reason": "totp_required", "country": country,
+					})
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnauthorized)
+					writeJSON(w, map[string]string{"error": "totp_required"})
+					return
+				}
+			}
+
+			auditLogger.LogFromRequest(r, userID, security.AuditEventSuspiciousIP, map[string]any{
+				"reason": "new_country", "country": country,
+			})
+			redisClient.PublishNotification(*userID, map[string]interface{}{
+				"type":    "new_country_login",
+				"country": country,
+			})
+		}
+
 		// Generate tokens for this device
 		accessToken, refreshToken, expiresAt, err := authService.GenerateTokens(*userID, req.DeviceID)
 		if err != nil {
@@ -398,6 +489,10 @@ func Login(authService *auth.AuthService, database *db.PostgresDB) http.HandlerF
 		}
 		hasPIN := pinHash != ""
 
+		auditLogger.LogFromRequest(r, userID, security.AuditEventLoginSuccess, map[string]any{
+			"device_id": deviceID.String(),
+		})
+
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]interface{}{
 			"access_token":  accessToken,
@@ -426,6 +521,10 @@ func RefreshToken(authService *auth.AuthService) http.HandlerFunc {
 		}
 
 		accessToken, expiresAt, err := authService.RefreshAccessToken(req.RefreshToken)
+		if err == auth.ErrAccountDisabled {
+			http.Error(w, "Account disabled", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
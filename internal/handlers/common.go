@@ -4,20 +4,23 @@ package handlers
 // This file contains foundational components used across multiple handler files.
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
 
 	"crypto/sha256"
 	"encoding/hex"
 
 	"github.com/jaydenbeard/messaging-app/internal/config"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 )
 
 // ============================================
@@ -119,111 +122,14 @@ func validateFileUpload(fileName, contentType string, fileSize int64, mediaLimit
 	return nil
 }
 
-// ============================================
-// ACCOUNT LOCKOUT TRACKING
-// ============================================
-
-// AccountLockoutTracker tracks failed verification attempts for brute force protection
-type AccountLockoutTracker struct {
-	mu             sync.RWMutex
-	failedAttempts map[string]*LockoutInfo
-}
-
-// LockoutInfo stores lockout state for an account
-type LockoutInfo struct {
-	Count       int
-	LastAttempt time.Time
-	LockedUntil *time.Time
-}
-
-var lockoutTracker = &AccountLockoutTracker{
-	failedAttempts: make(map[string]*LockoutInfo),
-}
-
-// recordFailedAttempt records a failed verification attempt
-func (t *AccountLockoutTracker) recordFailedAttempt(phone string) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	now := time.Now()
-	info, exists := t.failedAttempts[phone]
-	if !exists {
-		info = &LockoutInfo{Count: 0}
-		t.failedAttempts[phone] = info
-	}
-
-	info.Count++
-	info.LastAttempt = now
-
-	// Lock account after 5 failed attempts
-	if info.Count >= 5 {
-		lockoutTime := now.Add(1 * time.Hour)
-		info.LockedUntil = &lockoutTime
-	}
-}
-
-// isLocked checks if an account is currently locked
-func (t *AccountLockoutTracker) isLocked(phone string) bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	info, exists := t.failedAttempts[phone]
-	if !exists {
-		return false
-	}
-
-	if info.LockedUntil == nil {
-		return false
-	}
-
-	return time.Now().Before(*info.LockedUntil)
-}
-
-// getLockoutInfo returns lockout information for display
-func (t *AccountLockoutTracker) getLockoutInfo(phone string) (bool, *time.Time) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	info, exists := t.failedAttempts[phone]
-	if !exists {
-		return false, nil
-	}
-
-	return info.LockedUntil != nil && time.Now().Before(*info.LockedUntil), info.LockedUntil
-}
-
 // ============================================
 // IP AND REQUEST UTILITIES
 // ============================================
 
-// getClientIP extracts the real client IP from the request
+// getClientIP extracts the real client IP from the request, honoring
+// forwarding headers only from trusted proxies
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (from load balancers/proxies)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP (original client)
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			// Validate it's a real IP
-			if net.ParseIP(ip) != nil {
-				return ip
-			}
-		}
-	}
-
-	// Check X-Real-IP header
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		if net.ParseIP(xrip) != nil {
-			return xrip
-		}
-	}
-
-	// Fall back to remote address
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
+	return security.GetRealIP(r)
 }
 
 // generateRequestFingerprint creates a fingerprint of the request for tracking
@@ -251,3 +157,41 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 		"status": "healthy",
 	})
 }
+
+// readinessTimeout bounds each dependency ping of a readiness check
+const readinessTimeout = 2 * time.Second
+
+// CheckReadiness reports whether Postgres and Redis answer. The error names
+// the failing dependency only.
+func CheckReadiness(ctx context.Context, database *db.PostgresDB, redisClient *pubsub.RedisClient) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	if err := database.Ping(ctx); err != nil {
+		log.Printf("Warning: readiness check: postgres: %v", err)
+		return errors.New("postgres unavailable")
+	}
+	if err := redisClient.Ping(ctx); err != nil {
+		log.Printf("Warning: readiness check: redis: %v", err)
+		return errors.New("redis unavailable")
+	}
+	return nil
+}
+
+// ReadinessCheck returns 503 while the server can't reach its dependencies,
+// so load balancers and Consul stop routing to it
+func ReadinessCheck(database *db.PostgresDB, redisClient *pubsub.RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := CheckReadiness(r.Context(), database, redisClient); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			if err := json.NewEncoder(w).Encode(map[string]string{"status": "not_ready", "error": err.Error()}); err != nil {
+				log.Printf("Warning: failed to encode readiness response: %v", err)
+			}
+			return
+		}
+		writeJSON(w, map[string]string{
+			"status": "ready",
+		})
+	}
+}
@@ -4,18 +4,26 @@ package handlers
 
 import (
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jaydenbeard/messaging-app/internal/auth"
 	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
 	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 	"github.com/jaydenbeard/messaging-app/internal/websocket"
 )
 
@@ -41,6 +49,30 @@ func GetDevices(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
+// GetDevicePresence returns which of the current user's devices are
+// connected and when each was last active
+func GetDevicePresence(redisClient *pubsub.RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		devices, err := redisClient.GetDevicePresence(userID)
+		if err != nil {
+			log.Printf("Failed to get device presence for user %s: %v", userID, err)
+			http.Error(w, "Failed to get device presence", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"devices": devices,
+		})
+	}
+}
+
 // RemoveDevice removes a device from the user's account
 func RemoveDevice(database *db.PostgresDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -68,9 +100,63 @@ func RemoveDevice(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
+// RemoveOtherDevices signs out every device except the calling one: the
+// devices are deactivated, their tokens rejected and their connections
+// closed. The caller becomes primary if the primary was removed.
+// POST /api/v1/devices/remove-others
+func RemoveOtherDevices(database *db.PostgresDB, authService *auth.AuthService, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		deviceID, ok := middleware.GetDeviceID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		removed, err := database.DeactivateOtherDevices(userID, deviceID)
+		if err != nil {
+			log.Printf("Failed to remove other devices for user %s: %v", userID, err)
+			http.Error(w, "Failed to remove devices", http.StatusInternalServerError)
+			return
+		}
+
+		if len(removed) > 0 {
+			// Refresh is already refused for inactive devices; this cuts off
+			// access tokens issued before
+			if err := authService.SignOutDevices(removed); err != nil {
+				log.Printf("Warning: failed to sign out devices for user %s: %v", userID, err)
+			}
+			for _, removedID := range removed {
+				if err := redisClient.PublishDeviceKick(userID, removedID); err != nil {
+					log.Printf("Warning: failed to publish kick for device %s: %v", removedID, err)
+				}
+				auditLogger.LogFromRequest(r, &userID, security.AuditEventDeviceRemoved, map[string]any{
+					"device_id":  removedID.String(),
+					"removed_by": deviceID.String(),
+					"reason":     "remove_others",
+				})
+			}
+		}
+
+		if err := database.EnsurePrimaryDevice(userID); err != nil {
+			log.Printf("Warning: failed to reassign primary device for user %s: %v", userID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"status":  "removed",
+			"removed": len(removed),
+		})
+	}
+}
+
 // ================== PIN Management ==================
 
-// GetPIN retrieves the user's PIN settings
+// GetPIN reports whether the user has a PIN set
 func GetPIN(database *db.PostgresDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
@@ -79,17 +165,17 @@ func GetPIN(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		pinHash, pinLength, err := database.GetUserPIN(userID)
+		pinHash, _, err := database.GetUserPIN(userID)
 		if err != nil {
 			http.Error(w, "Failed to get PIN", http.StatusInternalServerError)
 			return
 		}
 
+		// The hash never leaves the server: with it a PIN could be brute
+		// forced offline, bypassing VerifyPIN's lockout
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]interface{}{
-			"has_pin":    pinHash != "",
-			"pin_hash":   pinHash,
-			"pin_length": pinLength,
+			"has_pin": pinHash != "",
 		})
 	}
 }
@@ -117,6 +203,12 @@ func SetPIN(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
+		// VerifyPIN checks PINs against this hash server-side
+		if !strings.HasPrefix(req.PinHash, "$argon2id$") {
+			http.Error(w, "pin_hash must be an Argon2id hash", http.StatusBadRequest)
+			return
+		}
+
 		if err := database.SaveUserPIN(userID, req.PinHash, req.PinLength); err != nil {
 			http.Error(w, "Failed to save PIN", http.StatusInternalServerError)
 			return
@@ -146,6 +238,162 @@ func DeletePIN(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
+// VerifyPIN checks the user's PIN on the server. Wrong PINs count toward a
+// lockout with escalating backoff, and locked PINs are refused without
+// being checked. A correct PIN returns a short-lived step-up token for
+// routes behind middleware.RequirePINStepUp.
+func VerifyPIN(database *db.PostgresDB, authService *auth.AuthService, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PIN string `json:"pin"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PIN == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		result, err := database.VerifyUserPIN(userID, req.PIN)
+		if errors.Is(err, db.ErrNoPIN) {
+			http.Error(w, "No PIN set", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Warning: PIN verification failed for user %s: %v", userID, err)
+			http.Error(w, "Failed to verify PIN", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if result.Valid {
+			metrics.RecordPINAttempt("success")
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventPINVerified, security.AuditResultSuccess, &userID, "PIN verified", nil)
+
+			deviceID, _ := middleware.GetDeviceID(r.Context())
+			token, expiresAt, err := authService.IssuePINStepUpToken(userID, deviceID)
+			if err != nil {
+				log.Printf("Warning: failed to issue PIN step-up token for user %s: %v", userID, err)
+				writeJSON(w, map[string]interface{}{"verified": true})
+				return
+			}
+			writeJSON(w, map[string]interface{}{
+				"verified":           true,
+				"step_up_token":      token,
+				"step_up_expires_at": expiresAt.UTC().Format(time.RFC3339),
+			})
+			return
+		}
+
+		if result.LockedUntil != nil {
+			metrics.RecordPINAttempt("locked")
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventPINLocked, security.AuditResultDenied, &userID, "PIN locked after failed attempts", map[string]any{"failed_attempts": result.FailedAttempts, "locked_until": result.LockedUntil.UTC().Format(time.RFC3339)})
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(time.Until(*result.LockedUntil).Seconds()+0.999), 1)))
+			w.WriteHeader(http.StatusTooManyRequests)
+			writeJSON(w, map[string]interface{}{
+				"verified":     false,
+				"locked_until": result.LockedUntil.UTC().Format(time.RFC3339),
+			})
+			return
+		}
+
+		metrics.RecordPINAttempt("failure")
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventPINFailed, security.AuditResultFailure, &userID, "Incorrect PIN", map[string]any{"failed_attempts": result.FailedAttempts})
+		w.WriteHeader(http.StatusUnauthorized)
+		writeJSON(w, map[string]interface{}{"verified": false})
+	}
+}
+
+// VerifyStepUp re-authenticates a user who has no PIN and issues the same
+// step-up token as VerifyPIN. The code is a TOTP code if the user has TOTP
+// set up, otherwise an SMS code requested through POST /auth/request-code.
+// Wrong codes count toward the phone number's verification lockout.
+// POST /api/v1/step-up/verify
+func VerifyStepUp(database *db.PostgresDB, authService *auth.AuthService, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		// A PIN is the stronger factor; it can't be skipped with a code
+		hasPIN, err := authService.HasPIN(userID)
+		if err != nil {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return
+		}
+		if hasPIN {
+			http.Error(w, "PIN set; use /api/v1/pin/verify", http.StatusConflict)
+			return
+		}
+
+		user, err := database.GetUserByID(userID)
+		if err != nil {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return
+		}
+		phone, _ := user["phone_number"].(string)
+		if rejectIfVerifyLocked(w, r, authService, auditLogger, phone) {
+			return
+		}
+
+		totpSecret, err := database.GetTOTPSecret(userID)
+		if err != nil {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return
+		}
+		method := "sms"
+		var valid bool
+		if totpSecret != "" {
+			method = "totp"
+			valid = authService.ValidateTOTPCode(userID, req.Code)
+		} else if valid, err = authService.VerifyCode(phone, req.Code); err != nil {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !valid {
+			recordVerifyFailure(r, authService, auditLogger, phone)
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventMFAVerified, security.AuditResultFailure, &userID, "Step-up re-authentication failed", map[string]any{"method": method})
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSON(w, map[string]interface{}{"verified": false, "method": method})
+			return
+		}
+		authService.ClearVerifyFailures(phone)
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventMFAVerified, security.AuditResultSuccess, &userID, "Step-up re-authentication", map[string]any{"method": method})
+
+		deviceID, _ := middleware.GetDeviceID(r.Context())
+		token, expiresAt, err := authService.IssuePINStepUpToken(userID, deviceID)
+		if err != nil {
+			log.Printf("Warning: failed to issue step-up token for user %s: %v", userID, err)
+			http.Error(w, "Failed to issue step-up token", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"verified":           true,
+			"method":             method,
+			"step_up_token":      token,
+			"step_up_expires_at": expiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
 // ================== Device Approval (Secure Linking) ==================
 
 // generateApprovalCode generates a 6-digit approval code
@@ -159,8 +407,27 @@ func generateApprovalCode() string {
 	return fmt.Sprintf("%06d", code%1000000)
 }
 
+// DeviceApprovalPolicy decides which devices may link without approval from
+// the primary device. Devices that were never linked, or that the user
+// removed, always need approval.
+type DeviceApprovalPolicy struct {
+	// SkipRecognized lets previously linked devices re-link without approval
+	SkipRecognized bool
+	// SkipTypes limits SkipRecognized to these device types; empty allows all
+	SkipTypes []string
+}
+
+// requiresApproval reports whether a device with this trust level and stored
+// type must go through the approval flow
+func (p DeviceApprovalPolicy) requiresApproval(trustLevel, deviceType string) bool {
+	if trustLevel != db.DeviceTrustRecognized || !p.SkipRecognized {
+		return true
+	}
+	return len(p.SkipTypes) > 0 && !slices.Contains(p.SkipTypes, deviceType)
+}
+
 // RequestDeviceApproval initiates a device linking request
-func RequestDeviceApproval(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
+func RequestDeviceApproval(database *db.PostgresDB, hub *websocket.Hub, policy DeviceApprovalPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			PhoneNumber string `json:"phone_number"`
@@ -209,9 +476,14 @@ func RequestDeviceApproval(database *db.PostgresDB, hub *websocket.Hub) http.Han
 			return
 		}
 
-		// Check if device was previously linked but is now inactive
-		wasPreviouslyLinked, err := database.WasDeviceEverLinked(*userID, deviceID)
-		if err == nil && wasPreviouslyLinked {
+		// A previously linked device may skip approval, depending on policy.
+		// The stored device type is used, not the one claimed in the request.
+		trustLevel, storedType, err := database.GetDeviceTrustLevel(*userID, deviceID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !policy.requiresApproval(trustLevel, storedType) {
 			isPrimary, err := database.IsPrimaryDevice(*userID, deviceID)
 			if err != nil {
 				// Log but continue - isPrimary defaults to false
@@ -221,6 +493,7 @@ func RequestDeviceApproval(database *db.PostgresDB, hub *websocket.Hub) http.Han
 			writeJSON(w, map[string]interface{}{
 				"status":        "previously_linked",
 				"requires_code": false,
+				"trust_level":   trustLevel,
 				"is_primary":    isPrimary,
 				"message":       "Device recognized, will be reactivated on login",
 			})
@@ -285,6 +558,7 @@ func RequestDeviceApproval(database *db.PostgresDB, hub *websocket.Hub) http.Han
 		writeJSON(w, map[string]interface{}{
 			"status":              "pending",
 			"requires_code":       true,
+			"trust_level":         trustLevel,
 			"request_id":          approvalReq.RequestID,
 			"expires_at":          approvalReq.ExpiresAt,
 			"primary_device_name": primaryDevice.DeviceName,
@@ -536,18 +810,24 @@ func UploadPrekeys(database *db.PostgresDB) http.HandlerFunc {
 			}
 		}
 
-		if err := database.SavePreKeys(userID, prekeys); err != nil {
+		inserted, err := database.SavePreKeys(userID, prekeys)
+		if err != nil {
 			http.Error(w, "Failed to save prekeys", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		writeJSON(w, map[string]string{"status": "uploaded"})
+		writeJSON(w, map[string]interface{}{
+			"status":   "uploaded",
+			"inserted": inserted,
+		})
 	}
 }
 
-// GetUserKeys returns a user's public keys for E2EE session
-func GetUserKeys(database *db.PostgresDB) http.HandlerFunc {
+// GetUserKeys returns a user's public keys for E2EE session.
+// When requireOneTimePrekey is set, bundles without a one-time pre-key are
+// refused rather than silently downgrading forward secrecy.
+func GetUserKeys(database *db.PostgresDB, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger, requireOneTimePrekey bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		userIDStr := vars["userId"]
@@ -558,21 +838,48 @@ func GetUserKeys(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		keys, err := database.GetUserKeys(userID)
+		keys, err := database.GetUserKeys(r.Context(), userID)
 		if err != nil {
 			http.Error(w, "User not found", http.StatusNotFound)
 			return
 		}
 
+		if available, _ := keys["prekey_available"].(bool); !available {
+			requesterID, _ := middleware.GetUserID(r.Context())
+			result := security.AuditResultSuccess
+			if requireOneTimePrekey {
+				result = security.AuditResultDenied
+			}
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventPrekeysLow, result, &userID,
+				"One-time pre-keys exhausted; key bundle served without one", map[string]any{
+					"requester_id": requesterID.String(),
+					"refused":      requireOneTimePrekey,
+				})
+			if requireOneTimePrekey {
+				w.Header().Set("Retry-After", "60")
+				http.Error(w, "No one-time pre-keys available, try again later", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		// Prompt the owner now if this fetch left them running low
+		if remaining, ok := keys["prekeys_remaining"].(int); ok {
+			redisClient.NotifyPrekeysLow(userID, remaining)
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, keys)
 	}
 }
 
+// identityKeyChangeLimit caps identity key changes per user per day. Each change
+// shows a safety-number warning to every contact, so rapid churn is abusive.
+const identityKeyChangeLimit = 3
+
 // UpdateKeys allows a user to update their encryption keys (e.g., when setting up a new device)
 // If the identity key changes, all contacts are notified via WebSocket
 // POST /api/v1/users/keys
-func UpdateKeys(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
+func UpdateKeys(database *db.PostgresDB, hub *websocket.Hub, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -596,6 +903,10 @@ func UpdateKeys(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 			return
 		}
 
+		if !allowIdentityKeyChange(w, r, database, redisClient, auditLogger, userID, req.PublicIdentityKey) {
+			return
+		}
+
 		// Update keys in database
 		identityKeyChanged, err := database.UpdateUserKeys(userID, req.PublicIdentityKey, req.PublicSignedPrekey, req.SignedPrekeySignature)
 		if err != nil {
@@ -606,36 +917,140 @@ func UpdateKeys(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 
 		// If identity key changed, notify all contacts
 		if identityKeyChanged {
-			log.Printf("[Security] Broadcasting identity_key_changed for user %s", userID)
+			notified := notifyIdentityKeyChanged(database, hub, userID, req.PublicIdentityKey)
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventKeyRotated, security.AuditResultSuccess, &userID,
+				"Identity key changed", map[string]any{"contacts_notified": notified})
+		}
 
-			// Get all users who have exchanged messages with this user
-			contacts, err := database.GetMessagedUsers(userID)
-			if err != nil {
-				log.Printf("[Security] Warning: failed to get contacts for identity notification: %v", err)
-			} else {
-				// Build the payload
-				payload, _ := json.Marshal(map[string]interface{}{
-					"user_id":          userID.String(),
-					"new_identity_key": req.PublicIdentityKey,
-				})
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"status":               "updated",
+			"identity_key_changed": identityKeyChanged,
+		})
+	}
+}
 
-				// Send notification to each contact
-				for _, contactID := range contacts {
-					msg := &models.WebSocketMessage{
-						Type:      "identity_key_changed",
-						SenderID:  userID,
-						Timestamp: time.Now().UTC(),
-						Payload:   payload,
-					}
-					hub.SendToUser(contactID.String(), msg)
-				}
-				log.Printf("[Security] Notified %d contacts about identity key change for user %s", len(contacts), userID)
+// allowIdentityKeyChange rate-limits identity key changes (not signed
+// pre-key refreshes), writing a 429 and returning false once the user is
+// over identityKeyChangeLimit
+func allowIdentityKeyChange(w http.ResponseWriter, r *http.Request, database *db.PostgresDB, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger, userID uuid.UUID, newIdentityKey string) bool {
+	currentIdentityKey, err := database.GetIdentityKey(userID)
+	if err != nil || currentIdentityKey == newIdentityKey {
+		return true
+	}
+	allowed, err := redisClient.CheckRateLimit("identity_key_change:"+userID.String(), identityKeyChangeLimit, 24*time.Hour)
+	if err != nil {
+		log.Printf("[Security] Warning: identity key rate limit check failed for user %s: %v", userID, err)
+		return true
+	}
+	if !allowed {
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventRateLimited, security.AuditResultDenied, &userID,
+			"Identity key change rate limit exceeded", map[string]any{"limit_per_day": identityKeyChangeLimit})
+		http.Error(w, "Too many identity key changes, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// notifyIdentityKeyChanged sends identity_key_changed to everyone who has
+// exchanged messages with the user and returns how many were notified
+func notifyIdentityKeyChanged(database *db.PostgresDB, hub *websocket.Hub, userID uuid.UUID, newIdentityKey string) int {
+	log.Printf("[Security] Broadcasting identity_key_changed for user %s", userID)
+
+	// Get all users who have exchanged messages with this user
+	contacts, err := database.GetMessagedUsers(userID)
+	if err != nil {
+		log.Printf("[Security] Warning: failed to get contacts for identity notification: %v", err)
+		return 0
+	}
+
+	// Build the payload
+	payload, _ := json.Marshal(map[string]interface{}{
+		"user_id":          userID.String(),
+		"new_identity_key": newIdentityKey,
+	})
+
+	// Send notification to each contact
+	for _, contactID := range contacts {
+		msg := &models.WebSocketMessage{
+			Type:      models.MessageTypeIdentityKeyChanged,
+			SenderID:  userID,
+			Timestamp: time.Now().UTC(),
+			Payload:   payload,
+		}
+		hub.SendToUser(contactID.String(), msg)
+	}
+	log.Printf("[Security] Notified %d contacts about identity key change for user %s", len(contacts), userID)
+	return len(contacts)
+}
+
+// RotateKeys is the "my account may be compromised" action: every session
+// is revoked and every connection closed, so all devices must sign in
+// again and sign messages with new tokens. Clients that send a fresh key
+// set have it installed first, which notifies contacts of the new identity
+// key the same way UpdateKeys does.
+// POST /api/v1/users/me/rotate-keys
+func RotateKeys(database *db.PostgresDB, hub *websocket.Hub, authService *auth.AuthService, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			PublicIdentityKey     string `json:"public_identity_key"`
+			PublicSignedPrekey    string `json:"public_signed_prekey"`
+			SignedPrekeySignature string `json:"signed_prekey_signature"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
 			}
 		}
+		if (req.PublicIdentityKey == "") != (req.PublicSignedPrekey == "") {
+			http.Error(w, "Missing required key fields", http.StatusBadRequest)
+			return
+		}
+
+		// Same limit as UpdateKeys, checked before anything is revoked so a
+		// refused request changes nothing; rotating without new keys still works
+		if req.PublicIdentityKey != "" && !allowIdentityKeyChange(w, r, database, redisClient, auditLogger, userID, req.PublicIdentityKey) {
+			return
+		}
+
+		identityKeyChanged := false
+		if req.PublicIdentityKey != "" {
+			changed, err := database.UpdateUserKeys(userID, req.PublicIdentityKey, req.PublicSignedPrekey, req.SignedPrekeySignature)
+			if err != nil {
+				log.Printf("[Keys] Failed to update keys for user %s: %v", userID, err)
+				http.Error(w, "Failed to update keys", http.StatusInternalServerError)
+				return
+			}
+			identityKeyChanged = changed
+			if changed {
+				notified := notifyIdentityKeyChanged(database, hub, userID, req.PublicIdentityKey)
+				auditLogger.LogSecurityEvent(r.Context(), security.AuditEventKeyRotated, security.AuditResultSuccess, &userID,
+					"Identity key changed", map[string]any{"contacts_notified": notified, "reason": "rotate_keys"})
+			}
+		}
+
+		if err := authService.RevokeUserTokens(userID); err != nil {
+			log.Printf("[Security] Failed to revoke tokens for user %s: %v", userID, err)
+			http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		if err := redisClient.PublishKick(userID); err != nil {
+			log.Printf("Warning: failed to publish kick for user %s: %v", userID, err)
+		}
+
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventSessionRevoked, security.AuditResultSuccess, &userID,
+			"All sessions revoked by user (possible compromise)", map[string]any{"identity_key_changed": identityKeyChanged})
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]interface{}{
-			"status":               "updated",
+			"status":               "revoked",
 			"identity_key_changed": identityKeyChanged,
 		})
 	}
@@ -720,13 +1135,37 @@ func SearchUsers(database *db.PostgresDB) http.HandlerFunc {
 			}
 		}
 
+		// Keyset cursor: opaque encoding of the last username on the previous page
+		opts := db.UserSearchOptions{
+			ExcludeFriends: r.URL.Query().Get("exclude_friends") == "true",
+			IncludeBlocked: r.URL.Query().Get("include_blocked") == "true",
+		}
+		if c := r.URL.Query().Get("cursor"); c != "" {
+			after, err := base64.RawURLEncoding.DecodeString(c)
+			if err != nil {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			opts.AfterUsername = string(after)
+		}
+
 		// Use SearchUsersExcludingBlockers to filter out users who blocked the searcher
-		users, err := database.SearchUsersExcludingBlockers(query, currentUserID, limit)
+		users, err := database.SearchUsersExcludingBlockers(query, currentUserID, limit, opts)
 		if err != nil {
 			fmt.Printf("Error searching users: %v\n", err)
 			http.Error(w, "Search failed", http.StatusInternalServerError)
 			return
 		}
+		if users == nil {
+			users = []map[string]interface{}{}
+		}
+
+		// The body stays a plain array for existing clients; the next page cursor
+		// goes in a header when this page was full
+		if len(users) == limit {
+			last, _ := users[len(users)-1]["username"].(string)
+			w.Header().Set("X-Next-Cursor", base64.RawURLEncoding.EncodeToString([]byte(last)))
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, users)
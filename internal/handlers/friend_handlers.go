@@ -2,16 +2,70 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/jaydenbeard/messaging-app/internal/websocket"
+)
+
+// friendEventPayload is the public profile of the user a friend event is
+// about. It never includes the phone number.
+func friendEventPayload(database *db.PostgresDB, userID uuid.UUID) map[string]interface{} {
+	payload := map[string]interface{}{"user_id": userID}
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Warning: failed to load profile for friend event: %v", err)
+		return payload
+	}
+	for _, key := range []string{"username", "display_name", "avatar_url"} {
+		if v, ok := user[key]; ok {
+			payload[key] = v
+		}
+	}
+	return payload
+}
+
+// notifyFriendEvent tells target about a friend request change made by actor,
+// over WebSocket or, if target is offline, by push
+func notifyFriendEvent(database *db.PostgresDB, hub *websocket.Hub, eventType string, target, actor uuid.UUID) {
+	if hub == nil {
+		return
+	}
+	payload, _ := json.Marshal(friendEventPayload(database, actor))
+	hub.NotifyUser(target, &models.WebSocketMessage{
+		Type:      eventType,
+		SenderID:  actor,
+		Timestamp: time.Now().UTC(),
+		Payload:   payload,
+	}, map[string]interface{}{
+		"type":    eventType,
+		"user_id": actor,
+	})
+}
+
+// Friend request spam limits
+const (
+	// maxPendingFriendRequests caps a user's outgoing requests awaiting an answer
+	maxPendingFriendRequests = 50
+	// friendRequestsPerHour caps requests sent per user per hour
+	friendRequestsPerHour = 20
+	// friendRequestDeclineLimit is how many declines from the same user it
+	// takes before further requests to them are silently dropped
+	friendRequestDeclineLimit = 3
 )
 
 // SendFriendRequest sends a friend request to another user
-func SendFriendRequest(database *db.PostgresDB) http.HandlerFunc {
+func SendFriendRequest(database *db.PostgresDB, hub *websocket.Hub, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -39,13 +93,52 @@ func SendFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		// Check if blocked
-		isBlocked, err := database.IsBlocked(addresseeID, userID)
-		if err == nil && isBlocked {
+		// A block in either direction stops requests; the error doesn't say which
+		isBlocked, err := database.AreEitherBlocked(userID, addresseeID)
+		if err != nil {
+			http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
+			return
+		}
+		if isBlocked {
 			http.Error(w, "Cannot send friend request", http.StatusForbidden)
 			return
 		}
 
+		// After repeated declines, further requests are dropped without
+		// notifying the addressee. The requester isn't told, so they can't
+		// probe for it.
+		declines, err := database.GetFriendRequestDeclineCount(userID, addresseeID)
+		if err != nil {
+			log.Printf("Warning: failed to get friend request decline count: %v", err)
+		} else if declines >= friendRequestDeclineLimit {
+			log.Printf("[Friends] Ignoring request from %s to %s after %d declines", userID, addresseeID, declines)
+			w.Header().Set("Content-Type", "application/json")
+			writeJSON(w, map[string]bool{"success": true})
+			return
+		}
+
+		pending, err := database.CountPendingSentFriendRequests(userID)
+		if err != nil {
+			http.Error(w, "Failed to send friend request", http.StatusInternalServerError)
+			return
+		}
+		if pending >= maxPendingFriendRequests {
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventRateLimited, security.AuditResultDenied, &userID,
+				"Pending friend request limit reached", map[string]any{"max_pending": maxPendingFriendRequests})
+			http.Error(w, "Too many pending friend requests; cancel some before sending more", http.StatusTooManyRequests)
+			return
+		}
+
+		allowed, err := redisClient.CheckRateLimit("friend_request:"+userID.String(), friendRequestsPerHour, time.Hour)
+		if err != nil {
+			log.Printf("Warning: friend request rate limit check failed for user %s: %v", userID, err)
+		} else if !allowed {
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventRateLimited, security.AuditResultDenied, &userID,
+				"Friend request rate limit exceeded", map[string]any{"limit_per_hour": friendRequestsPerHour})
+			http.Error(w, "Too many friend requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+
 		if err := database.SendFriendRequest(userID, addresseeID); err != nil {
 			if err.Error() == "already friends" || err.Error() == "friend request already pending" {
 				http.Error(w, err.Error(), http.StatusConflict)
@@ -55,13 +148,15 @@ func SendFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
+		notifyFriendEvent(database, hub, models.MessageTypeFriendRequest, addresseeID, userID)
+
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]bool{"success": true})
 	}
 }
 
 // AcceptFriendRequest accepts a pending friend request
-func AcceptFriendRequest(database *db.PostgresDB) http.HandlerFunc {
+func AcceptFriendRequest(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -92,6 +187,8 @@ func AcceptFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
+		notifyFriendEvent(database, hub, models.MessageTypeFriendAccepted, requesterID, userID)
+
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]bool{"success": true})
 	}
@@ -135,7 +232,7 @@ func DeclineFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 }
 
 // RemoveFriend removes an existing friendship
-func RemoveFriend(database *db.PostgresDB) http.HandlerFunc {
+func RemoveFriend(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -157,12 +254,38 @@ func RemoveFriend(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
+		// Let the other user's client refresh its friends list
+		if hub != nil {
+			hub.SendToUser(friendID.String(), &models.WebSocketMessage{
+				Type:    models.MessageTypeContactsChanged,
+				Payload: json.RawMessage(`{}`),
+			})
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]bool{"success": true})
 	}
 }
 
-// GetFriends returns all friends of the current user
+// Page sizes for the friends and blocked-users lists
+const (
+	defaultContactPageSize = 100
+	maxContactPageSize     = 500
+)
+
+// contactPageLimit reads the limit query parameter for contact lists
+func contactPageLimit(r *http.Request) int {
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= maxContactPageSize {
+			return parsed
+		}
+	}
+	return defaultContactPageSize
+}
+
+// GetFriends returns a page of the current user's friends. The body stays a
+// plain array for existing clients; the next page cursor goes in the
+// X-Next-Cursor header, as with user search.
 func GetFriends(database *db.PostgresDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
@@ -171,7 +294,11 @@ func GetFriends(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		friends, err := database.GetFriends(userID)
+		friends, next, err := database.GetFriends(userID, contactPageLimit(r), r.URL.Query().Get("cursor"))
+		if errors.Is(err, db.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Failed to get friends", http.StatusInternalServerError)
 			return
@@ -182,6 +309,9 @@ func GetFriends(database *db.PostgresDB) http.HandlerFunc {
 			friends = []db.FriendInfo{}
 		}
 
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, friends)
 	}
@@ -263,6 +393,51 @@ func GetFriendshipStatus(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
+// GetRelationship returns everything a profile screen needs about the caller's
+// relationship with another user in one call. Blocks in either direction are
+// reported the same way, as can_interact=false, so the response never tells
+// the caller that the other user blocked them.
+// GET /api/v1/users/{userId}/relationship
+func GetRelationship(database *db.PostgresDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		otherID, err := uuid.Parse(mux.Vars(r)["userId"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		status, err := database.GetFriendshipStatus(userID, otherID)
+		if err != nil {
+			http.Error(w, "Failed to get relationship", http.StatusInternalServerError)
+			return
+		}
+		eitherBlocked, err := database.AreEitherBlocked(userID, otherID)
+		if err != nil {
+			http.Error(w, "Failed to get relationship", http.StatusInternalServerError)
+			return
+		}
+		hasMessaged, err := database.HaveMessaged(userID, otherID)
+		if err != nil {
+			http.Error(w, "Failed to get relationship", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"user_id":           otherID,
+			"friendship_status": status,
+			"can_interact":      !eitherBlocked,
+			"has_messaged":      hasMessaged,
+		})
+	}
+}
+
 // CancelFriendRequest cancels an outgoing friend request
 func CancelFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -286,8 +461,7 @@ func CancelFriendRequest(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		// Cancel by removing the friendship record
-		if err := database.RemoveFriend(userID, addresseeID); err != nil {
+		if err := database.CancelFriendRequest(userID, addresseeID); err != nil {
 			http.Error(w, "Failed to cancel friend request", http.StatusInternalServerError)
 			return
 		}
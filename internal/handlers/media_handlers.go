@@ -3,28 +3,37 @@ package handlers
 // Media handlers for file upload, download, and privacy settings.
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/media"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/websocket"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/redis/go-redis/v9"
 )
 
 // ================== Media Handlers ==================
 
 // GetUploadURL returns a presigned URL for media upload
-func GetUploadURL(cfg *config.Config) http.HandlerFunc {
+func GetUploadURL(redisClient *pubsub.RedisClient, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			FileName    string `json:"file_name"`
@@ -46,17 +55,14 @@ func GetUploadURL(cfg *config.Config) http.HandlerFunc {
 		// Generate media ID
 		mediaID := uuid.New()
 
-		// Generate presigned URL from MinIO/S3
-		useHTTPS := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
-		scheme := "http"
-		if useHTTPS {
-			scheme = "https"
-		}
-
 		// For now, proxy through backend to avoid mixed content
-		baseURL := scheme + "://" + r.Host
-		uploadURL := baseURL + "/api/v1/media/upload-proxy/" + mediaID.String()
-		downloadURL := baseURL + "/api/v1/media/download-proxy/" + mediaID.String()
+		uploadURL := requestBaseURL(r) + "/api/v1/media/upload-proxy/" + mediaID.String()
+		downloadURL, err := newMediaDownloadURL(r, redisClient, cfg, mediaID, false)
+		if err != nil {
+			log.Printf("Failed to create download link for %s: %v", mediaID, err)
+			http.Error(w, "Failed to generate download link", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]interface{}{
@@ -112,6 +118,10 @@ func UpdatePrivacySetting(database *db.PostgresDB, hub *websocket.Hub) http.Hand
 			return
 		}
 
+		if hub != nil {
+			hub.InvalidatePrivacySettings(userID)
+		}
+
 		// If online status was changed, broadcast presence update immediately
 		if req.Setting == "show_online_status" && hub != nil {
 			hub.BroadcastPresenceUpdate(userID, true)
@@ -122,8 +132,79 @@ func UpdatePrivacySetting(database *db.PostgresDB, hub *websocket.Hub) http.Hand
 	}
 }
 
-// GetMediaURL returns a presigned URL for media download
-func GetMediaURL(database *db.PostgresDB, cfg *config.Config) http.HandlerFunc {
+// UpdatePrivacySettings updates any number of privacy settings at once.
+// Keys are setting names; unknown keys reject the whole request.
+func UpdatePrivacySettings(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		previous, err := database.UpdatePrivacySettings(userID, req)
+		if errors.Is(err, db.ErrUnknownPrivacySetting) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating privacy settings for user %s: %v", userID, err)
+			http.Error(w, "Failed to update settings", http.StatusInternalServerError)
+			return
+		}
+
+		if hub != nil {
+			hub.InvalidatePrivacySettings(userID)
+		}
+
+		// One presence broadcast for the whole save, only if visibility changed
+		if value, ok := req["show_online_status"]; ok && value != previous["show_online_status"] && hub != nil {
+			hub.BroadcastPresenceUpdate(userID, true)
+		}
+
+		settings, err := database.GetPrivacySettings(userID)
+		if err != nil {
+			http.Error(w, "Failed to get privacy settings", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, settings)
+	}
+}
+
+// mediaTokenBytes is the entropy of a media download token
+const mediaTokenBytes = 32
+
+// newMediaDownloadURL stores a download token for the media and returns the
+// link that redeems it, valid for cfg.MediaURLExpiry. Media is only ever
+// served through such links.
+func newMediaDownloadURL(r *http.Request, redisClient *pubsub.RedisClient, cfg *config.Config, mediaID uuid.UUID, oneTime bool) (string, error) {
+	tokenBytes := make([]byte, mediaTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	if err := redisClient.CreateMediaDownloadToken(token, mediaID, oneTime, cfg.MediaURLExpiry); err != nil {
+		return "", fmt.Errorf("failed to store media download token: %w", err)
+	}
+	// Proxy through backend to avoid mixed content
+	return requestBaseURL(r) + "/api/v1/media/download/" + token, nil
+}
+
+// GetMediaURL returns a download link for media that expires after
+// cfg.MediaURLExpiry. With ?one_time=true the link works for a single
+// download (its range requests included, for a short grace window), for
+// media sensitive enough that a leaked link must not stay reusable. The link carries its own token, so it needs no Authorization
+// header (e.g. as a <video> source).
+func GetMediaURL(redisClient *pubsub.RedisClient, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		mediaIDStr := vars["mediaId"]
@@ -133,22 +214,23 @@ func GetMediaURL(database *db.PostgresDB, cfg *config.Config) http.HandlerFunc {
 			http.Error(w, "Invalid media ID", http.StatusBadRequest)
 			return
 		}
+		oneTime := r.URL.Query().Get("one_time") == "true"
 
-		// Generate presigned download URL
-		useHTTPS := r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
-		scheme := "http"
-		if useHTTPS {
-			scheme = "https"
+		expiresAt := time.Now().UTC().Add(cfg.MediaURLExpiry)
+		downloadURL, err := newMediaDownloadURL(r, redisClient, cfg, mediaID, oneTime)
+		if err != nil {
+			log.Printf("Failed to create download link for %s: %v", mediaID, err)
+			http.Error(w, "Failed to generate download link", http.StatusInternalServerError)
+			return
 		}
 
-		// Proxy through backend to avoid mixed content
-		baseURL := scheme + "://" + r.Host
-		downloadURL := baseURL + "/api/v1/media/download-proxy/" + mediaID.String()
-
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
 		writeJSON(w, map[string]interface{}{
-			"url":       downloadURL,
-			"expiresIn": 3600,
+			"url":        downloadURL,
+			"expiresIn":  int(cfg.MediaURLExpiry.Seconds()),
+			"expires_at": expiresAt.Format(time.RFC3339),
+			"one_time":   oneTime,
 		})
 	}
 }
@@ -280,50 +362,406 @@ func UploadProxy(cfg *config.Config) http.HandlerFunc {
 	}
 }
 
-// DownloadProxy proxies file downloads from MinIO (to avoid mixed content)
-func DownloadProxy(cfg *config.Config) http.HandlerFunc {
+// DownloadWithToken serves the media a GetMediaURL token grants. It needs no
+// other authentication; one-time tokens only serve the client IP of their
+// first request, and stop working shortly after it.
+// GET /api/v1/media/download/{token}
+func DownloadWithToken(redisClient *pubsub.RedisClient, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		mediaIDStr := vars["mediaId"]
-		fmt.Printf("[Download] Request for media: %s\n", mediaIDStr)
-
-		mediaID, err := uuid.Parse(mediaIDStr)
+		mediaID, err := redisClient.RedeemMediaDownloadToken(mux.Vars(r)["token"], getClientIP(r))
 		if err != nil {
-			fmt.Printf("[Download] Invalid media ID: %s\n", mediaIDStr)
-			http.Error(w, "Invalid media ID", http.StatusBadRequest)
+			if err != redis.Nil {
+				log.Printf("Failed to redeem media download token: %v", err)
+			}
+			http.Error(w, "Download link expired or already used", http.StatusNotFound)
 			return
 		}
 
-		// Initialize MinIO client
-		useSSL := strings.HasPrefix(cfg.MinioURL, "https://")
-		endpoint := strings.TrimPrefix(cfg.MinioURL, "http://")
-		endpoint = strings.TrimPrefix(endpoint, "https://")
-		fmt.Printf("[Download] MinIO endpoint=%s, useSSL=%v, bucket=%s\n", endpoint, useSSL, cfg.MinioBucket)
+		w.Header().Set("Cache-Control", "no-store")
+		serveMedia(w, r, cfg, mediaID)
+	}
+}
 
-		minioClient, err := minio.New(endpoint, &minio.Options{
-			Creds:  credentials.NewStaticV4(cfg.MinioKey, cfg.MinioSecret, ""),
-			Secure: useSSL,
+// serveMedia streams a media object, honouring single-range requests
+func serveMedia(w http.ResponseWriter, r *http.Request, cfg *config.Config, mediaID uuid.UUID) {
+	// Initialize MinIO client
+	useSSL := strings.HasPrefix(cfg.MinioURL, "https://")
+	endpoint := strings.TrimPrefix(cfg.MinioURL, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	fmt.Printf("[Download] MinIO endpoint=%s, useSSL=%v, bucket=%s\n", endpoint, useSSL, cfg.MinioBucket)
+
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.MinioKey, cfg.MinioSecret, ""),
+		Secure: useSSL,
+	})
+	if err != nil {
+		fmt.Printf("[Download] Failed to create MinIO client: %v\n", err)
+		http.Error(w, "Failed to connect to storage", http.StatusInternalServerError)
+		return
+	}
+
+	// Object name in MinIO
+	objectName := fmt.Sprintf("media/%s", mediaID.String())
+	fmt.Printf("[Download] Looking for object: %s\n", objectName)
+
+	// Stat first so a Range can be checked against the size and an
+	// If-Range against the current version
+	objInfo, err := minioClient.StatObject(context.Background(), cfg.MinioBucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		fmt.Printf("[Download] Stat error: %v\n", err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Only read the version that was just checked
+	opts := minio.GetObjectOptions{}
+	if objInfo.ETag != "" {
+		if err := opts.SetMatchETag(objInfo.ETag); err != nil {
+			log.Printf("Warning: failed to pin download to ETag: %v", err)
+		}
+	}
+
+	// Media players seek with Range requests; the range is fetched from
+	// storage rather than reading the whole object
+	status := http.StatusOK
+	length := objInfo.Size
+	rng, partial, err := requestedRange(r, objInfo)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", objInfo.Size))
+		http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if partial {
+		if err := opts.SetRange(rng.start, rng.end); err != nil {
+			http.Error(w, "Invalid range", http.StatusBadRequest)
+			return
+		}
+		status = http.StatusPartialContent
+		length = rng.end - rng.start + 1
+	}
+
+	// Get object from MinIO
+	obj, err := minioClient.GetObject(
+		context.Background(),
+		cfg.MinioBucket,
+		objectName,
+		opts,
+	)
+	if err != nil {
+		fmt.Printf("[Download] GetObject error: %v\n", err)
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer func() {
+		if err := obj.Close(); err != nil {
+			log.Printf("Warning: failed to close object: %v", err)
+		}
+	}()
+
+	// The request is only sent on first use; surface errors before any headers
+	if _, err := obj.Stat(); err != nil {
+		fmt.Printf("[Download] Stat error: %v\n", err)
+		http.Error(w, "Failed to get file info", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("[Download] Serving file: size=%d, contentType=%s, partial=%v\n", objInfo.Size, objInfo.ContentType, partial)
+
+	// Set headers
+	w.Header().Set("Content-Type", objInfo.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if objInfo.ETag != "" {
+		w.Header().Set("ETag", `"`+objInfo.ETag+`"`)
+	}
+	if !objInfo.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", objInfo.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if partial {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.end, objInfo.Size))
+	}
+	w.WriteHeader(status)
+
+	// Stream the file to the client
+	bytesWritten, err := io.Copy(w, obj)
+	if err != nil {
+		fmt.Printf("[Download] Error streaming file: %v\n", err)
+	} else {
+		fmt.Printf("[Download] Streamed %d bytes\n", bytesWritten)
+	}
+}
+
+// errRangeNotSatisfiable is returned by requestedRange for a range that
+// starts beyond the end of the object
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is an inclusive range of byte offsets
+type byteRange struct {
+	start, end int64
+}
+
+// requestedRange returns the byte range a download asked for. The whole
+// object is served instead (partial false) when there is no Range header,
+// when it is malformed or asks for several ranges, or when If-Range no
+// longer matches the object.
+func requestedRange(r *http.Request, info minio.ObjectInfo) (rng byteRange, partial bool, err error) {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return byteRange{}, false, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && !ifRangeMatches(ifRange, info) {
+		return byteRange{}, false, nil
+	}
+
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return byteRange{}, false, nil
+	}
+
+	size := info.Size
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errRangeNotSatisfiable
+		}
+		return byteRange{start: max(size-n, 0), end: size - 1}, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, false, errRangeNotSatisfiable
+	}
+	return byteRange{start: start, end: end}, true, nil
+}
+
+// ifRangeMatches reports whether an If-Range validator still matches the
+// object: its strong ETag or its exact Last-Modified date
+func ifRangeMatches(validator string, info minio.ObjectInfo) bool {
+	if strings.HasPrefix(validator, `"`) {
+		return info.ETag != "" && validator == `"`+info.ETag+`"`
+	}
+	if strings.HasPrefix(validator, "W/") {
+		// Weak ETags never validate a range
+		return false
+	}
+	t, err := http.ParseTime(validator)
+	return err == nil && t.Equal(info.LastModified.UTC().Truncate(time.Second))
+}
+
+// ================== Avatars ==================
+
+// maxAvatarSize caps avatar uploads; profile pictures are shown small
+const maxAvatarSize = 5 * 1024 * 1024
+
+// avatarContentTypes are the image types accepted as avatars. SVG is left
+// out because it can carry script.
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// avatarURLPrefix is where avatars are served; UpdateUser only accepts
+// avatar URLs under it
+const avatarURLPrefix = "/api/v1/avatars/"
+
+// avatarURL returns the server-controlled URL for an uploaded avatar
+func avatarURL(userID, avatarID uuid.UUID) string {
+	return avatarURLPrefix + userID.String() + "/" + avatarID.String()
+}
+
+// isOwnAvatarURL reports whether rawURL is an avatar uploaded by userID.
+// Only UploadAvatar writes under a user's avatar prefix, so such a URL
+// always points at an image that went through validation and moderation.
+func isOwnAvatarURL(rawURL string, userID uuid.UUID) bool {
+	rest, ok := strings.CutPrefix(rawURL, avatarURLPrefix+userID.String()+"/")
+	if !ok {
+		return false
+	}
+	_, err := uuid.Parse(rest)
+	return err == nil
+}
+
+// userStorageRemovalTimeout bounds removeUserStorage
+const userStorageRemovalTimeout = 10 * time.Minute
+
+// removeUserStorage deletes a deleted user's media objects and avatars and
+// aborts their unfinished uploads. The account is already gone, so failures
+// are only logged.
+func removeUserStorage(cfg *config.Config, userID uuid.UUID, storage *db.UserStorage) {
+	ctx, cancel := context.WithTimeout(context.Background(), userStorageRemovalTimeout)
+	defer cancel()
+
+	core, err := newStorageCore(cfg)
+	if err != nil {
+		log.Printf("Warning: failed to connect to storage to remove media of deleted user %s: %v", userID, err)
+		return
+	}
+
+	for objectName, uploadID := range storage.PendingUploads {
+		if err := core.AbortMultipartUpload(ctx, cfg.MinioBucket, objectName, uploadID); err != nil {
+			log.Printf("Warning: failed to abort upload %s of deleted user %s: %v", objectName, userID, err)
+		}
+	}
+
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for _, key := range storage.ObjectKeys {
+			objects <- minio.ObjectInfo{Key: key}
+		}
+		avatars := core.Client.ListObjects(ctx, cfg.MinioBucket, minio.ListObjectsOptions{
+			Prefix:    fmt.Sprintf("avatars/%s/", userID),
+			Recursive: true,
 		})
+		for object := range avatars {
+			if object.Err != nil {
+				log.Printf("Warning: failed to list avatars of deleted user %s: %v", userID, object.Err)
+				return
+			}
+			objects <- object
+		}
+	}()
+
+	removed := 0
+	for result := range core.RemoveObjects(ctx, cfg.MinioBucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil {
+			log.Printf("Warning: failed to remove %s of deleted user %s: %v", result.ObjectName, userID, result.Err)
+			continue
+		}
+		removed++
+	}
+	log.Printf("Removed %d stored objects of deleted user %s", removed, userID)
+}
+
+// newStorageClient connects to MinIO using the configured endpoint
+func newStorageClient(cfg *config.Config) (*minio.Client, error) {
+	useSSL := strings.HasPrefix(cfg.MinioURL, "https://")
+	endpoint := strings.TrimPrefix(cfg.MinioURL, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+
+	return minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.MinioKey, cfg.MinioSecret, ""),
+		Secure: useSSL,
+	})
+}
+
+// UploadAvatar stores a new profile picture and sets it as the user's
+// avatar. The body is the raw image. moderator may be nil.
+// POST /api/v1/users/me/avatar
+func UploadAvatar(database *db.PostgresDB, cfg *config.Config, moderator media.AvatarModerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		image, err := io.ReadAll(io.LimitReader(r.Body, maxAvatarSize+1))
 		if err != nil {
-			fmt.Printf("[Download] Failed to create MinIO client: %v\n", err)
+			http.Error(w, "Failed to read image", http.StatusBadRequest)
+			return
+		}
+		if len(image) > maxAvatarSize {
+			http.Error(w, fmt.Sprintf("Avatar exceeds maximum size of %d bytes", maxAvatarSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// Trust the bytes, not the header: the sniffed type is what gets stored
+		contentType := http.DetectContentType(image)
+		if !avatarContentTypes[contentType] {
+			http.Error(w, "Avatar must be a JPEG, PNG, GIF or WebP image", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		if moderator != nil {
+			if err := moderator.ModerateAvatar(r.Context(), userID, contentType, image); err != nil {
+				if errors.Is(err, media.ErrAvatarRejected) {
+					log.Printf("SECURITY: Avatar rejected by moderation for user %s", userID)
+					http.Error(w, "Image not allowed as an avatar", http.StatusUnprocessableEntity)
+					return
+				}
+				log.Printf("Warning: avatar moderation failed for user %s: %v", userID, err)
+				http.Error(w, "Failed to check image", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		minioClient, err := newStorageClient(cfg)
+		if err != nil {
+			log.Printf("Failed to create MinIO client for avatar: %v", err)
 			http.Error(w, "Failed to connect to storage", http.StatusInternalServerError)
 			return
 		}
 
-		// Object name in MinIO
-		objectName := fmt.Sprintf("media/%s", mediaID.String())
-		fmt.Printf("[Download] Looking for object: %s\n", objectName)
+		avatarID := uuid.New()
+		objectName := fmt.Sprintf("avatars/%s/%s", userID, avatarID)
+		_, err = minioClient.PutObject(r.Context(), cfg.MinioBucket, objectName,
+			bytes.NewReader(image), int64(len(image)), minio.PutObjectOptions{ContentType: contentType})
+		if err != nil {
+			log.Printf("Avatar upload failed for user %s: %v", userID, err)
+			http.Error(w, "Upload failed", http.StatusInternalServerError)
+			return
+		}
 
-		// Get object from MinIO
-		obj, err := minioClient.GetObject(
-			context.Background(),
-			cfg.MinioBucket,
-			objectName,
-			minio.GetObjectOptions{},
-		)
+		url := avatarURL(userID, avatarID)
+		if err := database.UpdateUser(userID, map[string]interface{}{"avatar_url": url}); err != nil {
+			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]string{"avatar_url": url})
+	}
+}
+
+// GetAvatar serves an avatar uploaded through UploadAvatar
+// GET /api/v1/avatars/{userId}/{avatarId}
+func GetAvatar(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		userID, err := uuid.Parse(vars["userId"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		avatarID, err := uuid.Parse(vars["avatarId"])
 		if err != nil {
-			fmt.Printf("[Download] GetObject error: %v\n", err)
-			http.Error(w, "File not found", http.StatusNotFound)
+			http.Error(w, "Invalid avatar ID", http.StatusBadRequest)
+			return
+		}
+
+		minioClient, err := newStorageClient(cfg)
+		if err != nil {
+			log.Printf("Failed to create MinIO client for avatar: %v", err)
+			http.Error(w, "Failed to connect to storage", http.StatusInternalServerError)
+			return
+		}
+
+		obj, err := minioClient.GetObject(r.Context(), cfg.MinioBucket,
+			fmt.Sprintf("avatars/%s/%s", userID, avatarID), minio.GetObjectOptions{})
+		if err != nil {
+			http.Error(w, "Avatar not found", http.StatusNotFound)
 			return
 		}
 		defer func() {
@@ -332,26 +770,19 @@ func DownloadProxy(cfg *config.Config) http.HandlerFunc {
 			}
 		}()
 
-		// Get object info for Content-Type
 		objInfo, err := obj.Stat()
 		if err != nil {
-			fmt.Printf("[Download] Stat error: %v\n", err)
-			http.Error(w, "Failed to get file info", http.StatusInternalServerError)
+			http.Error(w, "Avatar not found", http.StatusNotFound)
 			return
 		}
 
-		fmt.Printf("[Download] Serving file: size=%d, contentType=%s\n", objInfo.Size, objInfo.ContentType)
-
-		// Set headers
+		// A new upload gets a new ID, so each URL's content never changes
 		w.Header().Set("Content-Type", objInfo.ContentType)
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", objInfo.Size))
-
-		// Stream the file to the client
-		bytesWritten, err := io.Copy(w, obj)
-		if err != nil {
-			fmt.Printf("[Download] Error streaming file: %v\n", err)
-		} else {
-			fmt.Printf("[Download] Streamed %d bytes\n", bytesWritten)
+		w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if _, err := io.Copy(w, obj); err != nil {
+			log.Printf("Warning: failed to stream avatar: %v", err)
 		}
 	}
 }
@@ -0,0 +1,382 @@
+package handlers
+
+// Resumable multipart upload handlers for large media.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jaydenbeard/messaging-app/internal/config"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// uploadPartSize is the size of every part but the last. S3 requires at
+// least 5 MiB; 8 MiB keeps a retried part cheap on a mobile connection.
+const uploadPartSize = 8 * 1024 * 1024
+
+// uploadPartURLExpiry is how long a presigned part URL stays valid. Clients
+// fetch the upload again for fresh URLs.
+const uploadPartURLExpiry = time.Hour
+
+// maxOpenMediaUploads caps a user's unfinished uploads, each of which holds
+// parts in storage until it is completed or the scheduler aborts it
+const maxOpenMediaUploads = 5
+
+// newStorageCore connects to MinIO with the low-level multipart API
+func newStorageCore(cfg *config.Config) (*minio.Core, error) {
+	client, err := newStorageClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &minio.Core{Client: client}, nil
+}
+
+// newPresignClient returns a MinIO client for presigning URLs that clients
+// use directly, so it signs for the public storage address. With the region
+// set, presigning is local and makes no request.
+func newPresignClient(cfg *config.Config) (*minio.Client, error) {
+	endpoint := cfg.MinioPublicURL
+	if endpoint == "" {
+		endpoint = cfg.MinioURL
+	}
+	useSSL := strings.HasPrefix(endpoint, "https://")
+	endpoint = strings.TrimPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+
+	return minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.MinioKey, cfg.MinioSecret, ""),
+		Secure: useSSL,
+		Region: cfg.MinioRegion,
+	})
+}
+
+// requestBaseURL is the scheme and host the client used, for building proxy URLs
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// uploadObjectName is where an upload's media object is stored
+func uploadObjectName(upload *db.MediaUpload) string {
+	return fmt.Sprintf("media/%s", upload.MediaID)
+}
+
+// uploadPartLength is the expected size of a part, counting from 1
+func uploadPartLength(upload *db.MediaUpload, partNumber int) int64 {
+	if partNumber == upload.PartCount {
+		return upload.TotalSize - int64(upload.PartCount-1)*upload.PartSize
+	}
+	return upload.PartSize
+}
+
+// receivedUploadParts returns the parts storage holds for an upload, by
+// part number. Parts of the wrong size are left out so the client sends
+// them again.
+func receivedUploadParts(ctx context.Context, core *minio.Core, cfg *config.Config, upload *db.MediaUpload) (map[int]minio.ObjectPart, error) {
+	received := make(map[int]minio.ObjectPart)
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, cfg.MinioBucket, uploadObjectName(upload), upload.StorageUploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.ObjectParts {
+			if part.PartNumber >= 1 && part.PartNumber <= upload.PartCount &&
+				part.Size == uploadPartLength(upload, part.PartNumber) {
+				received[part.PartNumber] = part
+			}
+		}
+		if !result.IsTruncated {
+			return received, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// mediaUploadResponse describes an upload and where to send each part.
+// Parts already received are marked so a client can resume with the rest;
+// the others get a presigned storage URL.
+func mediaUploadResponse(r *http.Request, cfg *config.Config, upload *db.MediaUpload, received map[int]minio.ObjectPart) (map[string]interface{}, error) {
+	baseURL := requestBaseURL(r)
+
+	var presigner *minio.Client
+	if upload.CompletedAt == nil && len(received) < upload.PartCount {
+		var err error
+		if presigner, err = newPresignClient(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	parts := make([]map[string]interface{}, 0, upload.PartCount)
+	for n := 1; n <= upload.PartCount; n++ {
+		_, uploaded := received[n]
+		part := map[string]interface{}{
+			"part_number": n,
+			"size":        uploadPartLength(upload, n),
+			"uploaded":    uploaded || upload.CompletedAt != nil,
+		}
+		if presigner != nil && !uploaded {
+			partURL, err := presigner.Presign(r.Context(), http.MethodPut, cfg.MinioBucket, uploadObjectName(upload),
+				uploadPartURLExpiry, url.Values{
+					"partNumber": {strconv.Itoa(n)},
+					"uploadId":   {upload.StorageUploadID},
+				})
+			if err != nil {
+				return nil, err
+			}
+			part["url"] = partURL.String()
+		}
+		parts = append(parts, part)
+	}
+
+	return map[string]interface{}{
+		"upload_id":      upload.UploadID.String(),
+		"media_id":       upload.MediaID.String(),
+		"part_size":      upload.PartSize,
+		"part_count":     upload.PartCount,
+		"parts":          parts,
+		"urls_expire_in": int(uploadPartURLExpiry.Seconds()),
+		"complete_url":   fmt.Sprintf("%s/api/v1/media/uploads/%s/complete", baseURL, upload.UploadID),
+		"completed":      upload.CompletedAt != nil,
+	}, nil
+}
+
+// writeMediaUpload writes the upload description with the given status
+func writeMediaUpload(w http.ResponseWriter, r *http.Request, cfg *config.Config, upload *db.MediaUpload, received map[int]minio.ObjectPart, status int) {
+	response, err := mediaUploadResponse(r, cfg, upload, received)
+	if err != nil {
+		log.Printf("Failed to presign part URLs for upload %s: %v", upload.UploadID, err)
+		http.Error(w, "Failed to prepare upload URLs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, response)
+}
+
+// getOwnMediaUpload loads the upload named in the path, writing the error
+// response if it can't
+func getOwnMediaUpload(w http.ResponseWriter, r *http.Request, database *db.PostgresDB, userID uuid.UUID) (*db.MediaUpload, bool) {
+	uploadID, err := uuid.Parse(mux.Vars(r)["uploadId"])
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	upload, err := database.GetMediaUpload(r.Context(), uploadID, userID)
+	if errors.Is(err, db.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get media upload %s: %v", uploadID, err)
+		http.Error(w, "Failed to get upload", http.StatusInternalServerError)
+		return nil, false
+	}
+	return upload, true
+}
+
+// StartMediaUpload begins a resumable multipart upload for a large file and
+// returns a presigned storage URL per part. Parts can be sent in any order
+// and retried.
+// POST /api/v1/media/uploads
+func StartMediaUpload(database *db.PostgresDB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			FileName    string `json:"file_name"`
+			ContentType string `json:"content_type"`
+			FileSize    int64  `json:"file_size"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validateFileUpload(req.FileName, req.ContentType, req.FileSize, cfg.MediaLimits); err != nil {
+			log.Printf("SECURITY: File upload validation failed: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		core, err := newStorageCore(cfg)
+		if err != nil {
+			log.Printf("Failed to create MinIO client for multipart upload: %v", err)
+			http.Error(w, "Failed to connect to storage", http.StatusInternalServerError)
+			return
+		}
+		exists, err := core.BucketExists(r.Context(), cfg.MinioBucket)
+		if err == nil && !exists {
+			err = core.MakeBucket(r.Context(), cfg.MinioBucket, minio.MakeBucketOptions{})
+		}
+		if err != nil {
+			log.Printf("Failed to prepare bucket %s for multipart upload: %v", cfg.MinioBucket, err)
+			http.Error(w, "Failed to prepare storage bucket", http.StatusInternalServerError)
+			return
+		}
+
+		upload := &db.MediaUpload{
+			UploadID:    uuid.New(),
+			MediaID:     uuid.New(),
+			UserID:      userID,
+			ContentType: req.ContentType,
+			TotalSize:   req.FileSize,
+			PartSize:    uploadPartSize,
+			PartCount:   int((req.FileSize + uploadPartSize - 1) / uploadPartSize),
+		}
+		objectName := uploadObjectName(upload)
+		upload.StorageUploadID, err = core.NewMultipartUpload(r.Context(), cfg.MinioBucket, objectName,
+			minio.PutObjectOptions{ContentType: req.ContentType})
+		if err != nil {
+			log.Printf("Failed to start multipart upload for %s: %v", upload.MediaID, err)
+			http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+			return
+		}
+
+		if err := database.CreateMediaUpload(r.Context(), upload, maxOpenMediaUploads); err != nil {
+			if abortErr := core.AbortMultipartUpload(r.Context(), cfg.MinioBucket, objectName, upload.StorageUploadID); abortErr != nil {
+				log.Printf("Warning: failed to abort multipart upload for %s: %v", upload.MediaID, abortErr)
+			}
+			if errors.Is(err, db.ErrTooManyUploads) {
+				http.Error(w, fmt.Sprintf("At most %d unfinished uploads are allowed", maxOpenMediaUploads), http.StatusTooManyRequests)
+				return
+			}
+			log.Printf("Failed to record multipart upload for %s: %v", upload.MediaID, err)
+			http.Error(w, "Failed to start upload", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("SECURITY: Multipart upload started - ID: %s, Type: %s, Size: %d, Parts: %d, ClientIP: %s",
+			upload.MediaID, req.ContentType, req.FileSize, upload.PartCount, getClientIP(r))
+
+		writeMediaUpload(w, r, cfg, upload, nil, http.StatusCreated)
+	}
+}
+
+// GetMediaUpload returns an upload's parts and which have been received,
+// with fresh URLs for the rest, so an interrupted client can resume
+// GET /api/v1/media/uploads/{uploadId}
+func GetMediaUpload(database *db.PostgresDB, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		upload, ok := getOwnMediaUpload(w, r, database, userID)
+		if !ok {
+			return
+		}
+
+		var received map[int]minio.ObjectPart
+		if upload.CompletedAt == nil {
+			core, err := newStorageCore(cfg)
+			if err == nil {
+				received, err = receivedUploadParts(r.Context(), core, cfg, upload)
+			}
+			if err != nil {
+				log.Printf("Failed to list parts of upload %s: %v", upload.UploadID, err)
+				http.Error(w, "Failed to get upload", http.StatusBadGateway)
+				return
+			}
+		}
+
+		writeMediaUpload(w, r, cfg, upload, received, http.StatusOK)
+	}
+}
+
+// CompleteMediaUpload assembles the parts into the media object once all
+// have arrived and returns a download link for it. Completing twice is
+// harmless.
+// POST /api/v1/media/uploads/{uploadId}/complete
+func CompleteMediaUpload(database *db.PostgresDB, redisClient *pubsub.RedisClient, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		upload, ok := getOwnMediaUpload(w, r, database, userID)
+		if !ok {
+			return
+		}
+
+		if upload.CompletedAt == nil {
+			core, err := newStorageCore(cfg)
+			if err != nil {
+				log.Printf("Failed to create MinIO client to complete upload: %v", err)
+				http.Error(w, "Failed to connect to storage", http.StatusInternalServerError)
+				return
+			}
+
+			received, err := receivedUploadParts(r.Context(), core, cfg, upload)
+			if err != nil {
+				log.Printf("Failed to list parts of upload %s: %v", upload.UploadID, err)
+				http.Error(w, "Failed to assemble upload", http.StatusBadGateway)
+				return
+			}
+			if len(received) < upload.PartCount {
+				writeMediaUpload(w, r, cfg, upload, received, http.StatusConflict)
+				return
+			}
+
+			parts := make([]minio.CompletePart, 0, upload.PartCount)
+			for n := 1; n <= upload.PartCount; n++ {
+				parts = append(parts, minio.CompletePart{PartNumber: n, ETag: received[n].ETag})
+			}
+			_, err = core.CompleteMultipartUpload(r.Context(), cfg.MinioBucket, uploadObjectName(upload), upload.StorageUploadID,
+				parts, minio.PutObjectOptions{ContentType: upload.ContentType})
+			if err != nil {
+				log.Printf("Failed to complete multipart upload for media %s: %v", upload.MediaID, err)
+				http.Error(w, "Failed to assemble upload", http.StatusBadGateway)
+				return
+			}
+
+			if err := database.CompleteMediaUpload(r.Context(), upload.UploadID); err != nil {
+				log.Printf("Warning: failed to mark upload %s complete: %v", upload.UploadID, err)
+			}
+			log.Printf("SECURITY: Multipart upload completed - ID: %s, Size: %d, ClientIP: %s",
+				upload.MediaID, upload.TotalSize, getClientIP(r))
+		}
+
+		expiresAt := time.Now().UTC().Add(cfg.MediaURLExpiry)
+		downloadURL, err := newMediaDownloadURL(r, redisClient, cfg, upload.MediaID, false)
+		if err != nil {
+			log.Printf("Failed to create download link for %s: %v", upload.MediaID, err)
+			http.Error(w, "Failed to generate download link", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]interface{}{
+			"media_id":     upload.MediaID.String(),
+			"status":       "uploaded",
+			"download_url": downloadURL,
+			"expires_at":   expiresAt.Format(time.RFC3339),
+		})
+	}
+}
@@ -3,15 +3,21 @@ package handlers
 // Message and Group handlers for messaging and group chat operations.
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 	"github.com/jaydenbeard/messaging-app/internal/websocket"
 )
 
@@ -37,9 +43,167 @@ func GetMessages(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
+// SearchMessages searches the metadata of the caller's messages (sender,
+// group, media type, date range). Content is end-to-end encrypted, so the
+// client fetches and decrypts the matching messages itself. The next page
+// cursor goes in the X-Next-Cursor header.
+// GET /api/v1/messages/search?sender=uuid&group=uuid&media_type=image&has_media=true&since=RFC3339&until=RFC3339&limit=50&cursor=...
+func SearchMessages(database *db.PostgresDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		filter := db.MessageSearchFilter{
+			MediaType: query.Get("media_type"),
+			HasMedia:  query.Get("has_media") == "true",
+			Limit:     50,
+			Cursor:    query.Get("cursor"),
+		}
+		if l := query.Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+				filter.Limit = parsed
+			}
+		}
+		for param, dst := range map[string]**uuid.UUID{"sender": &filter.SenderID, "group": &filter.GroupID} {
+			if v := query.Get(param); v != "" {
+				id, err := uuid.Parse(v)
+				if err != nil {
+					http.Error(w, "Invalid "+param, http.StatusBadRequest)
+					return
+				}
+				*dst = &id
+			}
+		}
+		for param, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := query.Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					http.Error(w, "Invalid "+param+": use RFC 3339", http.StatusBadRequest)
+					return
+				}
+				*dst = t
+			}
+		}
+
+		messages, next, err := database.SearchMessages(r.Context(), userID, filter)
+		if errors.Is(err, db.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to search messages for %s: %v", userID, err)
+			http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+			return
+		}
+
+		hideUnsharedReadStatus(database, userID, messages)
+
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"messages": messages,
+		})
+	}
+}
+
+// hideUnsharedReadStatus applies the hub's reciprocal read receipt rule to
+// the caller's sent messages: "read" is reported as "delivered" unless both
+// the caller and the recipient have show_read_receipts on
+func hideUnsharedReadStatus(database *db.PostgresDB, userID uuid.UUID, messages []db.MessageMetadata) {
+	shares := make(map[uuid.UUID]bool)
+	sharesReceipts := func(id uuid.UUID) bool {
+		enabled, ok := shares[id]
+		if !ok {
+			settings, err := database.GetPrivacySettings(id)
+			if err != nil {
+				log.Printf("Warning: failed to get privacy settings for user %s: %v", id, err)
+			}
+			value, set := settings["show_read_receipts"].(bool)
+			enabled = err == nil && (!set || value)
+			shares[id] = enabled
+		}
+		return enabled
+	}
+
+	for i := range messages {
+		m := &messages[i]
+		if m.SenderID != userID || m.Status != "read" {
+			continue
+		}
+		if !sharesReceipts(userID) || (m.ReceiverID != nil && !sharesReceipts(*m.ReceiverID)) {
+			m.Status = "delivered"
+		}
+	}
+}
+
+// GetConversations returns conversation summaries for rendering the inbox
+// GET /api/v1/conversations?limit=50
+func GetConversations(database *db.PostgresDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		limit := 50
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+				limit = parsed
+			}
+		}
+
+		summaries, err := database.GetConversationSummaries(userID, limit)
+		if err != nil {
+			log.Printf("Failed to get conversation summaries for %s: %v", userID, err)
+			http.Error(w, "Failed to fetch conversations", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"conversations": summaries,
+		})
+	}
+}
+
+// GetUnreadCounts returns total and per-conversation unread counts for the badge
+// GET /api/v1/messages/unread
+func GetUnreadCounts(database *db.PostgresDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		counts, err := database.GetUnreadCounts(userID)
+		if err != nil {
+			log.Printf("Failed to get unread counts for %s: %v", userID, err)
+			http.Error(w, "Failed to fetch unread counts", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, counts)
+	}
+}
+
 // UpdateMessageStatus updates delivery/read status
-func UpdateMessageStatus(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
+func UpdateMessageStatus(database *db.PostgresDB, hub *websocket.Hub, auditLogger *security.AuditLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		vars := mux.Vars(r)
 		messageIDStr := vars["messageId"]
 
@@ -63,8 +227,9 @@ func UpdateMessageStatus(database *db.PostgresDB, hub *websocket.Hub) http.Handl
 			return
 		}
 
-		// This will also notify the sender via WebSocket
-		// The actual notification is handled in the hub
+		if _, ok := applyMessageStatuses(w, r, database, hub, auditLogger, userID, []uuid.UUID{messageID}, req.Status); !ok {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]interface{}{
@@ -74,6 +239,76 @@ func UpdateMessageStatus(database *db.PostgresDB, hub *websocket.Hub) http.Handl
 	}
 }
 
+// applyMessageStatuses updates message statuses on behalf of a recipient and
+// sends the receipts. Non-recipients get a 403 and an audit entry. Returns
+// the number of messages whose status changed, or false if an error response
+// was written.
+func applyMessageStatuses(w http.ResponseWriter, r *http.Request, database *db.PostgresDB, hub *websocket.Hub, auditLogger *security.AuditLogger, userID uuid.UUID, messageIDs []uuid.UUID, status string) (int, bool) {
+	messages, err := database.UpdateMessageStatuses(userID, messageIDs, status, time.Now().UTC())
+	if errors.Is(err, db.ErrNotMessageRecipient) {
+		log.Printf("SECURITY: User %s tried to mark messages %s without being a recipient", userID, status)
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventInvalidRequest, security.AuditResultDenied, &userID,
+			"Message status change by non-recipient rejected", map[string]any{"status": status, "message_count": len(messageIDs)})
+		http.Error(w, "Not a recipient of every message", http.StatusForbidden)
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Error updating message statuses for user %s: %v", userID, err)
+		http.Error(w, "Failed to update message status", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	if hub != nil {
+		deviceID, _ := middleware.GetDeviceID(r.Context())
+		hub.NotifyMessageStatus(userID, deviceID, messageIDs, messages, status)
+	}
+	return len(messages), true
+}
+
+// maxBulkStatusMessages caps how many messages one bulk status update may touch
+const maxBulkStatusMessages = 500
+
+// UpdateMessageStatuses marks many messages delivered or read at once, e.g.
+// "mark all as read". The caller must be a recipient of every message.
+func UpdateMessageStatuses(database *db.PostgresDB, hub *websocket.Hub, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			MessageIDs []uuid.UUID `json:"message_ids"`
+			Status     string      `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Status != "delivered" && req.Status != "read" {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		if len(req.MessageIDs) == 0 || len(req.MessageIDs) > maxBulkStatusMessages {
+			http.Error(w, fmt.Sprintf("message_ids must contain 1 to %d IDs", maxBulkStatusMessages), http.StatusBadRequest)
+			return
+		}
+
+		updated, ok := applyMessageStatuses(w, r, database, hub, auditLogger, userID, req.MessageIDs, req.Status)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"updated": updated,
+			"status":  req.Status,
+		})
+	}
+}
+
 // ================== Group Handlers ==================
 
 // CreateGroup creates a new group chat
@@ -127,7 +362,7 @@ func GetGroup(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		members, err := database.GetGroupMembers(groupID)
+		members, err := database.ListGroupMembers(r.Context(), groupID)
 		if err != nil {
 			http.Error(w, "Group not found", http.StatusNotFound)
 			return
@@ -182,6 +417,10 @@ func AddGroupMember(database *db.PostgresDB) http.HandlerFunc {
 		}
 
 		if err := database.AddGroupMember(groupID, req.UserID, req.EncryptedKey); err != nil {
+			if errors.Is(err, db.ErrGroupFull) {
+				http.Error(w, "Group is full", http.StatusConflict)
+				return
+			}
 			fmt.Printf("Error adding member to group %s: %v\n", groupID, err)
 			http.Error(w, "Failed to add member", http.StatusInternalServerError)
 			return
@@ -240,3 +479,121 @@ func RemoveGroupMember(database *db.PostgresDB) http.HandlerFunc {
 		writeJSON(w, map[string]string{"status": "removed"})
 	}
 }
+
+// RekeyGroup stores per-member re-encrypted group keys after a membership change
+// and tells every member to fetch theirs. Only admins may rekey, and the keys
+// must cover exactly the current members.
+// POST /api/v1/groups/{groupId}/rekey
+func RekeyGroup(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requesterID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		groupID, err := uuid.Parse(mux.Vars(r)["groupId"])
+		if err != nil {
+			http.Error(w, "Invalid group ID", http.StatusBadRequest)
+			return
+		}
+
+		// AUTHORIZATION: Check if requester is a group admin
+		isAdmin, err := database.IsGroupAdmin(groupID, requesterID)
+		if err != nil {
+			log.Printf("Error checking group admin status for user %s: %v", requesterID, err)
+			http.Error(w, "Failed to verify permissions", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			http.Error(w, "Only group admins can rekey the group", http.StatusForbidden)
+			return
+		}
+
+		var req struct {
+			Keys []struct {
+				UserID       uuid.UUID `json:"user_id"`
+				EncryptedKey string    `json:"encrypted_key"`
+			} `json:"keys"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		keys := make(map[uuid.UUID]string, len(req.Keys))
+		for _, k := range req.Keys {
+			if k.EncryptedKey == "" {
+				http.Error(w, "Missing encrypted key", http.StatusBadRequest)
+				return
+			}
+			keys[k.UserID] = k.EncryptedKey
+		}
+
+		keyVersion, err := database.RekeyGroup(groupID, keys)
+		if errors.Is(err, db.ErrRekeyMembersMismatch) {
+			http.Error(w, "Keys must cover exactly the current group members", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Error rekeying group %s: %v", groupID, err)
+			http.Error(w, "Failed to rekey group", http.StatusInternalServerError)
+			return
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"group_id":    groupID,
+			"key_version": keyVersion,
+		})
+		for memberID := range keys {
+			hub.SendToUser(memberID.String(), &models.WebSocketMessage{
+				Type:      models.MessageTypeGroupRekey,
+				SenderID:  requesterID,
+				Timestamp: time.Now().UTC(),
+				Payload:   payload,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"status":      "rekeyed",
+			"key_version": keyVersion,
+		})
+	}
+}
+
+// GetGroupKey returns the caller's encrypted copy of the current group key
+// GET /api/v1/groups/{groupId}/key
+func GetGroupKey(database *db.PostgresDB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		groupID, err := uuid.Parse(mux.Vars(r)["groupId"])
+		if err != nil {
+			http.Error(w, "Invalid group ID", http.StatusBadRequest)
+			return
+		}
+
+		encryptedKey, keyVersion, err := database.GetGroupKey(groupID, userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Not a member of this group", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching group key for %s in %s: %v", userID, groupID, err)
+			http.Error(w, "Failed to fetch group key", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"group_id":      groupID,
+			"encrypted_key": encryptedKey,
+			"key_version":   keyVersion,
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package handlers
+
+// Abuse reports. Messages are end-to-end encrypted, so a report carries only
+// metadata plus whatever evidence the reporter submits from their own
+// decrypted copy; admins review the queue and dismiss, warn or ban.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jaydenbeard/messaging-app/internal/auth"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/jaydenbeard/messaging-app/internal/websocket"
+)
+
+// reportReasons are the categories a report can be filed under
+var reportReasons = []string{"spam", "harassment", "hate", "sexual", "violence", "other"}
+
+// reportActions are the decisions an admin can take on a report
+var reportActions = []string{"dismiss", "warn", "ban"}
+
+// ReportPolicy limits how much reporting one user can do
+type ReportPolicy struct {
+	// PerHour caps reports filed per user per hour
+	PerHour int
+	// MaxEvidenceBytes caps the evidence attached to one report
+	MaxEvidenceBytes int
+}
+
+// CreateReport files an abuse report against a user, optionally about one
+// message the reporter can see. The reported user is never told who
+// reported them.
+// POST /api/v1/reports
+func CreateReport(database *db.PostgresDB, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger, policy ReportPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			ReportedUserID *uuid.UUID `json:"reported_user_id"`
+			MessageID      *uuid.UUID `json:"message_id"`
+			Reason         string     `json:"reason"`
+			Evidence       string     `json:"evidence"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, int64(policy.MaxEvidenceBytes)+4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(reportReasons, req.Reason) {
+			http.Error(w, "Invalid reason", http.StatusBadRequest)
+			return
+		}
+		if len(req.Evidence) > policy.MaxEvidenceBytes || !utf8.ValidString(req.Evidence) {
+			http.Error(w, "Evidence too large or not valid UTF-8", http.StatusBadRequest)
+			return
+		}
+		if req.ReportedUserID == nil && req.MessageID == nil {
+			http.Error(w, "reported_user_id or message_id is required", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := redisClient.CheckRateLimit("reports:"+userID.String(), policy.PerHour, time.Hour)
+		if err != nil {
+			log.Printf("Warning: report rate limit check failed for user %s: %v", userID, err)
+		} else if !allowed {
+			auditLogger.LogSecurityEvent(r.Context(), security.AuditEventRateLimited, security.AuditResultDenied, &userID,
+				"Abuse report rate limit exceeded", map[string]any{"limit_per_hour": policy.PerHour})
+			http.Error(w, "Too many reports, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		report := &db.Report{
+			ReporterID: userID,
+			MessageID:  req.MessageID,
+			Reason:     req.Reason,
+			Evidence:   req.Evidence,
+		}
+
+		if req.MessageID != nil {
+			// A message can only be reported by someone it was sent to, and
+			// the report is always against its sender
+			msg, err := database.GetMessage(*req.MessageID)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to get message %s for report: %v", *req.MessageID, err)
+				http.Error(w, "Failed to file report", http.StatusInternalServerError)
+				return
+			}
+			canSee := msg.ReceiverID != nil && *msg.ReceiverID == userID
+			if msg.GroupID != nil {
+				canSee, err = database.IsGroupMember(*msg.GroupID, userID)
+				if err != nil {
+					log.Printf("Failed to check group membership for report: %v", err)
+					http.Error(w, "Failed to file report", http.StatusInternalServerError)
+					return
+				}
+			}
+			if !canSee || msg.SenderID == userID {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			if req.ReportedUserID != nil && *req.ReportedUserID != msg.SenderID {
+				http.Error(w, "reported_user_id must be the message's sender", http.StatusBadRequest)
+				return
+			}
+			report.ReportedUserID = msg.SenderID
+		} else {
+			if *req.ReportedUserID == userID {
+				http.Error(w, "Cannot report yourself", http.StatusBadRequest)
+				return
+			}
+			if _, err := database.GetUserByID(*req.ReportedUserID); err != nil {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			report.ReportedUserID = *req.ReportedUserID
+		}
+
+		if err := database.CreateReport(r.Context(), report); err != nil {
+			if errors.Is(err, db.ErrDuplicateReport) {
+				http.Error(w, "You have already reported this message", http.StatusConflict)
+				return
+			}
+			log.Printf("Failed to create report by %s: %v", userID, err)
+			http.Error(w, "Failed to file report", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("[Reports] Report %s filed against %s (%s)", report.ReportID, report.ReportedUserID, report.Reason)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, map[string]interface{}{
+			"report_id":  report.ReportID,
+			"status":     report.Status,
+			"created_at": report.CreatedAt,
+		})
+	}
+}
+
+// ListReports returns the review queue, oldest first
+// GET /api/v1/admin/reports?status=open&limit=50&cursor=
+func ListReports(database *db.PostgresDB, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		q := r.URL.Query()
+		status := q.Get("status")
+		if status == "" {
+			status = "open"
+		} else if status == "all" {
+			status = ""
+		} else if status != "open" && status != "dismissed" && status != "actioned" {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+		limit := 50
+		if l := q.Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 200 {
+				limit = parsed
+			}
+		}
+
+		reports, next, err := database.ListReports(r.Context(), status, q.Get("cursor"), limit)
+		if err != nil {
+			if errors.Is(err, db.ErrInvalidCursor) {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+			log.Printf("Failed to list reports: %v", err)
+			http.Error(w, "Failed to list reports", http.StatusInternalServerError)
+			return
+		}
+
+		// Evidence is user content, so reading it is auditable
+		auditLogger.LogAdminAction(adminID, "reports_list", "reports", "", map[string]any{
+			"status":  status,
+			"results": len(reports),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, map[string]interface{}{
+			"reports":     reports,
+			"next_cursor": next,
+		})
+	}
+}
+
+// GetReport returns one report with its evidence
+// GET /api/v1/admin/reports/{reportId}
+func GetReport(database *db.PostgresDB, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		reportID, err := uuid.Parse(mux.Vars(r)["reportId"])
+		if err != nil {
+			http.Error(w, "Invalid report ID", http.StatusBadRequest)
+			return
+		}
+
+		report, err := database.GetReport(r.Context(), reportID)
+		if errors.Is(err, db.ErrReportNotFound) {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get report %s: %v", reportID, err)
+			http.Error(w, "Failed to get report", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.LogAdminAction(adminID, "report_view", "reports", reportID.String(), nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, report)
+	}
+}
+
+// ResolveReport closes an open report. "warn" sends the reported user an
+// account_warning, "ban" bans them the same way the ban endpoint does.
+// POST /api/v1/admin/reports/{reportId}/resolve
+func ResolveReport(database *db.PostgresDB, hub *websocket.Hub, authService *auth.AuthService, redisClient *pubsub.RedisClient, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		reportID, err := uuid.Parse(mux.Vars(r)["reportId"])
+		if err != nil {
+			http.Error(w, "Invalid report ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Action string `json:"action"`
+			Note   string `json:"note"` // shown to the user with a warning
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(reportActions, req.Action) {
+			http.Error(w, "Invalid action (expected dismiss, warn or ban)", http.StatusBadRequest)
+			return
+		}
+
+		report, err := database.GetReport(r.Context(), reportID)
+		if errors.Is(err, db.ErrReportNotFound) {
+			http.Error(w, "Report not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to get report %s: %v", reportID, err)
+			http.Error(w, "Failed to resolve report", http.StatusInternalServerError)
+			return
+		}
+		if report.Status != "open" {
+			http.Error(w, "Report already resolved", http.StatusConflict)
+			return
+		}
+		if req.Action == "ban" && report.ReportedUserID == adminID {
+			http.Error(w, "Cannot ban yourself", http.StatusBadRequest)
+			return
+		}
+
+		// Claim the report first so two admins can't both act on it
+		report, err = database.ResolveReport(r.Context(), reportID, adminID, req.Action)
+		if errors.Is(err, db.ErrReportResolved) {
+			http.Error(w, "Report already resolved", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to resolve report %s: %v", reportID, err)
+			http.Error(w, "Failed to resolve report", http.StatusInternalServerError)
+			return
+		}
+
+		switch req.Action {
+		case "warn":
+			payload, _ := json.Marshal(map[string]interface{}{
+				"reason": report.Reason,
+				"note":   req.Note,
+			})
+			hub.NotifyUser(report.ReportedUserID, &models.WebSocketMessage{
+				Type:      models.MessageTypeAccountWarning,
+				Timestamp: time.Now().UTC(),
+				Payload:   payload,
+			}, map[string]interface{}{
+				"type": models.MessageTypeAccountWarning,
+			})
+		case "ban":
+			err := banAccount(r, authService, redisClient, auditLogger, adminID, report.ReportedUserID, map[string]any{
+				"reason":    report.Reason,
+				"report_id": reportID.String(),
+			})
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Failed to ban user %s for report %s: %v", report.ReportedUserID, reportID, err)
+				http.Error(w, "Report resolved but the ban failed; ban the user directly", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		auditLogger.LogAdminAction(adminID, "report_resolve", "reports", reportID.String(), map[string]any{
+			"action":           req.Action,
+			"reported_user_id": report.ReportedUserID.String(),
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, report)
+	}
+}
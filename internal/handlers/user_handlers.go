@@ -4,14 +4,19 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/jaydenbeard/messaging-app/internal/config"
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
 	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 )
 
 // GetCurrentUser returns the authenticated user's profile
@@ -128,9 +133,48 @@ func UpdateUser(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		if err := database.UpdateUser(userID, updates); err != nil {
-			http.Error(w, "Failed to update user", http.StatusInternalServerError)
-			return
+		// Avatars must come from UploadAvatar; arbitrary URLs would make every
+		// client that renders the profile fetch from wherever the user chose
+		if raw, ok := updates["avatar_url"]; ok {
+			avatar, isString := raw.(string)
+			if !isString || (avatar != "" && !isOwnAvatarURL(avatar, userID)) {
+				http.Error(w, "avatar_url must be an avatar uploaded via /api/v1/users/me/avatar", http.StatusBadRequest)
+				return
+			}
+			if avatar == "" {
+				updates["avatar_url"] = nil
+			}
+		}
+
+		// Usernames are claimed on their own so a name taken since the
+		// availability check gets a clear conflict instead of a generic failure
+		raw, hasUsername := updates["username"]
+		if hasUsername {
+			username, isString := raw.(string)
+			if !isString {
+				http.Error(w, "Invalid username", http.StatusBadRequest)
+				return
+			}
+			if err := validateUsername(username); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := database.ClaimUsername(userID, username); err != nil {
+				if errors.Is(err, db.ErrUsernameTaken) {
+					http.Error(w, "Username is already taken", http.StatusConflict)
+					return
+				}
+				http.Error(w, "Failed to update user", http.StatusInternalServerError)
+				return
+			}
+			delete(updates, "username")
+		}
+
+		if len(updates) > 0 || !hasUsername {
+			if err := database.UpdateUser(userID, updates); err != nil {
+				http.Error(w, "Failed to update user", http.StatusInternalServerError)
+				return
+			}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -138,8 +182,9 @@ func UpdateUser(database *db.PostgresDB) http.HandlerFunc {
 	}
 }
 
-// DeleteUser permanently deletes a user account
-func DeleteUser(database *db.PostgresDB) http.HandlerFunc {
+// DeleteUser permanently deletes a user account, then the user's media and
+// avatars in the background
+func DeleteUser(database *db.PostgresDB, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -148,15 +193,94 @@ func DeleteUser(database *db.PostgresDB) http.HandlerFunc {
 		}
 
 		// Delete user and all associated data
-		if err := database.DeleteUser(userID); err != nil {
+		storage, err := database.DeleteUser(userID)
+		if err != nil {
 			// Log the actual error for debugging
 			fmt.Printf("Error deleting user %s: %v\n", userID, err)
 			// Return generic error to client
 			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
 			return
 		}
+		go removeUserStorage(cfg, userID, storage)
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]string{"status": "deleted"})
 	}
 }
+
+// ExportUserData returns everything the server holds about the current
+// user's account: profile, devices, privacy settings, friends and blocked
+// users. Messages are end-to-end encrypted and only exist on devices, so
+// they are not part of the export.
+// GET /api/v1/users/me/export
+func ExportUserData(database *db.PostgresDB, auditLogger *security.AuditLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		profile, err := database.GetUserByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		devices, err := database.GetUserDevices(userID)
+		if err != nil {
+			log.Printf("Error exporting devices for user %s: %v", userID, err)
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+		privacy, err := database.GetPrivacySettings(userID)
+		if err != nil {
+			log.Printf("Error exporting privacy settings for user %s: %v", userID, err)
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+
+		friends := []db.FriendInfo{}
+		for cursor := ""; ; {
+			page, next, err := database.GetFriends(userID, maxContactPageSize, cursor)
+			if err != nil {
+				log.Printf("Error exporting friends for user %s: %v", userID, err)
+				http.Error(w, "Failed to export data", http.StatusInternalServerError)
+				return
+			}
+			friends = append(friends, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		blocked := []map[string]interface{}{}
+		for cursor := ""; ; {
+			page, next, err := database.GetBlockedUsers(userID, maxContactPageSize, cursor)
+			if err != nil {
+				log.Printf("Error exporting blocked users for user %s: %v", userID, err)
+				http.Error(w, "Failed to export data", http.StatusInternalServerError)
+				return
+			}
+			blocked = append(blocked, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		auditLogger.LogSecurityEvent(r.Context(), security.AuditEventDataExport, security.AuditResultSuccess, &userID, "Account data exported", nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]interface{}{
+			"exported_at":   time.Now().UTC().Format(time.RFC3339),
+			"profile":       profile,
+			"devices":       devices,
+			"privacy":       privacy,
+			"friends":       friends,
+			"blocked_users": blocked,
+		})
+	}
+}
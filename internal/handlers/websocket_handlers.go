@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -21,6 +23,7 @@ import (
 	"github.com/jaydenbeard/messaging-app/internal/db"
 	"github.com/jaydenbeard/messaging-app/internal/middleware"
 	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	security "github.com/jaydenbeard/messaging-app/internal/security"
 	"github.com/jaydenbeard/messaging-app/internal/websocket"
 )
@@ -213,7 +216,7 @@ var upgrader = ws.Upgrader{
 // Note: Device handlers (GetDevices, RemoveDevice, PIN, Device Approval, Keys, Search) moved to device_handlers.go
 // Note: Message handlers (GetMessages, UpdateMessageStatus) moved to message_handlers.go
 // Note: Group handlers (CreateGroup, GetGroup, AddGroupMember, RemoveGroupMember) moved to message_handlers.go
-// Note: Media handlers (GetUploadURL, GetPrivacySettings, UpdatePrivacySetting, GetMediaURL, UploadProxy, DownloadProxy) moved to media_handlers.go
+// Note: Media handlers (GetUploadURL, GetPrivacySettings, UpdatePrivacySetting, GetMediaURL, UploadProxy, DownloadWithToken) moved to media_handlers.go
 
 // ================== WebSocket Handler ==================
 
@@ -310,25 +313,25 @@ func BlockUser(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 			return
 		}
 
-		// Insert into blocked_users table
-		if err := database.BlockUser(blockerID, blockedID); err != nil {
+		// Insert into blocked_users table, severing any friendship or pending request
+		relationshipRemoved, err := database.BlockUser(blockerID, blockedID)
+		if err != nil {
 			log.Printf("Error blocking user: %v", err)
 			http.Error(w, "Failed to block user", http.StatusInternalServerError)
 			return
 		}
-
-		// Send WebSocket notification to the blocked user to remove the conversation
 		if hub != nil {
-			payload, err := json.Marshal(map[string]string{"blocker_id": blockerID.String()})
-			if err != nil {
-				log.Printf("Error marshaling block notification: %v", err)
-			} else {
-				blockNotification := &models.WebSocketMessage{
-					Type:    "user_blocked",
-					Payload: payload,
-				}
-				hub.SendToUser(blockedID.String(), blockNotification)
-			}
+			hub.InvalidateBlockStatus(blockerID, blockedID)
+		}
+
+		// Ask the blocked user's client to refresh its contacts. The notification
+		// is the same one sent for any relationship change, so it doesn't reveal
+		// the block itself.
+		if hub != nil && relationshipRemoved {
+			hub.SendToUser(blockedID.String(), &models.WebSocketMessage{
+				Type:    models.MessageTypeContactsChanged,
+				Payload: json.RawMessage(`{}`),
+			})
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -337,7 +340,7 @@ func BlockUser(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 }
 
 // UnblockUser unblocks a user
-func UnblockUser(database *db.PostgresDB) http.HandlerFunc {
+func UnblockUser(database *db.PostgresDB, hub *websocket.Hub) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		blockerID, ok := middleware.GetUserID(r.Context())
 		if !ok {
@@ -364,13 +367,17 @@ func UnblockUser(database *db.PostgresDB) http.HandlerFunc {
 			http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
 			return
 		}
+		if hub != nil {
+			hub.InvalidateBlockStatus(blockerID, blockedID)
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, map[string]bool{"success": true})
 	}
 }
 
-// GetBlockedUsers returns list of users blocked by the current user
+// GetBlockedUsers returns a page of users blocked by the current user, with
+// the next page cursor in the X-Next-Cursor header
 func GetBlockedUsers(database *db.PostgresDB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		blockerID, ok := middleware.GetUserID(r.Context())
@@ -379,7 +386,11 @@ func GetBlockedUsers(database *db.PostgresDB) http.HandlerFunc {
 			return
 		}
 
-		blockedUsers, err := database.GetBlockedUsers(blockerID)
+		blockedUsers, next, err := database.GetBlockedUsers(blockerID, contactPageLimit(r), r.URL.Query().Get("cursor"))
+		if errors.Is(err, db.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			log.Printf("Error getting blocked users: %v", err)
 			http.Error(w, "Failed to get blocked users", http.StatusInternalServerError)
@@ -391,6 +402,9 @@ func GetBlockedUsers(database *db.PostgresDB) http.HandlerFunc {
 			blockedUsers = []map[string]interface{}{}
 		}
 
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		writeJSON(w, blockedUsers)
 	}
@@ -427,7 +441,10 @@ func IsBlocked(database *db.PostgresDB) http.HandlerFunc {
 }
 
 // WebSocketHandler handles WebSocket upgrade and connection
-func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.HandlerFunc {
+func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService, redisClient *pubsub.RedisClient, compression websocket.CompressionConfig) http.HandlerFunc {
+	wsUpgrader := upgrader
+	wsUpgrader.EnableCompression = compression.Enabled
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		// SECURITY: Handle CORS preflight requests
 		if r.Method == http.MethodOptions {
@@ -452,6 +469,13 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 			return
 		}
 
+		// Existing connections keep draining during maintenance; only new ones are refused
+		if redisClient.IsMaintenanceMode() {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+
 		// Get token from multiple sources (in order of preference)
 		token := ""
 
@@ -481,7 +505,22 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 			}
 		}
 
-		// 3. Fallback to query param (required for browser WebSocket API)
+		// 3. Single-use ticket from POST /api/v1/ws-ticket (preferred for browsers)
+		// Keeps the long-lived JWT out of URLs and therefore out of proxy/server logs
+		if token == "" {
+			if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+				redeemed, err := redisClient.RedeemWSTicket(ticket)
+				if err != nil {
+					log.Printf("SECURITY: Invalid or expired WebSocket ticket from IP=%s fingerprint=%s", clientIP, requestFingerprint)
+					wsTracker.recordConnectionAttempt(clientIP, false)
+					http.Error(w, "Invalid or expired ticket", http.StatusUnauthorized)
+					return
+				}
+				token = redeemed
+			}
+		}
+
+		// 4. Fallback to query param (legacy browser clients)
 		// Note: WebSocket API in browsers doesn't support custom headers during handshake
 		// so query params are the standard approach for browser-based WebSocket auth
 		if token == "" {
@@ -500,6 +539,10 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 		if err != nil {
 			log.Printf("SECURITY: Invalid WebSocket token from IP=%s fingerprint=%s error=%v", clientIP, requestFingerprint, err)
 			wsTracker.recordConnectionAttempt(clientIP, false)
+			if err == auth.ErrAccountDisabled {
+				http.Error(w, "Account disabled", http.StatusForbidden)
+				return
+			}
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
@@ -519,7 +562,8 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 				"Sec-WebSocket-Protocol": []string{"Bearer"},
 			}
 		}
-		conn, err := upgrader.Upgrade(w, r, responseHeader)
+		wire := websocket.NewWireCounter(w)
+		conn, err := wsUpgrader.Upgrade(wire, r, responseHeader)
 		if err != nil {
 			log.Printf("SECURITY: WebSocket upgrade failed for user=%s IP=%s error=%v", claims.UserID, clientIP, err)
 			return
@@ -527,6 +571,15 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 
 		// Create client with connection metadata for security tracking
 		client := websocket.NewClient(hub, conn, claims.UserID, claims.DeviceID, token)
+		client.SetClientInfo(clientInfo(r))
+		if hub.RejectOutdatedClient(client) {
+			return
+		}
+		if compression.Negotiated(r) {
+			if err := client.EnableCompression(compression, wire); err != nil {
+				log.Printf("Warning: failed to enable WebSocket compression: %v", err)
+			}
+		}
 
 		// Register with hub
 		hub.Register(client)
@@ -537,6 +590,57 @@ func WebSocketHandler(hub *websocket.Hub, authService *auth.AuthService) http.Ha
 	}
 }
 
+// clientInfo returns the platform and app version a connecting client
+// reports, unvalidated, from the X-Platform and X-App-Version headers or, for browsers
+// that can't set headers, the platform and app_version query parameters
+func clientInfo(r *http.Request) (platform, version string) {
+	platform = r.Header.Get(middleware.PlatformHeader)
+	if platform == "" {
+		platform = r.URL.Query().Get("platform")
+	}
+	version = r.Header.Get(middleware.AppVersionHeader)
+	if version == "" {
+		version = r.URL.Query().Get("app_version")
+	}
+	return platform, version
+}
+
+// IssueWebSocketTicket exchanges the caller's access token for a short-lived,
+// single-use ticket to pass as /ws?ticket=...
+// POST /api/v1/ws-ticket
+func IssueWebSocketTicket(redisClient *pubsub.RedisClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// AuthMiddleware has already validated the bearer token
+		accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		ticketBytes := make([]byte, 32)
+		if _, err := rand.Read(ticketBytes); err != nil {
+			http.Error(w, "Failed to generate ticket", http.StatusInternalServerError)
+			return
+		}
+		ticket := base64.RawURLEncoding.EncodeToString(ticketBytes)
+
+		if err := redisClient.CreateWSTicket(ticket, accessToken); err != nil {
+			log.Printf("[WS] Failed to store ticket for user %s: %v", userID, err)
+			http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, map[string]interface{}{
+			"ticket":     ticket,
+			"expires_in": int(pubsub.WSTicketTTL.Seconds()),
+		})
+	}
+}
+
 // CSPReportHandler handles Content Security Policy violation reports
 func CSPReportHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
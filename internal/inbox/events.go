@@ -0,0 +1,162 @@
+package inbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultMaxEvents caps the number of events held per user. Events only
+// describe changes to messages and their size is capped by the hub, so older
+// ones are simply dropped.
+const DefaultMaxEvents = 1000
+
+// addEventScript numbers one event, appends it as "<seq>|<event>", keeps
+// only the newest entries and refreshes the TTLs. Device cursors live as long
+// as the events so a cursor never expires before the events it covers.
+// KEYS: events, sequence, cursors. ARGV: event, max events, ttl seconds.
+var addEventScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[2])
+redis.call('RPUSH', KEYS[1], seq .. '|' .. ARGV[1])
+redis.call('LTRIM', KEYS[1], -tonumber(ARGV[2]), -1)
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('EXPIRE', KEYS[3], ARGV[3])
+end
+return seq
+`)
+
+// ackEventsScript moves a device's cursor forward, never back.
+// KEYS: cursors. ARGV: device, seq, ttl seconds.
+var ackEventsScript = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '-1')
+if tonumber(ARGV[2]) > current then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// InboxEvent is a non-message event (reaction, edit, delete) held for a user
+// who was offline when it happened. Payload is relayed as sent by the client.
+type InboxEvent struct {
+	Type      string          `json:"type"`
+	MessageID uuid.UUID       `json:"message_id"`
+	SenderID  uuid.UUID       `json:"sender_id"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// eventsKey holds a user's pending events, oldest first. Hash-tagged like
+// overflowKey so ClearInbox can delete it with the inbox on Redis Cluster.
+func eventsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("{inbox:%s}:events", userID.String())
+}
+
+// legacyEventsKey is where the previous release held events. They are read
+// as un-numbered events until they expire; remove after one release.
+func legacyEventsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("inbox_events:%s", userID.String())
+}
+
+// eventSeqKey numbers a user's events; eventCursorsKey records, per device,
+// the last event number that device has received. Events are kept per user
+// and read by every device, so each device's cursor says where it is.
+func eventSeqKey(userID uuid.UUID) string {
+	return fmt.Sprintf("{inbox:%s}:event_seq", userID.String())
+}
+
+func eventCursorsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("{inbox:%s}:event_cursors", userID.String())
+}
+
+// AddEvent stores an event for each of the given offline users, pipelined.
+// Every device of the user receives it once.
+func (r *RedisInbox) AddEvent(userIDs []uuid.UUID, event *InboxEvent) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Preload so the pipelined EVALSHA calls cannot hit NOSCRIPT
+	if err := addEventScript.Load(r.ctx, r.client).Err(); err != nil {
+		return err
+	}
+
+	pipe := r.client.Pipeline()
+	for _, userID := range userIDs {
+		addEventScript.EvalSha(r.ctx, pipe,
+			[]string{eventsKey(userID), eventSeqKey(userID), eventCursorsKey(userID)},
+			string(data), DefaultMaxEvents, int64(r.ttl.Seconds()))
+	}
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+// GetPendingDeviceEvents returns the events a device hasn't received yet,
+// oldest first, and the number to pass to AckDeviceEvents once they are
+// sent. Events older than the inbox TTL are skipped.
+//
+// Events stored before they were numbered count as number 0 and go to each
+// device that has never acked, until they age out.
+func (r *RedisInbox) GetPendingDeviceEvents(userID, deviceID uuid.UUID) ([]*InboxEvent, int64, error) {
+	cursor := int64(-1)
+	if val, err := r.client.HGet(r.ctx, eventCursorsKey(userID), deviceID.String()).Int64(); err == nil {
+		cursor = val
+	} else if err != redis.Nil {
+		return nil, 0, err
+	}
+
+	results, err := r.client.LRange(r.ctx, eventsKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	legacy, err := r.client.LRange(r.ctx, legacyEventsKey(userID), 0, -1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	results = append(legacy, results...)
+
+	cutoff := time.Now().Add(-r.ttl)
+	last := cursor
+	events := make([]*InboxEvent, 0, len(results))
+	for _, entry := range results {
+		seq, data := int64(0), entry
+		if prefix, rest, ok := strings.Cut(entry, "|"); ok {
+			if n, err := strconv.ParseInt(prefix, 10, 64); err == nil {
+				seq, data = n, rest
+			}
+		}
+		if seq <= cursor {
+			continue
+		}
+		last = max(last, seq)
+
+		var event InboxEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue
+		}
+		if event.Timestamp.Before(cutoff) {
+			continue
+		}
+		events = append(events, &event)
+	}
+
+	return events, max(last, 0), nil
+}
+
+// AckDeviceEvents records that a device has received every event up to seq
+func (r *RedisInbox) AckDeviceEvents(userID, deviceID uuid.UUID, seq int64) error {
+	return ackEventsScript.Run(r.ctx, r.client, []string{eventCursorsKey(userID)},
+		deviceID.String(), seq, int64(r.ttl.Seconds())).Err()
+}
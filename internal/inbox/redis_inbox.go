@@ -4,17 +4,47 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
 	"github.com/redis/go-redis/v9"
 )
 
+// DefaultTTL is how long an undelivered message stays in a Redis inbox.
+// The database copy is unaffected and remains for compliance/cleanup jobs.
+const DefaultTTL = 30 * 24 * time.Hour
+
+// DefaultMaxSize caps the number of messages held per inbox. Beyond it the
+// oldest entries are evicted and the user is flagged for a full resync.
+const DefaultMaxSize = 10000
+
+// addScript adds one message, refreshes the inbox TTL and evicts the oldest
+// entries beyond the cap, counting evictions in the overflow key.
+// KEYS: inbox, overflow. ARGV: score, member, ttl seconds, max size.
+var addScript = redis.NewScript(`
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+local over = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[4])
+if over > 0 then
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, over - 1)
+	redis.call('INCRBY', KEYS[2], over)
+	redis.call('EXPIRE', KEYS[2], ARGV[3])
+	return over
+end
+return 0
+`)
+
 // RedisInbox manages user message inboxes using Redis ZSETs
 // This enables efficient offline message storage with timestamp ordering
 type RedisInbox struct {
-	client *redis.Client
-	ctx    context.Context
+	client  redis.UniversalClient
+	ctx     context.Context
+	ttl     time.Duration
+	maxSize int64
 }
 
 // InboxMessage represents a message stored in the inbox
@@ -27,16 +57,50 @@ type InboxMessage struct {
 	MediaID     *uuid.UUID `json:"media_id,omitempty"`
 	MediaType   string     `json:"media_type,omitempty"`
 	Timestamp   time.Time  `json:"timestamp"`
+
+	// DeviceID restricts the entry to one of the user's devices. It is set
+	// for deliveries parked by flow control, which the user's other devices
+	// already received. Unset entries go to whichever device drains first.
+	DeviceID *uuid.UUID `json:"device_id,omitempty"`
+
+	// Attempts counts deliveries of this message to DeviceID that went unacked
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// forDevice reports whether the entry may be delivered to deviceID
+func (m *InboxMessage) forDevice(deviceID uuid.UUID) bool {
+	return m.DeviceID == nil || *m.DeviceID == deviceID
 }
 
 // NewRedisInbox creates a new Redis inbox manager
-func NewRedisInbox(client *redis.Client) *RedisInbox {
+func NewRedisInbox(client redis.UniversalClient) *RedisInbox {
 	return &RedisInbox{
-		client: client,
-		ctx:    context.Background(),
+		client:  client,
+		ctx:     context.Background(),
+		ttl:     DefaultTTL,
+		maxSize: DefaultMaxSize,
+	}
+}
+
+// SetMaxSize changes the per-inbox message cap (non-positive values are ignored)
+func (r *RedisInbox) SetMaxSize(maxSize int64) {
+	if maxSize > 0 {
+		r.maxSize = maxSize
 	}
 }
 
+// SetTTL changes how long undelivered messages are kept (non-positive values are ignored)
+func (r *RedisInbox) SetTTL(ttl time.Duration) {
+	if ttl > 0 {
+		r.ttl = ttl
+	}
+}
+
+// expiryScore is the lowest score (message timestamp) still within the TTL
+func (r *RedisInbox) expiryScore() float64 {
+	return float64(time.Now().Add(-r.ttl).UnixNano())
+}
+
 // AddToInbox adds a message to a user's offline inbox using ZADD
 // Score is the Unix timestamp for ordering
 func (r *RedisInbox) AddToInbox(userID uuid.UUID, message *InboxMessage) error {
@@ -50,10 +114,15 @@ func (r *RedisInbox) AddToInbox(userID uuid.UUID, message *InboxMessage) error {
 	// Use timestamp as score for ordering
 	score := float64(message.Timestamp.UnixNano())
 
-	return r.client.ZAdd(r.ctx, key, redis.Z{
-		Score:  score,
-		Member: string(data),
-	}).Err()
+	// The key expires once no message has been added for a full TTL, so an
+	// abandoned inbox is dropped entirely; older members are pruned by score
+	evicted, err := addScript.Run(r.ctx, r.client, []string{key, overflowKey(userID)},
+		score, string(data), int64(r.ttl.Seconds()), r.maxSize).Int64()
+	if err != nil {
+		return err
+	}
+	r.recordEvictions(userID, evicted)
+	return nil
 }
 
 // AddMultipleToInbox adds a message to multiple users' inboxes (for group messages)
@@ -66,28 +135,101 @@ func (r *RedisInbox) AddMultipleToInbox(userIDs []uuid.UUID, message *InboxMessa
 
 	score := float64(message.Timestamp.UnixNano())
 
+	// Preload so the pipelined EVALSHA calls cannot hit NOSCRIPT
+	if err := addScript.Load(r.ctx, r.client).Err(); err != nil {
+		return err
+	}
+
 	pipe := r.client.Pipeline()
-	for _, userID := range userIDs {
+	cmds := make([]*redis.Cmd, len(userIDs))
+	for i, userID := range userIDs {
 		key := fmt.Sprintf("inbox:%s", userID.String())
-		pipe.ZAdd(r.ctx, key, redis.Z{
-			Score:  score,
-			Member: string(data),
-		})
+		cmds[i] = addScript.EvalSha(r.ctx, pipe, []string{key, overflowKey(userID)},
+			score, string(data), int64(r.ttl.Seconds()), r.maxSize)
 	}
 
 	_, err = pipe.Exec(r.ctx)
+	for i, cmd := range cmds {
+		if evicted, cmdErr := cmd.Int64(); cmdErr == nil {
+			r.recordEvictions(userIDs[i], evicted)
+		}
+	}
 	return err
 }
 
-// GetPendingMessages retrieves all pending messages for a user
+// overflowKey counts messages evicted from a user's inbox since their last resync.
+// The hash tag puts it in the inbox key's Redis Cluster slot, which addScript
+// and ClearInbox need.
+func overflowKey(userID uuid.UUID) string {
+	return fmt.Sprintf("{inbox:%s}:overflow", userID.String())
+}
+
+// legacyOverflowKey is where the previous release counted evictions. It is
+// still read and cleared so those evictions trigger a resync; remove once
+// the keys have expired.
+func legacyOverflowKey(userID uuid.UUID) string {
+	return fmt.Sprintf("inbox_overflow:%s", userID.String())
+}
+
+func (r *RedisInbox) recordEvictions(userID uuid.UUID, evicted int64) {
+	if evicted <= 0 {
+		return
+	}
+	metrics.InboxEvictedTotal.Add(float64(evicted))
+	log.Printf("[Inbox] Inbox for user %s over cap (%d), evicted %d oldest messages", userID, r.maxSize, evicted)
+}
+
+// GetEvictedCount returns how many messages were evicted from the user's inbox
+// since the last AckEvicted. Non-zero means the client must resync from the server.
+func (r *RedisInbox) GetEvictedCount(userID uuid.UUID) (int64, error) {
+	pipe := r.client.Pipeline()
+	current := pipe.Get(r.ctx, overflowKey(userID))
+	legacy := pipe.Get(r.ctx, legacyOverflowKey(userID))
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+	count, _ := current.Int64()
+	legacyCount, _ := legacy.Int64()
+	return count + legacyCount, nil
+}
+
+// AckEvicted clears evictions the client has been told about. Evictions that
+// happened in the meantime are preserved.
+func (r *RedisInbox) AckEvicted(userID uuid.UUID, count int64) error {
+	if count <= 0 {
+		return nil
+	}
+
+	// Evictions under the legacy key are acked first, as they are the oldest
+	legacy, err := r.client.GetDel(r.ctx, legacyOverflowKey(userID)).Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if count -= legacy; count <= 0 {
+		return nil
+	}
+
+	remaining, err := r.client.DecrBy(r.ctx, overflowKey(userID), count).Result()
+	if err != nil {
+		return err
+	}
+	if remaining <= 0 {
+		return r.client.Del(r.ctx, overflowKey(userID)).Err()
+	}
+	return nil
+}
+
+// GetPendingMessages retrieves up to limit pending messages for a user, or
+// all of them if limit is not positive
 // Returns messages ordered by timestamp (oldest first)
-func (r *RedisInbox) GetPendingMessages(userID uuid.UUID) ([]*InboxMessage, error) {
+func (r *RedisInbox) GetPendingMessages(userID uuid.UUID, limit int64) ([]*InboxMessage, error) {
 	key := fmt.Sprintf("inbox:%s", userID.String())
 
-	// Get all messages ordered by score (timestamp)
+	// Get unexpired messages ordered by score (timestamp)
 	results, err := r.client.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{
-		Min: "-inf",
-		Max: "+inf",
+		Min:   strconv.FormatFloat(r.expiryScore(), 'f', -1, 64),
+		Max:   "+inf",
+		Count: max(limit, 0),
 	}).Result()
 
 	if err != nil {
@@ -106,15 +248,61 @@ func (r *RedisInbox) GetPendingMessages(userID uuid.UUID) ([]*InboxMessage, erro
 	return messages, nil
 }
 
+// GetPendingDeviceMessages is GetPendingMessages for one device: entries
+// parked for the user's other devices are skipped. limit must be positive.
+func (r *RedisInbox) GetPendingDeviceMessages(userID, deviceID uuid.UUID, limit int64) ([]*InboxMessage, error) {
+	key := fmt.Sprintf("inbox:%s", userID.String())
+	minScore := strconv.FormatFloat(r.expiryScore(), 'f', -1, 64)
+
+	// Entries for other devices are rare, so this is normally one page
+	var messages []*InboxMessage
+	for offset := int64(0); int64(len(messages)) < limit; offset += limit {
+		results, err := r.client.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{
+			Min:    minScore,
+			Max:    "+inf",
+			Offset: offset,
+			Count:  limit,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, data := range results {
+			var msg InboxMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				continue
+			}
+			if msg.forDevice(deviceID) && int64(len(messages)) < limit {
+				messages = append(messages, &msg)
+			}
+		}
+		if int64(len(results)) < limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
 // GetPendingCount returns the number of pending messages for a user
 func (r *RedisInbox) GetPendingCount(userID uuid.UUID) (int64, error) {
 	key := fmt.Sprintf("inbox:%s", userID.String())
 	return r.client.ZCard(r.ctx, key).Result()
 }
 
-// RemoveFromInbox removes specific messages from a user's inbox
-// Called after successful delivery
+// RemoveFromInbox removes specific messages from a user's inbox, including
+// entries parked for any of the user's devices
 func (r *RedisInbox) RemoveFromInbox(userID uuid.UUID, messageIDs []uuid.UUID) error {
+	return r.removeMessages(userID, messageIDs, func(*InboxMessage) bool { return true })
+}
+
+// RemoveFromDeviceInbox removes messages a device has acked. Entries parked
+// for the user's other devices are kept, as those devices haven't acked them.
+func (r *RedisInbox) RemoveFromDeviceInbox(userID, deviceID uuid.UUID, messageIDs []uuid.UUID) error {
+	return r.removeMessages(userID, messageIDs, func(msg *InboxMessage) bool { return msg.forDevice(deviceID) })
+}
+
+// removeMessages removes the entries with the given IDs that match
+func (r *RedisInbox) removeMessages(userID uuid.UUID, messageIDs []uuid.UUID, match func(*InboxMessage) bool) error {
 	key := fmt.Sprintf("inbox:%s", userID.String())
 
 	// Get all messages to find the ones to remove
@@ -134,7 +322,7 @@ func (r *RedisInbox) RemoveFromInbox(userID uuid.UUID, messageIDs []uuid.UUID) e
 		if err := json.Unmarshal([]byte(data), &msg); err != nil {
 			continue
 		}
-		if messageIDSet[msg.MessageID] {
+		if messageIDSet[msg.MessageID] && match(&msg) {
 			pipe.ZRem(r.ctx, key, data)
 		}
 	}
@@ -143,10 +331,72 @@ func (r *RedisInbox) RemoveFromInbox(userID uuid.UUID, messageIDs []uuid.UUID) e
 	return err
 }
 
-// ClearInbox removes all messages from a user's inbox
+// PruneExpired removes messages older than the TTL from a user's inbox
+func (r *RedisInbox) PruneExpired(userID uuid.UUID) (int64, error) {
+	key := fmt.Sprintf("inbox:%s", userID.String())
+	return r.client.ZRemRangeByScore(r.ctx, key, "-inf",
+		"("+strconv.FormatFloat(r.expiryScore(), 'f', -1, 64)).Result()
+}
+
+// PruneAllExpired scans every inbox and removes messages older than the TTL.
+// Returns the number of messages removed. On Redis Cluster each master is
+// scanned, as SCAN only covers the node it runs on.
+func (r *RedisInbox) PruneAllExpired(ctx context.Context) (int64, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return r.pruneNode(ctx, r.client)
+	}
+
+	var removed atomic.Int64
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := r.pruneNode(ctx, node)
+		removed.Add(n)
+		return err
+	})
+	return removed.Load(), err
+}
+
+// pruneNode prunes the inboxes stored on one Redis node
+func (r *RedisInbox) pruneNode(ctx context.Context, client redis.UniversalClient) (int64, error) {
+	var removed int64
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, "inbox:*", 500).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		if len(keys) > 0 {
+			maxScore := "(" + strconv.FormatFloat(r.expiryScore(), 'f', -1, 64)
+			pipe := client.Pipeline()
+			cmds := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.ZRemRangeByScore(ctx, key, "-inf", maxScore)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return removed, err
+			}
+			for _, cmd := range cmds {
+				removed += cmd.Val()
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// ClearInbox removes all messages and events from a user's inbox
 func (r *RedisInbox) ClearInbox(userID uuid.UUID) error {
 	key := fmt.Sprintf("inbox:%s", userID.String())
-	return r.client.Del(r.ctx, key).Err()
+	pipe := r.client.Pipeline()
+	pipe.Del(r.ctx, key, overflowKey(userID), eventsKey(userID), eventSeqKey(userID), eventCursorsKey(userID))
+	pipe.Del(r.ctx, legacyOverflowKey(userID))
+	pipe.Del(r.ctx, legacyEventsKey(userID))
+	_, err := pipe.Exec(r.ctx)
+	return err
 }
 
 // GetInboxStats returns statistics about a user's inbox
@@ -0,0 +1,20 @@
+package media
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// ErrAvatarRejected is returned by an AvatarModerator to refuse an image
+var ErrAvatarRejected = errors.New("avatar rejected by moderation")
+
+// AvatarModerator reviews avatar images before they are stored. Unlike
+// message media, avatars are not end-to-end encrypted, so the server can
+// inspect them (e.g. with an image classification service).
+type AvatarModerator interface {
+	// ModerateAvatar returns ErrAvatarRejected (possibly wrapped) to refuse
+	// the image. Any other error fails the upload without blaming the image.
+	ModerateAvatar(ctx context.Context, userID uuid.UUID, contentType string, image []byte) error
+}
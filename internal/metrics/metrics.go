@@ -28,6 +28,20 @@ var (
 		[]string{"server_id", "message_type", "direction"},
 	)
 
+	WebSocketCompressedPayloadBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "messenger_websocket_compressed_payload_bytes_total",
+			Help: "Uncompressed size of outbound WebSocket frames sent with permessage-deflate",
+		},
+	)
+
+	WebSocketCompressionBytesSaved = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "messenger_websocket_compression_bytes_saved_total",
+			Help: "Bytes saved on the wire by permessage-deflate",
+		},
+	)
+
 	// Message metrics
 	MessagesTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -40,10 +54,20 @@ var (
 	MessageDeliveryLatency = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "messenger_message_delivery_latency_seconds",
-			Help:    "Message delivery latency in seconds",
+			Help:    "Time from the server receiving a message to queueing its deliver for a connected recipient",
 			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15), // 1ms to 16s
 		},
-		[]string{"delivery_type"}, // immediate, offline
+		[]string{"path"}, // local, cross_server
+	)
+
+	// Kept apart from MessageDeliveryLatency so hour-long waits for a
+	// recipient to reconnect don't skew real-time delivery percentiles
+	OfflineDeliveryLatency = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "messenger_offline_delivery_latency_seconds",
+			Help:    "Time from the server receiving a message to first sending it from the recipient's inbox",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 22), // 100ms to ~58h
+		},
 	)
 
 	// Authentication metrics
@@ -63,6 +87,14 @@ var (
 		[]string{"result"}, // success, failure, locked
 	)
 
+	SMSSendTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_sms_send_total",
+			Help: "Total number of verification SMS send attempts",
+		},
+		[]string{"provider", "result"}, // clicksend/twilio/log, success/failure
+	)
+
 	// API metrics
 	HTTPRequestsTotal = promauto.NewCounterVec(
 		prometheus.CounterOpts{
@@ -186,7 +218,21 @@ var (
 		},
 	)
 
+	RedeliveriesExhausted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "messenger_redeliveries_exhausted_total",
+			Help: "Total number of unacked messages dropped from redelivery after the redelivery cap",
+		},
+	)
+
 	// Cleanup metrics
+	InboxEvictedTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "messenger_inbox_evicted_total",
+			Help: "Total number of offline inbox messages evicted because an inbox exceeded its size cap",
+		},
+	)
+
 	ExpiredMessagesCleanedUp = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "messenger_expired_messages_cleaned_up_total",
@@ -194,6 +240,91 @@ var (
 		},
 	)
 
+	// Message queue (Redis Streams) metrics
+	QueueConsumerGroupLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "messenger_queue_consumer_group_lag",
+			Help: "Stream entries not yet delivered to the consumer group",
+		},
+		[]string{"stream", "group"},
+	)
+
+	QueueConsumerGroupPending = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "messenger_queue_consumer_group_pending",
+			Help: "Stream entries delivered to the consumer group but not yet acknowledged",
+		},
+		[]string{"stream", "group"},
+	)
+
+	QueueMessagesClaimedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_queue_messages_claimed_total",
+			Help: "Total number of stale pending stream entries claimed from other consumers",
+		},
+		[]string{"stream", "group"},
+	)
+
+	QueueDuplicateEventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_queue_duplicate_events_total",
+			Help: "Total number of redelivered stream events skipped as already processed",
+		},
+		[]string{"stream", "event_type"},
+	)
+
+	// Database metrics, labelled by the PostgresDB method that ran the statement
+	DBQueryDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "messenger_db_query_duration_seconds",
+			Help:    "Database statement latency in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to 4s
+		},
+		[]string{"query"},
+	)
+
+	DBQueryErrorsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_db_query_errors_total",
+			Help: "Total number of failed database statements",
+		},
+		[]string{"query"},
+	)
+
+	// Circuit breaker metrics
+	CircuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "messenger_circuit_breaker_state",
+			Help: "Circuit breaker state per dependency: 0 closed, 1 open, 2 half-open",
+		},
+		[]string{"breaker"},
+	)
+
+	CircuitBreakerRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_circuit_breaker_rejected_total",
+			Help: "Total number of calls failed fast by an open circuit breaker",
+		},
+		[]string{"breaker"},
+	)
+
+	RedisSubscriptionDropsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_redis_subscription_drops_total",
+			Help: "Total number of Redis pub/sub subscriptions lost and resubscribed",
+		},
+		[]string{"subscription"},
+	)
+
+	// Clients turned away for running an app version below the minimum
+	ClientUpgradeRejectionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "messenger_client_upgrade_rejections_total",
+			Help: "Total number of connections and auth requests refused for an outdated app version",
+		},
+		[]string{"platform", "surface"},
+	)
+
 	// Audit logging metrics
 	AuditQueueDepth = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -239,6 +370,20 @@ var (
 		},
 	)
 
+	AuditDeadLetterDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "messenger_audit_dead_letter_depth",
+			Help: "Current depth of the in-memory audit dead letter queue",
+		},
+	)
+
+	AuditDeadLetterPending = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "messenger_audit_dead_letter_pending",
+			Help: "Number of persisted dead-lettered audit events awaiting reprocessing",
+		},
+	)
+
 	AuditDroppedEventsTotal = promauto.NewCounter(
 		prometheus.CounterOpts{
 			Name: "messenger_audit_dropped_events_total",
@@ -380,9 +525,53 @@ func RecordMessageSent(messageType string) {
 	MessagesTotal.WithLabelValues(messageType).Inc()
 }
 
-// RecordDeliveryLatency records message delivery latency
-func RecordDeliveryLatency(deliveryType string, latency time.Duration) {
-	MessageDeliveryLatency.WithLabelValues(deliveryType).Observe(latency.Seconds())
+// RecordWebSocketCompression records one compressed frame. wireBytes
+// includes frame headers, so tiny frames can save nothing.
+func RecordWebSocketCompression(payloadBytes, wireBytes int) {
+	WebSocketCompressedPayloadBytes.Add(float64(payloadBytes))
+	if saved := payloadBytes - wireBytes; saved > 0 {
+		WebSocketCompressionBytesSaved.Add(float64(saved))
+	}
+}
+
+// RecordDeliveryLatency records real-time message delivery latency by delivery path
+func RecordDeliveryLatency(path string, latency time.Duration) {
+	MessageDeliveryLatency.WithLabelValues(path).Observe(latency.Seconds())
+}
+
+// RecordOfflineDeliveryLatency records how long a message waited in an inbox
+func RecordOfflineDeliveryLatency(latency time.Duration) {
+	OfflineDeliveryLatency.Observe(latency.Seconds())
+}
+
+// RecordDBQuery records one database statement's latency, and counts it as
+// an error if it failed
+func RecordDBQuery(query string, duration time.Duration, failed bool) {
+	DBQueryDuration.WithLabelValues(query).Observe(duration.Seconds())
+	if failed {
+		DBQueryErrorsTotal.WithLabelValues(query).Inc()
+	}
+}
+
+// SetCircuitBreakerState records a breaker's current state
+func SetCircuitBreakerState(breaker string, state int) {
+	CircuitBreakerState.WithLabelValues(breaker).Set(float64(state))
+}
+
+// RecordCircuitBreakerRejection counts a call failed fast by an open breaker
+func RecordCircuitBreakerRejection(breaker string) {
+	CircuitBreakerRejectedTotal.WithLabelValues(breaker).Inc()
+}
+
+// RecordRedisSubscriptionDrop counts a lost Redis pub/sub subscription
+func RecordRedisSubscriptionDrop(subscription string) {
+	RedisSubscriptionDropsTotal.WithLabelValues(subscription).Inc()
+}
+
+// RecordClientUpgradeRejection records a client refused for running an app
+// version below its platform's minimum. surface is "websocket" or "rest".
+func RecordClientUpgradeRejection(platform, surface string) {
+	ClientUpgradeRejectionsTotal.WithLabelValues(platform, surface).Inc()
 }
 
 // RecordAuthAttempt records an authentication attempt
@@ -399,6 +588,15 @@ func RecordPINAttempt(result string) {
 	PINAttemptsTotal.WithLabelValues(result).Inc()
 }
 
+// RecordSMSSend records a verification SMS send attempt
+func RecordSMSSend(provider string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	SMSSendTotal.WithLabelValues(provider, result).Inc()
+}
+
 // RecordMediaUpload records a media upload
 func RecordMediaUpload(mediaType string, sizeBytes int64) {
 	MediaUploadsTotal.WithLabelValues(mediaType).Inc()
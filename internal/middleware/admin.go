@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequireAdmin restricts a route to the configured admin user IDs.
+// Must run after AuthMiddleware so the user ID is present in the context.
+// An empty admin list denies everyone (admin endpoints are opt-in).
+func RequireAdmin(adminUserIDs []string) func(http.Handler) http.Handler {
+	admins := make(map[uuid.UUID]bool, len(adminUserIDs))
+	for _, id := range adminUserIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid admin user ID %q: %v", id, err)
+			continue
+		}
+		admins[parsed] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := GetUserID(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !admins[userID] {
+				log.Printf("SECURITY: Non-admin user %s attempted to access %s %s", userID, r.Method, r.URL.Path)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
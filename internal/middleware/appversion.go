@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jaydenbeard/messaging-app/internal/appversion"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+)
+
+// Headers clients use to report their platform and app version, and the one
+// telling clients below the recommended version what to upgrade to
+const (
+	PlatformHeader           = "X-Platform"
+	AppVersionHeader         = "X-App-Version"
+	UpgradeRecommendedHeader = "X-Upgrade-Recommended"
+)
+
+// VersionCheck judges a client's platform and app version, normally
+// RedisClient.CheckClientVersion
+type VersionCheck func(platform, version string) (appversion.Status, appversion.PlatformPolicy)
+
+// RequireSupportedVersion refuses requests from app versions below their
+// platform's minimum with 426 Upgrade Required. Clients below the
+// recommended version get UpgradeRecommendedHeader. Requests that don't
+// report a known platform are let through; a missing or invalid version is
+// refused only where the platform's rule sets RequireVersion.
+func RequireSupportedVersion(check VersionCheck) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			platform := appversion.NormalizePlatform(r.Header.Get(PlatformHeader))
+			if platform == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Left empty when missing or invalid
+			version, _ := appversion.Normalize(r.Header.Get(AppVersionHeader))
+
+			status, rule := check(platform, version)
+			switch status {
+			case appversion.UpgradeRequired:
+				log.Printf("[Version] Refused %s %s from %s %s (minimum %s)", r.Method, r.URL.Path, platform, version, rule.Minimum)
+				metrics.RecordClientUpgradeRejection(platform, "rest")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUpgradeRequired)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"error":           "force_upgrade",
+					"platform":        platform,
+					"minimum_version": rule.Minimum,
+				})
+				return
+			case appversion.UpgradeRecommended:
+				w.Header().Set(UpgradeRecommendedHeader, rule.Recommended)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
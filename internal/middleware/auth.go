@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"strings"
 
@@ -16,6 +17,19 @@ const (
 	DeviceIDKey contextKey = "device_id"
 )
 
+// InternalTokenHeader carries the shared secret chat servers present when
+// calling the presence and group services in place of a user's JWT
+const InternalTokenHeader = "X-Internal-Token"
+
+// IsInternalRequest reports whether r carries the internal service token.
+// An empty token never matches, so internal calls are refused until one is set.
+func IsInternalRequest(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(InternalTokenHeader)), []byte(token)) == 1
+}
+
 // AuthMiddleware validates JWT tokens
 func AuthMiddleware(authService *auth.AuthService, skipAuth func(*http.Request) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -47,6 +61,10 @@ func AuthMiddleware(authService *auth.AuthService, skipAuth func(*http.Request)
 			if err != nil {
 				if err == auth.ErrTokenExpired {
 					http.Error(w, "Token expired", http.StatusUnauthorized)
+				} else if err == auth.ErrAccountDisabled {
+					http.Error(w, "Account disabled", http.StatusForbidden)
+				} else if err == auth.ErrDeviceSignedOut {
+					http.Error(w, "Device signed out", http.StatusUnauthorized)
 				} else {
 					http.Error(w, "Invalid token", http.StatusUnauthorized)
 				}
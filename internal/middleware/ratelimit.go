@@ -10,16 +10,17 @@ import (
 	"time"
 
 	"github.com/jaydenbeard/messaging-app/internal/metrics"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 	"github.com/redis/go-redis/v9"
 )
 
 // EnhancedRateLimiter implements sophisticated multi-tier rate limiting with DDoS protection
 type EnhancedRateLimiter struct {
 	// Redis client for distributed rate limiting
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	ctx         context.Context
 
-	// Abuse detection (still in-memory for performance)
+	// Abuse detection (attempt counting in-memory, penalties shared via Redis)
 	abuseDetector *AbuseDetector
 
 	// Configuration
@@ -75,10 +76,14 @@ type AbuseDetector struct {
 	strictModeEnd map[string]time.Time // IP/User -> strict mode end time
 	mu            sync.RWMutex
 	config        *AbuseDetectionConfig
+
+	// Optional Redis client so penalties survive restarts and apply on every
+	// server. Without it, penalties are local to this process.
+	redisClient redis.UniversalClient
 }
 
 // NewEnhancedRateLimiter creates a new enhanced rate limiter with Redis support
-func NewEnhancedRateLimiter(config *RateLimitConfig, redisClient *redis.Client) *EnhancedRateLimiter {
+func NewEnhancedRateLimiter(config *RateLimitConfig, redisClient redis.UniversalClient) *EnhancedRateLimiter {
 	rl := &EnhancedRateLimiter{
 		redisClient:   redisClient,
 		ctx:           context.Background(),
@@ -86,6 +91,7 @@ func NewEnhancedRateLimiter(config *RateLimitConfig, redisClient *redis.Client)
 		config:        config,
 		logger:        log.New(log.Writer(), "[RATE-LIMIT] ", log.Ldate|log.Ltime|log.LUTC),
 	}
+	rl.abuseDetector.redisClient = redisClient
 
 	// Initialize cleanup goroutines (only for abuse detector now)
 	go rl.abuseDetector.cleanup()
@@ -181,12 +187,7 @@ func (rl *EnhancedRateLimiter) Middleware(next http.Handler) http.Handler {
 		}
 
 		// Extract identifiers
-		ip := r.RemoteAddr
-		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-			ip = forwarded
-		} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-			ip = realIP
-		}
+		ip := security.GetRealIP(r)
 
 		userID := ""
 		if user := r.Context().Value("userID"); user != nil {
@@ -474,6 +475,7 @@ func (ad *AbuseDetector) checkForAbuse(ip string, userID string) {
 		if len(recentAttempts) >= ad.config.Threshold {
 			ad.penaltyBox[ip] = now.Add(ad.config.PenaltyDuration)
 			ad.strictModeEnd[ip] = now.Add(ad.config.StrictModeDuration)
+			ad.persistPenalty("ip", ip)
 			metrics.RecordAbuseDetectionEvent("ip", "penalty")
 			metrics.RecordStrictModeActivation("ip")
 			log.Printf("ABUSE DETECTED: IP %s placed in penalty box for %v", ip, ad.config.PenaltyDuration)
@@ -487,6 +489,7 @@ func (ad *AbuseDetector) checkForAbuse(ip string, userID string) {
 			if len(recentAttempts) >= ad.config.Threshold {
 				ad.penaltyBox[userID] = now.Add(ad.config.PenaltyDuration)
 				ad.strictModeEnd[userID] = now.Add(ad.config.StrictModeDuration)
+				ad.persistPenalty("user", userID)
 				metrics.RecordAbuseDetectionEvent("user", "penalty")
 				metrics.RecordStrictModeActivation("user")
 				log.Printf("ABUSE DETECTED: User %s placed in penalty box for %v", userID, ad.config.PenaltyDuration)
@@ -495,15 +498,42 @@ func (ad *AbuseDetector) checkForAbuse(ip string, userID string) {
 	}
 }
 
-// IsInPenaltyBox checks if IP or user is in penalty box
+// persistPenalty stores a penalty and strict mode in Redis with matching
+// TTLs. The strict mode key is the one the IP/user limiters already read.
+func (ad *AbuseDetector) persistPenalty(kind string, id string) {
+	if ad.redisClient == nil {
+		return
+	}
+
+	ctx := context.Background()
+	pipe := ad.redisClient.Pipeline()
+	pipe.Set(ctx, fmt.Sprintf("ratelimit:penalty:%s", id), kind, ad.config.PenaltyDuration)
+	pipe.Set(ctx, fmt.Sprintf("ratelimit:%s:%s:mode", kind, id), "strict", ad.config.StrictModeDuration)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Warning: failed to persist abuse penalty for %s %s: %v", kind, id, err)
+	}
+}
+
+// IsInPenaltyBox checks if IP or user is in penalty box. Penalties set by
+// any server are read from Redis; the local copy covers Redis outages.
 func (ad *AbuseDetector) IsInPenaltyBox(key string) bool {
 	ad.mu.RLock()
-	defer ad.mu.RUnlock()
+	endTime, exists := ad.penaltyBox[key]
+	ad.mu.RUnlock()
 
-	if endTime, exists := ad.penaltyBox[key]; exists {
-		return time.Now().Before(endTime)
+	if exists && time.Now().Before(endTime) {
+		return true
+	}
+	if ad.redisClient == nil {
+		return false
+	}
+
+	n, err := ad.redisClient.Exists(context.Background(), fmt.Sprintf("ratelimit:penalty:%s", key)).Result()
+	if err != nil {
+		log.Printf("Warning: failed to check abuse penalty for %s: %v", key, err)
+		return false
 	}
-	return false
+	return n > 0
 }
 
 // RecordAttempt records an attempt for abuse detection (public for testing)
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/jaydenbeard/messaging-app/internal/auth"
+)
+
+// PINStepUpHeader carries the token returned by POST /pin/verify, or by
+// POST /step-up/verify for users without a PIN
+const PINStepUpHeader = "X-PIN-Step-Up"
+
+// RequirePINStepUp gates destructive actions behind a recent PIN re-entry.
+// Must run after AuthMiddleware. Users without a PIN re-authenticate with a
+// TOTP or SMS code instead; a stolen access token alone never passes.
+func RequirePINStepUp(authService *auth.AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := GetUserID(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			deviceID, _ := GetDeviceID(r.Context())
+
+			hasPIN, err := authService.HasPIN(userID)
+			if err != nil {
+				log.Printf("Warning: failed to check PIN for step-up: %v", err)
+				http.Error(w, "Failed to check PIN", http.StatusInternalServerError)
+				return
+			}
+
+			if !authService.ValidatePINStepUpToken(userID, deviceID, r.Header.Get(PINStepUpHeader)) {
+				// has_pin tells the client which endpoint issues the token
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = fmt.Fprintf(w, `{"error":"pin_step_up_required","has_pin":%t}`, hasPIN)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -16,6 +16,8 @@ const (
 	MessageTypeTyping      = "typing"       // Typing indicator
 	MessageTypeHeartbeat   = "heartbeat"    // Keep-alive ping
 	MessageTypePresence    = "presence"     // Update presence status
+	MessageTypeHello       = "hello"        // Report platform and app version
+	MessageTypeKeyRequest  = "key_request"  // Fetch a user's key bundle without a REST call
 
 	// Server -> Client
 	MessageTypeDeliver      = "deliver"       // Deliver message to recipient
@@ -25,6 +27,22 @@ const (
 	MessageTypeError        = "error"         // Error message
 	MessageTypeUserOnline   = "user_online"   // User came online
 	MessageTypeUserOffline  = "user_offline"  // User went offline
+	MessageTypeUnreadCount  = "unread_count"  // Unread counts changed
+	MessageTypeInboxStatus  = "inbox_status"  // Offline inbox size / resync required
+	MessageTypeKeyBundle    = "key_bundle"    // Reply to key_request
+
+	MessageTypePresenceSnapshot   = "presence_snapshot"    // Friends' online status, sent on connect
+	MessageTypeIdentityKeyChanged = "identity_key_changed" // Contact's safety number changed
+	MessageTypeGroupRekey         = "group_rekey"          // Group key rotated; fetch the new key
+	MessageTypeContactsChanged    = "contacts_changed"     // Friends or requests changed; refetch them
+	MessageTypeRebalanceHint      = "rebalance_hint"       // Server overloaded; reconnect to be placed elsewhere
+	MessageTypeAccountWarning     = "account_warning"      // An admin warned the user after an abuse report
+	MessageTypeSystem             = "system"               // Operator announcement (maintenance, policy updates)
+	MessageTypeUpgradeAvailable   = "upgrade_available"    // App is below the recommended version
+
+	// Friend requests
+	MessageTypeFriendRequest  = "friend_request"          // Someone sent the user a friend request
+	MessageTypeFriendAccepted = "friend_request_accepted" // The user's friend request was accepted
 
 	// Call signaling (WebRTC)
 	MessageTypeCallOffer    = "call_offer"    // Initiate call with SDP offer
@@ -41,19 +59,56 @@ const (
 
 	// Media key exchange (encrypted, server can't read)
 	MessageTypeMediaKey = "media_key" // Exchange media encryption keys between clients
+
+	// Presence status (online/away/dnd), set by the client and fanned out to contacts
+	MessageTypePresenceStatus = "presence_status"
+
+	// Changes to an existing message; stored in the inbox for offline recipients
+	MessageTypeReaction      = "reaction"       // Reaction added or removed
+	MessageTypeMessageEdit   = "message_edit"   // Sender edited the message
+	MessageTypeMessageDelete = "message_delete" // Sender deleted the message
+)
+
+// Presence statuses. Offline is derived from connections and can't be set.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceDND     = "dnd"
+	PresenceOffline = "offline"
 )
 
+// IsSettablePresenceStatus reports whether a client may choose status
+func IsSettablePresenceStatus(status string) bool {
+	switch status {
+	case PresenceOnline, PresenceAway, PresenceDND:
+		return true
+	}
+	return false
+}
+
 // WebSocketMessage is the envelope for all WebSocket communication
 type WebSocketMessage struct {
 	Type      string          `json:"type"`
 	MessageID uuid.UUID       `json:"messageId,omitempty"` // camelCase to match frontend
 	SenderID  uuid.UUID       `json:"sender_id,omitempty"`
 	DeviceID  uuid.UUID       `json:"device_id,omitempty"`
-	ServerID  string          `json:"server_id,omitempty"` // Track originating server for presence dedup
+	ServerID  string          `json:"server_id,omitempty"` // Originating server, so it can skip its own pub/sub echoes
 	Timestamp time.Time       `json:"timestamp,omitempty"`
 	Payload   json.RawMessage `json:"payload,omitempty"`
 	Signature string          `json:"signature,omitempty"` // HMAC signature for message authentication
 	Nonce     string          `json:"nonce,omitempty"`     // Unique nonce for replay protection
+
+	// TraceContext carries W3C trace context (traceparent/tracestate) across the
+	// Redis pub/sub boundary. Server-internal: stripped before delivery to clients.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// BroadcastTarget narrows a system broadcast to some clients. Zero fields
+// match every client; versions are inclusive bounds.
+type BroadcastTarget struct {
+	Platforms  []string `json:"platforms,omitempty"`
+	MinVersion string   `json:"min_version,omitempty"`
+	MaxVersion string   `json:"max_version,omitempty"`
 }
 
 // EncryptedMessage represents the encrypted payload of a message
@@ -196,6 +251,7 @@ type LoginRequest struct {
 	PublicIdentityKey     string    `json:"public_identity_key"`
 	PublicSignedPrekey    string    `json:"public_signed_prekey"`
 	SignedPrekeySignature string    `json:"signed_prekey_signature"`
+	TOTPCode              string    `json:"totp_code,omitempty"` // Step-up for logins from a new country
 }
 
 // AuthResponse contains authentication tokens
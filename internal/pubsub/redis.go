@@ -3,39 +3,75 @@ package pubsub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/appversion"
+	"github.com/jaydenbeard/messaging-app/internal/breaker"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
 	"github.com/jaydenbeard/messaging-app/internal/models"
 	"github.com/redis/go-redis/v9"
 )
 
 // RedisClient wraps the Redis connection for pub/sub and caching
 type RedisClient struct {
-	client *redis.Client
+	client redis.UniversalClient
 	ctx    context.Context
+
+	// publishBreaker fails pub/sub publishes fast while Redis keeps
+	// rejecting them, instead of every caller sitting through the retries
+	publishBreaker *breaker.Breaker
+
+	// The client version policy is checked on every request; it is cached
+	// here for versionPolicyCacheTTL
+	versionPolicyMu      sync.Mutex
+	versionPolicy        appversion.Policy
+	versionPolicyFetched time.Time
 }
 
 // Hub interface for message delivery callback
 type Hub interface {
 	DeliverFromRedis(userID uuid.UUID, msg *models.WebSocketMessage)
 	BroadcastPresenceFromRedis(msg *models.WebSocketMessage)
+	DisconnectUser(userID uuid.UUID)
+	DisconnectDevice(userID, deviceID uuid.UUID)
+	DeliverSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget) int
+}
+
+// UniversalOptions returns Redis connection options with optional
+// authentication. addr is a single host:port, or a comma-separated list for HA:
+//   - with REDIS_SENTINEL_MASTER set, the addresses are Sentinels and the
+//     client follows that master through failovers
+//   - otherwise more than one address means Redis Cluster seed nodes
+func UniversalOptions(addr string) *redis.UniversalOptions {
+	var addrs []string
+	for _, a := range strings.Split(addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return &redis.UniversalOptions{
+		Addrs:            addrs,
+		MasterName:       os.Getenv("REDIS_SENTINEL_MASTER"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		Password:         os.Getenv("REDIS_PASSWORD"), // Empty string if not set (no auth)
+	}
 }
 
-// NewRedisClient creates a new Redis client with optional authentication
+// NewRedisClient creates a new Redis client; see UniversalOptions for addr
 func NewRedisClient(addr string) (*RedisClient, error) {
-	// Get Redis password from environment variable (optional)
-	password := os.Getenv("REDIS_PASSWORD")
-
-	client := redis.NewClient(&redis.Options{
-		Addr:         addr,
-		Password:     password, // Empty string if not set (no auth)
-		DB:           0,
-		PoolSize:     10,
-		MinIdleConns: 5,
-	})
+	opts := UniversalOptions(addr)
+	opts.PoolSize = 10
+	opts.MinIdleConns = 5
+	client := redis.NewUniversalClient(opts)
 
 	ctx := context.Background()
 
@@ -45,13 +81,40 @@ func NewRedisClient(addr string) (*RedisClient, error) {
 	}
 
 	return &RedisClient{
-		client: client,
-		ctx:    ctx,
+		client:         client,
+		ctx:            ctx,
+		publishBreaker: breaker.New("redis_publish", breaker.DefaultFailureThreshold, breaker.DefaultCooldown),
 	}, nil
 }
 
+// MGet is MGET that also works on Redis Cluster, where one MGET may only
+// touch keys in a single hash slot. On a cluster the GETs are pipelined
+// instead and routed to each key's node; missing keys are nil either way.
+func MGet(ctx context.Context, client redis.UniversalClient, keys ...string) ([]interface{}, error) {
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		return client.MGet(ctx, keys...).Result()
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		if val, err := cmd.Result(); err == nil {
+			values[i] = val
+		}
+	}
+	return values, nil
+}
+
 // GetClient returns the underlying Redis client
-func (r *RedisClient) GetClient() *redis.Client {
+func (r *RedisClient) GetClient() redis.UniversalClient {
 	return r.client
 }
 
@@ -60,6 +123,11 @@ func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
 
+// Ping checks that Redis is reachable (for readiness checks)
+func (r *RedisClient) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
 // ================== Connection Registry ==================
 
 // RegisterConnection registers a user's connection to this server
@@ -67,8 +135,11 @@ func (r *RedisClient) Close() error {
 func (r *RedisClient) RegisterConnection(userID uuid.UUID, serverID string, deviceID uuid.UUID) {
 	key := "connections:" + userID.String()
 
-	// Store as hash: deviceID -> serverID
-	r.client.HSet(r.ctx, key, deviceID.String(), serverID)
+	// Store as hash: deviceID -> serverID, plus the device's last activity
+	r.client.HSet(r.ctx, key,
+		deviceID.String(), serverID,
+		deviceActiveField+deviceID.String(), time.Now().Unix(),
+	)
 
 	// Set expiry (will be refreshed by heartbeats)
 	r.client.Expire(r.ctx, key, 2*time.Minute)
@@ -79,24 +150,103 @@ func (r *RedisClient) RegisterConnection(userID uuid.UUID, serverID string, devi
 	r.client.Expire(r.ctx, serverKey, 2*time.Minute)
 }
 
-// UnregisterConnection removes a user's connection
+// UnregisterConnection removes a user's connection. The device's activity
+// fields stay while other devices keep the hash alive, so clients can show
+// when it was last active.
 func (r *RedisClient) UnregisterConnection(userID uuid.UUID, deviceID uuid.UUID) {
 	key := "connections:" + userID.String()
 	r.client.HDel(r.ctx, key, deviceID.String())
 }
 
-// RefreshConnection refreshes the TTL on a connection
+// RefreshConnection refreshes the TTL on a connection and the device's
+// last-active time
 func (r *RedisClient) RefreshConnection(userID uuid.UUID, deviceID uuid.UUID) {
 	key := "connections:" + userID.String()
+	r.client.HSet(r.ctx, key, deviceActiveField+deviceID.String(), time.Now().Unix())
 	r.client.Expire(r.ctx, key, 2*time.Minute)
 }
 
+// Besides deviceID -> serverID, the connections hash holds per-device
+// metadata under these prefixes. Device IDs never contain ':'.
+const (
+	deviceActiveField = "active:" // unix seconds of last activity
+	deviceTypeField   = "type:"   // ios, android, web, desktop
+)
+
+// DeviceConnections filters a raw connections hash down to its
+// deviceID -> serverID entries
+func DeviceConnections(hash map[string]string) map[string]string {
+	devices := make(map[string]string, len(hash))
+	for field, value := range hash {
+		if !strings.Contains(field, ":") {
+			devices[field] = value
+		}
+	}
+	return devices
+}
+
+// SetDeviceType records a connected device's type for GetDevicePresence
+func (r *RedisClient) SetDeviceType(userID, deviceID uuid.UUID, deviceType string) {
+	key := "connections:" + userID.String()
+	r.client.HSet(r.ctx, key, deviceTypeField+deviceID.String(), deviceType)
+}
+
+// DevicePresence is one device's entry in a user's connections hash
+type DevicePresence struct {
+	DeviceID   uuid.UUID `json:"device_id"`
+	DeviceType string    `json:"device_type,omitempty"`
+	Online     bool      `json:"online"`
+	LastActive time.Time `json:"last_active"`
+}
+
+// GetDevicePresence returns the user's devices seen since the hash was
+// created: connected ones and those that disconnected while another device
+// stayed online. Empty once the user is fully offline.
+func (r *RedisClient) GetDevicePresence(userID uuid.UUID) ([]DevicePresence, error) {
+	hash, err := r.client.HGetAll(r.ctx, "connections:"+userID.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]DevicePresence, 0)
+	for field, value := range hash {
+		idStr, ok := strings.CutPrefix(field, deviceActiveField)
+		if !ok {
+			continue
+		}
+		deviceID, err := uuid.Parse(idStr)
+		if err != nil {
+			continue
+		}
+		lastActive, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		_, online := hash[idStr]
+		devices = append(devices, DevicePresence{
+			DeviceID:   deviceID,
+			DeviceType: hash[deviceTypeField+idStr],
+			Online:     online,
+			LastActive: time.Unix(lastActive, 0).UTC(),
+		})
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].LastActive.After(devices[j].LastActive)
+	})
+	return devices, nil
+}
+
 // GetUserConnectionInfo returns if user is online and which servers they're on
 // This implements: "Where is User B?" query
 func (r *RedisClient) GetUserConnectionInfo(userID uuid.UUID) (bool, []string) {
 	key := "connections:" + userID.String()
 	result, err := r.client.HGetAll(r.ctx, key).Result()
-	if err != nil || len(result) == 0 {
+	if err != nil {
+		return false, nil
+	}
+	result = DeviceConnections(result)
+	if len(result) == 0 {
 		return false, nil
 	}
 
@@ -114,6 +264,20 @@ func (r *RedisClient) GetUserConnectionInfo(userID uuid.UUID) (bool, []string) {
 	return true, servers
 }
 
+// GetUserDeviceConnections returns the raw device -> server mapping from the
+// connections hash and its remaining TTL (for routing diagnostics)
+func (r *RedisClient) GetUserDeviceConnections(userID uuid.UUID) (map[string]string, time.Duration, error) {
+	key := "connections:" + userID.String()
+
+	pipe := r.client.Pipeline()
+	devicesCmd := pipe.HGetAll(r.ctx, key)
+	ttlCmd := pipe.TTL(r.ctx, key)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		return nil, 0, err
+	}
+	return DeviceConnections(devicesCmd.Val()), ttlCmd.Val(), nil
+}
+
 // GetUserServers returns all servers a user is connected to
 func (r *RedisClient) GetUserServers(userID uuid.UUID) ([]string, error) {
 	key := "connections:" + userID.String()
@@ -124,7 +288,7 @@ func (r *RedisClient) GetUserServers(userID uuid.UUID) ([]string, error) {
 
 	servers := make([]string, 0, len(result))
 	serverSet := make(map[string]bool)
-	for _, server := range result {
+	for _, server := range DeviceConnections(result) {
 		if !serverSet[server] {
 			servers = append(servers, server)
 			serverSet[server] = true
@@ -167,6 +331,64 @@ func (r *RedisClient) GetUserPresence(userID uuid.UUID) (isOnline bool, lastSeen
 	return false, t
 }
 
+// SetPresenceStatus stores the status a user chose (away, dnd). Setting
+// online clears it. The choice outlives connections, so it is kept until
+// changed rather than expiring with presence.
+func (r *RedisClient) SetPresenceStatus(userID uuid.UUID, status string) error {
+	key := "presence_status:" + userID.String()
+	if status == models.PresenceOnline {
+		return r.client.Del(r.ctx, key).Err()
+	}
+	return r.client.Set(r.ctx, key, status, 0).Err()
+}
+
+// GetPresenceStatus returns online, away, dnd, or offline if the user has no
+// live connection
+func (r *RedisClient) GetPresenceStatus(userID uuid.UUID) string {
+	if online, _ := r.GetUserPresence(userID); !online {
+		return models.PresenceOffline
+	}
+	return r.chosenPresenceStatus(userID)
+}
+
+// chosenPresenceStatus returns the stored status, defaulting to online
+func (r *RedisClient) chosenPresenceStatus(userID uuid.UUID) string {
+	status, err := r.client.Get(r.ctx, "presence_status:"+userID.String()).Result()
+	if err != nil || status == "" {
+		return models.PresenceOnline
+	}
+	return status
+}
+
+// GetBatchPresenceStatus returns the chosen status of each user that has one
+// (away, dnd). Users without an entry are online or offline per presence.
+func (r *RedisClient) GetBatchPresenceStatus(userIDs []uuid.UUID) map[uuid.UUID]string {
+	result := make(map[uuid.UUID]string)
+	if len(userIDs) == 0 {
+		return result
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = "presence_status:" + userID.String()
+	}
+
+	values, err := MGet(r.ctx, r.client, keys...)
+	if err != nil {
+		log.Printf("Warning: batch presence status lookup failed: %v", err)
+		return result
+	}
+
+	for i, userID := range userIDs {
+		if i < len(values) {
+			if status, ok := values[i].(string); ok && status != "" {
+				result[userID] = status
+			}
+		}
+	}
+	return result
+}
+
 // UpdateLastActive refreshes the user's presence TTL
 func (r *RedisClient) UpdateLastActive(userID uuid.UUID) {
 	key := "presence:" + userID.String()
@@ -176,24 +398,30 @@ func (r *RedisClient) UpdateLastActive(userID uuid.UUID) {
 	r.client.Expire(r.ctx, connKey, 2*time.Minute)
 }
 
-// GetBatchPresence checks presence for multiple users efficiently
+// GetBatchPresence checks presence for multiple users in a single MGET
 func (r *RedisClient) GetBatchPresence(userIDs []uuid.UUID) map[uuid.UUID]bool {
-	pipe := r.client.Pipeline()
-	cmds := make(map[uuid.UUID]*redis.StringCmd)
+	result := make(map[uuid.UUID]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return result
+	}
 
-	for _, userID := range userIDs {
-		key := "presence:" + userID.String()
-		cmds[userID] = pipe.Get(r.ctx, key)
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = "presence:" + userID.String()
 	}
 
-	if _, err := pipe.Exec(r.ctx); err != nil {
-		log.Printf("Warning: batch presence pipeline exec failed: %v", err)
+	values, err := MGet(r.ctx, r.client, keys...)
+	if err != nil {
+		log.Printf("Warning: batch presence lookup failed: %v", err)
 	}
 
-	result := make(map[uuid.UUID]bool)
-	for userID, cmd := range cmds {
-		val, err := cmd.Result()
-		result[userID] = err == nil && val == "online"
+	for i, userID := range userIDs {
+		online := false
+		if i < len(values) {
+			val, ok := values[i].(string)
+			online = ok && val == "online"
+		}
+		result[userID] = online
 	}
 
 	return result
@@ -212,21 +440,7 @@ func (r *RedisClient) PublishMessage(userID uuid.UUID, msg *models.WebSocketMess
 		return err
 	}
 
-	// Retry logic for critical message delivery
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if err := r.client.Publish(r.ctx, channel, data).Err(); err != nil {
-			if attempt == maxRetries {
-				log.Printf("ERROR: Failed to publish message after %d attempts: %v", maxRetries, err)
-				return err
-			}
-			log.Printf("WARN: Failed to publish message (attempt %d/%d): %v", attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt*100) * time.Millisecond) // Exponential backoff
-			continue
-		}
-		return nil
-	}
-	return nil
+	return r.publishWithRetry(channel, data, "message")
 }
 
 // PublishToServer publishes a message for delivery to users on a specific server
@@ -240,21 +454,7 @@ func (r *RedisClient) PublishToServer(serverID string, userID uuid.UUID, msg *mo
 		return err
 	}
 
-	// Retry logic for critical message delivery
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if err := r.client.Publish(r.ctx, channel, data).Err(); err != nil {
-			if attempt == maxRetries {
-				log.Printf("ERROR: Failed to publish to server after %d attempts: %v", maxRetries, err)
-				return err
-			}
-			log.Printf("WARN: Failed to publish to server (attempt %d/%d): %v", attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt*100) * time.Millisecond)
-			continue
-		}
-		return nil
-	}
-	return nil
+	return r.publishWithRetry(channel, data, "to server")
 }
 
 // PublishRaw publishes raw data to a user's channel (for system events like device approval)
@@ -262,21 +462,29 @@ func (r *RedisClient) PublishToServer(serverID string, userID uuid.UUID, msg *mo
 func (r *RedisClient) PublishRaw(userID uuid.UUID, data []byte) error {
 	channel := "messages:" + userID.String()
 
-	// Retry logic for critical events
-	maxRetries := 3
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		if err := r.client.Publish(r.ctx, channel, data).Err(); err != nil {
+	return r.publishWithRetry(channel, data, "raw message")
+}
+
+// publishWithRetry publishes a critical delivery, retrying with backoff.
+// While the publish breaker is open it returns breaker.ErrOpen at once so
+// callers can fall back (e.g. to the offline inbox).
+func (r *RedisClient) publishWithRetry(channel string, data []byte, what string) error {
+	return r.publishBreaker.Do(func() error {
+		maxRetries := 3
+		for attempt := 1; attempt <= maxRetries; attempt++ {
+			err := r.client.Publish(r.ctx, channel, data).Err()
+			if err == nil {
+				return nil
+			}
 			if attempt == maxRetries {
-				log.Printf("ERROR: Failed to publish raw message after %d attempts: %v", maxRetries, err)
+				log.Printf("ERROR: Failed to publish %s after %d attempts: %v", what, maxRetries, err)
 				return err
 			}
-			log.Printf("WARN: Failed to publish raw message (attempt %d/%d): %v", attempt, maxRetries, err)
-			time.Sleep(time.Duration(attempt*100) * time.Millisecond)
-			continue
+			log.Printf("WARN: Failed to publish %s (attempt %d/%d): %v", what, attempt, maxRetries, err)
+			time.Sleep(time.Duration(attempt*100) * time.Millisecond) // Exponential backoff
 		}
 		return nil
-	}
-	return nil
+	})
 }
 
 // PublishPresenceUpdate publishes a presence update to the global presence channel
@@ -340,92 +548,274 @@ func (r *RedisClient) PublishToDeviceRaw(deviceID uuid.UUID, data []byte) error
 // SubscribeToMessages subscribes to messages for users on this server
 func (r *RedisClient) SubscribeToMessages(hub Hub) {
 	// Pattern subscribe to all user message channels
-	pubsub := r.client.PSubscribe(r.ctx, "messages:*")
-	defer func() {
-		if err := pubsub.Close(); err != nil {
-			log.Printf("Warning: failed to close pubsub: %v", err)
-		}
-	}()
-
-	ch := pubsub.Channel()
-
-	for msg := range ch {
+	r.subscribe("messages", func() *redis.PubSub {
+		return r.client.PSubscribe(r.ctx, "messages:*")
+	}, func(msg *redis.Message) {
 		// Extract user ID from channel name
 		userIDStr := msg.Channel[len("messages:"):]
 		userID, err := uuid.Parse(userIDStr)
 		if err != nil {
-			continue
+			return
 		}
 
 		// Parse message
 		var wsMsg models.WebSocketMessage
 		if err := json.Unmarshal([]byte(msg.Payload), &wsMsg); err != nil {
 			log.Printf("Failed to parse pub/sub message: %v", err)
-			continue
+			return
 		}
 
 		// Deliver to hub
 		hub.DeliverFromRedis(userID, &wsMsg)
-	}
+	})
 }
 
 // SubscribeToServerMessages subscribes to messages specifically for this server
 func (r *RedisClient) SubscribeToServerMessages(serverID string, hub Hub) {
 	pattern := "server:" + serverID + ":*"
-	pubsub := r.client.PSubscribe(r.ctx, pattern)
-	defer func() {
-		if err := pubsub.Close(); err != nil {
-			log.Printf("Warning: failed to close pubsub: %v", err)
-		}
-	}()
-
-	ch := pubsub.Channel()
-
-	for msg := range ch {
+	r.subscribe("server", func() *redis.PubSub {
+		return r.client.PSubscribe(r.ctx, pattern)
+	}, func(msg *redis.Message) {
 		// Extract user ID from channel name: "server:serverID:userID"
 		prefix := "server:" + serverID + ":"
 		userIDStr := msg.Channel[len(prefix):]
 		userID, err := uuid.Parse(userIDStr)
 		if err != nil {
-			continue
+			return
 		}
 
 		var wsMsg models.WebSocketMessage
 		if err := json.Unmarshal([]byte(msg.Payload), &wsMsg); err != nil {
-			continue
+			return
 		}
 
 		hub.DeliverFromRedis(userID, &wsMsg)
-	}
+	})
 }
 
 // SubscribeToPresenceUpdates subscribes to the global presence channel
 // All servers receive presence updates from all other servers
 func (r *RedisClient) SubscribeToPresenceUpdates(hub Hub) {
-	pubsub := r.client.Subscribe(r.ctx, "presence:updates")
-	defer func() {
-		if err := pubsub.Close(); err != nil {
-			log.Printf("Warning: failed to close pubsub: %v", err)
-		}
-	}()
-
-	ch := pubsub.Channel()
-
-	for msg := range ch {
+	r.subscribe("presence", func() *redis.PubSub {
+		return r.client.Subscribe(r.ctx, "presence:updates")
+	}, func(msg *redis.Message) {
 		var wsMsg models.WebSocketMessage
 		if err := json.Unmarshal([]byte(msg.Payload), &wsMsg); err != nil {
 			log.Printf("Failed to parse presence update: %v", err)
-			continue
+			return
 		}
 
 		// Broadcast to all local clients
 		hub.BroadcastPresenceFromRedis(&wsMsg)
+	})
+}
+
+// SystemBroadcast is an operator announcement on the broadcast channel or
+// in storage. The target stays server-side; clients only receive the message.
+type SystemBroadcast struct {
+	Message *models.WebSocketMessage `json:"message"`
+	Target  models.BroadcastTarget   `json:"target"`
+}
+
+// PublishSystemBroadcast sends an announcement to every server, including
+// this one, for delivery to their matching clients
+func (r *RedisClient) PublishSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget) error {
+	data, err := json.Marshal(SystemBroadcast{Message: msg, Target: target})
+	if err != nil {
+		return err
+	}
+	return r.publishWithRetry("broadcast:system", data, "system broadcast")
+}
+
+// SubscribeToSystemBroadcasts delivers announcements from any server to
+// this server's clients
+func (r *RedisClient) SubscribeToSystemBroadcasts(hub Hub) {
+	r.subscribe("broadcast", func() *redis.PubSub {
+		return r.client.Subscribe(r.ctx, "broadcast:system")
+	}, func(msg *redis.Message) {
+		var broadcast SystemBroadcast
+		if err := json.Unmarshal([]byte(msg.Payload), &broadcast); err != nil || broadcast.Message == nil {
+			log.Printf("Failed to parse system broadcast: %v", err)
+			return
+		}
+		hub.DeliverSystemBroadcast(broadcast.Message, broadcast.Target)
+	})
+}
+
+// systemBroadcastIndex lists stored broadcast IDs scored by expiry (unix)
+const systemBroadcastIndex = "broadcast:system:active"
+
+// StoreSystemBroadcast keeps one copy of an announcement for ttl, for
+// devices that connect later
+func (r *RedisClient) StoreSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget, ttl time.Duration) error {
+	data, err := json.Marshal(SystemBroadcast{Message: msg, Target: target})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	id := msg.MessageID.String()
+
+	pipe := r.client.Pipeline()
+	pipe.Set(r.ctx, "broadcast:system:"+id, data, ttl)
+	pipe.ZAdd(r.ctx, systemBroadcastIndex, redis.Z{Score: float64(now.Add(ttl).Unix()), Member: id})
+	pipe.ZRemRangeByScore(r.ctx, systemBroadcastIndex, "-inf", strconv.FormatInt(now.Unix(), 10))
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+// GetSystemBroadcasts returns the stored announcements that haven't expired,
+// oldest first
+func (r *RedisClient) GetSystemBroadcasts() ([]SystemBroadcast, error) {
+	ids, err := r.client.ZRangeByScore(r.ctx, systemBroadcastIndex, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = "broadcast:system:" + id
+	}
+	values, err := MGet(r.ctx, r.client, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcasts := make([]SystemBroadcast, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var broadcast SystemBroadcast
+		if err := json.Unmarshal([]byte(data), &broadcast); err != nil || broadcast.Message == nil {
+			continue
+		}
+		broadcasts = append(broadcasts, broadcast)
+	}
+	return broadcasts, nil
+}
+
+// MarkSystemBroadcastDelivered records that devices received an
+// announcement, so it isn't sent again on their next connection. The record
+// lasts as long as a stored broadcast (ttl).
+func (r *RedisClient) MarkSystemBroadcastDelivered(broadcastID uuid.UUID, ttl time.Duration, deviceIDs ...uuid.UUID) error {
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, deviceID := range deviceIDs {
+		key := "broadcast:delivered:" + deviceID.String()
+		pipe.SAdd(r.ctx, key, broadcastID.String())
+		pipe.Expire(r.ctx, key, ttl)
+	}
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+// GetDeliveredSystemBroadcasts returns the IDs of the announcements a device
+// has already received
+func (r *RedisClient) GetDeliveredSystemBroadcasts(deviceID uuid.UUID) (map[string]bool, error) {
+	ids, err := r.client.SMembers(r.ctx, "broadcast:delivered:"+deviceID.String()).Result()
+	if err != nil {
+		return nil, err
+	}
+	delivered := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		delivered[id] = true
+	}
+	return delivered, nil
+}
+
+// PublishKick asks every server to close a user's connections (e.g. after a ban)
+func (r *RedisClient) PublishKick(userID uuid.UUID) error {
+	return r.client.Publish(r.ctx, "users:kick", userID.String()).Err()
+}
+
+// PublishDeviceKick asks every server to close one device's connection
+// (e.g. after "sign out other devices")
+func (r *RedisClient) PublishDeviceKick(userID, deviceID uuid.UUID) error {
+	return r.client.Publish(r.ctx, "users:kick", userID.String()+":"+deviceID.String()).Err()
+}
+
+// SubscribeToKicks subscribes to the global kick channel and disconnects
+// kicked users or devices from this server
+func (r *RedisClient) SubscribeToKicks(hub Hub) {
+	r.subscribe("kicks", func() *redis.PubSub {
+		return r.client.Subscribe(r.ctx, "users:kick")
+	}, func(msg *redis.Message) {
+		// Payload is "<userID>" or "<userID>:<deviceID>"
+		userStr, deviceStr, hasDevice := strings.Cut(msg.Payload, ":")
+		userID, err := uuid.Parse(userStr)
+		if err != nil {
+			log.Printf("Failed to parse kick event: %v", err)
+			return
+		}
+		if !hasDevice {
+			hub.DisconnectUser(userID)
+			return
+		}
+		deviceID, err := uuid.Parse(deviceStr)
+		if err != nil {
+			log.Printf("Failed to parse kick event: %v", err)
+			return
+		}
+		hub.DisconnectDevice(userID, deviceID)
+	})
+}
+
+// Resubscribe backoff after a subscription drops, doubling up to the max
+const (
+	subscribeBackoffMin = 100 * time.Millisecond
+	subscribeBackoffMax = 30 * time.Second
+)
+
+// subscribe runs a subscription until the client is closed, handing each
+// message to handle. If the connection drops it resubscribes with
+// exponential backoff; messages published while disconnected are lost, as
+// with any Redis pub/sub.
+func (r *RedisClient) subscribe(name string, open func() *redis.PubSub, handle func(*redis.Message)) {
+	backoff := subscribeBackoffMin
+	for {
+		pubsub := open()
+		// Wait for the subscription to be confirmed so a dead connection
+		// is noticed here rather than on the first message
+		_, err := pubsub.Receive(r.ctx)
+		if err == nil {
+			if backoff > subscribeBackoffMin {
+				log.Printf("[PubSub] Resubscribed to %s", name)
+			}
+			backoff = subscribeBackoffMin
+			for {
+				msg, recvErr := pubsub.ReceiveMessage(r.ctx)
+				if recvErr != nil {
+					err = recvErr
+					break
+				}
+				handle(msg)
+			}
+		}
+		if closeErr := pubsub.Close(); closeErr != nil && !errors.Is(closeErr, redis.ErrClosed) {
+			log.Printf("Warning: failed to close pubsub: %v", closeErr)
+		}
+
+		if errors.Is(err, redis.ErrClosed) || r.ctx.Err() != nil {
+			return // Shutting down
+		}
+		metrics.RecordRedisSubscriptionDrop(name)
+		log.Printf("Warning: Redis subscription %s dropped, resubscribing in %s: %v", name, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, subscribeBackoffMax)
 	}
 }
 
 // ================== Notifications ==================
 
-// PublishNotification sends a notification event for push notification delivery
+// PublishNotification sends a notification event for push notification
+// delivery. Notifications are best effort: they are skipped while the
+// publish breaker is open.
 func (r *RedisClient) PublishNotification(userID uuid.UUID, data map[string]interface{}) {
 	channel := "notifications:" + userID.String()
 
@@ -434,7 +824,33 @@ func (r *RedisClient) PublishNotification(userID uuid.UUID, data map[string]inte
 		return
 	}
 
-	r.client.Publish(r.ctx, channel, payload)
+	err = r.publishBreaker.Do(func() error {
+		return r.client.Publish(r.ctx, channel, payload).Err()
+	})
+	if err != nil && !errors.Is(err, breaker.ErrOpen) {
+		log.Printf("Warning: failed to publish notification: %v", err)
+	}
+}
+
+// PrekeyLowThreshold matches the scheduler's replenishment check; below it the
+// owner is told to upload more one-time pre-keys right away
+const PrekeyLowThreshold = 20
+
+// NotifyPrekeysLow prompts a user whose one-time pre-keys are below
+// PrekeyLowThreshold to upload more. Popular users can burn through pre-keys
+// well before the scheduler's next check, so key fetches call this; it is
+// throttled so every fetch doesn't re-notify.
+func (r *RedisClient) NotifyPrekeysLow(userID uuid.UUID, remaining int) {
+	if remaining >= PrekeyLowThreshold {
+		return
+	}
+	if allowed, err := r.CheckRateLimit("prekey_low:"+userID.String(), 1, 10*time.Minute); err != nil || !allowed {
+		return
+	}
+	r.PublishNotification(userID, map[string]interface{}{
+		"type":              "prekey_replenishment_needed",
+		"prekeys_remaining": remaining,
+	})
 }
 
 // ================== Typing Indicators ==================
@@ -500,3 +916,449 @@ func (r *RedisClient) GetCachedSession(tokenHash string) (*uuid.UUID, error) {
 func (r *RedisClient) InvalidateSession(tokenHash string) {
 	r.client.Del(r.ctx, "session:"+tokenHash)
 }
+
+// ================== WebSocket Tickets ==================
+
+// WSTicketTTL is how long a WebSocket ticket can be redeemed after issue
+const WSTicketTTL = 30 * time.Second
+
+// CreateWSTicket stores the access token behind a single-use ticket
+func (r *RedisClient) CreateWSTicket(ticket, accessToken string) error {
+	return r.client.Set(r.ctx, "ws_ticket:"+ticket, accessToken, WSTicketTTL).Err()
+}
+
+// RedeemWSTicket returns the access token for a ticket and deletes it, so a
+// ticket can only be used once. Returns redis.Nil for unknown or expired tickets.
+func (r *RedisClient) RedeemWSTicket(ticket string) (string, error) {
+	return r.client.GetDel(r.ctx, "ws_ticket:"+ticket).Result()
+}
+
+// ================== Media Download Tokens ==================
+
+// One-time and reusable tokens live under separate keys so a one-time
+// token can be redeemed atomically
+const (
+	mediaTokenPrefix     = "media_token:"
+	mediaTokenOncePrefix = "media_token_once:"
+)
+
+// mediaTokenGrace is how long a one-time token keeps working for its first
+// redeemer after their download starts, so the range requests of that
+// download (resuming, or a player reading the index at the end of a file)
+// are served too
+const mediaTokenGrace = 2 * time.Minute
+
+// redeemOnceScript returns a one-time token's media ID. The first use binds
+// the token to the redeemer (stored as "<media ID>|<redeemer>") and cuts its
+// lifetime to the grace window; later uses must come from the same redeemer.
+// KEYS: token. ARGV: grace ms, redeemer.
+var redeemOnceScript = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if not v then
+	return false
+end
+local sep = string.find(v, '|', 1, true)
+if not sep then
+	local ttl = redis.call('PTTL', KEYS[1])
+	local grace = tonumber(ARGV[1])
+	if ttl <= 0 or ttl > grace then
+		ttl = grace
+	end
+	redis.call('SET', KEYS[1], v .. '|' .. ARGV[2], 'PX', ttl)
+	return v
+end
+if string.sub(v, sep + 1) ~= ARGV[2] then
+	return false
+end
+return string.sub(v, 1, sep - 1)
+`)
+
+// CreateMediaDownloadToken stores a token granting download of one media
+// object until ttl passes. A one-time token is consumed by its first download.
+func (r *RedisClient) CreateMediaDownloadToken(token string, mediaID uuid.UUID, oneTime bool, ttl time.Duration) error {
+	prefix := mediaTokenPrefix
+	if oneTime {
+		prefix = mediaTokenOncePrefix
+	}
+	return r.client.Set(r.ctx, prefix+token, mediaID.String(), ttl).Err()
+}
+
+// RedeemMediaDownloadToken returns the media a token grants to redeemer (the
+// client's IP). A one-time token only works for its first redeemer from then
+// on, and expires mediaTokenGrace after that first use. Returns redis.Nil for
+// unknown, expired or used tokens.
+func (r *RedisClient) RedeemMediaDownloadToken(token, redeemer string) (uuid.UUID, error) {
+	value, err := redeemOnceScript.Run(r.ctx, r.client, []string{mediaTokenOncePrefix + token},
+		mediaTokenGrace.Milliseconds(), redeemer).Text()
+	if err == redis.Nil {
+		value, err = r.client.Get(r.ctx, mediaTokenPrefix+token).Result()
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(value)
+}
+
+// ================== Message Routing ==================
+
+// messageRoutedTTL covers client retries of a send; a retry after that
+// falls back to the message's stored status
+const messageRoutedTTL = 24 * time.Hour
+
+// MarkMessageRouted records that a message was handed to delivery, so a
+// retried send of it is only re-acked
+func (r *RedisClient) MarkMessageRouted(messageID uuid.UUID) error {
+	return r.client.Set(r.ctx, "msg_routed:"+messageID.String(), 1, messageRoutedTTL).Err()
+}
+
+// IsMessageRouted reports whether MarkMessageRouted was called for the
+// message within messageRoutedTTL
+func (r *RedisClient) IsMessageRouted(messageID uuid.UUID) (bool, error) {
+	n, err := r.client.Exists(r.ctx, "msg_routed:"+messageID.String()).Result()
+	return n > 0, err
+}
+
+// ================== Maintenance Mode ==================
+
+// maintenanceKey is shared by every chat server so one admin toggle quiesces the cluster
+const maintenanceKey = "maintenance:enabled"
+
+// SetMaintenanceMode turns cluster-wide maintenance mode on or off
+func (r *RedisClient) SetMaintenanceMode(enabled bool) error {
+	if enabled {
+		return r.client.Set(r.ctx, maintenanceKey, time.Now().UTC().Unix(), 0).Err()
+	}
+	return r.client.Del(r.ctx, maintenanceKey).Err()
+}
+
+// GetMaintenanceMode reports whether maintenance mode is on and since when
+func (r *RedisClient) GetMaintenanceMode() (bool, time.Time, error) {
+	since, err := r.client.Get(r.ctx, maintenanceKey).Int64()
+	if err == redis.Nil {
+		return false, time.Time{}, nil
+	}
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	return true, time.Unix(since, 0).UTC(), nil
+}
+
+// IsMaintenanceMode reports whether maintenance mode is on.
+// Fails open: a Redis error must not take the whole service down.
+func (r *RedisClient) IsMaintenanceMode() bool {
+	enabled, _, err := r.GetMaintenanceMode()
+	if err != nil {
+		log.Printf("Warning: failed to read maintenance flag: %v", err)
+		return false
+	}
+	return enabled
+}
+
+// ================== Client Version Policy ==================
+
+// versionPolicyKey holds the per-platform minimum and recommended app
+// versions, so they can change without a redeploy
+const versionPolicyKey = "client_versions:policy"
+
+// versionPolicyCacheTTL is how long a server uses its copy of the policy;
+// other servers pick up a change within it
+const versionPolicyCacheTTL = 5 * time.Second
+
+// SetVersionPolicy replaces the cluster's client version policy. An empty
+// policy removes every requirement.
+func (r *RedisClient) SetVersionPolicy(policy appversion.Policy) error {
+	var err error
+	if len(policy) == 0 {
+		policy = appversion.Policy{}
+		err = r.client.Del(r.ctx, versionPolicyKey).Err()
+	} else {
+		var data []byte
+		if data, err = json.Marshal(policy); err != nil {
+			return err
+		}
+		err = r.client.Set(r.ctx, versionPolicyKey, data, 0).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	r.versionPolicyMu.Lock()
+	r.versionPolicy, r.versionPolicyFetched = policy, time.Now()
+	r.versionPolicyMu.Unlock()
+	return nil
+}
+
+// GetVersionPolicy returns the cluster's client version policy, empty if none
+// is set
+func (r *RedisClient) GetVersionPolicy() (appversion.Policy, error) {
+	data, err := r.client.Get(r.ctx, versionPolicyKey).Bytes()
+	if err == redis.Nil {
+		return appversion.Policy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policy := appversion.Policy{}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// CheckClientVersion applies the cached version policy to a client. If
+// Redis can't be read it keeps the last policy it had, or fails open like
+// IsMaintenanceMode: a Redis error must not lock every client out.
+func (r *RedisClient) CheckClientVersion(platform, version string) (appversion.Status, appversion.PlatformPolicy) {
+	r.versionPolicyMu.Lock()
+	defer r.versionPolicyMu.Unlock()
+
+	if time.Since(r.versionPolicyFetched) >= versionPolicyCacheTTL {
+		policy, err := r.GetVersionPolicy()
+		if err != nil {
+			log.Printf("Warning: failed to read client version policy: %v", err)
+		} else {
+			r.versionPolicy = policy
+		}
+		// Also after an error, so a Redis outage costs one read per TTL
+		r.versionPolicyFetched = time.Now()
+	}
+	return r.versionPolicy.Check(platform, version)
+}
+
+// ================== Server Load ==================
+
+// serverLoadSetKey indexes the chat servers that have reported their load
+const serverLoadSetKey = "server_load_servers"
+
+func serverLoadKey(serverID string) string {
+	return "server_load:" + serverID
+}
+
+// SetServerLoad publishes this server's connection count. The entry expires
+// after ttl so a crashed server drops out of the cluster average.
+func (r *RedisClient) SetServerLoad(serverID string, connections int, ttl time.Duration) error {
+	pipe := r.client.Pipeline()
+	pipe.Set(r.ctx, serverLoadKey(serverID), connections, ttl)
+	pipe.SAdd(r.ctx, serverLoadSetKey, serverID)
+	_, err := pipe.Exec(r.ctx)
+	return err
+}
+
+// GetClusterLoad returns the connection count of every server with a live
+// load entry. Servers whose entry expired are pruned from the index.
+func (r *RedisClient) GetClusterLoad() (map[string]int, error) {
+	serverIDs, err := r.client.SMembers(r.ctx, serverLoadSetKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(serverIDs) == 0 {
+		return map[string]int{}, nil
+	}
+
+	keys := make([]string, len(serverIDs))
+	for i, serverID := range serverIDs {
+		keys[i] = serverLoadKey(serverID)
+	}
+	values, err := MGet(r.ctx, r.client, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	load := make(map[string]int, len(serverIDs))
+	var expired []interface{}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			expired = append(expired, serverIDs[i])
+			continue
+		}
+		connections, err := strconv.Atoi(str)
+		if err != nil {
+			continue
+		}
+		load[serverIDs[i]] = connections
+	}
+	if len(expired) > 0 {
+		r.client.SRem(r.ctx, serverLoadSetKey, expired...)
+	}
+	return load, nil
+}
+
+// ================== Contact Caching ==================
+
+// contactsCacheTTL bounds how stale a cached contact set can get
+const contactsCacheTTL = 5 * time.Minute
+
+// contactsEmptyMarker is stored in otherwise-empty contact sets so that
+// "no contacts" is cached too (Redis deletes empty sets)
+const contactsEmptyMarker = "-"
+
+// invalidateContactsScript drops a cached contact set when the partner is not
+// in it yet. Sets that are not cached are left alone.
+var invalidateContactsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 and redis.call('SISMEMBER', KEYS[1], ARGV[1]) == 0 then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// GetCachedContacts returns the cached set of users a user has messaged with.
+// The second return value is false on a cache miss.
+func (r *RedisClient) GetCachedContacts(userID uuid.UUID) ([]uuid.UUID, bool) {
+	members, err := r.client.SMembers(r.ctx, "contacts:"+userID.String()).Result()
+	if err != nil || len(members) == 0 {
+		return nil, false
+	}
+
+	contacts := make([]uuid.UUID, 0, len(members))
+	for _, member := range members {
+		if member == contactsEmptyMarker {
+			continue
+		}
+		contactID, err := uuid.Parse(member)
+		if err != nil {
+			continue
+		}
+		contacts = append(contacts, contactID)
+	}
+	return contacts, true
+}
+
+// CacheContacts stores a user's contact set with a short TTL
+func (r *RedisClient) CacheContacts(userID uuid.UUID, contacts []uuid.UUID) {
+	key := "contacts:" + userID.String()
+
+	members := make([]interface{}, 0, len(contacts)+1)
+	members = append(members, contactsEmptyMarker)
+	for _, contactID := range contacts {
+		members = append(members, contactID.String())
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Del(r.ctx, key)
+	pipe.SAdd(r.ctx, key, members...)
+	pipe.Expire(r.ctx, key, contactsCacheTTL)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		log.Printf("Warning: failed to cache contacts for %s: %v", userID, err)
+	}
+}
+
+// InvalidateContactsOnNewPartner drops both users' cached contact sets if they
+// do not already list each other (i.e. this is a new conversation)
+func (r *RedisClient) InvalidateContactsOnNewPartner(userA, userB uuid.UUID) {
+	if err := invalidateContactsScript.Run(r.ctx, r.client,
+		[]string{"contacts:" + userA.String()}, userB.String()).Err(); err != nil {
+		log.Printf("Warning: failed to invalidate contacts cache for %s: %v", userA, err)
+	}
+	if err := invalidateContactsScript.Run(r.ctx, r.client,
+		[]string{"contacts:" + userB.String()}, userA.String()).Err(); err != nil {
+		log.Printf("Warning: failed to invalidate contacts cache for %s: %v", userB, err)
+	}
+}
+
+// ================== Block Status Caching ==================
+
+// blockStatusCacheTTL bounds how long a cached block check is trusted.
+// Block and unblock invalidate the entry, so this only covers missed invalidations.
+const blockStatusCacheTTL = 30 * time.Second
+
+// blockPairKey returns the cache key for a pair of users, independent of order
+func blockPairKey(userA, userB uuid.UUID) string {
+	a, b := userA.String(), userB.String()
+	if a > b {
+		a, b = b, a
+	}
+	return "block_pair:" + a + ":" + b
+}
+
+// GetCachedBlockStatus returns whether either user has blocked the other.
+// The second return value is false on a cache miss.
+func (r *RedisClient) GetCachedBlockStatus(userA, userB uuid.UUID) (bool, bool) {
+	val, err := r.client.Get(r.ctx, blockPairKey(userA, userB)).Result()
+	if err != nil {
+		return false, false
+	}
+	return val == "1", true
+}
+
+// CacheBlockStatus stores the block status of a pair of users with a short TTL
+func (r *RedisClient) CacheBlockStatus(userA, userB uuid.UUID, blocked bool) {
+	val := "0"
+	if blocked {
+		val = "1"
+	}
+	if err := r.client.Set(r.ctx, blockPairKey(userA, userB), val, blockStatusCacheTTL).Err(); err != nil {
+		log.Printf("Warning: failed to cache block status: %v", err)
+	}
+}
+
+// InvalidateBlockStatus drops the cached block status of a pair of users
+func (r *RedisClient) InvalidateBlockStatus(userA, userB uuid.UUID) {
+	if err := r.client.Del(r.ctx, blockPairKey(userA, userB)).Err(); err != nil {
+		log.Printf("Warning: failed to invalidate block status: %v", err)
+	}
+}
+
+// ================== Privacy Settings Caching ==================
+
+// privacyCacheTTL bounds how long cached privacy settings are trusted.
+// Saving settings invalidates the entry, so this only covers missed invalidations.
+const privacyCacheTTL = 30 * time.Second
+
+func privacyCacheKey(userID uuid.UUID) string {
+	return "privacy:" + userID.String()
+}
+
+// GetCachedPrivacySettings returns one boolean privacy setting for each of
+// userIDs in a single round trip. Users missing from the result weren't cached.
+func (r *RedisClient) GetCachedPrivacySettings(userIDs []uuid.UUID, setting string) map[uuid.UUID]bool {
+	cached := make(map[uuid.UUID]bool)
+	if len(userIDs) == 0 {
+		return cached
+	}
+
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		cmds[i] = pipe.HGet(r.ctx, privacyCacheKey(userID), setting)
+	}
+	if _, err := pipe.Exec(r.ctx); err != nil && err != redis.Nil {
+		log.Printf("Warning: failed to read cached privacy settings: %v", err)
+	}
+	for i, cmd := range cmds {
+		if val, err := cmd.Result(); err == nil {
+			cached[userIDs[i]] = val == "1"
+		}
+	}
+	return cached
+}
+
+// CachePrivacySettings stores some of a user's boolean privacy settings with
+// a short TTL. Settings not given stay uncached.
+func (r *RedisClient) CachePrivacySettings(userID uuid.UUID, settings map[string]bool) {
+	if len(settings) == 0 {
+		return
+	}
+	fields := make([]any, 0, 2*len(settings))
+	for name, enabled := range settings {
+		val := "0"
+		if enabled {
+			val = "1"
+		}
+		fields = append(fields, name, val)
+	}
+
+	pipe := r.client.Pipeline()
+	pipe.HSet(r.ctx, privacyCacheKey(userID), fields...)
+	pipe.Expire(r.ctx, privacyCacheKey(userID), privacyCacheTTL)
+	if _, err := pipe.Exec(r.ctx); err != nil {
+		log.Printf("Warning: failed to cache privacy settings: %v", err)
+	}
+}
+
+// InvalidatePrivacySettings drops a user's cached privacy settings
+func (r *RedisClient) InvalidatePrivacySettings(userID uuid.UUID) {
+	if err := r.client.Del(r.ctx, privacyCacheKey(userID)).Err(); err != nil {
+		log.Printf("Warning: failed to invalidate privacy settings: %v", err)
+	}
+}
@@ -3,17 +3,19 @@ package queue
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
 	"github.com/redis/go-redis/v9"
 )
 
 // MessageQueue handles async message processing using Redis Streams
 // Used for analytics, archival, and async delivery retries
 type MessageQueue struct {
-	client    *redis.Client
+	client    redis.UniversalClient
 	ctx       context.Context
 	streamKey string
 }
@@ -25,12 +27,15 @@ type QueuedMessage struct {
 	ReceiverID  *uuid.UUID `json:"receiver_id,omitempty"`
 	GroupID     *uuid.UUID `json:"group_id,omitempty"`
 	Timestamp   time.Time  `json:"timestamp"`
-	EventType   string     `json:"event_type"` // "sent", "delivered", "read", "archived"
+	EventType   string     `json:"event_type"` // "sent", "delivered", "read", "archived", "group_fanout"
 	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+
+	// Event-specific data, e.g. the encrypted payload of a group_fanout job
+	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
 // NewMessageQueue creates a new message queue
-func NewMessageQueue(client *redis.Client, streamKey string) *MessageQueue {
+func NewMessageQueue(client redis.UniversalClient, streamKey string) *MessageQueue {
 	if streamKey == "" {
 		streamKey = "message_events"
 	}
@@ -115,31 +120,154 @@ func (q *MessageQueue) StartConsumer(consumerGroup, consumerName string, handler
 
 		for _, stream := range streams {
 			for _, message := range stream.Messages {
-				data, ok := message.Values["data"].(string)
-				if !ok {
-					continue
-				}
-
-				var queuedMsg QueuedMessage
-				if err := json.Unmarshal([]byte(data), &queuedMsg); err != nil {
-					log.Printf("Failed to parse queued message: %v", err)
-					continue
-				}
-
-				// Process the message
-				if err := handler(&queuedMsg); err != nil {
-					log.Printf("Failed to process message %s: %v", queuedMsg.MessageID, err)
-					// Could add to dead letter queue here
-					continue
-				}
-
-				// Acknowledge the message
-				q.client.XAck(q.ctx, q.streamKey, consumerGroup, message.ID)
+				q.processMessage(consumerGroup, message, handler)
 			}
 		}
 	}
 }
 
+// processMessage decodes and handles one stream entry, acknowledging it on success.
+// Failed entries stay pending so they can be reclaimed later.
+func (q *MessageQueue) processMessage(consumerGroup string, message redis.XMessage, handler func(*QueuedMessage) error) bool {
+	// Malformed entries can never succeed; ACK them so they aren't reclaimed forever
+	data, ok := message.Values["data"].(string)
+	if !ok {
+		q.client.XAck(q.ctx, q.streamKey, consumerGroup, message.ID)
+		return false
+	}
+
+	var queuedMsg QueuedMessage
+	if err := json.Unmarshal([]byte(data), &queuedMsg); err != nil {
+		log.Printf("Failed to parse queued message: %v", err)
+		q.client.XAck(q.ctx, q.streamKey, consumerGroup, message.ID)
+		return false
+	}
+
+	// Process the message
+	if err := handler(&queuedMsg); err != nil {
+		log.Printf("Failed to process message %s: %v", queuedMsg.MessageID, err)
+		// Could add to dead letter queue here
+		return false
+	}
+
+	// Acknowledge the message
+	q.client.XAck(q.ctx, q.streamKey, consumerGroup, message.ID)
+	return true
+}
+
+// ClaimStale takes over entries that have been pending in the group for longer
+// than minIdle (e.g. because their consumer crashed) and processes them.
+// At most count entries are claimed per call. Returns the number claimed.
+func (q *MessageQueue) ClaimStale(consumerGroup, consumerName string, minIdle time.Duration, count int64, handler func(*QueuedMessage) error) (int, error) {
+	claimed := 0
+	start := "0-0"
+	for int64(claimed) < count {
+		messages, next, err := q.client.XAutoClaim(q.ctx, &redis.XAutoClaimArgs{
+			Stream:   q.streamKey,
+			Group:    consumerGroup,
+			Consumer: consumerName,
+			MinIdle:  minIdle,
+			Start:    start,
+			Count:    count - int64(claimed),
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+
+		claimed += len(messages)
+		metrics.QueueMessagesClaimedTotal.WithLabelValues(q.streamKey, consumerGroup).Add(float64(len(messages)))
+		for _, message := range messages {
+			q.processMessage(consumerGroup, message, handler)
+		}
+
+		// "0-0" means the whole pending list has been scanned
+		if next == "0-0" || next == "" {
+			break
+		}
+		start = next
+	}
+	return claimed, nil
+}
+
+// StartAutoClaim periodically claims and reprocesses entries left pending by
+// crashed consumers. Blocks forever; run it in a goroutine.
+func (q *MessageQueue) StartAutoClaim(consumerGroup, consumerName string, interval, minIdle time.Duration, batchSize int64, handler func(*QueuedMessage) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		claimed, err := q.ClaimStale(consumerGroup, consumerName, minIdle, batchSize, handler)
+		if err != nil {
+			log.Printf("Error auto-claiming stale messages: %v", err)
+			continue
+		}
+		if claimed > 0 {
+			log.Printf("Reclaimed %d stale messages idle for more than %s", claimed, minIdle)
+		}
+	}
+}
+
+// GetGroupLag returns the number of stream entries not yet delivered to the
+// consumer group (lag) and the number delivered but not acknowledged (pending)
+func (q *MessageQueue) GetGroupLag(consumerGroup string) (lag int64, pending int64, err error) {
+	groups, err := q.client.XInfoGroups(q.ctx, q.streamKey).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, g := range groups {
+		if g.Name == consumerGroup {
+			return g.Lag, g.Pending, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("consumer group %s not found on stream %s", consumerGroup, q.streamKey)
+}
+
+// StartLagMonitor periodically exports consumer group lag and pending counts
+// as Prometheus gauges. Blocks forever; run it in a goroutine.
+func (q *MessageQueue) StartLagMonitor(consumerGroup string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		lag, pending, err := q.GetGroupLag(consumerGroup)
+		if err != nil {
+			log.Printf("Warning: failed to read consumer group lag: %v", err)
+			continue
+		}
+		// Lag is -1 when Redis cannot determine it (e.g. after entries were trimmed)
+		if lag >= 0 {
+			metrics.QueueConsumerGroupLag.WithLabelValues(q.streamKey, consumerGroup).Set(float64(lag))
+		}
+		metrics.QueueConsumerGroupPending.WithLabelValues(q.streamKey, consumerGroup).Set(float64(pending))
+	}
+}
+
+// WithIdempotency wraps a handler so each (message_id, event_type) pair is
+// processed at most once within ttl. Redelivered events (consumer retries,
+// auto-claim) are acknowledged without running the handler again. The
+// processed marker is released if the handler fails so the event can retry.
+func (q *MessageQueue) WithIdempotency(ttl time.Duration, handler func(*QueuedMessage) error) func(*QueuedMessage) error {
+	return func(msg *QueuedMessage) error {
+		key := "processed:" + q.streamKey + ":" + msg.EventType + ":" + msg.MessageID.String()
+
+		first, err := q.client.SetNX(q.ctx, key, time.Now().UTC().Unix(), ttl).Result()
+		if err != nil {
+			return fmt.Errorf("failed to check idempotency key: %w", err)
+		}
+		if !first {
+			log.Printf("Skipping duplicate %s event for message %s", msg.EventType, msg.MessageID)
+			metrics.QueueDuplicateEventsTotal.WithLabelValues(q.streamKey, msg.EventType).Inc()
+			return nil
+		}
+
+		if err := handler(msg); err != nil {
+			q.client.Del(q.ctx, key)
+			return err
+		}
+		return nil
+	}
+}
+
 // GetQueueLength returns the number of pending messages
 func (q *MessageQueue) GetQueueLength() (int64, error) {
 	return q.client.XLen(q.ctx, q.streamKey).Result()
@@ -3,23 +3,65 @@ package registry
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 )
 
+// Service names registered in Consul
+const (
+	ChatServerService = "chat-server"
+	PresenceService   = "presence-service"
+	GroupService      = "group-service"
+)
+
+// ttlCheckTTL is how long Consul waits for a heartbeat before marking an
+// instance critical; heartbeats are sent every ttlCheckInterval
+const (
+	ttlCheckTTL      = 15 * time.Second
+	ttlCheckInterval = 5 * time.Second
+)
+
 // ConsulRegistry handles service registration with Consul
 type ConsulRegistry struct {
-	client     *api.Client
-	serviceID  string
-	serverID   string
-	serverPort int
+	client      *api.Client
+	serviceName string
+	serviceID   string
+	serverID    string
+	serverPort  int
+	tags        []string
+
+	// ready decides whether heartbeats report passing; nil means always
+	ready         func() error
+	stopHeartbeat chan struct{}
+	stopOnce      sync.Once
+	// heartbeatMu keeps a heartbeat from registering again while, or after,
+	// Deregister runs
+	heartbeatMu sync.Mutex
+	stopped     bool
+
+	// registration is kept so a heartbeat can register again if Consul has
+	// lost the service (e.g. after an agent restart)
+	registration *api.AgentServiceRegistration
 }
 
-// NewConsulRegistry creates a new Consul registry
+// NewConsulRegistry creates a new Consul registry for a chat server
 func NewConsulRegistry(addr, serverID, serverPort string) (*ConsulRegistry, error) {
+	registry, err := NewServiceRegistry(addr, ChatServerService, serverID, serverPort)
+	if err != nil {
+		return nil, err
+	}
+	registry.tags = []string{"chat", "websocket"}
+	return registry, nil
+}
+
+// NewServiceRegistry creates a Consul registry for any of our services,
+// e.g. PresenceService. serviceID must be unique per instance.
+func NewServiceRegistry(addr, serviceName, serviceID, serverPort string) (*ConsulRegistry, error) {
 	config := api.DefaultConfig()
 	config.Address = addr
 
@@ -35,13 +77,27 @@ func NewConsulRegistry(addr, serverID, serverPort string) (*ConsulRegistry, erro
 	}
 
 	return &ConsulRegistry{
-		client:     client,
-		serviceID:  serverID,
-		serverID:   serverID,
-		serverPort: port,
+		client:        client,
+		serviceName:   serviceName,
+		serviceID:     serviceID,
+		serverID:      serviceID,
+		serverPort:    port,
+		stopHeartbeat: make(chan struct{}),
 	}, nil
 }
 
+// SetReadinessCheck sets the check run before each TTL heartbeat. While it
+// fails the instance is reported critical and drops out of discovery.
+// Call before Register.
+func (c *ConsulRegistry) SetReadinessCheck(ready func() error) {
+	c.ready = ready
+}
+
+// ttlCheckID is the ID of the instance's heartbeat check
+func (c *ConsulRegistry) ttlCheckID() string {
+	return "service:" + c.serviceID + ":ttl"
+}
+
 // Register registers this server with Consul
 func (c *ConsulRegistry) Register() error {
 	hostname, err := os.Hostname()
@@ -52,15 +108,30 @@ func (c *ConsulRegistry) Register() error {
 
 	registration := &api.AgentServiceRegistration{
 		ID:      c.serviceID,
-		Name:    "chat-server",
+		Name:    c.serviceName,
 		Port:    c.serverPort,
 		Address: hostname,
-		Tags:    []string{"chat", "websocket"},
-		Check: &api.AgentServiceCheck{
-			HTTP:                           fmt.Sprintf("http://%s:%d/health", hostname, c.serverPort),
-			Interval:                       "10s",
-			Timeout:                        "3s",
-			DeregisterCriticalServiceAfter: "30s",
+		Tags:    c.tags,
+		Checks: api.AgentServiceChecks{
+			// Liveness: Consul can reach the process
+			{
+				CheckID:                        "service:" + c.serviceID + ":http",
+				Name:                           "HTTP health",
+				HTTP:                           fmt.Sprintf("http://%s:%d/health", hostname, c.serverPort),
+				Interval:                       "10s",
+				Timeout:                        "3s",
+				DeregisterCriticalServiceAfter: "30s",
+			},
+			// Readiness: heartbeats from the process itself, sent only
+			// while its dependencies answer. A failing dependency only takes
+			// the instance out of discovery; it isn't deregistered, so it
+			// comes back as soon as heartbeats pass again.
+			{
+				CheckID: c.ttlCheckID(),
+				Name:    "Readiness heartbeat",
+				TTL:     ttlCheckTTL.String(),
+				Status:  api.HealthCritical,
+			},
 		},
 		Meta: map[string]string{
 			"server_id": c.serverID,
@@ -70,13 +141,71 @@ func (c *ConsulRegistry) Register() error {
 	if err := c.client.Agent().ServiceRegister(registration); err != nil {
 		return err
 	}
+	c.registration = registration
+
+	c.heartbeat()
+	go c.runHeartbeat()
 
 	log.Printf("✅ Registered with Consul: %s", c.serviceID)
 	return nil
 }
 
-// Deregister removes this server from Consul
+// runHeartbeat keeps the TTL check updated until Deregister
+func (c *ConsulRegistry) runHeartbeat() {
+	ticker := time.NewTicker(ttlCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopHeartbeat:
+			return
+		case <-ticker.C:
+			c.heartbeat()
+		}
+	}
+}
+
+// heartbeat reports the readiness check's result to Consul
+func (c *ConsulRegistry) heartbeat() {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if c.stopped {
+		return
+	}
+
+	status, output := api.HealthPassing, "ready"
+	if c.ready != nil {
+		if err := c.ready(); err != nil {
+			status, output = api.HealthCritical, err.Error()
+		}
+	}
+	if err := c.client.Agent().UpdateTTL(c.ttlCheckID(), output, status); err != nil {
+		// Most likely the service was deregistered (the HTTP check failed
+		// for too long, or the agent restarted): register again and retry
+		log.Printf("Warning: failed to update Consul health check, registering again: %v", err)
+		if err := c.client.Agent().ServiceRegister(c.registration); err != nil {
+			log.Printf("Warning: failed to register with Consul again: %v", err)
+			return
+		}
+		if err := c.client.Agent().UpdateTTL(c.ttlCheckID(), output, status); err != nil {
+			log.Printf("Warning: failed to update Consul health check: %v", err)
+		}
+	}
+}
+
+// Deregister marks this instance critical, so discovery drops it at once,
+// then removes it from Consul
 func (c *ConsulRegistry) Deregister() error {
+	c.stopOnce.Do(func() {
+		close(c.stopHeartbeat)
+		c.heartbeatMu.Lock()
+		c.stopped = true
+		c.heartbeatMu.Unlock()
+		if err := c.client.Agent().UpdateTTL(c.ttlCheckID(), "shutting down", api.HealthCritical); err != nil {
+			log.Printf("Warning: failed to mark service critical before deregistering: %v", err)
+		}
+	})
+
 	if err := c.client.Agent().ServiceDeregister(c.serviceID); err != nil {
 		return err
 	}
@@ -87,7 +216,7 @@ func (c *ConsulRegistry) Deregister() error {
 
 // GetHealthyServers returns all healthy chat servers
 func (c *ConsulRegistry) GetHealthyServers() ([]string, error) {
-	services, _, err := c.client.Health().Service("chat-server", "", true, nil)
+	services, _, err := c.client.Health().Service(ChatServerService, "", true, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -99,12 +228,32 @@ func (c *ConsulRegistry) GetHealthyServers() ([]string, error) {
 	return servers, nil
 }
 
+// GetServiceAddresses returns the host:port of every healthy instance of a
+// service, for calling it directly
+func (c *ConsulRegistry) GetServiceAddresses(serviceName string) ([]string, error) {
+	services, _, err := c.client.Health().Service(serviceName, "", true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(services))
+	for _, service := range services {
+		host := service.Service.Address
+		if host == "" {
+			// Registered without an address: Consul means the node's
+			host = service.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(service.Service.Port)))
+	}
+	return addrs, nil
+}
+
 // WatchServices watches for changes in available servers
 func (c *ConsulRegistry) WatchServices(callback func([]string)) {
 	var lastIndex uint64
 
 	for {
-		services, meta, err := c.client.Health().Service("chat-server", "", true, &api.QueryOptions{
+		services, meta, err := c.client.Health().Service(ChatServerService, "", true, &api.QueryOptions{
 			WaitIndex: lastIndex,
 			WaitTime:  5 * time.Minute,
 		})
@@ -396,8 +396,8 @@ func (aal *AsyncAuditLogger) writeBatch(events []*AuditEvent) error {
 			(id, user_id, session_id, device_id, event_type, severity, result,
 			 resource, resource_id, resource_type, action, event_data, description,
 			 ip_address, user_agent, request_id, request_path, request_method,
-			 country, region, city, timestamp, duration_ms, compliance_flags, data_category)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+			 country, region, city, timestamp, duration_ms, compliance_flags, data_category, retention_days)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
 		`)
 		if err != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
@@ -426,7 +426,7 @@ func (aal *AsyncAuditLogger) writeBatch(events []*AuditEvent) error {
 				event.IPAddress, event.UserAgent, event.RequestID,
 				event.RequestPath, event.RequestMethod,
 				event.Country, event.Region, event.City,
-				event.Timestamp, event.Duration, complianceFlags, event.DataCategory,
+				event.Timestamp, event.Duration, complianceFlags, event.DataCategory, retentionDaysArg(event),
 			)
 			if err != nil {
 				if rbErr := tx.Rollback(); rbErr != nil {
@@ -467,8 +467,8 @@ func (aal *AsyncAuditLogger) write(event *AuditEvent) error {
 			(id, user_id, session_id, device_id, event_type, severity, result,
 			 resource, resource_id, resource_type, action, event_data, description,
 			 ip_address, user_agent, request_id, request_path, request_method,
-			 country, region, city, timestamp, duration_ms, compliance_flags, data_category)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+			 country, region, city, timestamp, duration_ms, compliance_flags, data_category, retention_days)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
 		`, event.ID, event.UserID, event.SessionID, event.DeviceID,
 			event.EventType, event.Severity, event.Result,
 			event.Resource, event.ResourceID, event.ResourceType,
@@ -476,7 +476,7 @@ func (aal *AsyncAuditLogger) write(event *AuditEvent) error {
 			event.IPAddress, event.UserAgent, event.RequestID,
 			event.RequestPath, event.RequestMethod,
 			event.Country, event.Region, event.City,
-			event.Timestamp, event.Duration, complianceFlags, event.DataCategory,
+			event.Timestamp, event.Duration, complianceFlags, event.DataCategory, retentionDaysArg(event),
 		)
 
 		if err != nil {
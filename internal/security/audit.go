@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -87,6 +88,9 @@ const (
 	AuditEventDataAccess   AuditEventType = "data_access"
 	AuditEventDataModified AuditEventType = "data_modified"
 	AuditEventDataDeleted  AuditEventType = "data_deleted"
+
+	// Written by the retention purge; lists the chain links it removed
+	AuditEventAuditPurged AuditEventType = "audit_purged"
 )
 
 const (
@@ -120,6 +124,9 @@ type AuditConfig struct {
 	AuditFailureLogPath    string           `json:"audit_failure_log_path"`
 }
 
+// auditHealthInterval controls how often audit pipeline gauges are refreshed
+const auditHealthInterval = 10 * time.Second
+
 // DefaultAuditConfig returns default audit configuration
 func DefaultAuditConfig() *AuditConfig {
 	return &AuditConfig{
@@ -215,6 +222,75 @@ type AuditLogger struct {
 	failureLogger     *log.Logger
 	failureFile       *os.File
 	overflowSemaphore chan struct{} // Semaphore to limit concurrent overflow writes
+
+	// Long-lived validator so validation metrics accumulate across events
+	validator   *ComprehensiveAuditValidator
+	validatorMu sync.Mutex
+
+	// Optional IP geolocation for the Country/Region/City fields
+	geo GeoLocator
+}
+
+// GeoLocator resolves an IP address to an ISO country code, region and city.
+// Unknown addresses return empty strings.
+type GeoLocator interface {
+	Locate(ip string) (country, region, city string)
+}
+
+// SetGeoLocator enables location enrichment of audit events. Call before
+// logging starts.
+func (al *AuditLogger) SetGeoLocator(geo GeoLocator) {
+	al.geo = geo
+}
+
+// LocateIP returns the country code, region and city of an IP address, or
+// empty strings if geolocation is disabled or the address is unknown,
+// private or loopback
+func (al *AuditLogger) LocateIP(ip string) (country, region, city string) {
+	if al.geo == nil {
+		return "", "", ""
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsPrivate() || parsed.IsLoopback() ||
+		parsed.IsLinkLocalUnicast() || parsed.IsUnspecified() {
+		return "", "", ""
+	}
+	return al.geo.Locate(ip)
+}
+
+// RequestLocation is the geolocation of a request's client IP
+type RequestLocation struct {
+	Country string
+	Region  string
+	City    string
+}
+
+type requestLocationKey struct{}
+
+// GeoContextMiddleware resolves the client IP once per request and stores
+// its location in the request context for LogFromRequest and handlers
+func (al *AuditLogger) GeoContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if al.geo == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var loc RequestLocation
+		loc.Country, loc.Region, loc.City = al.LocateIP(GetRealIP(r))
+		ctx := context.WithValue(r.Context(), requestLocationKey{}, loc)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LocateRequest returns the location stored by GeoContextMiddleware, or
+// resolves the client IP if the middleware didn't run
+func (al *AuditLogger) LocateRequest(r *http.Request) RequestLocation {
+	if loc, ok := r.Context().Value(requestLocationKey{}).(RequestLocation); ok {
+		return loc
+	}
+	var loc RequestLocation
+	loc.Country, loc.Region, loc.City = al.LocateIP(GetRealIP(r))
+	return loc
 }
 
 // NewAuditLogger creates a new audit logger with default settings
@@ -271,10 +347,16 @@ func NewAuditLoggerWithConfig(db *sql.DB, config *AuditConfig) *AuditLogger {
 	al.wg.Add(1)
 	go al.batchWriter()
 
+	al.validator = NewComprehensiveAuditValidator(al)
+
 	// Start dead letter handler
 	al.wg.Add(1)
 	go al.deadLetterHandler()
 
+	// Start health monitor to keep audit pipeline gauges current
+	al.wg.Add(1)
+	go al.healthMonitor()
+
 	return al
 }
 
@@ -332,6 +414,9 @@ func (al *AuditLogger) Log(event *AuditEvent) {
 	if event.Result == "" {
 		event.Result = AuditResultSuccess
 	}
+	if event.Country == "" && event.IPAddress != "" {
+		event.Country, event.Region, event.City = al.LocateIP(event.IPAddress)
+	}
 
 	// Check filters
 	if !al.shouldLog(event) {
@@ -372,11 +457,10 @@ func (al *AuditLogger) Log(event *AuditEvent) {
 
 // shouldLog checks if an event should be logged based on configuration filters
 func (al *AuditLogger) shouldLog(event *AuditEvent) bool {
-	// Create comprehensive validator for event validation
-	validator := NewComprehensiveAuditValidator(al)
-
 	// Use comprehensive validation
-	err := validator.ValidateAuditEventWithContext(context.Background(), event)
+	al.validatorMu.Lock()
+	err := al.validator.ValidateAuditEventWithContext(context.Background(), event)
+	al.validatorMu.Unlock()
 	if err != nil {
 		log.Printf("[AUDIT_EVENT_FILTERED] Event failed validation: %v", err)
 		return false
@@ -426,6 +510,8 @@ func (al *AuditLogger) LogFromRequest(r *http.Request, userID *uuid.UUID, eventT
 		RequestMethod: r.Method,
 		Timestamp:     time.Now().UTC(),
 	}
+	loc := al.LocateRequest(r)
+	event.Country, event.Region, event.City = loc.Country, loc.Region, loc.City
 	al.Log(event)
 }
 
@@ -522,39 +608,108 @@ func (al *AuditLogger) batchWriter() {
 	}
 }
 
-// deadLetterHandler processes permanently failed audit events
+// deadLetterHandler persists permanently failed audit events to the
+// audit_dead_letter table so they can be reprocessed once the database
+// recovers. Events that cannot be persisted yet are held in memory and
+// retried on every flush interval.
 func (al *AuditLogger) deadLetterHandler() {
 	defer al.wg.Done()
 
+	var pending []*AuditEvent
+	ticker := time.NewTicker(al.config.FlushInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case event := <-al.deadLetterChan:
 			// Log the failed event to the failure log
 			al.failureLogger.Printf("Permanently failed audit event: ID=%s, Type=%s, UserID=%v, Error=Max retries exceeded",
 				event.ID, event.EventType, event.UserID)
+			pending = al.persistDeadLetters(append(pending, event))
+
+		case <-ticker.C:
+			if len(pending) > 0 {
+				pending = al.persistDeadLetters(pending)
+			}
+
 		case <-al.shutdown:
 			// Drain remaining failed events
+		drain:
 			for {
 				select {
 				case event := <-al.deadLetterChan:
-					al.failureLogger.Printf("Permanently failed audit event on shutdown: ID=%s, Type=%s, UserID=%v",
-						event.ID, event.EventType, event.UserID)
+					pending = append(pending, event)
 				default:
-					return
+					break drain
 				}
 			}
+
+			// Last attempt to persist; anything left only survives in the failure log
+			for _, event := range al.persistDeadLetters(pending) {
+				al.failureLogger.Printf("Permanently failed audit event on shutdown: ID=%s, Type=%s, UserID=%v",
+					event.ID, event.EventType, event.UserID)
+			}
+			return
 		}
 	}
 }
 
+// healthMonitor periodically runs the system health check, which publishes
+// queue and dead letter depth gauges, and refreshes the persisted dead letter count
+func (al *AuditLogger) healthMonitor() {
+	defer al.wg.Done()
+
+	ticker := time.NewTicker(auditHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			al.validatorMu.Lock()
+			err := al.validator.ValidateAuditSystemHealth()
+			al.validatorMu.Unlock()
+			if err != nil {
+				log.Printf("[AUDIT_HEALTH_WARNING] Health check failed: %v", err)
+			}
+			al.refreshDeadLetterPending(context.Background())
+		case <-al.shutdown:
+			return
+		}
+	}
+}
+
+// AuditHealth is a point-in-time snapshot of the audit pipeline
+type AuditHealth struct {
+	QueueDepth        int                            `json:"queue_depth"`
+	QueueCapacity     int                            `json:"queue_capacity"`
+	DeadLetterDepth   int                            `json:"dead_letter_depth"`
+	DeadLetterPending int64                          `json:"dead_letter_pending"`
+	ValidationMetrics ComprehensiveValidationMetrics `json:"validation_metrics"`
+}
+
+// Health returns the current audit pipeline state and accumulated validation metrics
+func (al *AuditLogger) Health(ctx context.Context) *AuditHealth {
+	al.validatorMu.Lock()
+	validation := *al.validator.GetValidationMetrics()
+	al.validatorMu.Unlock()
+
+	health := &AuditHealth{
+		QueueDepth:        len(al.queue),
+		QueueCapacity:     cap(al.queue),
+		DeadLetterDepth:   len(al.deadLetterChan),
+		ValidationMetrics: validation,
+	}
+	if pending, err := al.DeadLetterPendingCount(ctx); err == nil {
+		health.DeadLetterPending = pending
+	}
+	return health
+}
+
 // retryDBOperation retries a database operation with exponential backoff and comprehensive error handling
 func (al *AuditLogger) retryDBOperation(events []*AuditEvent, operation func() error) error {
 	var lastErr error
 	delay := al.config.BaseRetryDelay
 
-	// Create comprehensive validator for error handling
-	validator := NewComprehensiveAuditValidator(al)
-
 	for attempt := 0; attempt <= al.config.MaxRetries; attempt++ {
 		if attempt > 0 {
 			time.Sleep(delay)
@@ -601,7 +756,7 @@ func (al *AuditLogger) retryDBOperation(events []*AuditEvent, operation func() e
 	// All retries exhausted, send failed events to dead letter queue with comprehensive handling
 	for _, event := range events {
 		// Validate event before sending to dead letter queue
-		if err := validator.ValidateAuditEventBeforeLogging(event); err != nil {
+		if err := al.validator.ValidateAuditEventBeforeLogging(event); err != nil {
 			al.failureLogger.Printf("Failed event validation before dead letter queue: ID=%s, Error=%v", event.ID, err)
 			continue
 		}
@@ -745,13 +900,23 @@ func (al *AuditLogger) writeBatch(events []*AuditEvent) {
 			return fmt.Errorf("failed to start transaction for audit batch: %w", err)
 		}
 
+		// Hash chain assignment is serialized by the chain lock held for this transaction
+		prevHash, err := lockAuditChain(context.Background(), tx)
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				al.failureLogger.Printf("Warning: rollback failed: %v", rbErr)
+			}
+			return err
+		}
+
 		stmt, err := tx.Prepare(`
 			INSERT INTO security_audit_log
 			(id, user_id, session_id, device_id, event_type, severity, result,
 			 resource, resource_id, resource_type, action, event_data, description,
 			 ip_address, user_agent, request_id, request_path, request_method,
-			 country, region, city, timestamp, duration_ms, compliance_flags, data_category)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+			 country, region, city, timestamp, duration_ms, compliance_flags, data_category,
+			 prev_hash, entry_hash, retention_days)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28)
 		`)
 		if err != nil {
 			if rbErr := tx.Rollback(); rbErr != nil {
@@ -769,6 +934,17 @@ func (al *AuditLogger) writeBatch(events []*AuditEvent) {
 				eventData, _ = json.Marshal(event.EventData)
 			}
 
+			entryHash, err := prepareChainedEvent(prevHash, event, eventData)
+			if err != nil {
+				if rbErr := tx.Rollback(); rbErr != nil {
+					log.Printf("Warning: tx.Rollback failed: %v", rbErr)
+				}
+				if clErr := stmt.Close(); clErr != nil {
+					log.Printf("Warning: stmt.Close failed: %v", clErr)
+				}
+				return err
+			}
+
 			// Use pq.Array for PostgreSQL array type
 			complianceFlags := pq.Array(event.ComplianceFlags)
 
@@ -781,6 +957,7 @@ func (al *AuditLogger) writeBatch(events []*AuditEvent) {
 				event.RequestPath, event.RequestMethod,
 				event.Country, event.Region, event.City,
 				event.Timestamp, event.Duration, complianceFlags, event.DataCategory,
+				prevHash, entryHash, retentionDaysArg(event),
 			)
 			if err != nil {
 				if rbErr := tx.Rollback(); rbErr != nil {
@@ -791,6 +968,7 @@ func (al *AuditLogger) writeBatch(events []*AuditEvent) {
 				}
 				return fmt.Errorf("failed to insert audit event %s: %w", event.ID, err)
 			}
+			prevHash = entryHash
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -817,33 +995,72 @@ func (al *AuditLogger) write(event *AuditEvent) error {
 			eventData, _ = json.Marshal(event.EventData)
 		}
 
-		// Use pq.Array for PostgreSQL array type
-		complianceFlags := pq.Array(event.ComplianceFlags)
-
-		_, err := al.db.Exec(`
-			INSERT INTO security_audit_log
-			(id, user_id, session_id, device_id, event_type, severity, result,
-			 resource, resource_id, resource_type, action, event_data, description,
-			 ip_address, user_agent, request_id, request_path, request_method,
-			 country, region, city, timestamp, duration_ms, compliance_flags, data_category)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
-		`, event.ID, event.UserID, event.SessionID, event.DeviceID,
-			event.EventType, event.Severity, event.Result,
-			event.Resource, event.ResourceID, event.ResourceType,
-			event.Action, eventData, event.Description,
-			event.IPAddress, event.UserAgent, event.RequestID,
-			event.RequestPath, event.RequestMethod,
-			event.Country, event.Region, event.City,
-			event.Timestamp, event.Duration, complianceFlags, event.DataCategory,
-		)
-
-		if err != nil {
+		if err := al.insertChained(context.Background(), event, eventData, false); err != nil {
 			return fmt.Errorf("failed to write audit log: %w", err)
 		}
 		return nil
 	})
 }
 
+// insertChained inserts one event as the next link in the hash chain.
+// With ignoreDuplicate, an event whose ID already exists is skipped.
+func (al *AuditLogger) insertChained(ctx context.Context, event *AuditEvent, eventData []byte, ignoreDuplicate bool) error {
+	tx, err := al.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: tx.Rollback failed: %v", err)
+		}
+	}()
+
+	prevHash, err := lockAuditChain(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := insertChainedTx(ctx, tx, prevHash, event, eventData, ignoreDuplicate); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertChainedTx inserts an event after prevHash, the chain head read under
+// the chain lock held by tx. Events without an IP (background jobs) store NULL.
+func insertChainedTx(ctx context.Context, tx *sql.Tx, prevHash string, event *AuditEvent, eventData []byte, ignoreDuplicate bool) error {
+	entryHash, err := prepareChainedEvent(prevHash, event, eventData)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO security_audit_log
+		(id, user_id, session_id, device_id, event_type, severity, result,
+		 resource, resource_id, resource_type, action, event_data, description,
+		 ip_address, user_agent, request_id, request_path, request_method,
+		 country, region, city, timestamp, duration_ms, compliance_flags, data_category,
+		 prev_hash, entry_hash, retention_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NULLIF($14, '')::inet, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28)`
+	if ignoreDuplicate {
+		query += `
+		ON CONFLICT (id) DO NOTHING`
+	}
+
+	_, err = tx.ExecContext(ctx, query,
+		event.ID, event.UserID, event.SessionID, event.DeviceID,
+		event.EventType, event.Severity, event.Result,
+		event.Resource, event.ResourceID, event.ResourceType,
+		event.Action, eventData, event.Description,
+		event.IPAddress, event.UserAgent, event.RequestID,
+		event.RequestPath, event.RequestMethod,
+		event.Country, event.Region, event.City,
+		event.Timestamp, event.Duration, pq.Array(event.ComplianceFlags), event.DataCategory,
+		prevHash, entryHash, retentionDaysArg(event),
+	)
+	return err
+}
+
 // getSeverityForEventType returns the default severity for an event type
 func getSeverityForEventType(eventType AuditEventType) AuditSeverity {
 	switch eventType {
@@ -1126,5 +1343,60 @@ func (al *AuditLogger) CheckSuspiciousActivity(ctx context.Context, userID uuid.
 		return true, "Logins from multiple new locations"
 	}
 
+	// Check for logins from a country not seen before the last 24 hours
+	newCountry, err := al.hasRecentNewCountryLogin(ctx, userID)
+	if err != nil {
+		log.Printf("Warning: failed to check new login countries: %v", err)
+	}
+	if newCountry {
+		return true, "Login from a new country"
+	}
+
 	return false, ""
 }
+
+// hasRecentNewCountryLogin reports whether a login in the last 24 hours came
+// from a country with no earlier logins. Users without earlier located
+// logins are not flagged.
+func (al *AuditLogger) hasRecentNewCountryLogin(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var newCountry bool
+	err := al.db.QueryRowContext(ctx, `
+		WITH previous AS (
+			SELECT DISTINCT country FROM security_audit_log
+			WHERE user_id = $1
+			AND event_type = 'login_success'
+			AND country IS NOT NULL AND country <> ''
+			AND created_at < NOW() - INTERVAL '24 hours'
+		)
+		SELECT EXISTS(SELECT 1 FROM previous) AND EXISTS(
+			SELECT 1 FROM security_audit_log
+			WHERE user_id = $1
+			AND event_type = 'login_success'
+			AND country IS NOT NULL AND country <> ''
+			AND created_at > NOW() - INTERVAL '24 hours'
+			AND country NOT IN (SELECT country FROM previous)
+		)
+	`, userID).Scan(&newCountry)
+	return newCountry, err
+}
+
+// IsNewLoginCountry reports whether country differs from every country the
+// user has logged in from before. The first located login is not "new".
+func (al *AuditLogger) IsNewLoginCountry(ctx context.Context, userID uuid.UUID, country string) (bool, error) {
+	if country == "" {
+		return false, nil
+	}
+
+	var seen, total int
+	err := al.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE country = $2), COUNT(*)
+		FROM security_audit_log
+		WHERE user_id = $1
+		AND event_type = 'login_success'
+		AND country IS NOT NULL AND country <> ''
+	`, userID, country).Scan(&seen, &total)
+	if err != nil {
+		return false, err
+	}
+	return total > 0 && seen == 0, nil
+}
@@ -0,0 +1,406 @@
+package security
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// auditChainLockKey is the Postgres advisory lock that serializes hash chain
+// extension across every writer (batch writer, overflow writes, dead-letter
+// reprocessing) and across chat server instances sharing the database.
+const auditChainLockKey int64 = 0x53524155444954 // "SRAUDIT"
+
+// auditChainRecord is the canonical, hashed form of an audit row. It contains
+// only values that round-trip exactly through Postgres so a verifier can
+// recompute the hash from the stored row.
+type auditChainRecord struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          *uuid.UUID `json:"user_id"`
+	SessionID       *uuid.UUID `json:"session_id"`
+	DeviceID        *uuid.UUID `json:"device_id"`
+	EventType       string     `json:"event_type"`
+	Severity        string     `json:"severity"`
+	Result          string     `json:"result"`
+	Resource        string     `json:"resource"`
+	ResourceID      string     `json:"resource_id"`
+	ResourceType    string     `json:"resource_type"`
+	Action          string     `json:"action"`
+	EventData       string     `json:"event_data"`
+	Description     string     `json:"description"`
+	IPAddress       string     `json:"ip_address"`
+	UserAgent       string     `json:"user_agent"`
+	RequestID       string     `json:"request_id"`
+	RequestPath     string     `json:"request_path"`
+	RequestMethod   string     `json:"request_method"`
+	Country         string     `json:"country"`
+	Region          string     `json:"region"`
+	City            string     `json:"city"`
+	Timestamp       string     `json:"timestamp"`
+	Duration        int64      `json:"duration_ms"`
+	ComplianceFlags []string   `json:"compliance_flags"`
+	DataCategory    string     `json:"data_category"`
+}
+
+// ChainVerificationResult reports the outcome of walking the audit hash chain.
+// Purged counts links of rows removed for retention; only their linkage can
+// be checked.
+type ChainVerificationResult struct {
+	Valid       bool      `json:"valid"`
+	Checked     int64     `json:"checked"`
+	Purged      int64     `json:"purged,omitempty"`
+	BrokenAtSeq int64     `json:"broken_at_seq,omitempty"`
+	BrokenAtID  uuid.UUID `json:"broken_at_id,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// lockAuditChain takes the chain lock for the lifetime of tx and returns the
+// current chain head ("" for an empty chain)
+func lockAuditChain(ctx context.Context, tx *sql.Tx) (string, error) {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLockKey); err != nil {
+		return "", fmt.Errorf("failed to lock audit hash chain: %w", err)
+	}
+
+	var head string
+	err := tx.QueryRowContext(ctx, `
+		SELECT entry_hash FROM security_audit_log
+		WHERE entry_hash IS NOT NULL
+		ORDER BY chain_seq DESC
+		LIMIT 1
+	`).Scan(&head)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to read audit hash chain head: %w", err)
+	}
+	return head, nil
+}
+
+// prepareChainedEvent normalizes an event to what Postgres will store and
+// returns its entry hash: SHA-256(prev_hash || canonical_event), hex encoded
+func prepareChainedEvent(prevHash string, event *AuditEvent, eventData []byte) (string, error) {
+	// Postgres stores microseconds; hash exactly what will be read back
+	event.Timestamp = event.Timestamp.UTC().Truncate(time.Microsecond)
+	return computeEntryHash(prevHash, event, eventData)
+}
+
+// computeEntryHash hashes the canonical record for an event
+func computeEntryHash(prevHash string, event *AuditEvent, eventData []byte) (string, error) {
+	canonicalData, err := canonicalJSON(eventData)
+	if err != nil {
+		return "", err
+	}
+
+	flags := event.ComplianceFlags
+	if len(flags) == 0 {
+		flags = nil
+	}
+
+	record := auditChainRecord{
+		ID:              event.ID,
+		UserID:          event.UserID,
+		SessionID:       event.SessionID,
+		DeviceID:        event.DeviceID,
+		EventType:       string(event.EventType),
+		Severity:        string(event.Severity),
+		Result:          string(event.Result),
+		Resource:        event.Resource,
+		ResourceID:      event.ResourceID,
+		ResourceType:    event.ResourceType,
+		Action:          event.Action,
+		EventData:       canonicalData,
+		Description:     event.Description,
+		IPAddress:       canonicalIP(event.IPAddress),
+		UserAgent:       event.UserAgent,
+		RequestID:       event.RequestID,
+		RequestPath:     event.RequestPath,
+		RequestMethod:   event.RequestMethod,
+		Country:         event.Country,
+		Region:          event.Region,
+		City:            event.City,
+		Timestamp:       event.Timestamp.UTC().Format(time.RFC3339Nano),
+		Duration:        event.Duration,
+		ComplianceFlags: flags,
+		DataCategory:    event.DataCategory,
+	}
+
+	serialized, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize audit event for hashing: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	h.Write(serialized)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalJSON re-encodes JSON so key order and whitespace match what JSONB returns
+func canonicalJSON(data []byte) (string, error) {
+	if len(data) == 0 {
+		return "null", nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", fmt.Errorf("failed to canonicalize event data: %w", err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("failed to canonicalize event data: %w", err)
+	}
+	return string(out), nil
+}
+
+// canonicalIP normalizes textual IP representation (INET output vs. input)
+func canonicalIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// VerifyChain walks the audit hash chain in insertion order, recomputing each
+// entry hash and checking each prev_hash link. It stops at and reports the
+// first broken link. The first row's prev_hash is taken as the anchor so that
+// retention purges of the oldest rows do not invalidate the chain; rows purged
+// from the middle are walked through their audit_chain_links entries, each of
+// which must be listed by a chained audit_purged event.
+func (al *AuditLogger) VerifyChain(ctx context.Context) (*ChainVerificationResult, error) {
+	// One snapshot, so a purge running meanwhile can't show half its work
+	tx, err := al.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: tx.Rollback failed: %v", err)
+		}
+	}()
+
+	listed, err := listedChainLinks(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read before the rows: a transaction can't stream two queries at once
+	links, err := readChainLinks(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT chain_seq, id, user_id, session_id, device_id, event_type,
+		       COALESCE(severity, ''), COALESCE(result, ''),
+		       COALESCE(resource, ''), COALESCE(resource_id, ''), COALESCE(resource_type, ''),
+		       COALESCE(action, ''), event_data, COALESCE(description, ''),
+		       COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), COALESCE(request_id, ''),
+		       COALESCE(request_path, ''), COALESCE(request_method, ''),
+		       COALESCE(country, ''), COALESCE(region, ''), COALESCE(city, ''),
+		       timestamp, COALESCE(duration_ms, 0), compliance_flags, COALESCE(data_category, ''),
+		       COALESCE(prev_hash, ''), entry_hash
+		FROM security_audit_log
+		WHERE entry_hash IS NOT NULL
+		ORDER BY chain_seq
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit hash chain: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	result := &ChainVerificationResult{Valid: true}
+	var expectedPrev string
+	first := true
+
+	// followLinks walks the purged links before seq, returning false if one
+	// breaks the chain
+	followLinks := func(before int64) bool {
+		for links.ok && links.seq < before {
+			if first {
+				expectedPrev = links.prevHash
+				first = false
+			}
+			if links.prevHash != expectedPrev {
+				result.Valid = false
+				result.BrokenAtSeq = links.seq
+				result.Reason = "purged entry's prev_hash does not match previous entry (entry missing or reordered)"
+				return false
+			}
+			if listed[links.seq] != links.entryHash {
+				result.Valid = false
+				result.BrokenAtSeq = links.seq
+				result.Reason = "purged entry is not listed by any audit_purged event (link inserted or modified)"
+				return false
+			}
+			result.Purged++
+			expectedPrev = links.entryHash
+			links.next()
+		}
+		return true
+	}
+
+	for rows.Next() {
+		var seq int64
+		var event AuditEvent
+		var eventType, severity, resultStr string
+		var eventData []byte
+		var prevHash, entryHash string
+		if err := rows.Scan(
+			&seq, &event.ID, &event.UserID, &event.SessionID, &event.DeviceID, &eventType,
+			&severity, &resultStr,
+			&event.Resource, &event.ResourceID, &event.ResourceType,
+			&event.Action, &eventData, &event.Description,
+			&event.IPAddress, &event.UserAgent, &event.RequestID,
+			&event.RequestPath, &event.RequestMethod,
+			&event.Country, &event.Region, &event.City,
+			&event.Timestamp, &event.Duration, pq.Array(&event.ComplianceFlags), &event.DataCategory,
+			&prevHash, &entryHash,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit chain entry: %w", err)
+		}
+		event.EventType = AuditEventType(eventType)
+		event.Severity = AuditSeverity(severity)
+		event.Result = AuditResult(resultStr)
+
+		if !followLinks(seq) {
+			break
+		}
+		if first {
+			expectedPrev = prevHash
+			first = false
+		}
+
+		if prevHash != expectedPrev {
+			result.Valid = false
+			result.BrokenAtSeq = seq
+			result.BrokenAtID = event.ID
+			result.Reason = "prev_hash does not match previous entry (entry missing or reordered)"
+			break
+		}
+
+		recomputed, err := computeEntryHash(prevHash, &event, eventData)
+		if err != nil || recomputed != entryHash {
+			result.Valid = false
+			result.BrokenAtSeq = seq
+			result.BrokenAtID = event.ID
+			result.Reason = "entry_hash does not match entry contents (entry modified)"
+			break
+		}
+
+		result.Checked++
+		expectedPrev = entryHash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit hash chain: %w", err)
+	}
+	if result.Valid {
+		followLinks(math.MaxInt64)
+	}
+
+	if !result.Valid {
+		log.Printf("SECURITY: Audit hash chain broken at seq=%d id=%s: %s", result.BrokenAtSeq, result.BrokenAtID, result.Reason)
+	}
+	return result, nil
+}
+
+// listedChainLinks returns the chain_seq -> entry_hash of every purged link
+// listed by an audit_purged event. Those events are chained, so the walk
+// checks the lists themselves.
+func listedChainLinks(ctx context.Context, tx *sql.Tx) (map[int64]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT event_data->'links' FROM security_audit_log
+		WHERE event_type = $1 AND entry_hash IS NOT NULL`, AuditEventAuditPurged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit purge events: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	listed := make(map[int64]string)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan audit purge event: %w", err)
+		}
+		var links map[string]string
+		if err := json.Unmarshal(data, &links); err != nil {
+			// Leaves its links unlisted, which the walk reports
+			continue
+		}
+		for seq, entryHash := range links {
+			if n, err := strconv.ParseInt(seq, 10, 64); err == nil {
+				listed[n] = entryHash
+			}
+		}
+	}
+	return listed, rows.Err()
+}
+
+// chainLinkCursor walks audit_chain_links in chain order
+type chainLinkCursor struct {
+	links []chainLink
+	ok    bool // a link is loaded
+
+	chainLink
+}
+
+type chainLink struct {
+	seq       int64
+	prevHash  string
+	entryHash string
+}
+
+// readChainLinks reads every audit_chain_links row and loads the first
+func readChainLinks(ctx context.Context, tx *sql.Tx) (*chainLinkCursor, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT chain_seq, COALESCE(prev_hash, ''), entry_hash
+		FROM audit_chain_links
+		ORDER BY chain_seq
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit chain links: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	c := &chainLinkCursor{}
+	for rows.Next() {
+		var link chainLink
+		if err := rows.Scan(&link.seq, &link.prevHash, &link.entryHash); err != nil {
+			return nil, fmt.Errorf("failed to read audit chain links: %w", err)
+		}
+		c.links = append(c.links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit chain links: %w", err)
+	}
+	c.next()
+	return c, nil
+}
+
+// next loads the following link; ok is false at the end
+func (c *chainLinkCursor) next() {
+	c.ok = len(c.links) > 0
+	if c.ok {
+		c.chainLink, c.links = c.links[0], c.links[1:]
+	}
+}
@@ -0,0 +1,201 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+)
+
+// DeadLetterReprocessResult summarizes a dead-letter reprocessing run
+type DeadLetterReprocessResult struct {
+	Attempted   int   `json:"attempted"`
+	Reprocessed int   `json:"reprocessed"`
+	Failed      int   `json:"failed"`
+	Pending     int64 `json:"pending"` // Events still awaiting reprocessing after this run
+}
+
+// errNoAuditDatabase is returned when the logger was created without a database
+var errNoAuditDatabase = errors.New("audit logger has no database")
+
+// persistDeadLetters writes events to audit_dead_letter, returning the events
+// that could not be persisted yet. Persisting stops at the first failure since
+// the database is most likely still unavailable.
+func (al *AuditLogger) persistDeadLetters(events []*AuditEvent) []*AuditEvent {
+	var remaining []*AuditEvent
+	for i, event := range events {
+		if err := al.persistDeadLetter(event); err != nil {
+			al.failureLogger.Printf("Failed to persist dead-lettered audit event: ID=%s, Type=%s, Error=%v",
+				event.ID, event.EventType, err)
+			remaining = append(remaining, events[i:]...)
+			break
+		}
+	}
+
+	// Bound in-memory retention to the dead letter channel capacity; beyond that
+	// the oldest events only survive in the failure (and emergency) logs
+	if limit := cap(al.deadLetterChan); len(remaining) > limit {
+		for _, event := range remaining[:len(remaining)-limit] {
+			al.failureLogger.Printf("Dead letter backlog full, dropping failed event: ID=%s, Type=%s",
+				event.ID, event.EventType)
+			metrics.AuditDroppedEventsTotal.Inc()
+			if event.Severity == AuditSeverityCritical {
+				al.writeCriticalEventToEmergencyLog(event, errors.New("dead letter backlog full"))
+			}
+		}
+		remaining = remaining[len(remaining)-limit:]
+	}
+
+	al.refreshDeadLetterPending(context.Background())
+	return remaining
+}
+
+// persistDeadLetter stores a single failed event for later reprocessing
+func (al *AuditLogger) persistDeadLetter(event *AuditEvent) error {
+	if al.db == nil {
+		return errNoAuditDatabase
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-lettered event: %w", err)
+	}
+
+	var lastError string
+	if msg, ok := event.EventData["audit_error_message"].(string); ok {
+		lastError = msg
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = al.db.ExecContext(ctx, `
+		INSERT INTO audit_dead_letter (event_id, event_type, severity, event, last_error)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_id) DO NOTHING
+	`, event.ID, event.EventType, event.Severity, data, lastError)
+	if err != nil {
+		return fmt.Errorf("failed to insert dead-lettered event: %w", err)
+	}
+	return nil
+}
+
+// ReprocessDeadLetters attempts to re-insert up to limit pending dead-lettered
+// events into security_audit_log, oldest first. Inserts are idempotent, so an
+// event whose original write actually committed is simply marked reprocessed.
+func (al *AuditLogger) ReprocessDeadLetters(ctx context.Context, limit int) (*DeadLetterReprocessResult, error) {
+	if al.db == nil {
+		return nil, errNoAuditDatabase
+	}
+
+	rows, err := al.db.QueryContext(ctx, `
+		SELECT id, event FROM audit_dead_letter
+		WHERE reprocessed_at IS NULL
+		ORDER BY created_at
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dead-lettered events: %w", err)
+	}
+
+	type deadLetter struct {
+		id    int64
+		event AuditEvent
+	}
+	var letters []deadLetter
+	for rows.Next() {
+		var dl deadLetter
+		var data []byte
+		if err := rows.Scan(&dl.id, &data); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan dead-lettered event: %w", err)
+		}
+		if err := json.Unmarshal(data, &dl.event); err != nil {
+			log.Printf("Warning: skipping undecodable dead-lettered audit event %d: %v", dl.id, err)
+			continue
+		}
+		letters = append(letters, dl)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, fmt.Errorf("failed to iterate dead-lettered events: %w", err)
+	}
+	if err := rows.Close(); err != nil {
+		log.Printf("Warning: failed to close rows: %v", err)
+	}
+
+	result := &DeadLetterReprocessResult{Attempted: len(letters)}
+	for i := range letters {
+		dl := &letters[i]
+		if err := al.insertAuditEvent(ctx, &dl.event); err != nil {
+			result.Failed++
+			if _, updErr := al.db.ExecContext(ctx, `
+				UPDATE audit_dead_letter
+				SET attempts = attempts + 1, last_attempt_at = NOW(), last_error = $2
+				WHERE id = $1
+			`, dl.id, err.Error()); updErr != nil {
+				log.Printf("Warning: failed to record dead letter attempt for %s: %v", dl.event.ID, updErr)
+			}
+			continue
+		}
+
+		result.Reprocessed++
+		if _, err := al.db.ExecContext(ctx, `
+			UPDATE audit_dead_letter
+			SET attempts = attempts + 1, last_attempt_at = NOW(), reprocessed_at = NOW()
+			WHERE id = $1
+		`, dl.id); err != nil {
+			log.Printf("Warning: failed to mark dead letter %s reprocessed: %v", dl.event.ID, err)
+		}
+	}
+
+	result.Pending, err = al.DeadLetterPendingCount(ctx)
+	if err != nil {
+		return result, err
+	}
+	metrics.AuditDeadLetterPending.Set(float64(result.Pending))
+
+	log.Printf("[AUDIT_DEAD_LETTER] Reprocessed %d/%d dead-lettered events (%d failed, %d pending)",
+		result.Reprocessed, result.Attempted, result.Failed, result.Pending)
+	return result, nil
+}
+
+// DeadLetterPendingCount returns the number of dead-lettered events awaiting reprocessing
+func (al *AuditLogger) DeadLetterPendingCount(ctx context.Context) (int64, error) {
+	if al.db == nil {
+		return 0, errNoAuditDatabase
+	}
+
+	var count int64
+	err := al.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM audit_dead_letter WHERE reprocessed_at IS NULL`,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count dead-lettered events: %w", err)
+	}
+	return count, nil
+}
+
+// refreshDeadLetterPending updates the pending dead-letter gauge
+func (al *AuditLogger) refreshDeadLetterPending(ctx context.Context) {
+	count, err := al.DeadLetterPendingCount(ctx)
+	if err != nil {
+		return
+	}
+	metrics.AuditDeadLetterPending.Set(float64(count))
+}
+
+// insertAuditEvent writes one event to security_audit_log without the retry
+// and dead-letter handling of write. Duplicate event IDs are ignored.
+func (al *AuditLogger) insertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	eventData, _ := json.Marshal(event.EventData)
+
+	if err := al.insertChained(ctx, event, eventData, true); err != nil {
+		return fmt.Errorf("failed to reinsert audit event %s: %w", event.ID, err)
+	}
+	return nil
+}
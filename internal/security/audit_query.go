@@ -0,0 +1,218 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 500
+)
+
+var (
+	// ErrInvalidAuditCursor is returned when a pagination cursor cannot be decoded
+	ErrInvalidAuditCursor = errors.New("invalid audit cursor")
+	// ErrInvalidAuditFilter is returned when a filter value is malformed
+	ErrInvalidAuditFilter = errors.New("invalid audit filter")
+)
+
+// AuditFilter describes an audit log query. Zero values mean "no filter".
+// Results are ordered newest first and paginated with an opaque keyset cursor.
+type AuditFilter struct {
+	UserID     *uuid.UUID
+	EventTypes []AuditEventType
+	Severities []AuditSeverity
+	Results    []AuditResult
+	IPAddress  string
+	From       time.Time // inclusive
+	To         time.Time // exclusive
+	Cursor     string    // NextCursor from the previous page
+	Limit      int
+}
+
+// AuditEventPage is one page of audit query results
+type AuditEventPage struct {
+	Events     []*AuditEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// QueryEvents returns audit events matching the filter.
+//
+// Pagination is keyset-based on (created_at, id) so deep pages stay cheap, and
+// the user/event_type/severity predicates line up with idx_audit_user,
+// idx_audit_type and idx_audit_severity. All inputs are bound parameters.
+func (al *AuditLogger) QueryEvents(ctx context.Context, filter AuditFilter) (*AuditEventPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditQueryLimit
+	}
+	if limit > maxAuditQueryLimit {
+		limit = maxAuditQueryLimit
+	}
+
+	var conditions []string
+	var args []any
+	addCondition := func(clause string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	if filter.UserID != nil {
+		addCondition("user_id = $%d", *filter.UserID)
+	}
+	if len(filter.EventTypes) > 0 {
+		types := make([]string, len(filter.EventTypes))
+		for i, t := range filter.EventTypes {
+			types[i] = string(t)
+		}
+		addCondition("event_type = ANY($%d)", pq.Array(types))
+	}
+	if len(filter.Severities) > 0 {
+		severities := make([]string, len(filter.Severities))
+		for i, s := range filter.Severities {
+			severities[i] = string(s)
+		}
+		addCondition("severity = ANY($%d)", pq.Array(severities))
+	}
+	if len(filter.Results) > 0 {
+		results := make([]string, len(filter.Results))
+		for i, r := range filter.Results {
+			results[i] = string(r)
+		}
+		addCondition("result = ANY($%d)", pq.Array(results))
+	}
+	if filter.IPAddress != "" {
+		if net.ParseIP(filter.IPAddress) == nil {
+			return nil, fmt.Errorf("%w: invalid IP address %q", ErrInvalidAuditFilter, filter.IPAddress)
+		}
+		addCondition("ip_address = $%d::inet", filter.IPAddress)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at < $%d", filter.To)
+	}
+	if filter.Cursor != "" {
+		cursorTime, cursorID, err := decodeAuditCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, cursorTime, cursorID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT id, user_id, session_id, device_id, event_type, severity, result,
+		       COALESCE(resource, ''), COALESCE(resource_id, ''), COALESCE(resource_type, ''),
+		       COALESCE(action, ''), event_data, COALESCE(description, ''),
+		       COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), COALESCE(request_id, ''),
+		       COALESCE(request_path, ''), COALESCE(request_method, ''),
+		       COALESCE(country, ''), COALESCE(region, ''), COALESCE(city, ''),
+		       timestamp, created_at, COALESCE(duration_ms, 0), compliance_flags, COALESCE(data_category, '')
+		FROM security_audit_log`
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, " AND ")
+	}
+	// Fetch one extra row to know whether there is a next page
+	args = append(args, limit+1)
+	query += fmt.Sprintf("\n\t\tORDER BY created_at DESC, id DESC\n\t\tLIMIT $%d", len(args))
+
+	rows, err := al.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Warning: failed to close rows: %v", err)
+		}
+	}()
+
+	page := &AuditEventPage{Events: make([]*AuditEvent, 0, limit)}
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		if len(page.Events) == limit {
+			last := page.Events[len(page.Events)-1]
+			page.NextCursor = encodeAuditCursor(lastCreatedAt, last.ID)
+			break
+		}
+
+		event := &AuditEvent{}
+		var eventData []byte
+		var timestamp sql.NullTime
+		var severity, result sql.NullString
+		var duration int64
+		if err := rows.Scan(
+			&event.ID, &event.UserID, &event.SessionID, &event.DeviceID,
+			&event.EventType, &severity, &result,
+			&event.Resource, &event.ResourceID, &event.ResourceType,
+			&event.Action, &eventData, &event.Description,
+			&event.IPAddress, &event.UserAgent, &event.RequestID,
+			&event.RequestPath, &event.RequestMethod,
+			&event.Country, &event.Region, &event.City,
+			&timestamp, &lastCreatedAt, &duration,
+			pq.Array(&event.ComplianceFlags), &event.DataCategory,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+
+		event.Severity = AuditSeverity(severity.String)
+		event.Result = AuditResult(result.String)
+		event.Duration = duration
+		event.Timestamp = lastCreatedAt
+		if timestamp.Valid {
+			event.Timestamp = timestamp.Time
+		}
+		if len(eventData) > 0 {
+			if err := json.Unmarshal(eventData, &event.EventData); err != nil {
+				log.Printf("Warning: failed to unmarshal event data: %v", err)
+			}
+		}
+
+		page.Events = append(page.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate audit events: %w", err)
+	}
+
+	return page, nil
+}
+
+// encodeAuditCursor builds an opaque keyset cursor from the last row of a page
+func encodeAuditCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeAuditCursor parses a cursor produced by encodeAuditCursor
+func decodeAuditCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidAuditCursor
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, uuid.Nil, ErrInvalidAuditCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidAuditCursor
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidAuditCursor
+	}
+	return createdAt, id, nil
+}
@@ -0,0 +1,228 @@
+package security
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/config"
+	"github.com/lib/pq"
+)
+
+// auditRetentionBatchSize bounds each purge transaction. The chain lock is
+// held for the duration, so audit writes wait on it.
+const auditRetentionBatchSize = 1000
+
+// AuditArchiver receives a purge batch, as one JSON object per row, before it
+// is deleted. An error aborts the batch and nothing is deleted.
+type AuditArchiver func(ctx context.Context, firstSeq, lastSeq int64, rows []json.RawMessage) error
+
+// retentionDaysArg is the retention_days column value for an event: NULL
+// unless the event set its own retention
+func retentionDaysArg(event *AuditEvent) any {
+	if event.RetentionDays > 0 {
+		return event.RetentionDays
+	}
+	return nil
+}
+
+// PurgeExpiredAuditEvents deletes audit rows past their retention, oldest
+// first, and returns how many were deleted. A row is kept for its own
+// retention_days, or policy.DefaultDays if it has none; high and critical
+// rows are kept at least policy.HighDays and policy.CriticalDays. The newest
+// row is never purged, since it is the chain head new events link to.
+//
+// Purged rows leave their chain_seq, prev_hash and entry_hash in
+// audit_chain_links, so VerifyChain still checks the links across the gap;
+// links older than the oldest remaining row are dropped. Each batch appends
+// an audit_purged event to the chain listing the links it left, which is
+// what VerifyChain trusts them on. If archive is set each batch is handed to
+// it before being deleted.
+func PurgeExpiredAuditEvents(ctx context.Context, db *sql.DB, policy config.AuditRetention, archive AuditArchiver) (int, error) {
+	if policy.DefaultDays <= 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	total := 0
+	for ctx.Err() == nil {
+		deleted, err := purgeAuditBatch(ctx, db, policy, archive, now)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if deleted < auditRetentionBatchSize {
+			break
+		}
+	}
+	return total, nil
+}
+
+// purgeAuditBatch deletes up to auditRetentionBatchSize expired rows in one
+// transaction. Returns how many were deleted.
+func purgeAuditBatch(ctx context.Context, db *sql.DB, policy config.AuditRetention, archive AuditArchiver, now time.Time) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Warning: failed to rollback: %v", err)
+		}
+	}()
+
+	// Hold the chain lock so the head can't move while links are recorded
+	head, err := lockAuditChain(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT chain_seq, row_to_json(a) FROM security_audit_log a
+		WHERE created_at < $1::timestamptz - make_interval(days => CASE severity
+				WHEN 'critical' THEN GREATEST(COALESCE(retention_days, $2), $4)
+				WHEN 'high' THEN GREATEST(COALESCE(retention_days, $2), $3)
+				ELSE COALESCE(retention_days, $2)
+			END)
+		  AND chain_seq < (SELECT MAX(chain_seq) FROM security_audit_log WHERE entry_hash IS NOT NULL)
+		ORDER BY chain_seq
+		LIMIT $5`, now, policy.DefaultDays, policy.HighDays, policy.CriticalDays, auditRetentionBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select expired audit events: %w", err)
+	}
+	var seqs []int64
+	var archived []json.RawMessage
+	for rows.Next() {
+		var seq int64
+		var row []byte
+		if err := rows.Scan(&seq, &row); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		seqs = append(seqs, seq)
+		if archive != nil {
+			archived = append(archived, row)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(seqs) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(ctx, seqs[0], seqs[len(seqs)-1], archived); err != nil {
+			return 0, fmt.Errorf("failed to archive audit events: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		WITH purged AS (
+			DELETE FROM security_audit_log WHERE chain_seq = ANY($1::bigint[])
+			RETURNING chain_seq, prev_hash, entry_hash
+		)
+		INSERT INTO audit_chain_links (chain_seq, prev_hash, entry_hash)
+		SELECT chain_seq, prev_hash, entry_hash FROM purged WHERE entry_hash IS NOT NULL`,
+		pq.Array(seqs)); err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	// Links before the oldest remaining row aren't needed: VerifyChain anchors
+	// on the first entry it sees
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM audit_chain_links
+		WHERE chain_seq < (SELECT MIN(chain_seq) FROM security_audit_log WHERE entry_hash IS NOT NULL)`); err != nil {
+		return 0, fmt.Errorf("failed to trim audit chain links: %w", err)
+	}
+
+	if err := recordAuditPurge(ctx, tx, head, seqs); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(seqs), nil
+}
+
+// recordAuditPurge appends an audit_purged event after head listing the
+// purged seq ranges and the links it vouches for: those just left by the
+// batch, and those listed by purge events the batch removed
+func recordAuditPurge(ctx context.Context, tx *sql.Tx, head string, seqs []int64) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT chain_seq, entry_hash FROM audit_chain_links
+		WHERE listed_by IS NULL OR listed_by = ANY($1::bigint[])
+		ORDER BY chain_seq`, pq.Array(seqs))
+	if err != nil {
+		return fmt.Errorf("failed to read unlisted audit chain links: %w", err)
+	}
+	links := make(map[string]string)
+	var linkSeqs []int64
+	for rows.Next() {
+		var seq int64
+		var entryHash string
+		if err := rows.Scan(&seq, &entryHash); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		links[strconv.FormatInt(seq, 10)] = entryHash
+		linkSeqs = append(linkSeqs, seq)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	eventData, err := json.Marshal(map[string]any{
+		"ranges": seqRanges(seqs),
+		"links":  links,
+	})
+	if err != nil {
+		return err
+	}
+	event := &AuditEvent{
+		ID:          uuid.New(),
+		EventType:   AuditEventAuditPurged,
+		Severity:    AuditSeverityHigh,
+		Result:      AuditResultSuccess,
+		Resource:    "security_audit_log",
+		Action:      "purge",
+		Description: fmt.Sprintf("Purged %d audit events past retention", len(seqs)),
+		Timestamp:   time.Now(),
+	}
+	if err := insertChainedTx(ctx, tx, head, event, eventData, false); err != nil {
+		return fmt.Errorf("failed to record audit purge: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE audit_chain_links
+		SET listed_by = (SELECT chain_seq FROM security_audit_log WHERE id = $1)
+		WHERE chain_seq = ANY($2::bigint[])`, event.ID, pq.Array(linkSeqs)); err != nil {
+		return fmt.Errorf("failed to record audit purge: %w", err)
+	}
+	return nil
+}
+
+// seqRanges collapses sorted seqs into inclusive [first, last] runs
+func seqRanges(seqs []int64) [][2]int64 {
+	var ranges [][2]int64
+	for _, seq := range seqs {
+		if n := len(ranges); n > 0 && ranges[n-1][1] == seq-1 {
+			ranges[n-1][1] = seq
+			continue
+		}
+		ranges = append(ranges, [2]int64{seq, seq})
+	}
+	return ranges
+}
@@ -23,15 +23,15 @@ type ComprehensiveAuditValidator struct {
 
 // ComprehensiveValidationMetrics tracks validation performance and failures
 type ComprehensiveValidationMetrics struct {
-	TotalValidations         int
-	ValidationFailures       int
-	CriticalEventBypasses    int
-	ConfigurationValidations int
-	EventValidations         int
-	DatabaseValidations      int
-	PathValidations          int
-	SeverityValidations      int
-	EventTypeValidations     int
+	TotalValidations         int `json:"total_validations"`
+	ValidationFailures       int `json:"validation_failures"`
+	CriticalEventBypasses    int `json:"critical_event_bypasses"`
+	ConfigurationValidations int `json:"configuration_validations"`
+	EventValidations         int `json:"event_validations"`
+	DatabaseValidations      int `json:"database_validations"`
+	PathValidations          int `json:"path_validations"`
+	SeverityValidations      int `json:"severity_validations"`
+	EventTypeValidations     int `json:"event_type_validations"`
 }
 
 // NewComprehensiveAuditValidator creates a new comprehensive validator
@@ -1408,21 +1408,28 @@ func (v *ComprehensiveAuditValidator) ValidateAuditEventBeforeLogging(event *Aud
 }
 
 // ValidateAuditSystemHealth performs comprehensive health check of audit system
+// and publishes queue and dead letter depths as Prometheus gauges
 func (v *ComprehensiveAuditValidator) ValidateAuditSystemHealth() error {
+	if v.auditLogger == nil {
+		return fmt.Errorf("no audit logger to check")
+	}
+
 	// Check queue health
 	queueLength := len(v.auditLogger.queue)
+	metrics.AuditQueueDepth.Set(float64(queueLength))
 	if queueLength > v.auditLogger.config.QueueSize/2 {
 		log.Printf("[AUDIT_HEALTH_WARNING] High queue length: %d/%d", queueLength, v.auditLogger.config.QueueSize)
 	}
 
 	// Check dead letter queue health
 	deadLetterLength := len(v.auditLogger.deadLetterChan)
+	metrics.AuditDeadLetterDepth.Set(float64(deadLetterLength))
 	if deadLetterLength > 500 {
 		log.Printf("[AUDIT_HEALTH_WARNING] High dead letter queue length: %d", deadLetterLength)
 	}
 
-	// Check metrics for anomalies - note: counters don't have Value() method in this context
-	// This would need to be implemented differently for actual monitoring
+	// Overflow and validation failure counters are incremented at the source
+	// (Log and logValidationFailure), so alerting can use rate() on them directly
 
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 	"net"
 	"net/http"
 	"regexp"
@@ -302,29 +303,105 @@ func (fp *DeviceFingerprint) MatchesFuzzy(other *DeviceFingerprint) bool {
 	return true
 }
 
-// GetRealIP extracts the real client IP from request
+// DefaultTrustedProxyCIDRs are the networks whose forwarding headers are
+// honored unless SetTrustedProxies is called: loopback and private ranges,
+// where load balancers and ingress controllers normally sit
+var DefaultTrustedProxyCIDRs = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+}
+
+var (
+	trustedProxies   = mustParseCIDRs(DefaultTrustedProxyCIDRs)
+	trustedProxiesMu sync.RWMutex
+)
+
+// SetTrustedProxies replaces the networks allowed to set X-Forwarded-For and
+// X-Real-IP. Requests from other peers are identified by their TCP address.
+func SetTrustedProxies(cidrs []string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxiesMu.Lock()
+	trustedProxies = nets
+	trustedProxiesMu.Unlock()
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// isTrustedProxy checks if ip belongs to a trusted proxy network
+func isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRealIP extracts the real client IP from request. Forwarding headers are
+// only honored when the TCP peer is a trusted proxy; otherwise they could be
+// spoofed by anyone reaching the server directly.
 func GetRealIP(r *http.Request) string {
-	// Check X-Forwarded-For (from load balancer)
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(peer)) {
+		return peer
+	}
+
+	// Check X-Forwarded-For (from load balancer). Walk from the nearest hop
+	// back and return the first address that isn't one of our proxies.
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				break
+			}
+			if !isTrustedProxy(ip) || i == 0 {
+				return hop
+			}
 		}
 	}
 
 	// Check X-Real-IP
-	xri := r.Header.Get("X-Real-IP")
-	if xri != "" {
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
 		return xri
 	}
 
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	return peer
 }
 
 // ============================================
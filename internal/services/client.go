@@ -0,0 +1,192 @@
+// Package services calls the presence and group services from chat servers.
+// Instances are discovered through Consul; calls are authenticated with the
+// internal service token and guarded by a circuit breaker so callers can
+// fall back to direct Redis/Postgres access.
+//
+// Calls are made from the hub's delivery path, so each gets one attempt
+// within a short deadline and no retries: falling back to direct access is
+// quicker than waiting on a second instance.
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/breaker"
+	"github.com/jaydenbeard/messaging-app/internal/middleware"
+	"github.com/jaydenbeard/messaging-app/internal/registry"
+)
+
+// ErrNoInstances is returned when Consul knows no healthy instance of a service
+var ErrNoInstances = errors.New("no healthy service instances")
+
+const (
+	// discoveryTTL is how long a service's instance list is reused before
+	// Consul is asked again. Once there is a list, it is refreshed in the
+	// background so no call waits on Consul.
+	discoveryTTL = 30 * time.Second
+
+	// callTimeout bounds a whole call. Successive calls rotate through the
+	// instances, so one slow instance only costs the calls sent to it.
+	callTimeout = 250 * time.Millisecond
+)
+
+// statusError is a non-2xx response. 5xx responses count as failures.
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.code, e.body)
+}
+
+// Client calls one internal service over HTTP
+type Client struct {
+	service  string
+	registry *registry.ConsulRegistry
+	token    string
+	http     *http.Client
+	breaker  *breaker.Breaker
+
+	mu         sync.Mutex
+	addrs      []string
+	refreshed  time.Time
+	refreshing bool
+	next       atomic.Uint32
+}
+
+// NewClient creates a client for the named Consul service
+func NewClient(reg *registry.ConsulRegistry, service, token string) *Client {
+	return &Client{
+		service:  service,
+		registry: reg,
+		token:    token,
+		http:     &http.Client{Timeout: callTimeout},
+		breaker:  breaker.New(service, breaker.DefaultFailureThreshold, breaker.DefaultCooldown),
+	}
+}
+
+// instances returns the service's healthy instances. Only the first call
+// waits on Consul; after that a stale list is returned while a background
+// refresh runs, and kept if Consul fails.
+func (c *Client) instances() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.addrs) > 0 {
+		if time.Since(c.refreshed) >= discoveryTTL && !c.refreshing {
+			c.refreshing = true
+			go c.refresh()
+		}
+		return c.addrs, nil
+	}
+
+	addrs, err := c.registry.GetServiceAddresses(c.service)
+	if err != nil {
+		return nil, fmt.Errorf("discover %s: %w", c.service, err)
+	}
+	c.addrs, c.refreshed = addrs, time.Now()
+	if len(addrs) == 0 {
+		return nil, ErrNoInstances
+	}
+	return addrs, nil
+}
+
+// refresh replaces the cached instance list from Consul
+func (c *Client) refresh() {
+	addrs, err := c.registry.GetServiceAddresses(c.service)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		log.Printf("Warning: %s discovery failed, using last known instances: %v", c.service, err)
+		return
+	}
+	// An empty list is kept too, so calls fail fast and fall back
+	c.addrs, c.refreshed = addrs, time.Now()
+}
+
+// do sends a request to the next instance of the service and decodes the
+// JSON response into out, all within callTimeout. Network errors, timeouts
+// and 5xx responses count against the breaker.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	if err := c.breaker.Allow(); err != nil {
+		return fmt.Errorf("%s: %w", c.service, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	err := c.attempt(ctx, method, path, body, out)
+	var status *statusError
+	if err != nil && (!errors.As(err, &status) || status.code >= 500) {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+	return err
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, body, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	addrs, err := c.instances()
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return ErrNoInstances
+	}
+	addr := addrs[int(c.next.Add(1)-1)%len(addrs)]
+
+	if err := c.send(ctx, method, "http://"+addr+path, payload, out); err != nil {
+		return fmt.Errorf("%s: %w", c.service, err)
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, url string, payload []byte, out any) error {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(middleware.InternalTokenHeader, c.token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return &statusError{code: resp.StatusCode, body: string(bytes.TrimSpace(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
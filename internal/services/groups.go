@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/registry"
+)
+
+// GroupClient calls the group service (cmd/groupservice)
+type GroupClient struct {
+	client *Client
+}
+
+// NewGroupClient creates a group service client
+func NewGroupClient(reg *registry.ConsulRegistry, token string) *GroupClient {
+	return &GroupClient{client: NewClient(reg, registry.GroupService, token)}
+}
+
+// GetGroupMemberIDs returns the user IDs of a group's members
+func (g *GroupClient) GetGroupMemberIDs(ctx context.Context, groupID uuid.UUID) ([]uuid.UUID, error) {
+	var resp struct {
+		UserIDs []uuid.UUID `json:"user_ids"`
+	}
+	if err := g.client.do(ctx, http.MethodGet, "/groups/"+groupID.String()+"/members", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.UserIDs, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/registry"
+)
+
+// PresenceClient calls the presence service (cmd/presence)
+type PresenceClient struct {
+	client *Client
+}
+
+// NewPresenceClient creates a presence service client
+func NewPresenceClient(reg *registry.ConsulRegistry, token string) *PresenceClient {
+	return &PresenceClient{client: NewClient(reg, registry.PresenceService, token)}
+}
+
+// MaxPresenceBatch is the most users the presence service looks up in one
+// request; GetBatchPresence splits larger batches
+const MaxPresenceBatch = 1000
+
+// GetBatchPresence reports which of the users are online
+func (p *PresenceClient) GetBatchPresence(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	result := make(map[uuid.UUID]bool, len(userIDs))
+	for start := 0; start < len(userIDs); start += MaxPresenceBatch {
+		batch := userIDs[start:min(start+MaxPresenceBatch, len(userIDs))]
+		ids := make([]string, len(batch))
+		for i, userID := range batch {
+			ids[i] = userID.String()
+		}
+
+		var resp []struct {
+			UserID   string `json:"user_id"`
+			IsOnline bool   `json:"is_online"`
+		}
+		if err := p.client.do(ctx, http.MethodPost, "/presence/batch", map[string][]string{"user_ids": ids}, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, userID := range batch {
+			result[userID] = false
+		}
+		for _, presence := range resp {
+			if userID, err := uuid.Parse(presence.UserID); err == nil {
+				result[userID] = presence.IsOnline
+			}
+		}
+	}
+	return result, nil
+}
@@ -259,6 +259,11 @@ func (c *ClickSendService) SendVerificationCode(phoneNumber, code string) error
 	return c.SendSMS(phoneNumber, message)
 }
 
+// Name returns "clicksend"
+func (c *ClickSendService) Name() string {
+	return "clicksend"
+}
+
 // getBasicAuth creates the basic authentication header value
 func (c *ClickSendService) getBasicAuth() string {
 	auth := c.username + ":" + c.apiKey
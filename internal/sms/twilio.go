@@ -0,0 +1,143 @@
+package sms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const twilioAPIBase = "https://api.twilio.com/2010-04-01/Accounts/"
+
+// TwilioService handles SMS sending via the Twilio Messages API
+type TwilioService struct {
+	accountSID string
+	authToken  string
+	from       string
+	client     *http.Client
+	logger     *log.Logger
+}
+
+// twilioError is the error body returned by the Twilio API
+type twilioError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewTwilioService creates a new Twilio SMS service
+func NewTwilioService() (*TwilioService, error) {
+	accountSID := os.Getenv("TWILIO_ACCOUNT_SID")
+	authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+	from := os.Getenv("TWILIO_FROM")
+
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, fmt.Errorf("Twilio credentials not configured: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM required")
+	}
+
+	return &TwilioService{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: log.New(os.Stdout, "[TWILIO] ", log.Ldate|log.Ltime|log.LUTC),
+	}, nil
+}
+
+// SendSMS sends an SMS message to the specified phone number, retrying
+// transient failures like ClickSendService does
+func (t *TwilioService) SendSMS(to, message string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", t.from)
+	form.Set("Body", message)
+	endpoint := twilioAPIBase + t.accountSID + "/Messages.json"
+
+	var lastErr error
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := baseDelay * time.Duration(1<<uint(attempt-1))
+			t.logger.Printf("Retrying SMS send in %v (attempt %d/%d)", delay, attempt+1, maxRetries)
+			time.Sleep(delay)
+		}
+
+		req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(t.accountSID, t.authToken)
+
+		resp, err := t.client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to send SMS: %w", err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			log.Printf("Warning: failed to close response body: %v", closeErr)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("failed to read response: %w", err)
+			continue
+		}
+
+		if resp.StatusCode == http.StatusCreated {
+			return nil
+		}
+
+		var apiErr twilioError
+		_ = json.Unmarshal(body, &apiErr)
+		t.logger.Printf("Twilio API error - Status: %d, Code: %d, Message: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		lastErr = fmt.Errorf("SMS send failed: %s", apiErr.Message)
+
+		// 4xx other than throttling means the request itself is bad
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
+
+// HealthCheck verifies the Twilio credentials by fetching the account
+func (t *TwilioService) HealthCheck() error {
+	req, err := http.NewRequest("GET", twilioAPIBase+t.accountSID+".json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health check request: %w", err)
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Twilio health check failed - service unreachable: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Warning: failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Twilio service health check failed: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SendVerificationCode sends a verification code via SMS
+func (t *TwilioService) SendVerificationCode(phoneNumber, code string) error {
+	message := fmt.Sprintf("Your SilentRelay verification code is: %s\n\nThis code expires in 5 minutes.", code)
+	return t.SendSMS(phoneNumber, message)
+}
+
+// Name returns "twilio"
+func (t *TwilioService) Name() string {
+	return "twilio"
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/appversion"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// systemBroadcastTTL is how long an announcement is kept for devices that
+// connect after it was sent
+const systemBroadcastTTL = 7 * 24 * time.Hour
+
+// SendSystemBroadcast delivers an operator announcement to every matching
+// client in the cluster. In cluster mode it goes out on the broadcast
+// channel, which this server also receives; if the publish fails only this
+// server's clients get it and the error is returned.
+func (h *Hub) SendSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget) error {
+	if !h.clusterMode {
+		h.DeliverSystemBroadcast(msg, target)
+		return nil
+	}
+	if err := h.redis.PublishSystemBroadcast(msg, target); err != nil {
+		h.DeliverSystemBroadcast(msg, target)
+		return err
+	}
+	return nil
+}
+
+// DeliverSystemBroadcast sends an announcement to this server's clients that
+// match the target and returns how many it reached
+func (h *Hub) DeliverSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget) int {
+	data := mustMarshal(msg)
+
+	h.mu.RLock()
+	targetClients := make([]*Client, 0)
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			if broadcastMatches(target, client) {
+				targetClients = append(targetClients, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	reached := make([]uuid.UUID, 0, len(targetClients))
+	for _, client := range targetClients {
+		select {
+		case client.send <- data:
+			reached = append(reached, client.DeviceID)
+		default:
+			// Buffer full; the client is already falling behind
+		}
+	}
+	if err := h.redis.MarkSystemBroadcastDelivered(msg.MessageID, systemBroadcastTTL, reached...); err != nil {
+		log.Printf("Warning: failed to record system broadcast deliveries: %v", err)
+	}
+	sent := len(reached)
+	log.Printf("[Broadcast] %s delivered to %d local clients", msg.MessageID, sent)
+	return sent
+}
+
+// broadcastMatches reports whether a client is in a broadcast's target.
+// Version bounds never match clients that didn't report a version.
+func broadcastMatches(target models.BroadcastTarget, client *Client) bool {
+	platform, version := client.ClientInfo()
+	if len(target.Platforms) > 0 && !slices.Contains(target.Platforms, platform) {
+		return false
+	}
+	if target.MinVersion != "" && (version == "" || appversion.Compare(version, target.MinVersion) < 0) {
+		return false
+	}
+	if target.MaxVersion != "" && (version == "" || appversion.Compare(version, target.MaxVersion) > 0) {
+		return false
+	}
+	return true
+}
+
+// QueueSystemBroadcast stores one copy of an announcement for
+// systemBroadcastTTL. Matching devices that weren't connected when it was
+// sent get it on their next connection.
+func (h *Hub) QueueSystemBroadcast(msg *models.WebSocketMessage, target models.BroadcastTarget) error {
+	return h.redis.StoreSystemBroadcast(msg, target, systemBroadcastTTL)
+}
+
+// deliverPendingBroadcasts sends a device the stored announcements it hasn't
+// received that match it. Runs on connect and again after hello, which may
+// report the platform and version a target needs.
+func (h *Hub) deliverPendingBroadcasts(client *Client) {
+	broadcasts, err := h.redis.GetSystemBroadcasts()
+	if err != nil {
+		log.Printf("Warning: failed to get system broadcasts: %v", err)
+		return
+	}
+	if len(broadcasts) == 0 {
+		return
+	}
+	delivered, err := h.redis.GetDeliveredSystemBroadcasts(client.DeviceID)
+	if err != nil {
+		log.Printf("Warning: failed to get delivered system broadcasts: %v", err)
+		return
+	}
+
+	for _, broadcast := range broadcasts {
+		if delivered[broadcast.Message.MessageID.String()] || !broadcastMatches(broadcast.Target, client) {
+			continue
+		}
+		select {
+		case client.send <- mustMarshal(broadcast.Message):
+		default:
+			// Buffer full; the rest go out on the next connection
+			return
+		}
+		if err := h.redis.MarkSystemBroadcastDelivered(broadcast.Message.MessageID, systemBroadcastTTL, client.DeviceID); err != nil {
+			log.Printf("Warning: failed to record system broadcast delivery: %v", err)
+		}
+	}
+}
@@ -1,29 +1,28 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/security"
 )
 
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 10 * time.Second
+// Time allowed to write a message to the peer. Ping timing is set on the
+// hub (see keepalive.go).
+const writeWait = 10 * time.Second
 
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period (must be less than pongWait)
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer (10MB for media references)
-	maxMessageSize = 10 * 1024 * 1024
-)
+// DefaultReadLimit is the largest frame accepted from a client (overridable
+// via SetReadLimit)
+const DefaultReadLimit = 256 * 1024
 
 // Client represents a single WebSocket connection
 type Client struct {
@@ -39,6 +38,12 @@ type Client struct {
 	UserID   uuid.UUID
 	DeviceID uuid.UUID
 
+	// Platform and app version the client reported, normalized; empty if
+	// it didn't say (see clientinfo.go)
+	platform   string
+	appVersion string
+	infoMu     sync.RWMutex
+
 	// Authentication token for HMAC verification
 	authToken string
 
@@ -46,11 +51,34 @@ type Client struct {
 	messageTokens int
 	lastRefill    time.Time
 	tokenMu       sync.Mutex
+
+	// Last time inbound activity refreshed the connection registry
+	// (only touched from the hub goroutine)
+	lastActivityRefresh time.Time
+
+	// Outbound compression settings and socket byte counter (see compression.go)
+	compression CompressionConfig
+	wire        *WireCounter
+
+	// Unacked deliver messages and whether sending is paused (see flowcontrol.go),
+	// and the inbox chunk being delivered (see inboxdrain.go)
+	inFlight     map[uuid.UUID]unackedDelivery
+	flowPaused   bool
+	inboxChunk   *inboxChunk
+	inboxStopped bool
+	flowMu       sync.Mutex
+
+	// Unix nanoseconds of the last inbound frame (see idle.go)
+	lastInbound atomic.Int64
+
+	// Close code and reason set by the hub before closing send (see close.go)
+	closeCode int
+	closeText string
 }
 
 // NewClient creates a new Client instance
 func NewClient(hub *Hub, conn *websocket.Conn, userID, deviceID uuid.UUID, authToken string) *Client {
-	return &Client{
+	client := &Client{
 		hub:           hub,
 		conn:          conn,
 		send:          make(chan []byte, 100), // Reduced buffer size for better backpressure
@@ -60,6 +88,20 @@ func NewClient(hub *Hub, conn *websocket.Conn, userID, deviceID uuid.UUID, authT
 		messageTokens: 200, // Start with 200 tokens (full burst capacity)
 		lastRefill:    time.Now(),
 	}
+	client.touch()
+	return client
+}
+
+// closeWithReason sends a close frame and closes the connection. The read
+// pump then fails and unregisters the client.
+func (c *Client) closeWithReason(code int, reason string) {
+	deadline := time.Now().Add(writeWait)
+	if err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline); err != nil {
+		log.Printf("Warning: failed to send close frame: %v", err)
+	}
+	if err := c.conn.Close(); err != nil {
+		log.Printf("Warning: failed to close connection: %v", err)
+	}
 }
 
 // canSendMessage checks if client can send a message (rate limiting)
@@ -103,25 +145,30 @@ func (c *Client) ReadPump() {
 		}
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+	// Only pongs extend the deadline, so a connection that stops answering
+	// pings is dropped even if the peer's kernel still accepts our writes
+	if err := c.conn.SetReadDeadline(c.hub.pongDeadline()); err != nil {
 		log.Printf("Warning: failed to set read deadline: %v", err)
 	}
 	c.conn.SetPongHandler(func(string) error {
-		if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
-			return err
-		}
-		return nil
+		return c.conn.SetReadDeadline(c.hub.pongDeadline())
 	})
 
 	for {
-		_, messageBytes, err := c.conn.ReadMessage()
+		messageBytes, err := c.readFrame()
+		if errors.Is(err, errFrameTooLarge) {
+			c.rejectOversizedFrame()
+			break
+		}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if isPongTimeout(err) {
+				log.Printf("[Keepalive] No pong from user=%s, device=%s; closing half-open connection", c.UserID, c.DeviceID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
+		c.touch()
 
 		// Parse the incoming message
 		var msg models.WebSocketMessage
@@ -157,9 +204,47 @@ func (c *Client) ReadPump() {
 	}
 }
 
+// errFrameTooLarge is returned by readFrame for frames over the read limit
+var errFrameTooLarge = errors.New("frame exceeds read limit")
+
+// readFrame reads the next message, stopping one byte past the hub's read
+// limit so an oversized frame is never buffered in full. The limit is
+// enforced here rather than with conn.SetReadLimit, which would close with
+// 1009 before the hub could audit the connection.
+func (c *Client) readFrame() ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	limit := c.hub.readLimit
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errFrameTooLarge
+	}
+	return data, nil
+}
+
+// rejectOversizedFrame audits and closes a connection that sent a frame
+// over the read limit
+func (c *Client) rejectOversizedFrame() {
+	log.Printf("SECURITY: Frame over read limit (%d bytes) from user=%s, device=%s", c.hub.readLimit, c.UserID, c.DeviceID)
+	if c.hub.auditLogger != nil {
+		c.hub.auditLogger.LogSecurityEvent(context.Background(), security.AuditEventInvalidRequest,
+			security.AuditResultDenied, &c.UserID,
+			"WebSocket frame exceeded read limit", map[string]any{
+				"device_id":   c.DeviceID.String(),
+				"limit_bytes": c.hub.readLimit,
+			})
+	}
+	c.closeWithReason(CloseMessageTooLarge, "message too large")
+}
+
 // WritePump pumps messages from the hub to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.hub.pingInterval)
 	defer func() {
 		ticker.Stop()
 		if err := c.conn.Close(); err != nil {
@@ -175,12 +260,20 @@ func (c *Client) WritePump() {
 			}
 			if !ok {
 				// Hub closed the channel
-				if err := c.conn.WriteMessage(websocket.CloseMessage, []byte{}); err != nil {
+				if err := c.conn.WriteMessage(websocket.CloseMessage, c.closeFrame()); err != nil {
 					log.Printf("Warning: failed to write close message: %v", err)
 				}
 				return
 			}
 
+			compress := c.shouldCompress(message)
+			c.conn.EnableWriteCompression(compress)
+			var wireBefore int64
+			if compress && c.wire != nil {
+				wireBefore = c.wire.BytesWritten()
+			}
+			payloadBytes := len(message)
+
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
@@ -219,6 +312,7 @@ func (c *Client) WritePump() {
 						}
 						return
 					}
+					payloadBytes += 1 + len(nextMessage)
 					processed++
 				default:
 					// Buffer is empty, break out of the for loop
@@ -230,6 +324,9 @@ func (c *Client) WritePump() {
 			if err := w.Close(); err != nil {
 				return
 			}
+			if compress {
+				c.recordCompression(payloadBytes, wireBefore)
+			}
 
 			// Log if we're falling behind
 			if processed > 20 {
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jaydenbeard/messaging-app/internal/appversion"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// SetClientInfo records the platform and app version the client reported.
+// Values that don't normalize are ignored, keeping what was known before.
+func (c *Client) SetClientInfo(platform, version string) {
+	platform = appversion.NormalizePlatform(platform)
+	version, ok := appversion.Normalize(version)
+
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	if platform != "" {
+		c.platform = platform
+	}
+	if ok {
+		c.appVersion = version
+	}
+}
+
+// ClientInfo returns the client's normalized platform and app version, empty
+// if not reported
+func (c *Client) ClientInfo() (platform, version string) {
+	c.infoMu.RLock()
+	defer c.infoMu.RUnlock()
+	return c.platform, c.appVersion
+}
+
+// handleHello takes the platform and app version from a client's hello
+// message, for clients that can't set headers on the WebSocket handshake
+func (h *Hub) handleHello(client *Client, msg *models.WebSocketMessage) {
+	var payload struct {
+		Platform   string `json:"platform"`
+		AppVersion string `json:"app_version"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		h.sendErrorToClient(msg.SenderID, "Invalid hello payload")
+		return
+	}
+
+	client.SetClientInfo(payload.Platform, payload.AppVersion)
+	if h.applyVersionPolicy(client, true) {
+		return
+	}
+	go h.recordClientInfo(client)
+	// Broadcasts targeted by platform or version can match only now
+	go h.deliverPendingBroadcasts(client)
+}
+
+// RejectOutdatedClient applies the version policy to a connection that isn't
+// registered yet. Returns true if it was below its platform's minimum and has
+// been sent force_upgrade and closed.
+func (h *Hub) RejectOutdatedClient(client *Client) bool {
+	return h.applyVersionPolicy(client, false)
+}
+
+// applyVersionPolicy sends a client below its platform's minimum version a
+// force_upgrade error and closes it, returning true, and one below the
+// recommended version an upgrade_available notice. Before registration the
+// write pump isn't running, so the error is written directly.
+func (h *Hub) applyVersionPolicy(client *Client, registered bool) bool {
+	platform, version := client.ClientInfo()
+	status, rule := h.redis.CheckClientVersion(platform, version)
+
+	switch status {
+	case appversion.UpgradeRequired:
+		log.Printf("[Version] Rejected %s %s connection: user=%s device=%s (minimum %s)",
+			platform, version, client.UserID, client.DeviceID, rule.Minimum)
+		metrics.RecordClientUpgradeRejection(platform, "websocket")
+
+		data := mustMarshal(&models.WebSocketMessage{
+			Type:      models.MessageTypeError,
+			Timestamp: time.Now().UTC(),
+			Payload: mustMarshal(map[string]interface{}{
+				"error":           "force_upgrade",
+				"platform":        platform,
+				"minimum_version": rule.Minimum,
+				"retryable":       false,
+			}),
+		})
+		if registered {
+			select {
+			case client.send <- data:
+			default:
+			}
+			go client.closeWithReason(CloseUpgradeRequired, "upgrade required")
+			return true
+		}
+		if err := client.conn.SetWriteDeadline(time.Now().Add(writeWait)); err == nil {
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Warning: failed to send force_upgrade: %v", err)
+			}
+		}
+		client.closeWithReason(CloseUpgradeRequired, "upgrade required")
+		return true
+
+	case appversion.UpgradeRecommended:
+		data := mustMarshal(&models.WebSocketMessage{
+			Type:      models.MessageTypeUpgradeAvailable,
+			Timestamp: time.Now().UTC(),
+			Payload: mustMarshal(map[string]string{
+				"platform":            platform,
+				"current_version":     version,
+				"recommended_version": rule.Recommended,
+				"minimum_version":     rule.Minimum,
+			}),
+		})
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+	return false
+}
+
+// recordClientInfo stores the client's platform and app version on its
+// device, for the version distribution admins see
+func (h *Hub) recordClientInfo(client *Client) {
+	platform, version := client.ClientInfo()
+	if platform == "" && version == "" {
+		return
+	}
+	if err := h.db.UpdateDeviceClientInfo(client.UserID, client.DeviceID, platform, version); err != nil {
+		log.Printf("Warning: failed to record client info for device=%s: %v", client.DeviceID, err)
+	}
+}
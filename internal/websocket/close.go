@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// Close codes sent before the hub closes a connection, so clients can tell
+// why they were disconnected. 4xxx codes are application-defined.
+//
+// Clients should sign in again, not reconnect, after CloseInvalidSignature
+// and CloseSignedOut; should not reconnect after CloseReplaced (another
+// connection for the same device is live); should back off after
+// CloseServerFull and CloseTooManyConnections; should ask the user to update
+// the app after CloseUpgradeRequired; and may reconnect at once after
+// CloseServerShutdown or CloseIdleTimeout.
+const (
+	CloseServerShutdown     = websocket.CloseGoingAway       // 1001: server draining, reconnect elsewhere
+	CloseMessageTooLarge    = websocket.ClosePolicyViolation // 1008: frame over the read limit
+	CloseServerFull         = websocket.CloseTryAgainLater   // 1013: server at its connection limit
+	CloseIdleTimeout        = 4000
+	CloseInvalidSignature   = 4001
+	CloseSignedOut          = 4003
+	CloseReplaced           = 4009
+	CloseUpgradeRequired    = 4026
+	CloseTooManyConnections = 4029
+)
+
+// closeSend records why the hub is dropping the client and closes its send
+// channel; WritePump then sends the close frame. Call with h.mu held so the
+// channel is closed only once.
+func (c *Client) closeSend(code int, reason string) {
+	c.closeCode = code
+	c.closeText = reason
+	close(c.send)
+}
+
+// closeFrame is the close message WritePump sends once the hub has closed
+// the send channel
+func (c *Client) closeFrame() []byte {
+	if c.closeCode == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeText)
+}
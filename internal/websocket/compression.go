@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+)
+
+// CompressionConfig controls permessage-deflate on client connections.
+// Only outbound frames of at least MinSize bytes are compressed; below that
+// the deflate block overhead costs more than it saves.
+type CompressionConfig struct {
+	Enabled bool
+	Level   int
+	MinSize int
+}
+
+// Negotiated reports whether the client offered permessage-deflate, which is
+// the only case in which the upgrader accepts it
+func (cfg CompressionConfig) Negotiated(r *http.Request) bool {
+	if !cfg.Enabled {
+		return false
+	}
+	for _, ext := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// WireCounter wraps the ResponseWriter passed to Upgrade so the hijacked
+// connection counts the bytes actually written to the socket
+type WireCounter struct {
+	http.ResponseWriter
+	written atomic.Int64
+}
+
+// NewWireCounter wraps w for byte counting
+func NewWireCounter(w http.ResponseWriter) *WireCounter {
+	return &WireCounter{ResponseWriter: w}
+}
+
+// Hijack implements http.Hijacker for the WebSocket upgrader
+func (wc *WireCounter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := wc.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingConn{Conn: conn, written: &wc.written}, brw, nil
+}
+
+// BytesWritten returns the number of bytes written to the socket so far
+func (wc *WireCounter) BytesWritten() int64 {
+	return wc.written.Load()
+}
+
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// EnableCompression turns on compression for outbound frames. Call it only
+// when cfg.Negotiated was true for the upgrade request. wire may be nil, in
+// which case bytes saved are not measured.
+//
+// Inbound frames are inflated by the connection before ReadMessage returns,
+// so HMAC verification always sees the decompressed payload.
+func (c *Client) EnableCompression(cfg CompressionConfig, wire *WireCounter) error {
+	if err := c.conn.SetCompressionLevel(cfg.Level); err != nil {
+		return err
+	}
+	c.compression = cfg
+	c.wire = wire
+	return nil
+}
+
+// shouldCompress decides per frame; a batch that will pick up queued
+// messages is compressed even if the first message alone is small
+func (c *Client) shouldCompress(first []byte) bool {
+	if !c.compression.Enabled {
+		return false
+	}
+	return len(first) >= c.compression.MinSize || len(c.send) > 0
+}
+
+// recordCompression updates the bytes-saved metric for one compressed frame
+func (c *Client) recordCompression(payloadBytes int, wireBefore int64) {
+	if c.wire == nil {
+		return
+	}
+	metrics.RecordWebSocketCompression(payloadBytes, int(c.wire.BytesWritten()-wireBefore))
+}
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/inbox"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// eventPayloadOverhead allows for the JSON around an edit's ciphertext
+const eventPayloadOverhead = 1024
+
+// maxEventPayloadSize is the largest reaction, edit or delete payload relayed
+// or stored. An edit carries at most a text message's ciphertext, base64
+// encoded; anything bigger would let one client fill other users' inboxes.
+func (h *Hub) maxEventPayloadSize() int {
+	return base64.StdEncoding.EncodedLen(h.maxCiphertextSize) + eventPayloadOverhead
+}
+
+// handleMessageEvent relays a reaction, edit or delete to everyone else in the
+// message's conversation. Recipients come from the stored message, not the
+// client. Offline recipients get the event from their inbox on reconnect.
+func (h *Hub) handleMessageEvent(ctx context.Context, msg *models.WebSocketMessage) {
+	if maxSize := h.maxEventPayloadSize(); len(msg.Payload) > maxSize {
+		log.Printf("[Event] Rejected oversized %s from %s: %d bytes (max %d)", msg.Type, msg.SenderID, len(msg.Payload), maxSize)
+		h.sendToUser(msg.SenderID, &models.WebSocketMessage{
+			Type:      models.MessageTypeError,
+			MessageID: msg.MessageID,
+			Timestamp: time.Now().UTC(),
+			Payload:   json.RawMessage(fmt.Sprintf(`{"error": "event_too_large", "max_bytes": %d}`, maxSize)),
+		})
+		return
+	}
+
+	var payload struct {
+		MessageID uuid.UUID `json:"message_id"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.MessageID == uuid.Nil {
+		log.Printf("[Event] Invalid %s payload from %s", msg.Type, msg.SenderID)
+		return
+	}
+
+	message, err := h.db.GetMessage(payload.MessageID)
+	if err != nil {
+		log.Printf("[Event] Could not fetch message %s: %v", payload.MessageID, err)
+		return
+	}
+
+	recipients, ok := h.messageEventRecipients(ctx, msg, message)
+	if !ok {
+		log.Printf("SECURITY: %s on message %s rejected for user %s", msg.Type, payload.MessageID, msg.SenderID)
+		return
+	}
+
+	if msg.Type == models.MessageTypeMessageDelete {
+		if _, err := h.db.MarkMessageDeleted(message.MessageID, msg.SenderID); err != nil {
+			log.Printf("Warning: failed to mark message %s deleted: %v", message.MessageID, err)
+		}
+	}
+
+	event := &models.WebSocketMessage{
+		Type:      msg.Type,
+		MessageID: message.MessageID,
+		SenderID:  msg.SenderID,
+		Timestamp: time.Now().UTC(),
+		Payload:   msg.Payload,
+	}
+
+	// Keep the actor's other devices in step
+	h.sendToUserAllDevices(msg.SenderID, event, msg.DeviceID)
+
+	offline := make([]uuid.UUID, 0)
+	for _, userID := range recipients {
+		if !h.relayToUser(ctx, userID, event) {
+			offline = append(offline, userID)
+		}
+	}
+
+	if err := h.inbox.AddEvent(offline, &inbox.InboxEvent{
+		Type:      event.Type,
+		MessageID: event.MessageID,
+		SenderID:  event.SenderID,
+		Payload:   event.Payload,
+		Timestamp: event.Timestamp,
+	}); err != nil {
+		log.Printf("Failed to store %s for offline users: %v", msg.Type, err)
+	}
+}
+
+// messageEventRecipients checks the actor may change the message and returns
+// the other participants. Only the sender may edit or delete; any participant
+// may react. Blocked direct-message pairs get an empty list so the block
+// isn't revealed.
+func (h *Hub) messageEventRecipients(ctx context.Context, msg *models.WebSocketMessage, message *db.Message) ([]uuid.UUID, bool) {
+	isSender := message.SenderID == msg.SenderID
+	if msg.Type != models.MessageTypeReaction && !isSender {
+		return nil, false
+	}
+
+	if message.GroupID != nil {
+		members, err := h.groupMemberIDs(ctx, *message.GroupID)
+		if err != nil {
+			log.Printf("[Event] Failed to get group members: %v", err)
+			return nil, false
+		}
+		isMember := false
+		recipients := make([]uuid.UUID, 0, len(members))
+		for _, member := range members {
+			if member == msg.SenderID {
+				isMember = true
+				continue
+			}
+			recipients = append(recipients, member)
+		}
+		return recipients, isMember
+	}
+
+	if message.ReceiverID == nil {
+		return nil, false
+	}
+	other := *message.ReceiverID
+	if !isSender {
+		if other != msg.SenderID {
+			return nil, false
+		}
+		other = message.SenderID
+	}
+
+	blocked, err := h.areEitherBlocked(msg.SenderID, other)
+	if err != nil {
+		log.Printf("[Event] Failed to check block status: %v", err)
+		return nil, false
+	}
+	if blocked {
+		return nil, true
+	}
+	return []uuid.UUID{other}, true
+}
+
+// relayToUser sends a message to all of a user's devices on any server.
+// Returns false if the user is offline or none of their servers could be
+// reached.
+func (h *Hub) relayToUser(ctx context.Context, userID uuid.UUID, msg *models.WebSocketMessage) bool {
+	isOnline, serverIDs := h.locateUser(userID)
+	if !isOnline || len(serverIDs) == 0 {
+		return false
+	}
+
+	reached := false
+	for _, serverID := range serverIDs {
+		if serverID == h.serverID {
+			h.sendToUserAllDevices(userID, msg, uuid.Nil)
+			reached = true
+			continue
+		}
+		if err := h.publishToServer(ctx, serverID, userID, msg); err != nil {
+			log.Printf("Warning: failed to publish to server %s: %v", serverID, err)
+			continue
+		}
+		reached = true
+	}
+	return reached
+}
+
+// deliverPendingEvents sends a reconnecting client the reactions, edits and
+// deletes its device missed. Runs after pending messages so they arrive
+// first. Each of the user's devices gets every event.
+func (h *Hub) deliverPendingEvents(client *Client) {
+	events, last, err := h.inbox.GetPendingDeviceEvents(client.UserID, client.DeviceID)
+	if err != nil {
+		log.Printf("Failed to fetch pending events: %v", err)
+		return
+	}
+
+	for _, event := range events {
+		data := mustMarshal(&models.WebSocketMessage{
+			Type:      event.Type,
+			MessageID: event.MessageID,
+			SenderID:  event.SenderID,
+			Timestamp: event.Timestamp,
+			Payload:   event.Payload,
+		})
+		select {
+		case client.send <- data:
+		default:
+			// Buffer full; keep everything for the next connection
+			return
+		}
+	}
+
+	if len(events) == 0 {
+		return
+	}
+	if err := h.inbox.AckDeviceEvents(client.UserID, client.DeviceID, last); err != nil {
+		log.Printf("Warning: failed to record delivered events: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/inbox"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// DefaultMaxInFlight is how many deliver messages a client may have unacked
+// before the hub stops sending and parks further messages in the inbox.
+// It is below the send buffer size so a slow reader is paused rather than
+// disconnected for filling the buffer.
+const DefaultMaxInFlight = 64
+
+// SetMaxInFlight sets the per-connection window of unacked deliver messages.
+// Zero disables flow control.
+func (h *Hub) SetMaxInFlight(n int) {
+	h.maxInFlight = n
+}
+
+// MaxRedeliveries is how many times an unacked message is put back in the
+// inbox before it is dropped. The message stays in the database, so the
+// client can still fetch it with a resync.
+const MaxRedeliveries = 5
+
+// unackedDelivery is a deliver message sent to a client and not yet acked
+type unackedDelivery struct {
+	msg      *models.WebSocketMessage
+	sentAt   time.Time
+	attempts int  // earlier deliveries of msg that went unacked
+	inInbox  bool // msg is an inbox entry sent as part of a chunk
+}
+
+// reserveDelivery records msg as in flight. It returns false, and marks the
+// client paused, if the window is full or the client is already paused
+// waiting for its inbox to drain.
+func (c *Client) reserveDelivery(msg *models.WebSocketMessage, limit int) bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	return c.reserveDeliveryLocked(msg, 0, limit)
+}
+
+// reserveDeliveryLocked is reserveDelivery with c.flowMu held. attempts is
+// how many earlier deliveries of msg went unacked.
+func (c *Client) reserveDeliveryLocked(msg *models.WebSocketMessage, attempts, limit int) bool {
+	if limit > 0 && (c.flowPaused || len(c.inFlight) >= limit) {
+		c.flowPaused = true
+		return false
+	}
+	if c.inFlight == nil {
+		c.inFlight = make(map[uuid.UUID]unackedDelivery)
+	}
+	c.inFlight[msg.MessageID] = unackedDelivery{msg: msg, sentAt: time.Now(), attempts: attempts}
+	return true
+}
+
+// hasInFlight reports whether messageID was sent and not yet acked
+func (c *Client) hasInFlight(messageID uuid.UUID) bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	_, ok := c.inFlight[messageID]
+	return ok
+}
+
+// releaseDelivery removes messageID from the window. It returns true when
+// the client was paused and now has room, i.e. the inbox should be drained.
+// An ack for a message that already timed out still counts as proof the
+// client is reading again.
+func (c *Client) releaseDelivery(messageID uuid.UUID, limit int) bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	delete(c.inFlight, messageID)
+
+	if c.flowPaused && (limit <= 0 || len(c.inFlight) < limit) {
+		c.flowPaused = false
+		return true
+	}
+	return false
+}
+
+// takeUnacked removes and returns deliveries sent before cutoff. A zero
+// cutoff, used on disconnect, takes all of them and stops inbox delivery.
+// On disconnect, inbox chunk messages are not returned, as they are still
+// in the inbox for the next connect. On timeout they are returned marked
+// inInbox, so they are requeued and count towards MaxRedeliveries like any
+// other delivery; left in place, a message the client never acks would be
+// resent at the head of every chunk. If dropping them ends the chunk,
+// inboxEnded is set and its acked IDs are returned for finishInboxChunk.
+// resume is true when a paused client has room again and its inbox should
+// be drained; a client that never acks would otherwise stay paused.
+func (c *Client) takeUnacked(cutoff time.Time, limit int) (expired []unackedDelivery, inboxAcked []uuid.UUID, inboxEnded, resume bool) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	for id, d := range c.inFlight {
+		if cutoff.IsZero() || d.sentAt.Before(cutoff) {
+			delete(c.inFlight, id)
+			if c.inboxChunk != nil {
+				if _, ok := c.inboxChunk.pending[id]; ok {
+					delete(c.inboxChunk.pending, id)
+					if cutoff.IsZero() {
+						continue
+					}
+					d.inInbox = true
+				}
+			}
+			expired = append(expired, d)
+		}
+	}
+	if cutoff.IsZero() {
+		c.inboxStopped = true
+		if c.inboxChunk != nil {
+			c.inboxChunk.sealed = true
+		}
+	}
+	if c.inboxChunk != nil {
+		inboxAcked, inboxEnded = c.endInboxChunkLocked()
+	}
+	if !cutoff.IsZero() && c.flowPaused && (limit <= 0 || len(c.inFlight) < limit) {
+		c.flowPaused = false
+		resume = true
+	}
+	return expired, inboxAcked, inboxEnded, resume
+}
+
+// Delivery paths, the path label of messenger_message_delivery_latency_seconds
+const (
+	deliveryPathLocal       = "local"        // sender and recipient on this server
+	deliveryPathCrossServer = "cross_server" // published to the recipient's server
+)
+
+// recordDeliveryLatency observes the time from the server receiving a
+// message to its deliver being queued for a connection. Deliver envelopes
+// carry the receiving server's timestamp, including across pub/sub.
+func recordDeliveryLatency(path string, deliveryMsg *models.WebSocketMessage) {
+	if deliveryMsg.Timestamp.IsZero() {
+		return
+	}
+	metrics.RecordDeliveryLatency(path, time.Since(deliveryMsg.Timestamp))
+}
+
+// recordOfflineDeliveryLatency observes the time a message spent in the
+// inbox before it was sent. Redeliveries of a message that went unacked
+// are not observed again.
+func recordOfflineDeliveryLatency(msg *inbox.InboxMessage) {
+	if msg.Timestamp.IsZero() || msg.Attempts > 0 {
+		return
+	}
+	metrics.RecordOfflineDeliveryLatency(time.Since(msg.Timestamp))
+}
+
+// deliverToClient sends a deliver message to one connection, respecting its
+// in-flight window. When the window is full the message is stored in the
+// user's inbox instead and sent once the client acks earlier messages; its
+// latency is then recorded as offline when the inbox sends it.
+func (h *Hub) deliverToClient(client *Client, deliveryMsg *models.WebSocketMessage, data []byte, path string) bool {
+	if !client.reserveDelivery(deliveryMsg, h.maxInFlight) {
+		if h.parkDelivery(client, deliveryMsg, 0) {
+			log.Printf("[Flow] Window full, parked message %s for device=%s", deliveryMsg.MessageID, client.DeviceID)
+		}
+		return true
+	}
+
+	select {
+	case client.send <- data:
+		recordDeliveryLatency(path, deliveryMsg)
+		return true
+	default:
+		client.releaseDelivery(deliveryMsg.MessageID, h.maxInFlight)
+		return false
+	}
+}
+
+// parkDelivery stores a deliver message in the user's inbox so it is sent
+// on the next drain or connect. The entry is for this device only: the
+// user's other devices were sent the message themselves. attempts is how
+// many deliveries of the message to this device went unacked.
+func (h *Hub) parkDelivery(client *Client, deliveryMsg *models.WebSocketMessage, attempts int) bool {
+	var payload models.EncryptedMessage
+	if err := json.Unmarshal(deliveryMsg.Payload, &payload); err != nil {
+		log.Printf("Warning: failed to parse deliver payload for inbox: %v", err)
+		return false
+	}
+
+	inboxMsg := &inbox.InboxMessage{
+		MessageID:   deliveryMsg.MessageID,
+		SenderID:    deliveryMsg.SenderID,
+		GroupID:     payload.GroupID,
+		Ciphertext:  payload.Ciphertext,
+		MessageType: payload.MessageType,
+		MediaID:     payload.MediaID,
+		MediaType:   payload.MediaType,
+		Timestamp:   deliveryMsg.Timestamp,
+		DeviceID:    &client.DeviceID,
+		Attempts:    attempts,
+	}
+	if err := h.inbox.AddToInbox(client.UserID, inboxMsg); err != nil {
+		log.Printf("Failed to add message to inbox: %v", err)
+		return false
+	}
+	return true
+}
+
+// resumeDelivery frees the acked message's slot and drains the inbox if the
+// client had been paused or the ack completed an inbox chunk
+func (h *Hub) resumeDelivery(client *Client, messageID uuid.UUID) {
+	resume := client.releaseDelivery(messageID, h.maxInFlight)
+	if acked, done := client.ackInboxDelivery(messageID); done {
+		go func() {
+			h.finishInboxChunk(client, acked)
+			h.deliverPendingMessages(client)
+		}()
+		return
+	}
+	if resume {
+		go h.deliverPendingMessages(client)
+	}
+}
+
+// DefaultAckTimeout is how long a delivered message may go unacked before it
+// is put back in the inbox
+const DefaultAckTimeout = 30 * time.Second
+
+// SetAckTimeout sets the delivery ACK timeout. Zero disables redelivery of
+// unacked messages. Call before Run.
+func (h *Hub) SetAckTimeout(d time.Duration) {
+	h.ackTimeout = d
+}
+
+// ackSweepTicker returns a ticker for the hub loop, or nil if ACK timeouts
+// are disabled
+func (h *Hub) ackSweepTicker() *time.Ticker {
+	if h.ackTimeout <= 0 {
+		return nil
+	}
+	return time.NewTicker(max(h.ackTimeout/2, time.Second))
+}
+
+// sweepUnackedDeliveries moves deliveries that timed out back to the inbox
+// and starts a drain to redeliver them. A client paused on a full window is
+// unpaused once the sweep frees it, even if it never acks.
+func (h *Hub) sweepUnackedDeliveries() {
+	cutoff := time.Now().Add(-h.ackTimeout)
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		expired, inboxAcked, inboxEnded, resume := client.takeUnacked(cutoff, h.maxInFlight)
+		if len(expired) == 0 && !inboxEnded && !resume {
+			continue
+		}
+		// The chunk, if any, ended without every ack; what's left is sent again
+		go func() {
+			h.requeueDeliveries(client, h.removeExpiredInboxDeliveries(client, expired))
+			if inboxEnded {
+				h.finishInboxChunk(client, inboxAcked)
+			}
+			h.deliverPendingMessages(client)
+		}()
+	}
+}
+
+// removeExpiredInboxDeliveries takes timed-out chunk messages out of the
+// inbox so requeueDeliveries can put them back with their attempt counted.
+// It returns the deliveries to requeue; if the removal fails, the chunk
+// messages are left as they were rather than duplicated.
+func (h *Hub) removeExpiredInboxDeliveries(client *Client, deliveries []unackedDelivery) []unackedDelivery {
+	var ids []uuid.UUID
+	for _, d := range deliveries {
+		if d.inInbox {
+			ids = append(ids, d.msg.MessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return deliveries
+	}
+	if err := h.inbox.RemoveFromDeviceInbox(client.UserID, client.DeviceID, ids); err != nil {
+		log.Printf("Warning: failed to remove timed-out messages from inbox: %v", err)
+		live := deliveries[:0]
+		for _, d := range deliveries {
+			if !d.inInbox {
+				live = append(live, d)
+			}
+		}
+		return live
+	}
+	return deliveries
+}
+
+// requeueDeliveries puts unacked deliveries back in the inbox, dropping
+// those already redelivered MaxRedeliveries times
+func (h *Hub) requeueDeliveries(client *Client, deliveries []unackedDelivery) {
+	if len(deliveries) == 0 {
+		return
+	}
+	requeued, dropped := 0, 0
+	for _, d := range deliveries {
+		if d.attempts >= MaxRedeliveries {
+			dropped++
+			continue
+		}
+		if h.parkDelivery(client, d.msg, d.attempts+1) {
+			requeued++
+		}
+	}
+	if dropped > 0 {
+		metrics.RedeliveriesExhausted.Add(float64(dropped))
+	}
+	log.Printf("[Flow] Requeued %d unacked messages for device=%s (%d dropped after %d redeliveries)",
+		requeued, client.DeviceID, dropped, MaxRedeliveries)
+}
+
+// requeueOnDisconnect returns a departing client's unacked deliveries to the
+// inbox so a crash mid-delivery doesn't lose them. Unacked inbox messages
+// never left it and are sent on the next connect.
+func (h *Hub) requeueOnDisconnect(client *Client) {
+	unacked, inboxAcked, inboxEnded, _ := client.takeUnacked(time.Time{}, h.maxInFlight)
+	if len(unacked) > 0 {
+		go h.requeueDeliveries(client, unacked)
+	}
+	if inboxEnded {
+		go h.finishInboxChunk(client, inboxAcked)
+	}
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveryWindow(t *testing.T) {
+	t.Run("Parks deliveries past the window and drains them on ack", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetMaxInFlight(2)
+		client := newTestClient(h, uuid.New(), 16)
+
+		messages := []*models.WebSocketMessage{testDeliverMessage(), testDeliverMessage(), testDeliverMessage()}
+		for _, msg := range messages {
+			assert.True(t, h.deliverToClient(client, msg, mustMarshal(msg), deliveryPathLocal))
+		}
+
+		assert.Equal(t, messages[0].MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		assert.Equal(t, messages[1].MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		assert.Empty(t, client.send, "the third message must not be sent while the window is full")
+		assert.False(t, client.hasInFlight(messages[2].MessageID))
+		assert.Equal(t, []uuid.UUID{messages[2].MessageID}, inboxIDs(pendingInbox(t, h, client)))
+
+		// Paused: even a new message waits behind the parked one
+		late := testDeliverMessage()
+		assert.True(t, h.deliverToClient(client, late, mustMarshal(late), deliveryPathLocal))
+		assert.Empty(t, client.send)
+
+		h.resumeDelivery(client, messages[0].MessageID)
+		assert.Equal(t, messages[2].MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		assert.True(t, client.hasInFlight(messages[2].MessageID))
+		// Window full again (messages 1 and 2); the late one waits for the next ack
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+
+		h.resumeDelivery(client, messages[1].MessageID)
+		h.resumeDelivery(client, messages[2].MessageID)
+		assert.Equal(t, late.MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		h.resumeDelivery(client, late.MessageID)
+
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 0 },
+			time.Second, 5*time.Millisecond, "acked inbox messages must be removed")
+	})
+
+	t.Run("Zero window disables flow control", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetMaxInFlight(0)
+		client := newTestClient(h, uuid.New(), 256)
+
+		for i := 0; i < 100; i++ {
+			msg := testDeliverMessage()
+			assert.True(t, h.deliverToClient(client, msg, mustMarshal(msg), deliveryPathLocal))
+		}
+		assert.Len(t, client.send, 100)
+		assert.Empty(t, pendingInbox(t, h, client))
+	})
+
+	t.Run("Full send buffer frees the slot", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		client := newTestClient(h, uuid.New(), 1)
+
+		first, second := testDeliverMessage(), testDeliverMessage()
+		assert.True(t, h.deliverToClient(client, first, mustMarshal(first), deliveryPathLocal))
+		assert.False(t, h.deliverToClient(client, second, mustMarshal(second), deliveryPathLocal))
+		assert.True(t, client.hasInFlight(first.MessageID))
+		assert.False(t, client.hasInFlight(second.MessageID))
+	})
+}
@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/queue"
+	"github.com/jaydenbeard/messaging-app/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultGroupFanoutThreshold is the member count above which group messages
+// are fanned out by a queue worker instead of inline in the hub loop
+const DefaultGroupFanoutThreshold = 200
+
+const (
+	groupFanoutStream        = "group_fanout"
+	groupFanoutConsumerGroup = "group_fanout_workers"
+	groupFanoutEventType     = "group_fanout"
+
+	// Redelivered jobs within this window are skipped so members aren't
+	// sent the same message twice
+	groupFanoutIdempotencyTTL = time.Hour
+
+	groupFanoutClaimInterval = 30 * time.Second
+	groupFanoutClaimMinIdle  = time.Minute
+	groupFanoutClaimBatch    = 50
+)
+
+// groupFanoutJob is the queued payload of a group_fanout event
+type groupFanoutJob struct {
+	Message      *models.EncryptedMessage `json:"message"`
+	SealedSender bool                     `json:"sealed_sender"`
+}
+
+// SetGroupFanoutThreshold sets the member count above which group messages
+// are fanned out through the queue. Zero always fans out inline. Call before Run.
+func (h *Hub) SetGroupFanoutThreshold(n int) {
+	h.groupFanoutThreshold = n
+}
+
+// enqueueGroupFanout queues a stored group message for fan-out by a worker
+func (h *Hub) enqueueGroupFanout(msg *db.Message, payload *models.EncryptedMessage, isSealedSender bool) error {
+	data, err := json.Marshal(groupFanoutJob{Message: payload, SealedSender: isSealedSender})
+	if err != nil {
+		return err
+	}
+	_, err = h.fanoutQueue.Enqueue(&queue.QueuedMessage{
+		MessageID: msg.MessageID,
+		SenderID:  msg.SenderID,
+		GroupID:   payload.GroupID,
+		Timestamp: msg.Timestamp,
+		EventType: groupFanoutEventType,
+		Payload:   data,
+	})
+	return err
+}
+
+// StartGroupFanoutWorker consumes group_fanout jobs. Every chat server runs
+// one in a shared consumer group, so each job is fanned out once; jobs left
+// pending by a crashed server are reclaimed by the others. Blocks forever;
+// run it in a goroutine.
+func (h *Hub) StartGroupFanoutWorker() {
+	handler := h.fanoutQueue.WithIdempotency(groupFanoutIdempotencyTTL, h.processGroupFanout)
+	go h.fanoutQueue.StartAutoClaim(groupFanoutConsumerGroup, h.serverID, groupFanoutClaimInterval, groupFanoutClaimMinIdle, groupFanoutClaimBatch, handler)
+	h.fanoutQueue.StartConsumer(groupFanoutConsumerGroup, h.serverID, handler)
+}
+
+// processGroupFanout resolves the group's current members and delivers a
+// queued group message to them
+func (h *Hub) processGroupFanout(qm *queue.QueuedMessage) error {
+	if qm.EventType != groupFanoutEventType || qm.GroupID == nil {
+		return nil
+	}
+
+	var job groupFanoutJob
+	if err := json.Unmarshal(qm.Payload, &job); err != nil || job.Message == nil {
+		// Malformed jobs can never succeed; drop them rather than retry
+		log.Printf("Warning: dropping malformed group fan-out job %s: %v", qm.MessageID, err)
+		return nil
+	}
+	job.Message.GroupID = qm.GroupID
+
+	ctx, span := tracing.Start(context.Background(), "hub.groupFanoutWorker",
+		trace.WithAttributes(attribute.String("message.id", qm.MessageID.String())))
+	defer span.End()
+
+	members, err := h.groupMemberIDs(ctx, *qm.GroupID)
+	if err != nil {
+		return fmt.Errorf("get group members: %w", err)
+	}
+
+	msg := &db.Message{
+		MessageID:   qm.MessageID,
+		SenderID:    qm.SenderID,
+		GroupID:     qm.GroupID,
+		Ciphertext:  job.Message.Ciphertext,
+		MessageType: job.Message.MessageType,
+		MediaID:     job.Message.MediaID,
+		MediaType:   job.Message.MediaType,
+		Timestamp:   qm.Timestamp,
+	}
+	h.fanOutGroupMessage(ctx, msg, job.Message, members, job.SealedSender)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -22,14 +23,29 @@ import (
 	"github.com/jaydenbeard/messaging-app/internal/pubsub"
 	"github.com/jaydenbeard/messaging-app/internal/queue"
 	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/jaydenbeard/messaging-app/internal/services"
+	"github.com/jaydenbeard/messaging-app/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Connection limits for DoS protection
+// Connection limits for DoS protection (overridable via SetConnectionLimits)
 const (
-	MaxConnectionsPerUser = 10    // Max devices per user
-	MaxTotalConnections   = 10000 // Max total WebSocket connections
+	DefaultMaxConnectionsPerUser = 10    // Max devices per user
+	DefaultMaxTotalConnections   = 10000 // Max total WebSocket connections
 )
 
+// Ciphertext size limits for DoS protection (overridable via SetMessageLimits)
+const (
+	DefaultMaxCiphertextSize      = 64 * 1024  // Text messages
+	DefaultMaxMediaCiphertextSize = 256 * 1024 // Messages referencing a media_id (key material, captions, thumbnails)
+)
+
+// DefaultMaxClockSkew is how far a send's client timestamp may be from server
+// time before the message is rejected (overridable via SetMaxClockSkew)
+const DefaultMaxClockSkew = 5 * time.Minute
+
 // Hub maintains the set of active clients and broadcasts messages
 // Implements the message flows from the sequence diagrams:
 // - Multi-device sync (User's devices on different servers)
@@ -63,12 +79,29 @@ type Hub struct {
 	// Message queue for async processing
 	queue *queue.MessageQueue
 
+	// Queue of large-group fan-out jobs, and the member count above which
+	// group messages are fanned out through it (0 = always inline)
+	fanoutQueue          *queue.MessageQueue
+	groupFanoutThreshold int
+
+	// Presence and group services, discovered via Consul. Nil, or a failed
+	// call, falls back to reading Redis/Postgres directly.
+	presenceService *services.PresenceClient
+	groupService    *services.GroupClient
+
 	// Mutex for thread-safe client map access
 	mu sync.RWMutex
 
 	// Connection tracking for limits
 	totalConnections int32
 
+	// Max connections per user and on this server
+	maxConnectionsPerUser int
+	maxTotalConnections   int
+
+	// Max bytes per inbound frame; larger frames close the connection
+	readLimit int64
+
 	// Shutdown signal
 	shutdown chan struct{}
 
@@ -81,6 +114,46 @@ type Hub struct {
 
 	// Audit logger for security events
 	auditLogger *security.AuditLogger
+
+	// Max ciphertext bytes per message, without and with a media attachment
+	maxCiphertextSize      int
+	maxMediaCiphertextSize int
+
+	// Max unacked deliver messages per connection (0 = unlimited)
+	maxInFlight int
+
+	// Disconnect clients silent for longer than this (0 = never)
+	idleTimeout time.Duration
+
+	// Requeue delivered messages unacked for longer than this (0 = never)
+	ackTimeout time.Duration
+
+	// Reject sends whose client timestamp is further than this from server time (0 = never)
+	maxClockSkew time.Duration
+
+	// Protocol-level ping period and how long a pong may take
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+
+	// Publish to other servers via Redis; off for single-node deploys
+	clusterMode bool
+
+	// Hint new clients to reconnect elsewhere while this server holds more
+	// than rebalanceHighWaterPercent of the cluster's average connections
+	rebalanceHighWaterPercent int
+	rebalanceHintPercent      int
+	rebalanceMinConnections   int
+	clusterAverageLoad        atomic.Int64
+
+	// A user whose last connection closes is marked offline only once the
+	// grace period passes without a reconnect
+	offlineTimers *OfflineTimers
+
+	// Refuse key_request bundles without a one-time pre-key
+	requireOneTimePrekey bool
+
+	// Unread count queries waiting for a worker (see unread.go)
+	unreadCountJobs chan unreadCountJob
 }
 
 // NewHub creates a new Hub instance
@@ -109,7 +182,7 @@ func NewHub(serverID string, redis *pubsub.RedisClient, database *db.PostgresDB,
 		secret = []byte(hmacSecret)
 	}
 
-	return &Hub{
+	h := &Hub{
 		serverID:    serverID,
 		clients:     make(map[uuid.UUID]map[*Client]bool),
 		register:    make(chan *Client),
@@ -119,15 +192,112 @@ func NewHub(serverID string, redis *pubsub.RedisClient, database *db.PostgresDB,
 		db:          database,
 		inbox:       inbox.NewRedisInbox(redis.GetClient()),
 		queue:       queue.NewMessageQueue(redis.GetClient(), "message_events"),
+		fanoutQueue: queue.NewMessageQueue(redis.GetClient(), groupFanoutStream),
 		shutdown:    make(chan struct{}),
 		hmacSecret:  secret,
 		nonceStore:  make(map[string]time.Time),
 		auditLogger: auditLogger,
+
+		maxConnectionsPerUser:  DefaultMaxConnectionsPerUser,
+		maxTotalConnections:    DefaultMaxTotalConnections,
+		readLimit:              DefaultReadLimit,
+		maxCiphertextSize:      DefaultMaxCiphertextSize,
+		maxMediaCiphertextSize: DefaultMaxMediaCiphertextSize,
+		maxInFlight:            DefaultMaxInFlight,
+		idleTimeout:            DefaultIdleTimeout,
+		ackTimeout:             DefaultAckTimeout,
+		maxClockSkew:           DefaultMaxClockSkew,
+		pingInterval:           DefaultPingInterval,
+		pongTimeout:            DefaultPongTimeout,
+		groupFanoutThreshold:   DefaultGroupFanoutThreshold,
+		clusterMode:            true,
+
+		rebalanceHighWaterPercent: DefaultRebalanceHighWaterPercent,
+		rebalanceHintPercent:      DefaultRebalanceHintPercent,
+		rebalanceMinConnections:   DefaultRebalanceMinConnections,
+
+		unreadCountJobs: make(chan unreadCountJob, unreadCountQueueSize),
+	}
+	h.offlineTimers = NewOfflineTimers(DefaultPresenceOfflineGrace, h.markOffline)
+	h.startUnreadCountWorkers()
+	return h
+}
+
+// SetInboxLimits sets how long undelivered messages stay in offline inboxes
+// and how many messages an inbox may hold before the oldest are evicted
+func (h *Hub) SetInboxLimits(ttl time.Duration, maxSize int64) {
+	h.inbox.SetTTL(ttl)
+	h.inbox.SetMaxSize(maxSize)
+}
+
+// SetConnectionLimits sets how many connections one user may hold and how
+// many this server accepts in total. Call before Run.
+func (h *Hub) SetConnectionLimits(perUser, total int) {
+	h.maxConnectionsPerUser = perUser
+	h.maxTotalConnections = total
+}
+
+// SetReadLimit sets the largest frame accepted from a client. It must fit
+// the largest allowed ciphertext once base64-encoded. Call before Run.
+func (h *Hub) SetReadLimit(n int) {
+	h.readLimit = int64(n)
+}
+
+// SetMessageLimits sets the max ciphertext size for text messages and for
+// messages that reference an uploaded media_id
+func (h *Hub) SetMessageLimits(maxCiphertextSize, maxMediaCiphertextSize int) {
+	h.maxCiphertextSize = maxCiphertextSize
+	h.maxMediaCiphertextSize = maxMediaCiphertextSize
+}
+
+// SetMaxClockSkew sets how far a send's client timestamp may be from server
+// time. Zero disables the check. Call before Run.
+func (h *Hub) SetMaxClockSkew(d time.Duration) {
+	h.maxClockSkew = d
+}
+
+// SetClusterMode controls cross-server fan-out. With it off the hub assumes
+// it is the only chat server: users are online only if connected here and
+// nothing is published to Redis for other servers. Call before Run.
+func (h *Hub) SetClusterMode(enabled bool) {
+	h.clusterMode = enabled
+}
+
+// locateUser answers "Where is User B?". In single-node mode the local
+// client map is authoritative, which also skips stale Redis entries left
+// by a previous process.
+func (h *Hub) locateUser(userID uuid.UUID) (bool, []string) {
+	if h.clusterMode {
+		return h.redis.GetUserConnectionInfo(userID)
+	}
+
+	h.mu.RLock()
+	online := len(h.clients[userID]) > 0
+	h.mu.RUnlock()
+	if !online {
+		return false, nil
 	}
+	return true, []string{h.serverID}
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
+	var idleSweep <-chan time.Time
+	if ticker := h.idleSweepTicker(); ticker != nil {
+		defer ticker.Stop()
+		idleSweep = ticker.C
+	}
+	var ackSweep <-chan time.Time
+	if ticker := h.ackSweepTicker(); ticker != nil {
+		defer ticker.Stop()
+		ackSweep = ticker.C
+	}
+	var loadReport <-chan time.Time
+	if ticker := h.loadReportTicker(); ticker != nil {
+		defer ticker.Stop()
+		loadReport = ticker.C
+	}
+
 	for {
 		select {
 		case client := <-h.register:
@@ -139,6 +309,15 @@ func (h *Hub) Run() {
 		case message := <-h.broadcast:
 			h.handleMessage(message)
 
+		case <-idleSweep:
+			h.sweepIdleClients()
+
+		case <-ackSweep:
+			h.sweepUnackedDeliveries()
+
+		case <-loadReport:
+			go h.reportLoad()
+
 		case <-h.shutdown:
 			h.closeAllClients()
 			return
@@ -170,20 +349,34 @@ func (h *Hub) registerClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	// One connection per device: a reconnect that beats the old connection's
+	// timeout replaces it, otherwise both would receive every message
+	if userClients, ok := h.clients[client.UserID]; ok {
+		for existing := range userClients {
+			if existing.DeviceID == client.DeviceID {
+				delete(userClients, existing)
+				existing.closeSend(CloseReplaced, "replaced by new connection")
+				h.requeueOnDisconnect(existing)
+				atomic.AddInt32(&h.totalConnections, -1)
+				log.Printf("[Hub] Replacing stale connection: user=%s, device=%s", client.UserID, client.DeviceID)
+			}
+		}
+	}
+
 	// Check total connection limit (DoS protection)
-	if h.totalConnections >= MaxTotalConnections {
+	if int(atomic.LoadInt32(&h.totalConnections)) >= h.maxTotalConnections {
 		log.Printf("SECURITY: Max total connections reached (%d), rejecting user=%s",
-			MaxTotalConnections, client.UserID)
-		close(client.send)
+			h.maxTotalConnections, client.UserID)
+		client.closeSend(CloseServerFull, "server at capacity")
 		return
 	}
 
 	// Check per-user connection limit (prevent single user from hogging connections)
 	if userClients, ok := h.clients[client.UserID]; ok {
-		if len(userClients) >= MaxConnectionsPerUser {
+		if len(userClients) >= h.maxConnectionsPerUser {
 			log.Printf("SECURITY: Max connections per user reached (%d) for user=%s",
-				MaxConnectionsPerUser, client.UserID)
-			close(client.send)
+				h.maxConnectionsPerUser, client.UserID)
+			client.closeSend(CloseTooManyConnections, "too many connections")
 			return
 		}
 	}
@@ -206,11 +399,92 @@ func (h *Hub) registerClient(client *Client) {
 		client.UserID, client.DeviceID, h.serverID)
 
 	// Broadcast presence update to all connected users
-	// This notifies everyone that this user came online
-	go h.broadcastPresenceUpdate(client.UserID, true)
+	// This notifies everyone that this user came online, unless they are
+	// back within the offline grace period and contacts never saw them leave
+	if !h.offlineTimers.Cancel(client.UserID) {
+		go h.broadcastPresenceUpdate(client.UserID, true)
+	}
+
+	// Tell the new connection who is online now; otherwise every friend shows
+	// offline until their next presence change
+	go h.sendPresenceSnapshot(client)
+
+	// Deliver pending messages from inbox (User B comes online flow), then
+	// the reactions, edits and deletes missed while offline, then any
+	// announcements sent meanwhile
+	go func() {
+		h.deliverPendingMessages(client)
+		h.deliverPendingEvents(client)
+		h.deliverPendingBroadcasts(client)
+	}()
+
+	go h.recordDeviceType(client)
+	go h.recordClientInfo(client)
+
+	// Overloaded relative to the rest of the cluster: ask a few new clients
+	// to reconnect so the load balancer can route them elsewhere
+	if h.shouldHintRebalance() {
+		h.sendRebalanceHint(client)
+	}
+}
+
+// recordDeviceType adds the device's type to the connections hash so other
+// devices can show e.g. "active on mobile"
+func (h *Hub) recordDeviceType(client *Client) {
+	deviceType, err := h.db.GetDeviceType(client.UserID, client.DeviceID)
+	if err != nil {
+		log.Printf("Warning: failed to get device type for device=%s: %v", client.DeviceID, err)
+		return
+	}
+	h.redis.SetDeviceType(client.UserID, client.DeviceID, deviceType)
+}
+
+// sendPresenceSnapshot sends a newly connected client the current online status
+// of the user's friends. Friends in ghost mode are always reported offline.
+func (h *Hub) sendPresenceSnapshot(client *Client) {
+	friendIDs, err := h.db.GetFriendIDs(client.UserID)
+	if err != nil {
+		log.Printf("Warning: failed to get friends for presence snapshot: %v", err)
+		return
+	}
+
+	presence := h.batchPresence(friendIDs)
+	hidden, err := h.db.GetUsersHidingOnlineStatus(friendIDs)
+	if err != nil {
+		// Fail closed on privacy: report nobody as online rather than leak ghost mode users
+		log.Printf("Warning: failed to get privacy settings for presence snapshot: %v", err)
+		return
+	}
+
+	online := make([]string, 0, len(friendIDs))
+	onlineIDs := make([]uuid.UUID, 0, len(friendIDs))
+	for _, friendID := range friendIDs {
+		if presence[friendID] && !hidden[friendID] {
+			online = append(online, friendID.String())
+			onlineIDs = append(onlineIDs, friendID)
+		}
+	}
+
+	// Away/DND for online friends; anyone online and not listed is "online"
+	statuses := make(map[string]string)
+	for friendID, status := range h.redis.GetBatchPresenceStatus(onlineIDs) {
+		statuses[friendID.String()] = status
+	}
 
-	// Deliver pending messages from inbox (User B comes online flow)
-	go h.deliverPendingMessages(client)
+	snapshot := &models.WebSocketMessage{
+		Type:      models.MessageTypePresenceSnapshot,
+		Timestamp: time.Now().UTC(),
+		Payload: mustMarshal(map[string]interface{}{
+			"online":   online,
+			"statuses": statuses,
+		}),
+	}
+
+	select {
+	case client.send <- mustMarshal(snapshot):
+	default:
+		// Buffer full; the client will catch up from individual presence events
+	}
 }
 
 func (h *Hub) unregisterClient(client *Client) {
@@ -221,6 +495,7 @@ func (h *Hub) unregisterClient(client *Client) {
 		if _, ok := userClients[client]; ok {
 			delete(userClients, client)
 			close(client.send)
+			h.requeueOnDisconnect(client)
 			// Use atomic operation for counter
 			atomic.AddInt32(&h.totalConnections, -1)
 
@@ -230,9 +505,9 @@ func (h *Hub) unregisterClient(client *Client) {
 			// If no more devices connected, set offline
 			if len(userClients) == 0 {
 				delete(h.clients, client.UserID)
-				h.redis.SetUserPresence(client.UserID, false)
-				// Broadcast presence update to all connected users
-				go h.broadcastPresenceUpdate(client.UserID, false)
+				// Mark offline and broadcast once the grace period shows
+				// this isn't just a reconnect
+				h.offlineTimers.Schedule(client.UserID)
 			}
 
 			log.Printf("Client unregistered: user=%s, device=%s",
@@ -266,7 +541,7 @@ func (h *Hub) handleMessage(msg *models.WebSocketMessage) {
 
 		// SECURITY: Terminate connection on signature verification failure
 		// This prevents message tampering and MITM attacks
-		go h.unregisterClient(client)
+		go client.closeWithReason(CloseInvalidSignature, "invalid signature")
 		return
 	}
 
@@ -276,17 +551,37 @@ func (h *Hub) handleMessage(msg *models.WebSocketMessage) {
 		return
 	}
 
+	// Start a span for the inbound message, continuing the client's trace if it sent one
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), msg.TraceContext), "ws."+msg.Type,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("messaging.ws.type", msg.Type),
+			attribute.String("server.id", h.serverID),
+		),
+	)
+	defer span.End()
+
+	// Any inbound traffic proves the connection is alive; keep routing info fresh
+	// even if heartbeats lapse (heartbeats refresh on their own below)
+	if msg.Type != models.MessageTypeHeartbeat {
+		h.refreshActivity(client)
+	}
+
 	switch msg.Type {
 	case models.MessageTypeSend:
-		h.handleSendMessage(msg)
+		h.handleSendMessage(ctx, msg)
 	case models.MessageTypeDeliveryAck:
-		h.handleDeliveryAck(msg)
+		h.handleDeliveryAck(client, msg)
 	case models.MessageTypeReadReceipt:
 		h.handleReadReceipt(msg)
 	case models.MessageTypeTyping:
 		h.handleTypingIndicator(msg)
 	case models.MessageTypeHeartbeat:
 		h.handleHeartbeat(msg)
+	case models.MessageTypeHello:
+		h.handleHello(client, msg)
+	case models.MessageTypeKeyRequest:
+		h.handleKeyRequest(ctx, client, msg)
 	// Call signaling - forward to recipient
 	case models.MessageTypeCallOffer,
 		models.MessageTypeCallAnswer,
@@ -294,7 +589,7 @@ func (h *Hub) handleMessage(msg *models.WebSocketMessage) {
 		models.MessageTypeCallEnd,
 		models.MessageTypeCallBusy,
 		models.MessageTypeIceCandidate:
-		h.handleCallSignaling(msg)
+		h.handleCallSignaling(ctx, msg)
 	// Device-to-device sync - relay encrypted data (server can't read it)
 	case models.MessageTypeSyncRequest,
 		models.MessageTypeSyncData,
@@ -302,7 +597,14 @@ func (h *Hub) handleMessage(msg *models.WebSocketMessage) {
 		h.handleDeviceSync(msg)
 	// Media key exchange - forward encrypted key to recipient
 	case models.MessageTypeMediaKey:
-		h.handleMediaKey(msg)
+		h.handleMediaKey(ctx, msg)
+	case models.MessageTypePresenceStatus:
+		h.handlePresenceStatus(msg)
+	// Reactions, edits and deletes - relay, or hold in the inbox if offline
+	case models.MessageTypeReaction,
+		models.MessageTypeMessageEdit,
+		models.MessageTypeMessageDelete:
+		h.handleMessageEvent(ctx, msg)
 	}
 }
 
@@ -425,9 +727,51 @@ func (h *Hub) checkAndStoreNonce(msg *models.WebSocketMessage) bool {
 }
 
 // handleSendMessage implements the "Message Flow (Both Users Online)" sequence
-func (h *Hub) handleSendMessage(msg *models.WebSocketMessage) {
+func (h *Hub) handleSendMessage(ctx context.Context, msg *models.WebSocketMessage) {
 	log.Printf("[MSG] handleSendMessage: from=%s", msg.SenderID)
 
+	// During maintenance, refuse new sends but keep acks/receipts flowing so
+	// existing conversations settle before the deploy
+	if h.redis.IsMaintenanceMode() {
+		h.sendToUser(msg.SenderID, &models.WebSocketMessage{
+			Type:      models.MessageTypeError,
+			MessageID: msg.MessageID,
+			Timestamp: time.Now().UTC(),
+			Payload:   json.RawMessage(`{"error": "maintenance", "retryable": true}`),
+		})
+		return
+	}
+
+	// The server is the timestamp authority; the client's timestamp only
+	// covers the HMAC. One far from server time is a replay or a broken
+	// clock, so the message is refused and the client told the server time.
+	now := time.Now().UTC()
+	if h.maxClockSkew > 0 && !msg.Timestamp.IsZero() {
+		if skew := now.Sub(msg.Timestamp).Abs(); skew > h.maxClockSkew {
+			log.Printf("SECURITY: Rejected message from %s with client timestamp %s off by %s", msg.SenderID, msg.Timestamp.Format(time.RFC3339), skew)
+			if h.auditLogger != nil {
+				h.auditLogger.LogSecurityEvent(ctx, security.AuditEventReplayAttempt,
+					security.AuditResultFailure, &msg.SenderID,
+					"WebSocket message timestamp outside allowed clock skew", map[string]any{
+						"device_id":        msg.DeviceID,
+						"client_timestamp": msg.Timestamp,
+						"skew_seconds":     int(skew.Seconds()),
+					})
+			}
+			h.sendToUser(msg.SenderID, &models.WebSocketMessage{
+				Type:      models.MessageTypeError,
+				MessageID: msg.MessageID,
+				Timestamp: now,
+				Payload: mustMarshal(map[string]interface{}{
+					"error":       "clock_skew",
+					"server_time": now,
+					"retryable":   true,
+				}),
+			})
+			return
+		}
+	}
+
 	// Parse the encrypted message payload
 	var payload models.EncryptedMessage
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
@@ -439,13 +783,53 @@ func (h *Hub) handleSendMessage(msg *models.WebSocketMessage) {
 		msg.SenderID, payload.ReceiverID, payload.MessageType, len(payload.Ciphertext),
 		payload.SealedSenderCertificateID != nil)
 
+	// Reject oversized payloads before they reach the database or inboxes.
+	// Media content itself goes through object storage; only media messages
+	// get the larger allowance.
+	maxSize := h.maxCiphertextSize
+	if payload.MediaID != nil {
+		maxSize = h.maxMediaCiphertextSize
+	}
+	if len(payload.Ciphertext) > maxSize {
+		log.Printf("[MSG] Rejected oversized message from %s: %d bytes (max %d)", msg.SenderID, len(payload.Ciphertext), maxSize)
+		h.sendToUser(msg.SenderID, &models.WebSocketMessage{
+			Type:      models.MessageTypeError,
+			MessageID: msg.MessageID,
+			Timestamp: time.Now().UTC(),
+			Payload:   json.RawMessage(fmt.Sprintf(`{"error": "message_too_large", "max_bytes": %d}`, maxSize)),
+		})
+		return
+	}
+
 	// Step 2: Use client's message_id if provided, otherwise generate new one
-	// This allows clients to correlate status updates with their local messages
+	// This allows clients to correlate status updates with their local messages,
+	// and makes a retried send idempotent (see db.ErrDuplicateMessage)
 	messageID := msg.MessageID
 	if messageID == uuid.Nil {
 		messageID = uuid.New()
 	}
-	timestamp := time.Now().UTC()
+	timestamp := now
+
+	// Direct messages between users where either blocked the other are dropped.
+	// The sender still gets a "sent" ack so the block isn't revealed.
+	if payload.ReceiverID != nil && payload.GroupID == nil {
+		blocked, err := h.areEitherBlocked(msg.SenderID, *payload.ReceiverID)
+		if err != nil {
+			log.Printf("Failed to check block status: %v", err)
+			h.sendErrorToClient(msg.SenderID, "Failed to save message")
+			return
+		}
+		if blocked {
+			log.Printf("[MSG] Dropped message %s: block between sender and recipient", messageID)
+			h.sendToUserAllDevices(msg.SenderID, &models.WebSocketMessage{
+				Type:      models.MessageTypeSentAck,
+				MessageID: messageID,
+				Timestamp: timestamp,
+				Payload:   sentAckPayload(timestamp),
+			}, msg.DeviceID)
+			return
+		}
+	}
 
 	// Handle sealed sender message format
 	var isSealedSender bool
@@ -487,30 +871,67 @@ func (h *Hub) handleSendMessage(msg *models.WebSocketMessage) {
 		}
 	}
 
-	if err := h.db.SaveMessage(dbMessage); err != nil {
+	storeCtx, storeSpan := tracing.Start(ctx, "db.SaveMessage",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL),
+	)
+	err := h.db.SaveMessage(storeCtx, dbMessage)
+	duplicate := errors.Is(err, db.ErrDuplicateMessage)
+	if duplicate {
+		err = nil
+	}
+	tracing.RecordError(storeSpan, err)
+	storeSpan.End()
+	if err != nil {
 		log.Printf("Failed to save message: %v", err)
 		h.sendErrorToClient(msg.SenderID, "Failed to save message")
 		return
 	}
+	if duplicate {
+		// A retry of a send we already stored: ack it again with the
+		// original timestamp rather than storing a second copy. If the first
+		// attempt stopped before routing the message, route it now.
+		timestamp = dbMessage.Timestamp
+		if h.messageRouted(messageID) {
+			log.Printf("[MSG] Duplicate send of message %s from %s, re-acking", messageID, msg.SenderID)
+			h.sendToUserAllDevices(msg.SenderID, &models.WebSocketMessage{
+				Type:      models.MessageTypeSentAck,
+				MessageID: messageID,
+				Timestamp: timestamp,
+				Payload:   sentAckPayload(timestamp),
+			}, msg.DeviceID)
+			return
+		}
+		log.Printf("[MSG] Duplicate send of message %s from %s was never routed, delivering it", messageID, msg.SenderID)
+	}
+	// A first direct message creates a new contact pair; drop stale cached contact sets
+	if payload.ReceiverID != nil && payload.GroupID == nil {
+		h.redis.InvalidateContactsOnNewPartner(msg.SenderID, *payload.ReceiverID)
+	}
+
 	// NOTE: Conversation state is managed CLIENT-SIDE only for security.
 	// Server only stores encrypted message content, not metadata about who talks to whom.
 
-	// Step 3: ACK (status: sent) to sender - all sender's devices
+	// Step 3: ACK (status: sent) to sender - all sender's devices, with the
+	// canonical timestamp clients should display and order by
 	ack := &models.WebSocketMessage{
 		Type:      models.MessageTypeSentAck,
 		MessageID: messageID,
 		Timestamp: timestamp,
-		Payload:   json.RawMessage(`{"status": "sent"}`),
+		Payload:   sentAckPayload(timestamp),
 	}
 	h.sendToUserAllDevices(msg.SenderID, ack, msg.DeviceID)
 
 	// Step 4+5: Where is User B? Route message accordingly
 	if payload.GroupID != nil {
 		// Group message - fan-out to all members
-		h.deliverGroupMessage(dbMessage, &payload, isSealedSender)
+		h.deliverGroupMessage(ctx, dbMessage, &payload, isSealedSender)
 	} else if payload.ReceiverID != nil {
 		// Direct message
-		h.deliverDirectMessage(dbMessage, &payload, isSealedSender)
+		h.deliverDirectMessage(ctx, dbMessage, &payload, isSealedSender)
+	}
+	if err := h.redis.MarkMessageRouted(messageID); err != nil {
+		log.Printf("Warning: failed to mark message %s routed: %v", messageID, err)
 	}
 
 	// Step 10.1: Async processing - enqueue for analytics/archival
@@ -521,13 +942,40 @@ func (h *Hub) handleSendMessage(msg *models.WebSocketMessage) {
 	}()
 }
 
+// messageRouted reports whether an earlier send of the message got as far
+// as delivery. When unsure it says no: recipients drop a message ID they
+// already have, but a message never routed is lost.
+func (h *Hub) messageRouted(messageID uuid.UUID) bool {
+	routed, err := h.redis.IsMessageRouted(messageID)
+	if err != nil {
+		log.Printf("Warning: failed to check whether message %s was routed: %v", messageID, err)
+		return false
+	}
+	if routed {
+		return true
+	}
+	stored, err := h.db.GetMessage(messageID)
+	return err == nil && stored.Status != "sent"
+}
+
+// sentAckPayload is the payload of a sent_ack carrying the server timestamp
+func sentAckPayload(timestamp time.Time) json.RawMessage {
+	return mustMarshal(map[string]interface{}{
+		"status":           "sent",
+		"server_timestamp": timestamp,
+	})
+}
+
 // deliverDirectMessage implements cross-server message delivery
-func (h *Hub) deliverDirectMessage(msg *db.Message, payload *models.EncryptedMessage, isSealedSender bool) {
+func (h *Hub) deliverDirectMessage(ctx context.Context, msg *db.Message, payload *models.EncryptedMessage, isSealedSender bool) {
 	recipientID := *payload.ReceiverID
+	ctx, span := tracing.Start(ctx, "hub.deliverDirectMessage",
+		trace.WithAttributes(attribute.String("message.id", msg.MessageID.String())))
+	defer span.End()
 	log.Printf("[Deliver] deliverDirectMessage: to=%s, msg_id=%s", recipientID, msg.MessageID)
 
 	// Check if recipient is online (Step 4: Where is User B?)
-	isOnline, serverIDs := h.redis.GetUserConnectionInfo(recipientID)
+	isOnline, serverIDs := h.locateUser(recipientID)
 	log.Printf("[Deliver] Recipient online=%v, servers=%v", isOnline, serverIDs)
 
 	deliveryMsg := &models.WebSocketMessage{
@@ -561,12 +1009,12 @@ func (h *Hub) deliverDirectMessage(msg *db.Message, payload *models.EncryptedMes
 		if onThisServer && len(localClients) > 0 {
 			// Deliver locally to all recipient's devices
 			deliveredCount := 0
+			data := mustMarshal(deliveryMsg)
 			for client := range localClients {
-				select {
-				case client.send <- mustMarshal(deliveryMsg):
+				if h.deliverToClient(client, deliveryMsg, data, deliveryPathLocal) {
 					deliveredCount++
 					log.Printf("[Deliver] Message delivered to device=%s", client.DeviceID)
-				default:
+				} else {
 					log.Printf("[Deliver] Warning: Client buffer full, unregistering device=%s", client.DeviceID)
 					go h.unregisterClient(client)
 				}
@@ -576,15 +1024,27 @@ func (h *Hub) deliverDirectMessage(msg *db.Message, payload *models.EncryptedMes
 
 		// Also publish to Redis for any other servers the user is connected to
 		// This handles multi-device across servers (User A's Tablet on Server C, etc.)
+		reached, publishFailed := onThisServer && len(localClients) > 0, false
 		for _, serverID := range serverIDs {
 			if serverID != h.serverID {
-				if err := h.redis.PublishToServer(serverID, recipientID, deliveryMsg); err != nil {
+				if err := h.publishToServer(ctx, serverID, recipientID, deliveryMsg); err != nil {
 					log.Printf("Warning: failed to publish to server %s: %v", serverID, err)
+					publishFailed = true
+				} else {
+					reached = true
 				}
 			}
 		}
+
+		// No server could be reached (e.g. the publish breaker is open):
+		// keep the message for the recipient's next connect instead
+		if publishFailed && !reached {
+			span.SetAttributes(attribute.Bool("recipient.publish_failed", true))
+			h.handleOfflineDelivery(recipientID, msg, payload)
+		}
 	} else {
 		// User B is offline - use offline flow
+		span.SetAttributes(attribute.Bool("recipient.offline", true))
 		h.handleOfflineDelivery(recipientID, msg, payload)
 	}
 }
@@ -610,7 +1070,7 @@ func (h *Hub) handleOfflineDelivery(userID uuid.UUID, msg *db.Message, _ *models
 	// Step 3.2: Store message in inbox table (already done in SaveMessage)
 
 	// Step 3.3: Send push notification for User B
-	h.redis.PublishNotification(userID, map[string]interface{}{
+	h.publishNotificationWithBadge(userID, map[string]interface{}{
 		"type":       "new_message",
 		"message_id": msg.MessageID,
 		"sender_id":  msg.SenderID,
@@ -626,33 +1086,56 @@ func (h *Hub) handleOfflineDelivery(userID uuid.UUID, msg *db.Message, _ *models
 }
 
 // deliverGroupMessage implements "Group Message Fan-Out (50-person group)"
-func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMessage, isSealedSender bool) {
+func (h *Hub) deliverGroupMessage(ctx context.Context, msg *db.Message, payload *models.EncryptedMessage, isSealedSender bool) {
 	groupID := *payload.GroupID
+	ctx, span := tracing.Start(ctx, "hub.deliverGroupMessage",
+		trace.WithAttributes(attribute.String("message.id", msg.MessageID.String())))
+	defer span.End()
 
 	// Step 2+3: Who's in group? Get all members
-	members, err := h.db.GetGroupMembers(groupID)
+	members, err := h.groupMemberIDs(ctx, groupID)
 	if err != nil {
 		log.Printf("Failed to get group members: %v", err)
 		return
 	}
 
+	// Large groups are fanned out by a queue worker so the hub loop isn't
+	// blocked; the sender already has its "sent" ack
+	if h.groupFanoutThreshold > 0 && len(members) > h.groupFanoutThreshold {
+		err := h.enqueueGroupFanout(msg, payload, isSealedSender)
+		if err == nil {
+			span.SetAttributes(attribute.Bool("group.queued_fanout", true))
+			return
+		}
+		log.Printf("Warning: failed to enqueue group fan-out, delivering inline: %v", err)
+	}
+
+	h.fanOutGroupMessage(ctx, msg, payload, members, isSealedSender)
+}
+
+// fanOutGroupMessage delivers a group message to every member but the sender:
+// directly or via Redis for online members, to inboxes for offline ones
+func (h *Hub) fanOutGroupMessage(ctx context.Context, msg *db.Message, payload *models.EncryptedMessage, members []uuid.UUID, isSealedSender bool) {
+	groupID := *payload.GroupID
+	span := trace.SpanFromContext(ctx)
+
 	// Step 4+5: Check status of all users
-	onlineMembers := make([]db.GroupMember, 0)
-	offlineMembers := make([]db.GroupMember, 0)
+	onlineMembers := make([]uuid.UUID, 0)
+	offlineMembers := make([]uuid.UUID, 0)
 	serverGroups := make(map[string][]uuid.UUID) // serverID -> userIDs
 
 	for _, member := range members {
-		if member.UserID == msg.SenderID {
+		if member == msg.SenderID {
 			continue // Don't send to self
 		}
 
-		isOnline, serverIDs := h.redis.GetUserConnectionInfo(member.UserID)
+		isOnline, serverIDs := h.locateUser(member)
 
 		if isOnline && len(serverIDs) > 0 {
 			onlineMembers = append(onlineMembers, member)
 			// Group by server for parallel delivery
 			for _, serverID := range serverIDs {
-				serverGroups[serverID] = append(serverGroups[serverID], member.UserID)
+				serverGroups[serverID] = append(serverGroups[serverID], member)
 			}
 		} else {
 			offlineMembers = append(offlineMembers, member)
@@ -660,6 +1143,10 @@ func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMess
 	}
 
 	log.Printf("[Group] Fan-out: %d online, %d offline", len(onlineMembers), len(offlineMembers))
+	span.SetAttributes(
+		attribute.Int("group.online_members", len(onlineMembers)),
+		attribute.Int("group.offline_members", len(offlineMembers)),
+	)
 
 	// Step 6: For online users - parallel delivery to each server
 	deliveryMsg := &models.WebSocketMessage{
@@ -675,16 +1162,18 @@ func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMess
 		deliveryMsg.SenderID = uuid.Nil // Hide sender from server
 	}
 
+	reached := make(map[uuid.UUID]bool)     // delivered locally or published for
+	unpublished := make(map[uuid.UUID]bool) // a publish to one of their servers failed
 	for serverID, userIDs := range serverGroups {
 		if serverID == h.serverID {
 			// Deliver locally
+			data := mustMarshal(deliveryMsg)
 			h.mu.RLock()
 			for _, userID := range userIDs {
 				if clients, ok := h.clients[userID]; ok {
+					reached[userID] = true
 					for client := range clients {
-						select {
-						case client.send <- mustMarshal(deliveryMsg):
-						default:
+						if !h.deliverToClient(client, deliveryMsg, data, deliveryPathLocal) {
 							go h.unregisterClient(client)
 						}
 					}
@@ -694,20 +1183,26 @@ func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMess
 		} else {
 			// Route to other server via Redis
 			for _, userID := range userIDs {
-				if err := h.redis.PublishToServer(serverID, userID, deliveryMsg); err != nil {
+				if err := h.publishToServer(ctx, serverID, userID, deliveryMsg); err != nil {
 					log.Printf("Warning: failed to publish to server %s: %v", serverID, err)
+					unpublished[userID] = true
+				} else {
+					reached[userID] = true
 				}
 			}
 		}
 	}
 
-	// Step 7: For offline users - store for later delivery
-	if len(offlineMembers) > 0 {
-		offlineUserIDs := make([]uuid.UUID, len(offlineMembers))
-		for i, m := range offlineMembers {
-			offlineUserIDs[i] = m.UserID
+	// Members no server could be reached for get the message via their
+	// inbox instead, like offline members
+	for userID := range unpublished {
+		if !reached[userID] {
+			offlineMembers = append(offlineMembers, userID)
 		}
+	}
 
+	// Step 7: For offline users - store for later delivery
+	if len(offlineMembers) > 0 {
 		// Step 7.1+7.2: Write to inboxes (ZADD) and inbox records
 		inboxMsg := &inbox.InboxMessage{
 			MessageID:   msg.MessageID,
@@ -718,13 +1213,13 @@ func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMess
 			Timestamp:   msg.Timestamp,
 		}
 
-		if err := h.inbox.AddMultipleToInbox(offlineUserIDs, inboxMsg); err != nil {
+		if err := h.inbox.AddMultipleToInbox(offlineMembers, inboxMsg); err != nil {
 			log.Printf("Failed to add message to offline inboxes: %v", err)
 		}
 
 		// Step 7.3: Send push notifications to offline users
-		for _, userID := range offlineUserIDs {
-			h.redis.PublishNotification(userID, map[string]interface{}{
+		for _, userID := range offlineMembers {
+			h.publishNotificationWithBadge(userID, map[string]interface{}{
 				"type":       "new_group_message",
 				"message_id": msg.MessageID,
 				"group_id":   groupID,
@@ -748,24 +1243,45 @@ func (h *Hub) deliverGroupMessage(msg *db.Message, payload *models.EncryptedMess
 	h.sendToUser(msg.SenderID, statusUpdate)
 }
 
-// deliverPendingMessages implements "User B comes online" flow
+// deliverPendingMessages implements "User B comes online" flow. The inbox is
+// sent one chunk at a time and a chunk is removed only once the client has
+// acked all of it (see inboxdrain.go), so a connection that drops mid-drain
+// loses nothing and gets the unacked rest on its next connect.
 func (h *Hub) deliverPendingMessages(client *Client) {
-	// Step 5.2: Retrieve pending messages from inbox (ZSET)
-	messages, err := h.inbox.GetPendingMessages(client.UserID)
+	if !client.beginInboxChunk() {
+		return
+	}
+	defer func() {
+		// Nothing was sent, or every message was acked before the last one was
+		if acked, done := client.sealInboxChunk(); done {
+			h.finishInboxChunk(client, acked)
+			if len(acked) > 0 {
+				go h.deliverPendingMessages(client)
+			}
+		}
+	}()
+
+	// Step 5.2: Retrieve the next chunk of pending messages from inbox (ZSET)
+	messages, err := h.inbox.GetPendingDeviceMessages(client.UserID, client.DeviceID, inboxChunkSize)
 	if err != nil {
 		log.Printf("Failed to fetch pending messages: %v", err)
 		return
 	}
 
-	if len(messages) == 0 {
+	// Messages evicted by the inbox cap are only in the database now
+	evicted, err := h.inbox.GetEvictedCount(client.UserID)
+	if err != nil {
+		log.Printf("Warning: failed to read inbox eviction count: %v", err)
+	}
+
+	if len(messages) == 0 && evicted == 0 {
 		return
 	}
 
 	log.Printf("[Deliver] Delivering %d pending messages to user %s", len(messages), client.UserID)
+	defer h.sendInboxStatus(client, evicted)
 
-	deliveredIDs := make([]uuid.UUID, 0, len(messages))
-
-	// Step 5.4: Deliver all pending messages
+	// Step 5.4: Deliver the chunk
 	for _, msg := range messages {
 		deliveryMsg := &models.WebSocketMessage{
 			Type:      models.MessageTypeDeliver,
@@ -781,34 +1297,92 @@ func (h *Hub) deliverPendingMessages(client *Client) {
 			}),
 		}
 
+		// Stop at a full window; the rest stay in the inbox until acks free it
+		if !client.reserveInboxDelivery(deliveryMsg, msg.Attempts, h.maxInFlight) {
+			break
+		}
+
 		select {
 		case client.send <- mustMarshal(deliveryMsg):
-			deliveredIDs = append(deliveredIDs, msg.MessageID)
+			recordOfflineDeliveryLatency(msg)
 		default:
+			client.releaseDelivery(msg.MessageID, h.maxInFlight)
+			client.dropInboxDelivery(msg.MessageID)
 			return
 		}
 	}
+}
+
+// sendInboxStatus tells the client how many messages are still queued and
+// whether older ones were evicted, in which case it must resync from the server
+func (h *Hub) sendInboxStatus(client *Client, evicted int64) {
+	remaining, err := h.inbox.GetPendingCount(client.UserID)
+	if err != nil {
+		log.Printf("Warning: failed to read inbox size: %v", err)
+	}
 
-	// Remove delivered messages from inbox
-	if len(deliveredIDs) > 0 {
-		if err := h.inbox.RemoveFromInbox(client.UserID, deliveredIDs); err != nil {
-			log.Printf("Warning: failed to remove from inbox: %v", err)
+	status := &models.WebSocketMessage{
+		Type:      models.MessageTypeInboxStatus,
+		Timestamp: time.Now().UTC(),
+		Payload: mustMarshal(map[string]interface{}{
+			"remaining":       remaining,
+			"evicted":         evicted,
+			"resync_required": evicted > 0,
+		}),
+	}
+
+	select {
+	case client.send <- mustMarshal(status):
+		if err := h.inbox.AckEvicted(client.UserID, evicted); err != nil {
+			log.Printf("Warning: failed to clear inbox eviction count: %v", err)
 		}
+	default:
+		// Buffer full; the eviction flag stays set for the next connection
 	}
 }
 
-func (h *Hub) handleDeliveryAck(msg *models.WebSocketMessage) {
+// deliveryAckPayload is the optional body of a delivery_ack. A silent ack
+// confirms receipt for flow control and redelivery only: the message isn't
+// marked delivered and the sender gets no receipt. Clients send it for
+// messages they don't acknowledge to the sender, such as ones from a
+// conversation not yet accepted or ones they failed to decrypt.
+type deliveryAckPayload struct {
+	Silent bool `json:"silent,omitempty"`
+}
+
+func (h *Hub) handleDeliveryAck(client *Client, msg *models.WebSocketMessage) {
 	// Step 7: Delivery ACK received from recipient
-	now := time.Now().UTC()
-	if err := h.db.UpdateMessageStatus(msg.MessageID, "delivered", now); err != nil {
-		log.Printf("Warning: failed to update message status: %v", err)
+	h.resumeDelivery(client, msg.MessageID)
+
+	var ack deliveryAckPayload
+	if len(msg.Payload) > 0 {
+		if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+			log.Printf("Warning: invalid delivery_ack payload: %v", err)
+		}
+	}
+	if ack.Silent {
+		return
 	}
 
-	// Step 8: Forward delivery ACK to sender
 	message, err := h.db.GetMessage(msg.MessageID)
 	if err != nil {
 		return
 	}
+	if !h.authorizeStatusChange(msg.SenderID, message, "delivered") {
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := h.db.UpdateMessageStatus(msg.MessageID, "delivered", now); err != nil {
+		log.Printf("Warning: failed to update message status: %v", err)
+	}
+	go func() {
+		if err := h.queue.EnqueueDeliveryStatus(msg.MessageID, "delivered"); err != nil {
+			log.Printf("Warning: failed to enqueue delivery status: %v", err)
+		}
+	}()
+
+	// Step 8: Forward delivery ACK to sender
 
 	// Step 9: Status update (delivered) to sender
 	statusUpdate := &models.WebSocketMessage{
@@ -835,27 +1409,162 @@ func (h *Hub) handleReadReceipt(msg *models.WebSocketMessage) {
 	log.Printf("[ReadReceipt] Processing %d read receipts", len(payload.MessageIDs))
 
 	now := time.Now().UTC()
+	messages := make([]*db.Message, 0, len(payload.MessageIDs))
 	for _, messageID := range payload.MessageIDs {
+		message, err := h.db.GetMessage(messageID)
+		if err != nil {
+			log.Printf("[ReadReceipt] Could not fetch message %s: %v", messageID, err)
+			continue
+		}
+		if !h.authorizeStatusChange(msg.SenderID, message, "read") {
+			continue
+		}
+
 		log.Printf("[ReadReceipt] Marking message %s as read", messageID)
 		if err := h.db.UpdateMessageStatus(messageID, "read", now); err != nil {
 			log.Printf("Warning: failed to update message status: %v", err)
 		}
+		if err := h.queue.EnqueueDeliveryStatus(messageID, "read"); err != nil {
+			log.Printf("Warning: failed to enqueue read status: %v", err)
+		}
 
-		message, err := h.db.GetMessage(messageID)
-		if err != nil {
-			log.Printf("[ReadReceipt] Could not fetch message %s: %v", messageID, err)
-			continue
+		// Group messages share one status row; track each member's read state separately
+		if message.GroupID != nil {
+			if err := h.db.MarkGroupMessageRead(messageID, msg.SenderID, now); err != nil {
+				log.Printf("Warning: failed to record group read receipt: %v", err)
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	h.sendReceipts(msg.SenderID, messages, "read", now)
+
+	// Reader's unread counts changed; update their other devices and OS badge
+	if len(messages) > 0 {
+		h.syncUnreadCounts(msg.SenderID)
+	}
+}
+
+// authorizeStatusChange reports whether userID may mark the message delivered
+// or read: only its receiver, or a group member other than the sender, can.
+// Anything else (e.g. a sender faking receipts on their own message) is
+// audited and rejected.
+func (h *Hub) authorizeStatusChange(userID uuid.UUID, message *db.Message, status string) bool {
+	recipient := false
+	if message.SenderID != userID {
+		if message.GroupID != nil {
+			isMember, err := h.db.IsGroupMember(*message.GroupID, userID)
+			if err != nil {
+				log.Printf("Warning: failed to check group membership: %v", err)
+				return false
+			}
+			recipient = isMember
+		} else {
+			recipient = message.ReceiverID != nil && *message.ReceiverID == userID
+		}
+	}
+	if recipient {
+		return true
+	}
+
+	log.Printf("SECURITY: User %s tried to mark message %s %s without being a recipient", userID, message.MessageID, status)
+	if h.auditLogger != nil {
+		h.auditLogger.LogSecurityEvent(context.Background(), security.AuditEventInvalidRequest,
+			security.AuditResultDenied, &userID,
+			"Message status change by non-recipient rejected", map[string]any{
+				"message_id": message.MessageID,
+				"status":     status,
+			})
+	}
+	return false
+}
+
+// NotifyMessageStatus does the bookkeeping of the WebSocket acks for
+// messages marked delivered or read over HTTP by one of the reader's devices.
+// Delivered messages are released from that device's in-flight window and
+// leave its inbox, so the ack sweep doesn't redeliver them. Receipts go out,
+// and unread counts are refreshed, only for the messages whose status
+// changed (see db.UpdateMessageStatuses).
+func (h *Hub) NotifyMessageStatus(readerID, deviceID uuid.UUID, messageIDs []uuid.UUID, changed []*db.Message, status string) {
+	if status == "delivered" && len(messageIDs) > 0 {
+		h.releaseDeliveries(readerID, deviceID, messageIDs)
+		if err := h.inbox.RemoveFromDeviceInbox(readerID, deviceID, messageIDs); err != nil {
+			log.Printf("Warning: failed to remove from inbox: %v", err)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	go func() {
+		for _, message := range changed {
+			if err := h.queue.EnqueueDeliveryStatus(message.MessageID, status); err != nil {
+				log.Printf("Warning: failed to enqueue %s status: %v", status, err)
+			}
+		}
+	}()
+
+	h.sendReceipts(readerID, changed, status, time.Now().UTC())
+
+	if status == "read" {
+		h.syncUnreadCounts(readerID)
+	}
+}
+
+// releaseDeliveries frees the in-flight slots a device's local connection
+// holds for messageIDs, as a delivery_ack would
+func (h *Hub) releaseDeliveries(userID, deviceID uuid.UUID, messageIDs []uuid.UUID) {
+	h.mu.RLock()
+	var client *Client
+	for c := range h.clients[userID] {
+		if c.DeviceID == deviceID {
+			client = c
+			break
+		}
+	}
+	h.mu.RUnlock()
+	if client == nil {
+		return
+	}
+
+	for _, messageID := range messageIDs {
+		if client.hasInFlight(messageID) {
+			h.resumeDelivery(client, messageID)
+		}
+	}
+}
+
+// sendReceipts sends each message's sender a status_update. Read receipts are
+// reciprocal: they are only sent when both the reader and the sender have
+// show_read_receipts on; otherwise the sender just sees "delivered". Messages
+// are still marked read for the reader's unread counts.
+func (h *Hub) sendReceipts(readerID uuid.UUID, messages []*db.Message, status string, now time.Time) {
+	readerShares := status != "read" || h.privacySettingEnabled(readerID, "show_read_receipts")
+	senderShares := make(map[uuid.UUID]bool)
+
+	for _, message := range messages {
+		sent := status
+		if status == "read" {
+			shares, ok := senderShares[message.SenderID]
+			if !ok {
+				shares = h.privacySettingEnabled(message.SenderID, "show_read_receipts")
+				senderShares[message.SenderID] = shares
+			}
+			if !readerShares || !shares {
+				sent = "delivered"
+			}
 		}
 
 		statusUpdate := &models.WebSocketMessage{
 			Type:      models.MessageTypeStatusUpdate,
-			MessageID: messageID,
+			MessageID: message.MessageID,
 			Timestamp: now,
-			Payload:   json.RawMessage(`{"status": "read"}`),
+			Payload:   mustMarshal(map[string]string{"status": sent}),
 		}
 
-		log.Printf("[ReadReceipt] Sending status_update (read) to sender %s for message %s", message.SenderID, messageID)
-		// Also sync read status to sender's other devices
+		log.Printf("[ReadReceipt] Sending status_update (%s) to sender %s for message %s", sent, message.SenderID, message.MessageID)
+		// Also sync status to sender's other devices
 		h.sendToUserAllDevices(message.SenderID, statusUpdate, uuid.Nil)
 	}
 }
@@ -870,6 +1579,12 @@ func (h *Hub) handleTypingIndicator(msg *models.WebSocketMessage) {
 		return
 	}
 
+	// Typing indicators are reciprocal: users with show_typing_indicator off
+	// neither send nor receive them
+	if !h.privacySettingEnabled(msg.SenderID, "show_typing_indicator") {
+		return
+	}
+
 	typingMsg := &models.WebSocketMessage{
 		Type:      models.MessageTypeTyping,
 		SenderID:  msg.SenderID,
@@ -878,19 +1593,52 @@ func (h *Hub) handleTypingIndicator(msg *models.WebSocketMessage) {
 	}
 
 	if payload.GroupID != nil {
-		members, err := h.db.GetGroupMembers(*payload.GroupID)
+		members, err := h.groupMemberIDs(context.Background(), *payload.GroupID)
 		if err != nil {
 			log.Printf("[Typing] Failed to get group members: %v", err)
 			return
 		}
+		memberIDs := make([]uuid.UUID, 0, len(members))
 		for _, member := range members {
-			if member.UserID != msg.SenderID {
-				h.sendToUser(member.UserID, typingMsg)
+			if member != msg.SenderID {
+				memberIDs = append(memberIDs, member)
+			}
+		}
+		hidden, err := h.usersHidingTypingIndicator(memberIDs)
+		if err != nil {
+			log.Printf("[Typing] Failed to get privacy settings: %v", err)
+			return
+		}
+		for _, memberID := range memberIDs {
+			if !hidden[memberID] {
+				h.sendToUser(memberID, typingMsg)
 			}
 		}
 	} else if payload.ReceiverID != nil {
-		h.sendToUser(*payload.ReceiverID, typingMsg)
+		if h.privacySettingEnabled(*payload.ReceiverID, "show_typing_indicator") {
+			h.sendToUser(*payload.ReceiverID, typingMsg)
+		}
+	}
+}
+
+// activityRefreshInterval throttles registry refreshes from regular traffic;
+// well under the 2 minute connection/presence TTL
+const activityRefreshInterval = 30 * time.Second
+
+// refreshActivity re-registers the client's connection and presence so the
+// entries cannot expire while the client is actively sending messages.
+// RegisterConnection is used rather than a TTL bump so an already-expired
+// entry is restored.
+func (h *Hub) refreshActivity(client *Client) {
+	now := time.Now()
+	if now.Sub(client.lastActivityRefresh) < activityRefreshInterval {
+		return
 	}
+	client.lastActivityRefresh = now
+
+	h.redis.RegisterConnection(client.UserID, h.serverID, client.DeviceID)
+	h.redis.UpdateLastActive(client.UserID)
+	h.redis.SetUserPresence(client.UserID, true)
 }
 
 func (h *Hub) handleHeartbeat(msg *models.WebSocketMessage) {
@@ -911,8 +1659,34 @@ func (h *Hub) handleHeartbeat(msg *models.WebSocketMessage) {
 	h.sendToUser(msg.SenderID, ack)
 }
 
+// handlePresenceStatus sets the sender's away/dnd/online status, syncs it to
+// their other devices and tells contacts. DND also mutes push notifications.
+func (h *Hub) handlePresenceStatus(msg *models.WebSocketMessage) {
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || !models.IsSettablePresenceStatus(payload.Status) {
+		h.sendErrorToClient(msg.SenderID, "Invalid presence status")
+		return
+	}
+
+	if err := h.redis.SetPresenceStatus(msg.SenderID, payload.Status); err != nil {
+		log.Printf("Warning: failed to set presence status for %s: %v", msg.SenderID, err)
+		h.sendErrorToClient(msg.SenderID, "Failed to set presence status")
+		return
+	}
+
+	h.sendToUserAllDevices(msg.SenderID, &models.WebSocketMessage{
+		Type:      models.MessageTypePresenceStatus,
+		Timestamp: time.Now().UTC(),
+		Payload:   mustMarshal(map[string]string{"status": payload.Status}),
+	}, msg.DeviceID)
+
+	go h.broadcastPresenceUpdate(msg.SenderID, true)
+}
+
 // handleCallSignaling forwards WebRTC signaling messages between peers
-func (h *Hub) handleCallSignaling(msg *models.WebSocketMessage) {
+func (h *Hub) handleCallSignaling(ctx context.Context, msg *models.WebSocketMessage) {
 	// Parse the payload to get the recipient
 	// Accept both target_id (frontend) and recipient_id (legacy) for compatibility
 	var payload struct {
@@ -941,6 +1715,13 @@ func (h *Hub) handleCallSignaling(msg *models.WebSocketMessage) {
 
 	log.Printf("[Call] Signaling: type=%s, from=%s, to=%s", msg.Type, msg.SenderID, recipientID)
 
+	// Treat a blocked pair like an offline recipient so the block isn't revealed
+	blocked, err := h.areEitherBlocked(msg.SenderID, recipientID)
+	if err != nil {
+		log.Printf("[Call] Failed to check block status: %v", err)
+		return
+	}
+
 	// Forward the message to the recipient with sender info
 	forwardMsg := &models.WebSocketMessage{
 		Type:      msg.Type,
@@ -950,9 +1731,9 @@ func (h *Hub) handleCallSignaling(msg *models.WebSocketMessage) {
 	}
 
 	// Check if recipient is online
-	isOnline, serverIDs := h.redis.GetUserConnectionInfo(recipientID)
+	isOnline, serverIDs := h.locateUser(recipientID)
 
-	if !isOnline || len(serverIDs) == 0 {
+	if blocked || !isOnline || len(serverIDs) == 0 {
 		// Recipient is offline - send busy signal back to caller
 		log.Printf("[Call] Recipient offline, sending busy signal")
 		busyMsg := &models.WebSocketMessage{
@@ -984,7 +1765,7 @@ func (h *Hub) handleCallSignaling(msg *models.WebSocketMessage) {
 	// Also publish to Redis for other servers
 	for _, serverID := range serverIDs {
 		if serverID != h.serverID {
-			if err := h.redis.PublishToServer(serverID, recipientID, forwardMsg); err != nil {
+			if err := h.publishToServer(ctx, serverID, recipientID, forwardMsg); err != nil {
 				log.Printf("Warning: failed to publish to server %s: %v", serverID, err)
 			}
 		}
@@ -1045,7 +1826,7 @@ func (h *Hub) handleDeviceSync(msg *models.WebSocketMessage) {
 
 // handleMediaKey forwards encrypted media keys between clients
 // The server CANNOT read the encrypted key - it's E2EE between clients
-func (h *Hub) handleMediaKey(msg *models.WebSocketMessage) {
+func (h *Hub) handleMediaKey(ctx context.Context, msg *models.WebSocketMessage) {
 	// Parse the payload to get the recipient
 	var payload struct {
 		MediaID      uuid.UUID `json:"media_id"`
@@ -1072,7 +1853,7 @@ func (h *Hub) handleMediaKey(msg *models.WebSocketMessage) {
 	}
 
 	// Check if recipient is online
-	isOnline, serverIDs := h.redis.GetUserConnectionInfo(payload.RecipientID)
+	isOnline, serverIDs := h.locateUser(payload.RecipientID)
 
 	if !isOnline || len(serverIDs) == 0 {
 		log.Printf("[MediaKey] Recipient offline, cannot deliver media key for media %s", payload.MediaID)
@@ -1099,7 +1880,7 @@ func (h *Hub) handleMediaKey(msg *models.WebSocketMessage) {
 	// Also publish to Redis for other servers
 	for _, serverID := range serverIDs {
 		if serverID != h.serverID {
-			if err := h.redis.PublishToServer(serverID, payload.RecipientID, forwardMsg); err != nil {
+			if err := h.publishToServer(ctx, serverID, payload.RecipientID, forwardMsg); err != nil {
 				log.Printf("Warning: failed to publish to server %s: %v", serverID, err)
 			}
 		}
@@ -1127,6 +1908,9 @@ func (h *Hub) sendToDevice(userID, deviceID uuid.UUID, msg *models.WebSocketMess
 	}
 
 	// If not found locally, publish to Redis for other servers
+	if !h.clusterMode {
+		return
+	}
 	if err := h.redis.PublishToDevice(userID, deviceID, msg); err != nil {
 		log.Printf("Warning: failed to publish to device: %v", err)
 	}
@@ -1148,9 +1932,9 @@ func (h *Hub) sendToUser(userID uuid.UUID, msg *models.WebSocketMessage) {
 				go h.unregisterClient(client)
 			}
 		}
-	} else {
+	} else if h.clusterMode {
 		// User not on this server, publish to Redis
-		if err := h.redis.PublishMessage(userID, msg); err != nil {
+		if err := h.publishToUser(userID, msg); err != nil {
 			log.Printf("Warning: failed to publish message: %v", err)
 		}
 	}
@@ -1177,7 +1961,10 @@ func (h *Hub) sendToUserAllDevices(userID uuid.UUID, msg *models.WebSocketMessag
 	}
 
 	// Also publish to Redis for devices on other servers
-	if err := h.redis.PublishMessage(userID, msg); err != nil {
+	if !h.clusterMode {
+		return
+	}
+	if err := h.publishToUser(userID, msg); err != nil {
 		log.Printf("Warning: failed to publish message: %v", err)
 	}
 }
@@ -1191,6 +1978,110 @@ func (h *Hub) sendErrorToClient(userID uuid.UUID, errorMsg string) {
 	h.sendToUser(userID, errMsg)
 }
 
+// getContacts returns the users who have exchanged direct messages with userID.
+// The contact set is cached in Redis so presence changes don't scan the messages table.
+func (h *Hub) getContacts(userID uuid.UUID) ([]uuid.UUID, error) {
+	if contacts, ok := h.redis.GetCachedContacts(userID); ok {
+		return contacts, nil
+	}
+
+	contacts, err := h.db.GetMessagedUsers(userID)
+	if err != nil {
+		return nil, err
+	}
+	h.redis.CacheContacts(userID, contacts)
+	return contacts, nil
+}
+
+// areEitherBlocked checks if either user has blocked the other. The result is
+// cached briefly in Redis so the send and call paths cost one lookup.
+func (h *Hub) areEitherBlocked(userA, userB uuid.UUID) (bool, error) {
+	if blocked, ok := h.redis.GetCachedBlockStatus(userA, userB); ok {
+		return blocked, nil
+	}
+
+	blocked, err := h.db.AreEitherBlocked(userA, userB)
+	if err != nil {
+		return false, err
+	}
+	h.redis.CacheBlockStatus(userA, userB, blocked)
+	return blocked, nil
+}
+
+// InvalidateBlockStatus drops the cached block status of a pair of users.
+// Called by HTTP handlers after a block or unblock.
+func (h *Hub) InvalidateBlockStatus(userA, userB uuid.UUID) {
+	h.redis.InvalidateBlockStatus(userA, userB)
+}
+
+// privacySettingEnabled reports whether one of the user's boolean privacy
+// settings is on. A setting the user never stored is on, like the column
+// defaults. Settings are cached briefly in Redis, since typing indicators
+// check them on every keystroke burst. Fails closed: if the settings can't
+// be read, the setting is treated as off.
+func (h *Hub) privacySettingEnabled(userID uuid.UUID, setting string) bool {
+	if enabled, ok := h.redis.GetCachedPrivacySettings([]uuid.UUID{userID}, setting)[userID]; ok {
+		return enabled
+	}
+
+	settings, err := h.db.GetPrivacySettings(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get privacy settings for user %s: %v", userID, err)
+		return false
+	}
+	toCache := make(map[string]bool, len(settings)+1)
+	for name, value := range settings {
+		if v, ok := value.(bool); ok {
+			toCache[name] = v
+		}
+	}
+	enabled, ok := toCache[setting]
+	if !ok {
+		// Cache the default too, or every check would miss
+		enabled = true
+		toCache[setting] = enabled
+	}
+	h.redis.CachePrivacySettings(userID, toCache)
+	return enabled
+}
+
+// usersHidingTypingIndicator returns which of userIDs have
+// show_typing_indicator off, reading the database only for users whose
+// setting isn't cached
+func (h *Hub) usersHidingTypingIndicator(userIDs []uuid.UUID) (map[uuid.UUID]bool, error) {
+	const setting = "show_typing_indicator"
+
+	hidden := make(map[uuid.UUID]bool, len(userIDs))
+	cached := h.redis.GetCachedPrivacySettings(userIDs, setting)
+	misses := make([]uuid.UUID, 0)
+	for _, userID := range userIDs {
+		if enabled, ok := cached[userID]; ok {
+			hidden[userID] = !enabled
+		} else {
+			misses = append(misses, userID)
+		}
+	}
+	if len(misses) == 0 {
+		return hidden, nil
+	}
+
+	loaded, err := h.db.GetUsersHidingTypingIndicator(misses)
+	if err != nil {
+		return nil, err
+	}
+	for _, userID := range misses {
+		hidden[userID] = loaded[userID]
+		h.redis.CachePrivacySettings(userID, map[string]bool{setting: !loaded[userID]})
+	}
+	return hidden, nil
+}
+
+// InvalidatePrivacySettings drops a user's cached privacy settings.
+// Called by HTTP handlers after the settings are saved.
+func (h *Hub) InvalidatePrivacySettings(userID uuid.UUID) {
+	h.redis.InvalidatePrivacySettings(userID)
+}
+
 // BroadcastPresenceUpdate is an exported wrapper for broadcastPresenceUpdate
 // Used by HTTP handlers to trigger presence updates (e.g., when privacy settings change)
 func (h *Hub) BroadcastPresenceUpdate(userID uuid.UUID, isOnline bool) {
@@ -1241,6 +2132,9 @@ func (h *Hub) broadcastPresenceUpdate(userID uuid.UUID, isOnline bool) {
 	payload := map[string]interface{}{
 		"user_id": userID.String(),
 	}
+	if msgType == models.MessageTypeUserOnline {
+		payload["status"] = h.redis.GetPresenceStatus(userID)
+	}
 	if includeLastSeen {
 		payload["last_seen"] = time.Now().UTC().Unix()
 	}
@@ -1255,7 +2149,7 @@ func (h *Hub) broadcastPresenceUpdate(userID uuid.UUID, isOnline bool) {
 
 	// Get users who have exchanged messages with this user (contacts only)
 	// This prevents broadcasting presence to all 1M+ users (scalability fix)
-	contacts, err := h.db.GetMessagedUsers(userID)
+	contacts, err := h.getContacts(userID)
 	if err != nil {
 		log.Printf("Failed to get contacts for presence broadcast: %v", err)
 		return
@@ -1292,7 +2186,9 @@ func (h *Hub) broadcastPresenceUpdate(userID uuid.UUID, isOnline bool) {
 
 	// Also publish to Redis for other servers via dedicated presence channel
 	// Include contact list so other servers can filter too
-	h.redis.PublishPresenceUpdate(userID, isOnline, data)
+	if h.clusterMode {
+		h.redis.PublishPresenceUpdate(userID, isOnline, data)
+	}
 }
 
 // BroadcastPresenceFromRedis handles presence updates received from other servers via Redis
@@ -1306,7 +2202,7 @@ func (h *Hub) BroadcastPresenceFromRedis(msg *models.WebSocketMessage) {
 	userID := msg.SenderID
 
 	// Get users who have exchanged messages with this user (contacts only)
-	contacts, err := h.db.GetMessagedUsers(userID)
+	contacts, err := h.getContacts(userID)
 	if err != nil {
 		log.Printf("Failed to get contacts for presence broadcast: %v", err)
 		return
@@ -1342,18 +2238,95 @@ func (h *Hub) BroadcastPresenceFromRedis(msg *models.WebSocketMessage) {
 	}
 }
 
+// publishToServer publishes a message to another server inside a producer span,
+// injecting the span's trace context into the envelope so the receiving server
+// can continue the trace on the other side of Redis pub/sub
+func (h *Hub) publishToServer(ctx context.Context, serverID string, userID uuid.UUID, msg *models.WebSocketMessage) error {
+	if !h.clusterMode {
+		return nil
+	}
+	channel := "server:" + serverID + ":" + userID.String()
+	ctx, span := tracing.Start(ctx, channel+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("redis"),
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(channel),
+		),
+	)
+	defer span.End()
+
+	// Copy so concurrent fan-out to multiple servers doesn't share trace context
+	traced := *msg
+	traced.TraceContext = tracing.Inject(ctx)
+	traced.ServerID = h.serverID
+
+	err := h.redis.PublishToServer(serverID, userID, &traced)
+	tracing.RecordError(span, err)
+	return err
+}
+
+// publishToUser publishes msg on the user's channel for their devices on
+// other servers. Every server receives it, this one included; the origin
+// tag lets DeliverFromRedis skip the echo of what was delivered locally.
+func (h *Hub) publishToUser(userID uuid.UUID, msg *models.WebSocketMessage) error {
+	tagged := *msg
+	tagged.ServerID = h.serverID
+	return h.redis.PublishMessage(userID, &tagged)
+}
+
+// publishRawToUser is publishToUser for an already marshalled event.
+// Subscribers decode every user-channel payload as a WebSocketMessage, so it
+// is decoded here too to carry the origin tag.
+func (h *Hub) publishRawToUser(userID uuid.UUID, data []byte) error {
+	var msg models.WebSocketMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("decode event for publish: %w", err)
+	}
+	return h.publishToUser(userID, &msg)
+}
+
 // DeliverFromRedis handles messages from other servers via Redis pub/sub
 func (h *Hub) DeliverFromRedis(userID uuid.UUID, msg *models.WebSocketMessage) {
+	_, span := tracing.StartConsumer(context.Background(), "hub.DeliverFromRedis", msg.TraceContext,
+		semconv.MessagingSystemKey.String("redis"),
+		semconv.MessagingOperationTypeReceive,
+		attribute.String("server.id", h.serverID),
+	)
+	defer span.End()
+
+	// Our own publish coming back: local devices already have it
+	if msg.ServerID == h.serverID {
+		span.SetAttributes(attribute.Bool("message.echo", true))
+		return
+	}
+
+	// Trace context and origin are server-internal and never forwarded to clients
+	msg.TraceContext = nil
+	msg.ServerID = ""
+
 	h.mu.RLock()
 	clients, ok := h.clients[userID]
 	h.mu.RUnlock()
 
 	if !ok {
+		span.SetAttributes(attribute.Bool("recipient.local", false))
 		return
 	}
+	span.SetAttributes(attribute.Int("recipient.local_devices", len(clients)))
 
 	data := mustMarshal(msg)
 	for client := range clients {
+		if msg.Type == models.MessageTypeDeliver {
+			// Already sent and awaiting its ack, e.g. via another path
+			if client.hasInFlight(msg.MessageID) {
+				continue
+			}
+			if !h.deliverToClient(client, msg, data, deliveryPathCrossServer) {
+				go h.unregisterClient(client)
+			}
+			continue
+		}
 		select {
 		case client.send <- data:
 		default:
@@ -1383,7 +2356,10 @@ func (h *Hub) BroadcastToUser(userID uuid.UUID, payload interface{}) {
 	}
 
 	// Also publish to Redis for clients on other servers
-	if err := h.redis.PublishRaw(userID, data); err != nil {
+	if !h.clusterMode {
+		return
+	}
+	if err := h.publishRawToUser(userID, data); err != nil {
 		log.Printf("Warning: failed to publish raw: %v", err)
 	}
 }
@@ -1417,6 +2393,9 @@ func (h *Hub) SendToDevice(deviceID uuid.UUID, payload interface{}) {
 
 	// If not found locally, publish to Redis with device targeting
 	// Other servers can check if they have this device
+	if !h.clusterMode {
+		return
+	}
 	if err := h.redis.PublishToDeviceRaw(deviceID, data); err != nil {
 		log.Printf("Warning: failed to publish to device: %v", err)
 	}
@@ -1447,18 +2426,69 @@ func (h *Hub) SendToUser(userIDStr string, message *models.WebSocketMessage) {
 	h.mu.RUnlock()
 
 	// Also publish via Redis for cross-server delivery
-	if err := h.redis.PublishRaw(userID, data); err != nil {
+	if !h.clusterMode {
+		return
+	}
+	if err := h.publishRawToUser(userID, data); err != nil {
 		log.Printf("Warning: failed to publish: %v", err)
 	}
 }
 
+// NotifyUser sends a system event to all of a user's devices. If the user has
+// no connection on any server, push is published for the notification
+// service instead.
+func (h *Hub) NotifyUser(userID uuid.UUID, message *models.WebSocketMessage, push map[string]interface{}) {
+	if online, _ := h.locateUser(userID); !online {
+		h.redis.PublishNotification(userID, push)
+		return
+	}
+	h.SendToUser(userID.String(), message)
+}
+
+// DisconnectUser closes all of a user's connections on this server.
+// Called for kick events received via Redis.
+func (h *Hub) DisconnectUser(userID uuid.UUID) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients[userID]))
+	for client := range h.clients[userID] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeWithReason(CloseSignedOut, "signed out")
+	}
+	if len(clients) > 0 {
+		log.Printf("[Kick] Disconnected %d connection(s) for user %s", len(clients), userID)
+	}
+}
+
+// DisconnectDevice closes one device's connection on this server.
+// Called for device kick events received via Redis.
+func (h *Hub) DisconnectDevice(userID, deviceID uuid.UUID) {
+	h.mu.RLock()
+	var target *Client
+	for client := range h.clients[userID] {
+		if client.DeviceID == deviceID {
+			target = client
+			break
+		}
+	}
+	h.mu.RUnlock()
+
+	if target != nil {
+		target.closeWithReason(CloseSignedOut, "signed out")
+		log.Printf("[Kick] Disconnected device %s for user %s", deviceID, userID)
+	}
+}
+
 func (h *Hub) closeAllClients() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	for userID, clients := range h.clients {
 		for client := range clients {
-			close(client.send)
+			client.closeSend(CloseServerShutdown, "server shutting down")
 			h.redis.UnregisterConnection(userID, client.DeviceID)
 		}
 	}
@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/jaydenbeard/messaging-app/internal/inbox"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHub returns a single-node hub backed by an in-memory Redis. Tests
+// that need the database pass one; the rest pass nil and stay off DB paths.
+func newTestHub(t *testing.T, database *db.PostgresDB) *Hub {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redisClient, err := pubsub.NewRedisClient(mr.Addr())
+	require.NoError(t, err)
+
+	h := NewHub("test-server", redisClient, database, strings.Repeat("k", 32), nil)
+	h.SetClusterMode(false)
+	t.Cleanup(func() {
+		h.Shutdown()
+		_ = redisClient.Close()
+	})
+	return h
+}
+
+// newTestClient adds a connection for userID to the hub without a socket:
+// what the hub sends it is read from its send buffer
+func newTestClient(h *Hub, userID uuid.UUID, buffer int) *Client {
+	client := &Client{
+		hub:      h,
+		send:     make(chan []byte, buffer),
+		UserID:   userID,
+		DeviceID: uuid.New(),
+	}
+	h.mu.Lock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]bool)
+	}
+	h.clients[userID][client] = true
+	h.mu.Unlock()
+	return client
+}
+
+func testDeliverMessage() *models.WebSocketMessage {
+	return &models.WebSocketMessage{
+		Type:      models.MessageTypeDeliver,
+		MessageID: uuid.New(),
+		SenderID:  uuid.New(),
+		Timestamp: time.Now().UTC(),
+		Payload: mustMarshal(&models.EncryptedMessage{
+			Ciphertext:  []byte("ciphertext"),
+			MessageType: "text",
+		}),
+	}
+}
+
+// nextFrame returns the next frame of type msgType sent to the client,
+// skipping others, or fails after timeout
+func nextFrame(t *testing.T, client *Client, msgType string, timeout time.Duration) *models.WebSocketMessage {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case data := <-client.send:
+			var msg models.WebSocketMessage
+			require.NoError(t, json.Unmarshal(data, &msg))
+			if msg.Type == msgType {
+				return &msg
+			}
+		case <-deadline:
+			t.Fatalf("no %s frame within %s", msgType, timeout)
+			return nil
+		}
+	}
+}
+
+// expectNoFrame fails if a frame of type msgType is sent within wait
+func expectNoFrame(t *testing.T, client *Client, msgType string, wait time.Duration) {
+	t.Helper()
+	deadline := time.After(wait)
+	for {
+		select {
+		case data := <-client.send:
+			var msg models.WebSocketMessage
+			require.NoError(t, json.Unmarshal(data, &msg))
+			if msg.Type == msgType {
+				t.Fatalf("unexpected %s frame for message %s", msgType, msg.MessageID)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// pendingInbox returns the client's entries in the user's inbox, oldest first
+func pendingInbox(t *testing.T, h *Hub, client *Client) []*inbox.InboxMessage {
+	t.Helper()
+	messages, err := h.inbox.GetPendingDeviceMessages(client.UserID, client.DeviceID, 1000)
+	require.NoError(t, err)
+	return messages
+}
+
+func inboxIDs(messages []*inbox.InboxMessage) []uuid.UUID {
+	ids := make([]uuid.UUID, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.MessageID
+	}
+	return ids
+}
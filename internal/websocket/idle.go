@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultIdleTimeout disconnects clients that send no frames for three
+// missed heartbeats (clients heartbeat every 30s). Pongs don't count:
+// browsers answer pings even when the app itself has stalled.
+const DefaultIdleTimeout = 90 * time.Second
+
+// minIdleSweepInterval bounds how often the sweeper runs for short timeouts
+const minIdleSweepInterval = 5 * time.Second
+
+// SetIdleTimeout sets how long a client may go without sending a frame
+// before it is disconnected. Zero disables the sweeper. Call before Run.
+func (h *Hub) SetIdleTimeout(d time.Duration) {
+	h.idleTimeout = d
+}
+
+// touch records inbound activity; called by the read pump for every frame
+func (c *Client) touch() {
+	c.lastInbound.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long ago the client last sent a frame
+func (c *Client) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastInbound.Load()))
+}
+
+// idleSweepTicker returns a ticker for the hub loop, or nil if idle
+// timeouts are disabled
+func (h *Hub) idleSweepTicker() *time.Ticker {
+	if h.idleTimeout <= 0 {
+		return nil
+	}
+	return time.NewTicker(max(h.idleTimeout/3, minIdleSweepInterval))
+}
+
+// sweepIdleClients closes connections past the idle timeout. The read pump
+// then fails and unregisters the client, which updates routing and presence.
+func (h *Hub) sweepIdleClients() {
+	now := time.Now()
+
+	h.mu.RLock()
+	var idle []*Client
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			if client.idleFor(now) > h.idleTimeout {
+				idle = append(idle, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range idle {
+		log.Printf("[Hub] Disconnecting idle client: user=%s, device=%s, idle=%s",
+			client.UserID, client.DeviceID, client.idleFor(now).Round(time.Second))
+		go client.closeWithReason(CloseIdleTimeout, "idle timeout")
+	}
+}
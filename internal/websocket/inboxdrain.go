@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// inboxChunkSize is how many offline messages are sent per chunk. It is
+// below DefaultMaxInFlight so a whole chunk fits in the delivery window.
+const inboxChunkSize = 32
+
+// inboxChunk is the part of the inbox sent to a client and not yet removed.
+// Messages leave the inbox only once the client has acked the whole chunk,
+// so a connection that drops mid-drain gets the rest on its next connect.
+type inboxChunk struct {
+	pending map[uuid.UUID]struct{} // sent, awaiting delivery_ack
+	acked   []uuid.UUID            // acked, to remove from the inbox
+	sealed  bool                   // every message in the chunk has been sent
+	ended   bool                   // nothing left to ack; closed once acked are removed
+}
+
+// beginInboxChunk starts a chunk. It returns false if one is already in
+// progress, as the ack that completes it starts the next, or the client has
+// disconnected.
+func (c *Client) beginInboxChunk() bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk != nil || c.inboxStopped {
+		return false
+	}
+	c.inboxChunk = &inboxChunk{pending: make(map[uuid.UUID]struct{})}
+	return true
+}
+
+// reserveInboxDelivery is reserveDelivery for a message of the current chunk.
+// The message joins the chunk before it is sent so an ack can't outrun it.
+func (c *Client) reserveInboxDelivery(msg *models.WebSocketMessage, attempts, limit int) bool {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk == nil || c.inboxStopped || !c.reserveDeliveryLocked(msg, attempts, limit) {
+		return false
+	}
+	c.inboxChunk.pending[msg.MessageID] = struct{}{}
+	return true
+}
+
+// dropInboxDelivery takes back a chunk message that could not be sent
+func (c *Client) dropInboxDelivery(messageID uuid.UUID) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk != nil {
+		delete(c.inboxChunk.pending, messageID)
+	}
+}
+
+// sealInboxChunk marks the chunk fully sent. If nothing is left to ack, the
+// chunk ends and the acked IDs are returned with done set.
+func (c *Client) sealInboxChunk() (acked []uuid.UUID, done bool) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk == nil {
+		return nil, false
+	}
+	c.inboxChunk.sealed = true
+	return c.endInboxChunkLocked()
+}
+
+// ackInboxDelivery records an ack for a chunk message. When it was the last
+// one the chunk ends and the acked IDs are returned with done set.
+func (c *Client) ackInboxDelivery(messageID uuid.UUID) (acked []uuid.UUID, done bool) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk == nil {
+		return nil, false
+	}
+	if _, ok := c.inboxChunk.pending[messageID]; !ok {
+		return nil, false
+	}
+	delete(c.inboxChunk.pending, messageID)
+	c.inboxChunk.acked = append(c.inboxChunk.acked, messageID)
+	return c.endInboxChunkLocked()
+}
+
+// endInboxChunkLocked ends a sealed chunk with nothing left pending. The
+// chunk stays in place until finishInboxChunk has removed its acked
+// messages, so a drain starting in between can't send them again. It ends
+// once: whoever gets done must call finishInboxChunk. c.flowMu must be held.
+func (c *Client) endInboxChunkLocked() ([]uuid.UUID, bool) {
+	chunk := c.inboxChunk
+	if chunk.ended || !chunk.sealed || len(chunk.pending) > 0 {
+		return nil, false
+	}
+	chunk.ended = true
+	return chunk.acked, true
+}
+
+// closeInboxChunk drops an ended chunk so the next one can begin
+func (c *Client) closeInboxChunk() {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+
+	if c.inboxChunk != nil && c.inboxChunk.ended {
+		c.inboxChunk = nil
+	}
+}
+
+// finishInboxChunk removes an ended chunk's acked messages from the inbox
+// and closes the chunk. Copies parked for the user's other devices stay
+// until those devices ack.
+func (h *Hub) finishInboxChunk(client *Client, acked []uuid.UUID) {
+	defer client.closeInboxChunk()
+
+	if len(acked) == 0 {
+		return
+	}
+	if err := h.inbox.RemoveFromDeviceInbox(client.UserID, client.DeviceID, acked); err != nil {
+		log.Printf("Warning: failed to remove from inbox: %v", err)
+	}
+}
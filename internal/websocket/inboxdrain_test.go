@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/inbox"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedInbox adds n messages for the client's user, oldest first
+func seedInbox(t *testing.T, h *Hub, client *Client, n int) []uuid.UUID {
+	t.Helper()
+	base := time.Now().UTC().Add(-time.Minute)
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = uuid.New()
+		require.NoError(t, h.inbox.AddToInbox(client.UserID, &inbox.InboxMessage{
+			MessageID:   ids[i],
+			SenderID:    uuid.New(),
+			Ciphertext:  []byte("ciphertext"),
+			MessageType: "text",
+			Timestamp:   base.Add(time.Duration(i) * time.Millisecond),
+		}))
+	}
+	return ids
+}
+
+// receiveDelivers reads n deliver frames and returns their message IDs
+func receiveDelivers(t *testing.T, client *Client, n int) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID
+	}
+	return ids
+}
+
+func ackAll(h *Hub, client *Client, ids []uuid.UUID) {
+	for _, id := range ids {
+		h.resumeDelivery(client, id)
+	}
+}
+
+func TestInboxDrain(t *testing.T) {
+	t.Run("Sends a chunk at a time and removes it once fully acked", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		client := newTestClient(h, uuid.New(), 256)
+		seeded := seedInbox(t, h, client, inboxChunkSize+8)
+
+		h.deliverPendingMessages(client)
+		chunk := receiveDelivers(t, client, inboxChunkSize)
+		assert.Equal(t, seeded[:inboxChunkSize], chunk)
+		status := nextFrame(t, client, models.MessageTypeInboxStatus, time.Second)
+		assert.JSONEq(t, `{"remaining": 40, "evicted": 0, "resync_required": false}`, string(status.Payload))
+
+		// Nothing leaves the inbox before the whole chunk is acked
+		ackAll(h, client, chunk[:inboxChunkSize-1])
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+		assert.Len(t, pendingInbox(t, h, client), len(seeded))
+
+		ackAll(h, client, chunk[inboxChunkSize-1:])
+		rest := receiveDelivers(t, client, 8)
+		assert.Equal(t, seeded[inboxChunkSize:], rest)
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 8 },
+			time.Second, 5*time.Millisecond)
+
+		ackAll(h, client, rest)
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 0 },
+			time.Second, 5*time.Millisecond)
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+	})
+
+	t.Run("Window smaller than a chunk", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetMaxInFlight(5)
+		client := newTestClient(h, uuid.New(), 256)
+		seeded := seedInbox(t, h, client, 8)
+
+		h.deliverPendingMessages(client)
+		first := receiveDelivers(t, client, 5)
+		assert.Equal(t, seeded[:5], first)
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+
+		ackAll(h, client, first)
+		rest := receiveDelivers(t, client, 3)
+		assert.Equal(t, seeded[5:], rest)
+
+		ackAll(h, client, rest)
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 0 },
+			time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Disconnect mid-chunk keeps the unacked rest", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		client := newTestClient(h, uuid.New(), 256)
+		seeded := seedInbox(t, h, client, 4)
+
+		h.deliverPendingMessages(client)
+		chunk := receiveDelivers(t, client, 4)
+		ackAll(h, client, chunk[:2])
+
+		h.requeueOnDisconnect(client)
+		require.Eventually(t, func() bool {
+			ids := inboxIDs(pendingInbox(t, h, client))
+			return len(ids) == 2 && ids[0] == seeded[2] && ids[1] == seeded[3]
+		}, time.Second, 5*time.Millisecond, "acked messages are removed, unacked ones stay")
+
+		// A closed connection starts no further chunks
+		h.deliverPendingMessages(client)
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+	})
+
+	t.Run("Skips entries parked for another device", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		userID := uuid.New()
+		client := newTestClient(h, userID, 256)
+		other := newTestClient(h, userID, 256)
+
+		msg := testDeliverMessage()
+		require.True(t, h.parkDelivery(other, msg, 0))
+		seeded := seedInbox(t, h, client, 1)
+
+		h.deliverPendingMessages(client)
+		assert.Equal(t, seeded, receiveDelivers(t, client, 1))
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+		assert.Contains(t, inboxIDs(pendingInbox(t, h, other)), msg.MessageID)
+	})
+}
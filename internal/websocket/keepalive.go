@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// DefaultPingInterval keeps connections busy enough to survive NAT and proxy
+// idle timeouts, which are commonly 30-60s, independent of app heartbeats
+const DefaultPingInterval = 25 * time.Second
+
+// DefaultPongTimeout is how long a client has to answer a ping before the
+// connection is treated as half-open and closed
+const DefaultPongTimeout = 10 * time.Second
+
+// SetKeepalive sets how often the server pings each connection and how long
+// a pong may take. Both must be positive. Call before Run.
+func (h *Hub) SetKeepalive(pingInterval, pongTimeout time.Duration) {
+	h.pingInterval = pingInterval
+	h.pongTimeout = pongTimeout
+}
+
+// pongDeadline is the read deadline after a pong: the next ping is due in
+// one interval, and its pong may take up to the timeout
+func (h *Hub) pongDeadline() time.Time {
+	return time.Now().Add(h.pingInterval + h.pongTimeout)
+}
+
+// isPongTimeout reports whether a read failed because the pong deadline passed
+func isPongTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
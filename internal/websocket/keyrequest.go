@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/jaydenbeard/messaging-app/internal/security"
+)
+
+// keyRequestsPerMinute caps key_request messages per user. Each one consumes a
+// one-time pre-key, so a tight loop over the socket would drain a contact's.
+const keyRequestsPerMinute = 30
+
+// SetRequireOneTimePrekey makes key_request refuse bundles without a one-time
+// pre-key, as GET /users/{userId}/keys does. Call before Run.
+func (h *Hub) SetRequireOneTimePrekey(require bool) {
+	h.requireOneTimePrekey = require
+}
+
+// handleKeyRequest answers a key_request with the peer's key bundle: identity
+// key, signed pre-key and one one-time pre-key, consumed just as the REST
+// endpoint consumes it. The reply goes to the requesting connection only and
+// carries the request's messageId.
+func (h *Hub) handleKeyRequest(ctx context.Context, client *Client, msg *models.WebSocketMessage) {
+	var payload struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.UserID == uuid.Nil {
+		h.replyKeyRequestError(client, msg, "invalid_user_id", false)
+		return
+	}
+
+	if allowed, err := h.redis.CheckRateLimit("key_request:"+client.UserID.String(), keyRequestsPerMinute, time.Minute); err == nil && !allowed {
+		h.replyKeyRequestError(client, msg, "rate_limited", true)
+		return
+	}
+
+	keys, err := h.db.GetUserKeys(ctx, payload.UserID)
+	if err != nil {
+		h.replyKeyRequestError(client, msg, "user_not_found", false)
+		return
+	}
+
+	if available, _ := keys["prekey_available"].(bool); !available {
+		result := security.AuditResultSuccess
+		if h.requireOneTimePrekey {
+			result = security.AuditResultDenied
+		}
+		if h.auditLogger != nil {
+			h.auditLogger.LogSecurityEvent(ctx, security.AuditEventPrekeysLow, result, &payload.UserID,
+				"One-time pre-keys exhausted; key bundle served without one", map[string]any{
+					"requester_id": client.UserID.String(),
+					"refused":      h.requireOneTimePrekey,
+					"transport":    "websocket",
+				})
+		}
+		if h.requireOneTimePrekey {
+			h.replyKeyRequestError(client, msg, "prekeys_exhausted", true)
+			return
+		}
+	}
+
+	if remaining, ok := keys["prekeys_remaining"].(int); ok {
+		h.redis.NotifyPrekeysLow(payload.UserID, remaining)
+	}
+
+	h.replyKeyRequest(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeKeyBundle,
+		MessageID: msg.MessageID,
+		Timestamp: time.Now().UTC(),
+		Payload:   mustMarshal(keys),
+	})
+}
+
+// replyKeyRequestError sends a key_request failure to the requesting connection
+func (h *Hub) replyKeyRequestError(client *Client, msg *models.WebSocketMessage, reason string, retryable bool) {
+	h.replyKeyRequest(client, &models.WebSocketMessage{
+		Type:      models.MessageTypeError,
+		MessageID: msg.MessageID,
+		Timestamp: time.Now().UTC(),
+		Payload: mustMarshal(map[string]interface{}{
+			"error":     reason,
+			"request":   models.MessageTypeKeyRequest,
+			"retryable": retryable,
+		}),
+	})
+}
+
+func (h *Hub) replyKeyRequest(client *Client, reply *models.WebSocketMessage) {
+	select {
+	case client.send <- mustMarshal(reply):
+	default:
+		log.Printf("Warning: dropped %s reply for user %s: send buffer full", reply.Type, client.UserID)
+	}
+}
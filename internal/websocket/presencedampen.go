@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultPresenceOfflineGrace is how long a user's last connection may be
+// gone before they are marked offline and contacts are told (overridable via
+// SetPresenceOfflineGrace). Mobile clients switching networks usually
+// reconnect well within it.
+const DefaultPresenceOfflineGrace = 5 * time.Second
+
+// OfflineTimers holds one pending "went offline" callback per user, fired
+// once the grace period passes without a Cancel
+type OfflineTimers struct {
+	grace   time.Duration
+	fire    func(userID uuid.UUID)
+	mu      sync.Mutex
+	pending map[uuid.UUID]*pendingOffline
+}
+
+// pendingOffline is a scheduled callback. The timer callback compares
+// entries, not timers, so it never reads a timer being assigned.
+type pendingOffline struct {
+	timer *time.Timer
+}
+
+// NewOfflineTimers creates timers that call fire grace after Schedule. A
+// grace of 0 or less calls fire right away.
+func NewOfflineTimers(grace time.Duration, fire func(userID uuid.UUID)) *OfflineTimers {
+	return &OfflineTimers{
+		grace:   grace,
+		fire:    fire,
+		pending: make(map[uuid.UUID]*pendingOffline),
+	}
+}
+
+// Schedule (re)starts the user's grace period
+func (t *OfflineTimers) Schedule(userID uuid.UUID) {
+	if t.grace <= 0 {
+		go t.fire(userID)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if pending, ok := t.pending[userID]; ok {
+		pending.timer.Stop()
+	}
+	pending := &pendingOffline{}
+	pending.timer = time.AfterFunc(t.grace, func() {
+		t.firePending(userID, pending)
+	})
+	t.pending[userID] = pending
+}
+
+// Cancel drops the user's pending callback. It returns true if one was
+// stopped before firing: the user never went offline for anyone else.
+func (t *OfflineTimers) Cancel(userID uuid.UUID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending, ok := t.pending[userID]
+	if !ok {
+		return false
+	}
+	delete(t.pending, userID)
+	return pending.timer.Stop()
+}
+
+// firePending runs the callback unless it was cancelled or rescheduled while
+// the timer was starting
+func (t *OfflineTimers) firePending(userID uuid.UUID, pending *pendingOffline) {
+	t.mu.Lock()
+	if t.pending[userID] != pending {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.pending, userID)
+	t.mu.Unlock()
+
+	t.fire(userID)
+}
+
+// SetPresenceOfflineGrace sets the offline grace period. 0 marks users
+// offline as soon as their last connection closes. Call before Run.
+func (h *Hub) SetPresenceOfflineGrace(grace time.Duration) {
+	h.offlineTimers = NewOfflineTimers(grace, h.markOffline)
+}
+
+// markOffline stores the user's last seen time and tells contacts they went
+// offline, unless they came back in the meantime, here or on another server.
+// Until then presence still reads online, matching what contacts were told.
+func (h *Hub) markOffline(userID uuid.UUID) {
+	h.mu.RLock()
+	_, local := h.clients[userID]
+	h.mu.RUnlock()
+	if local {
+		return
+	}
+	if connected, _ := h.redis.GetUserConnectionInfo(userID); connected {
+		return
+	}
+	h.redis.SetUserPresence(userID, false)
+	h.broadcastPresenceUpdate(userID, false)
+}
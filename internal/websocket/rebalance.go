@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// Rebalance defaults (overridable via SetRebalance). A server holding more
+// than 125% of the cluster's average connections, and at least 500, asks
+// one in twenty newly registered clients to reconnect so the load balancer
+// can place them elsewhere.
+const (
+	DefaultRebalanceHighWaterPercent = 125
+	DefaultRebalanceHintPercent      = 5
+	DefaultRebalanceMinConnections   = 500
+)
+
+// loadReportInterval is how often each server publishes its connection count
+// and refreshes its view of the cluster average
+const loadReportInterval = 10 * time.Second
+
+// rebalanceDelayMax spreads hinted reconnects over a few seconds so they
+// don't arrive at the load balancer in a burst
+const rebalanceDelayMax = 5 * time.Second
+
+// SetRebalance sets the rebalance high-water mark as a percentage of the
+// cluster's average connection count, the percentage of new connections
+// hinted while above it, and the connection count below which no hints are
+// sent. A zero high-water mark or hint percentage disables hints. Call
+// before Run.
+func (h *Hub) SetRebalance(highWaterPercent, hintPercent, minConnections int) {
+	h.rebalanceHighWaterPercent = highWaterPercent
+	h.rebalanceHintPercent = hintPercent
+	h.rebalanceMinConnections = minConnections
+}
+
+// rebalanceEnabled reports whether this hub compares itself to the cluster.
+// Single-node deploys have nowhere else to send clients.
+func (h *Hub) rebalanceEnabled() bool {
+	return h.clusterMode && h.rebalanceHighWaterPercent > 0 && h.rebalanceHintPercent > 0
+}
+
+// loadReportTicker returns a ticker for the hub loop, or nil if rebalance
+// hints are disabled
+func (h *Hub) loadReportTicker() *time.Ticker {
+	if !h.rebalanceEnabled() {
+		return nil
+	}
+	return time.NewTicker(loadReportInterval)
+}
+
+// reportLoad publishes this server's connection count to Redis and caches
+// the cluster's average for registerClient
+func (h *Hub) reportLoad() {
+	connections := int(atomic.LoadInt32(&h.totalConnections))
+	if err := h.redis.SetServerLoad(h.serverID, connections, 3*loadReportInterval); err != nil {
+		log.Printf("Warning: failed to publish server load: %v", err)
+		return
+	}
+
+	load, err := h.redis.GetClusterLoad()
+	if err != nil {
+		log.Printf("Warning: failed to read cluster load: %v", err)
+		return
+	}
+	if len(load) == 0 {
+		return
+	}
+	total := 0
+	for _, n := range load {
+		total += n
+	}
+	h.clusterAverageLoad.Store(int64(total / len(load)))
+}
+
+// shouldHintRebalance decides whether a newly registered client is asked to
+// reconnect elsewhere. Only a random fraction is hinted while this server is
+// over the high-water mark, so load drains gradually instead of every client
+// stampeding to the next server.
+func (h *Hub) shouldHintRebalance() bool {
+	if !h.rebalanceEnabled() {
+		return false
+	}
+	connections := int64(atomic.LoadInt32(&h.totalConnections))
+	average := h.clusterAverageLoad.Load()
+	if average == 0 || connections < int64(h.rebalanceMinConnections) {
+		return false
+	}
+	if connections*100 <= average*int64(h.rebalanceHighWaterPercent) {
+		return false
+	}
+	return rand.IntN(100) < h.rebalanceHintPercent
+}
+
+// sendRebalanceHint asks the client to close and reconnect after a short
+// random delay. It is only a hint: clients that ignore it keep working.
+func (h *Hub) sendRebalanceHint(client *Client) {
+	hint := &models.WebSocketMessage{
+		Type:      models.MessageTypeRebalanceHint,
+		Timestamp: time.Now().UTC(),
+		Payload: mustMarshal(map[string]interface{}{
+			"reason":             "server_overloaded",
+			"reconnect_after_ms": rand.Int64N(rebalanceDelayMax.Milliseconds()),
+		}),
+	}
+
+	select {
+	case client.send <- mustMarshal(hint):
+		log.Printf("[Rebalance] Hinted client to reconnect: user=%s, device=%s, connections=%d, cluster_average=%d",
+			client.UserID, client.DeviceID, atomic.LoadInt32(&h.totalConnections), h.clusterAverageLoad.Load())
+	default:
+		// Buffer full; the client stays here
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/metrics"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitChunkSealed waits until the client's inbox chunk, if any, has been
+// fully sent, so a sweep sees it as it would between hub ticks
+func waitChunkSealed(t *testing.T, client *Client) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		client.flowMu.Lock()
+		defer client.flowMu.Unlock()
+		return client.inboxChunk == nil || client.inboxChunk.sealed
+	}, time.Second, time.Millisecond)
+}
+
+func TestAckTimeoutRedelivery(t *testing.T) {
+	const ackTimeout = 20 * time.Millisecond
+
+	t.Run("Redelivers until the cap, then drops", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetAckTimeout(ackTimeout)
+		client := newTestClient(h, uuid.New(), 16)
+		exhausted := testutil.ToFloat64(metrics.RedeliveriesExhausted)
+
+		msg := testDeliverMessage()
+		require.True(t, h.deliverToClient(client, msg, mustMarshal(msg), deliveryPathLocal))
+		assert.Equal(t, msg.MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+
+		for attempt := 1; attempt <= MaxRedeliveries; attempt++ {
+			time.Sleep(ackTimeout + 10*time.Millisecond)
+			waitChunkSealed(t, client)
+			h.sweepUnackedDeliveries()
+
+			assert.Equal(t, msg.MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID,
+				"attempt %d", attempt)
+			pending := pendingInbox(t, h, client)
+			require.Len(t, pending, 1)
+			assert.Equal(t, attempt, pending[0].Attempts)
+		}
+
+		time.Sleep(ackTimeout + 10*time.Millisecond)
+		waitChunkSealed(t, client)
+		h.sweepUnackedDeliveries()
+
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 0 },
+			time.Second, 5*time.Millisecond, "the message is dropped after MaxRedeliveries")
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+		assert.Equal(t, exhausted+1, testutil.ToFloat64(metrics.RedeliveriesExhausted))
+	})
+
+	t.Run("Acked deliveries are not redelivered", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetAckTimeout(ackTimeout)
+		client := newTestClient(h, uuid.New(), 16)
+
+		msg := testDeliverMessage()
+		require.True(t, h.deliverToClient(client, msg, mustMarshal(msg), deliveryPathLocal))
+		h.resumeDelivery(client, msg.MessageID)
+
+		time.Sleep(ackTimeout + 10*time.Millisecond)
+		h.sweepUnackedDeliveries()
+		nextFrame(t, client, models.MessageTypeDeliver, time.Second)
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+		assert.Empty(t, pendingInbox(t, h, client))
+	})
+
+	t.Run("Sweep unpauses a client that never acks", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		h.SetAckTimeout(ackTimeout)
+		h.SetMaxInFlight(1)
+		client := newTestClient(h, uuid.New(), 16)
+
+		first, second := testDeliverMessage(), testDeliverMessage()
+		require.True(t, h.deliverToClient(client, first, mustMarshal(first), deliveryPathLocal))
+		require.True(t, h.deliverToClient(client, second, mustMarshal(second), deliveryPathLocal))
+		assert.Equal(t, first.MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		expectNoFrame(t, client, models.MessageTypeDeliver, 50*time.Millisecond)
+
+		time.Sleep(ackTimeout + 10*time.Millisecond)
+		h.sweepUnackedDeliveries()
+
+		// Both are back in the inbox, oldest first; the window lets one through
+		assert.Equal(t, first.MessageID, nextFrame(t, client, models.MessageTypeDeliver, time.Second).MessageID)
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 2 },
+			time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Disconnect parks unacked deliveries", func(t *testing.T) {
+		h := newTestHub(t, nil)
+		client := newTestClient(h, uuid.New(), 16)
+
+		msg := testDeliverMessage()
+		require.True(t, h.deliverToClient(client, msg, mustMarshal(msg), deliveryPathLocal))
+		h.requeueOnDisconnect(client)
+
+		require.Eventually(t, func() bool { return len(pendingInbox(t, h, client)) == 1 },
+			time.Second, 5*time.Millisecond)
+		pending := pendingInbox(t, h, client)
+		assert.Equal(t, msg.MessageID, pending[0].MessageID)
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.False(t, client.hasInFlight(msg.MessageID))
+	})
+}
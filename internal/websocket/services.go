@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/breaker"
+	"github.com/jaydenbeard/messaging-app/internal/services"
+)
+
+// SetInternalServices routes presence and group member lookups through the
+// presence and group services. Either may be nil to keep reading Redis or
+// Postgres directly. Call before Run.
+func (h *Hub) SetInternalServices(presence *services.PresenceClient, groups *services.GroupClient) {
+	h.presenceService = presence
+	h.groupService = groups
+}
+
+// serviceLookupTimeout bounds each lookup through a service, however many
+// calls it takes. These run on the hub loop, so past it the direct read is
+// the quicker answer.
+const serviceLookupTimeout = 300 * time.Millisecond
+
+// groupMemberIDs returns the user IDs of a group's members, from the group
+// service if there is one and it answers, otherwise from the database
+func (h *Hub) groupMemberIDs(ctx context.Context, groupID uuid.UUID) ([]uuid.UUID, error) {
+	if h.groupService != nil {
+		callCtx, cancel := context.WithTimeout(ctx, serviceLookupTimeout)
+		memberIDs, err := h.groupService.GetGroupMemberIDs(callCtx, groupID)
+		cancel()
+		if err == nil {
+			return memberIDs, nil
+		}
+		logServiceFallback("group", err)
+	}
+
+	members, err := h.db.GetGroupMembers(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	memberIDs := make([]uuid.UUID, len(members))
+	for i, member := range members {
+		memberIDs[i] = member.UserID
+	}
+	return memberIDs, nil
+}
+
+// batchPresence reports which users are online, from the presence service if
+// there is one and it answers, otherwise from Redis
+func (h *Hub) batchPresence(userIDs []uuid.UUID) map[uuid.UUID]bool {
+	if h.presenceService != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceLookupTimeout)
+		presence, err := h.presenceService.GetBatchPresence(ctx, userIDs)
+		cancel()
+		if err == nil {
+			return presence
+		}
+		logServiceFallback("presence", err)
+	}
+	return h.redis.GetBatchPresence(userIDs)
+}
+
+// logServiceFallback logs a failed service call. Calls refused by an open
+// breaker aren't logged: the failures that opened it already were.
+func logServiceFallback(service string, err error) {
+	if errors.Is(err, breaker.ErrOpen) {
+		return
+	}
+	log.Printf("Warning: %s service call failed, using direct access: %v", service, err)
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/models"
+)
+
+// unreadCountWorkers bounds how many unread count queries run at once. The
+// query aggregates over messages and group receipts, so it never runs on
+// the hub loop: one group message can need a badge for every offline member.
+const unreadCountWorkers = 4
+
+// unreadCountQueueSize bounds the jobs waiting for a worker. When it is
+// full, pushes go out without a badge and device syncs are skipped; both
+// catch up with the user's next unread change.
+const unreadCountQueueSize = 4096
+
+// unreadCountJob asks for a user's unread counts: sent to their devices, or
+// added as the badge of a push notification when notification is set
+type unreadCountJob struct {
+	userID       uuid.UUID
+	notification map[string]interface{}
+}
+
+// startUnreadCountWorkers starts the workers serving unreadCountJobs
+func (h *Hub) startUnreadCountWorkers() {
+	for i := 0; i < unreadCountWorkers; i++ {
+		go h.runUnreadCountWorker()
+	}
+}
+
+func (h *Hub) runUnreadCountWorker() {
+	for {
+		select {
+		case job := <-h.unreadCountJobs:
+			if job.notification != nil {
+				h.publishWithBadge(job.userID, job.notification)
+			} else {
+				h.pushUnreadCounts(job.userID)
+			}
+		case <-h.shutdown:
+			return
+		}
+	}
+}
+
+// syncUnreadCounts pushes the user's current unread counts to all of their
+// devices and publishes a badge update for push delivery
+func (h *Hub) syncUnreadCounts(userID uuid.UUID) {
+	select {
+	case h.unreadCountJobs <- unreadCountJob{userID: userID}:
+	default:
+		log.Printf("Warning: unread count queue full, skipping sync for %s", userID)
+	}
+}
+
+// publishNotificationWithBadge publishes a push notification including the
+// user's total unread count so the OS badge is accurate
+func (h *Hub) publishNotificationWithBadge(userID uuid.UUID, data map[string]interface{}) {
+	select {
+	case h.unreadCountJobs <- unreadCountJob{userID: userID, notification: data}:
+	default:
+		// Better a push without a badge than no push
+		h.redis.PublishNotification(userID, data)
+	}
+}
+
+func (h *Hub) pushUnreadCounts(userID uuid.UUID) {
+	counts, err := h.db.GetUnreadCounts(userID)
+	if err != nil {
+		log.Printf("Warning: failed to get unread counts for %s: %v", userID, err)
+		return
+	}
+
+	h.sendToUserAllDevices(userID, &models.WebSocketMessage{
+		Type:      models.MessageTypeUnreadCount,
+		Timestamp: time.Now().UTC(),
+		Payload:   mustMarshal(counts),
+	}, uuid.Nil)
+
+	h.redis.PublishNotification(userID, map[string]interface{}{
+		"type":  "badge_update",
+		"badge": counts.Total,
+	})
+}
+
+func (h *Hub) publishWithBadge(userID uuid.UUID, data map[string]interface{}) {
+	if counts, err := h.db.GetUnreadCounts(userID); err == nil {
+		data["badge"] = counts.Total
+	} else {
+		log.Printf("Warning: failed to get unread counts for %s: %v", userID, err)
+	}
+	h.redis.PublishNotification(userID, data)
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/jaydenbeard/messaging-app/internal/appversion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppVersionCompare(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"2.14.0", "2.14.0", 0},
+		{"2.1", "2.1.0", 0},
+		{"v2.1.0", "2.1.0", 0},
+		{"2.9.0", "2.10.0", -1},
+		{"3.0", "2.99.99", 1},
+		{"2.14.0-beta.1", "2.14.0", 0},
+		{"2.14.0+build.7", "2.13.9", 1},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, appversion.Compare(c.a, c.b), "%s vs %s", c.a, c.b)
+	}
+}
+
+func TestAppVersionValid(t *testing.T) {
+	assert.True(t, appversion.Valid("1"))
+	assert.True(t, appversion.Valid("2.14.0-rc1"))
+	assert.False(t, appversion.Valid(""))
+	assert.False(t, appversion.Valid("latest"))
+}
+
+func TestAppVersionNormalize(t *testing.T) {
+	cases := map[string]string{
+		"2.14.0":         "2.14.0",
+		" v2.14 ":        "2.14.0",
+		"3":              "3.0.0",
+		"2.14-RC1":       "2.14.0-rc1",
+		"2.14.0+build.7": "2.14.0",
+	}
+	for in, want := range cases {
+		got, ok := appversion.Normalize(in)
+		assert.True(t, ok, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "latest", "2.14.0.1", "12345.0", "2.14.0-<script>"} {
+		_, ok := appversion.Normalize(in)
+		assert.False(t, ok, in)
+	}
+
+	assert.Equal(t, "ios", appversion.NormalizePlatform(" iOS"))
+	assert.Equal(t, "", appversion.NormalizePlatform("symbian"))
+}
+
+func TestAppVersionPolicyCheck(t *testing.T) {
+	policy := appversion.Policy{
+		"ios":     {Minimum: "2.10.0", Recommended: "2.14.0"},
+		"android": {Minimum: "2.9.0"},
+	}
+
+	status, rule := policy.Check("ios", "2.9.5")
+	assert.Equal(t, appversion.UpgradeRequired, status)
+	assert.Equal(t, "2.10.0", rule.Minimum)
+
+	status, rule = policy.Check("ios", "2.12.0")
+	assert.Equal(t, appversion.UpgradeRecommended, status)
+	assert.Equal(t, "2.14.0", rule.Recommended)
+
+	status, _ = policy.Check("ios", "2.14.0-beta.1")
+	assert.Equal(t, appversion.Supported, status)
+
+	status, _ = policy.Check("android", "2.9.0")
+	assert.Equal(t, appversion.Supported, status)
+
+	// Unlisted platforms and unreported versions aren't judged
+	status, _ = policy.Check("web", "0.1.0")
+	assert.Equal(t, appversion.Supported, status)
+	status, _ = policy.Check("ios", "")
+	assert.Equal(t, appversion.Supported, status)
+
+	// Unless the platform requires one
+	policy["android"] = appversion.PlatformPolicy{Minimum: "2.9.0", RequireVersion: true}
+	status, rule = policy.Check("android", "")
+	assert.Equal(t, appversion.UpgradeRequired, status)
+	assert.Equal(t, "2.9.0", rule.Minimum)
+	status, _ = policy.Check("android", "latest")
+	assert.Equal(t, appversion.UpgradeRequired, status)
+	status, _ = policy.Check("android", "2.9.1")
+	assert.Equal(t, appversion.Supported, status)
+}
+
+func TestAppVersionPolicyValidate(t *testing.T) {
+	assert.NoError(t, appversion.Policy{"ios": {Minimum: "2.10.0", Recommended: "2.14.0"}}.Validate())
+	assert.NoError(t, appversion.Policy{}.Validate())
+	assert.Error(t, appversion.Policy{"symbian": {Minimum: "1.0.0"}}.Validate())
+	assert.Error(t, appversion.Policy{"IOS": {Minimum: "1.0.0"}}.Validate())
+	assert.Error(t, appversion.Policy{"web": {Minimum: "latest"}}.Validate())
+	assert.Error(t, appversion.Policy{"ios": {Minimum: "2.14.0", Recommended: "2.10.0"}}.Validate())
+	assert.NoError(t, appversion.Policy{"ios": {Minimum: "2.10.0", RequireVersion: true}}.Validate())
+	assert.Error(t, appversion.Policy{"ios": {Recommended: "2.10.0", RequireVersion: true}}.Validate())
+}
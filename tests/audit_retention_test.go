@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/jaydenbeard/messaging-app/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRetentionDefaults(t *testing.T) {
+	t.Setenv("AUDIT_RETENTION_DAYS", "")
+	t.Setenv("AUDIT_RETENTION_HIGH_DAYS", "")
+	t.Setenv("AUDIT_RETENTION_CRITICAL_DAYS", "")
+
+	r := config.AuditRetentionFromEnv()
+	assert.Equal(t, 0, r.DefaultDays, "audit events are kept forever unless configured")
+	assert.Equal(t, config.MinAuditRetentionHighDays, r.HighDays)
+	assert.Equal(t, config.MinAuditRetentionCriticalDays, r.CriticalDays)
+}
+
+func TestAuditRetentionComplianceFloors(t *testing.T) {
+	t.Setenv("AUDIT_RETENTION_DAYS", "90")
+	t.Setenv("AUDIT_RETENTION_HIGH_DAYS", "30")
+	t.Setenv("AUDIT_RETENTION_CRITICAL_DAYS", "400")
+
+	r := config.AuditRetentionFromEnv()
+	assert.Equal(t, 90, r.DefaultDays)
+	assert.Equal(t, config.MinAuditRetentionHighDays, r.HighDays, "high events can't go below the floor")
+	assert.Equal(t, config.MinAuditRetentionCriticalDays, r.CriticalDays, "critical events can't go below the floor")
+
+	t.Setenv("AUDIT_RETENTION_CRITICAL_DAYS", "3650")
+	assert.Equal(t, 3650, config.AuditRetentionFromEnv().CriticalDays)
+}
@@ -0,0 +1,50 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jaydenbeard/messaging-app/internal/breaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errDown := errors.New("down")
+	fail := func() error { return errDown }
+	succeed := func() error { return nil }
+
+	t.Run("Opens after consecutive failures", func(t *testing.T) {
+		b := breaker.New("test", 3, time.Minute)
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, b.Do(fail), errDown)
+		}
+		assert.Equal(t, breaker.Open, b.State())
+		assert.ErrorIs(t, b.Do(succeed), breaker.ErrOpen)
+	})
+
+	t.Run("Success resets the failure count", func(t *testing.T) {
+		b := breaker.New("test", 2, time.Minute)
+		assert.Error(t, b.Do(fail))
+		require.NoError(t, b.Do(succeed))
+		assert.Error(t, b.Do(fail))
+		assert.Equal(t, breaker.Closed, b.State())
+	})
+
+	t.Run("Trial call after cooldown closes or reopens", func(t *testing.T) {
+		b := breaker.New("test", 1, 10*time.Millisecond)
+		assert.Error(t, b.Do(fail))
+		time.Sleep(20 * time.Millisecond)
+
+		require.NoError(t, b.Allow())
+		assert.Equal(t, breaker.HalfOpen, b.State())
+		assert.ErrorIs(t, b.Allow(), breaker.ErrOpen, "only one trial call at a time")
+		b.Failure()
+		assert.Equal(t, breaker.Open, b.State())
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, b.Do(succeed))
+		assert.Equal(t, breaker.Closed, b.State())
+	})
+}
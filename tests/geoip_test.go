@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jaydenbeard/messaging-app/internal/geoip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdb encoding helpers for building a tiny MaxMind DB by hand
+
+func mmdbString(s string) []byte {
+	return append([]byte{byte(2<<5 | len(s))}, s...)
+}
+
+func mmdbMap(pairs int) []byte {
+	return []byte{byte(7<<5 | pairs)}
+}
+
+func mmdbArray(items int) []byte {
+	return []byte{byte(items), 11 - 7} // extended type
+}
+
+func mmdbUint16(v uint16) []byte {
+	return []byte{5<<5 | 2, byte(v >> 8), byte(v)}
+}
+
+func mmdbUint32(v uint32) []byte {
+	return []byte{6<<5 | 4, byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// buildTestMMDB returns an IPv4 database with one node: 0.0.0.0/1 maps to a
+// Berlin record and 128.0.0.0/1 has no data
+func buildTestMMDB() []byte {
+	const nodeCount = 1
+
+	// "names" is stored once and referenced by a pointer the second time
+	names := mmdbString("names")
+	data := concat(
+		mmdbMap(3),
+		mmdbString("country"), mmdbMap(1), mmdbString("iso_code"), mmdbString("DE"),
+		mmdbString("city"), mmdbMap(1),
+	)
+	namesOffset := len(data)
+	data = concat(data,
+		names, mmdbMap(1), mmdbString("en"), mmdbString("Berlin"),
+		mmdbString("subdivisions"), mmdbArray(1), mmdbMap(1),
+		[]byte{1 << 5, byte(namesOffset)}, // pointer to "names"
+		mmdbMap(1), mmdbString("en"), mmdbString("Land Berlin"),
+	)
+
+	// 24-bit records: left -> data offset 0, right -> "no data"
+	record := nodeCount + 16
+	tree := []byte{
+		byte(record >> 16), byte(record >> 8), byte(record),
+		0, 0, nodeCount,
+	}
+
+	metadata := concat(
+		mmdbMap(3),
+		mmdbString("node_count"), mmdbUint32(nodeCount),
+		mmdbString("record_size"), mmdbUint16(24),
+		mmdbString("ip_version"), mmdbUint16(4),
+	)
+
+	return concat(tree, make([]byte, 16), data, []byte("\xAB\xCD\xEFMaxMind.com"), metadata)
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	reader, err := geoip.FromBytes(buildTestMMDB())
+	require.NoError(t, err)
+
+	t.Run("Address with a record", func(t *testing.T) {
+		loc, found, err := reader.Lookup(net.ParseIP("1.2.3.4"))
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "DE", loc.Country)
+		assert.Equal(t, "Land Berlin", loc.Region)
+		assert.Equal(t, "Berlin", loc.City)
+	})
+
+	t.Run("Address without a record", func(t *testing.T) {
+		_, found, err := reader.Lookup(net.ParseIP("200.1.1.1"))
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Locate tolerates bad input", func(t *testing.T) {
+		country, region, city := reader.Locate("not-an-ip")
+		assert.Empty(t, country)
+		assert.Empty(t, region)
+		assert.Empty(t, city)
+
+		country, _, _ = reader.Locate("10.0.0.1")
+		assert.Equal(t, "DE", country)
+	})
+
+	t.Run("IPv6 address in an IPv4 database", func(t *testing.T) {
+		_, _, err := reader.Lookup(net.ParseIP("2001:db8::1"))
+		assert.ErrorIs(t, err, geoip.ErrInvalidIP)
+	})
+}
+
+func TestGeoIPRejectsInvalidDatabase(t *testing.T) {
+	_, err := geoip.FromBytes([]byte("not a database"))
+	assert.ErrorIs(t, err, geoip.ErrInvalidDatabase)
+}
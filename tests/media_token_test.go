@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/pubsub"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaDownloadTokens(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client, err := pubsub.NewRedisClient(mr.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	mediaID := uuid.New()
+	const first, second = "203.0.113.7", "198.51.100.9"
+
+	t.Run("One-time token serves only its first redeemer", func(t *testing.T) {
+		require.NoError(t, client.CreateMediaDownloadToken("once", mediaID, true, time.Hour))
+
+		got, err := client.RedeemMediaDownloadToken("once", first)
+		require.NoError(t, err)
+		assert.Equal(t, mediaID, got)
+
+		// Range requests of the same download
+		got, err = client.RedeemMediaDownloadToken("once", first)
+		require.NoError(t, err)
+		assert.Equal(t, mediaID, got)
+
+		_, err = client.RedeemMediaDownloadToken("once", second)
+		assert.Equal(t, redis.Nil, err, "a different client must be refused")
+	})
+
+	t.Run("One-time token expires after the grace window", func(t *testing.T) {
+		require.NoError(t, client.CreateMediaDownloadToken("grace", mediaID, true, time.Hour))
+		_, err := client.RedeemMediaDownloadToken("grace", first)
+		require.NoError(t, err)
+
+		mr.FastForward(3 * time.Minute)
+		_, err = client.RedeemMediaDownloadToken("grace", first)
+		assert.Equal(t, redis.Nil, err)
+	})
+
+	t.Run("Reusable token serves anyone until it expires", func(t *testing.T) {
+		require.NoError(t, client.CreateMediaDownloadToken("reusable", mediaID, false, time.Hour))
+		for _, redeemer := range []string{first, second, first} {
+			got, err := client.RedeemMediaDownloadToken("reusable", redeemer)
+			require.NoError(t, err)
+			assert.Equal(t, mediaID, got)
+		}
+
+		mr.FastForward(2 * time.Hour)
+		_, err := client.RedeemMediaDownloadToken("reusable", first)
+		assert.Equal(t, redis.Nil, err)
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		_, err := client.RedeemMediaDownloadToken("missing", first)
+		assert.Equal(t, redis.Nil, err)
+	})
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateMediaUploadCap(t *testing.T) {
+	database, raw := openTestDB(t)
+	userID := createTestUser(t, raw)
+	const maxOpen, attempts = 5, 20
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  int
+		rejected int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := database.CreateMediaUpload(context.Background(), &db.MediaUpload{
+				UploadID:        uuid.New(),
+				MediaID:         uuid.New(),
+				UserID:          userID,
+				StorageUploadID: "test",
+				ContentType:     "video/mp4",
+				TotalSize:       1,
+				PartSize:        1,
+				PartCount:       1,
+			}, maxOpen)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				created++
+			case db.ErrTooManyUploads:
+				rejected++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, maxOpen, created, "concurrent starts must not overshoot the cap")
+	assert.Equal(t, attempts-maxOpen, rejected)
+}
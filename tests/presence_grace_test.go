@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOfflineTimers(t *testing.T) {
+	const grace = 20 * time.Millisecond
+
+	newTimers := func(grace time.Duration) (*websocket.OfflineTimers, chan uuid.UUID) {
+		fired := make(chan uuid.UUID, 4)
+		return websocket.NewOfflineTimers(grace, func(userID uuid.UUID) { fired <- userID }), fired
+	}
+
+	t.Run("Fires after the grace period", func(t *testing.T) {
+		timers, fired := newTimers(grace)
+		userID := uuid.New()
+		timers.Schedule(userID)
+
+		select {
+		case got := <-fired:
+			t.Fatalf("fired early for %s", got)
+		case <-time.After(grace / 2):
+		}
+		select {
+		case got := <-fired:
+			assert.Equal(t, userID, got)
+		case <-time.After(time.Second):
+			t.Fatal("did not fire")
+		}
+		assert.False(t, timers.Cancel(userID), "nothing left to cancel after firing")
+	})
+
+	t.Run("Cancel before the grace period stops it", func(t *testing.T) {
+		timers, fired := newTimers(grace)
+		userID := uuid.New()
+		timers.Schedule(userID)
+		assert.True(t, timers.Cancel(userID))
+		assert.False(t, timers.Cancel(userID))
+
+		select {
+		case got := <-fired:
+			t.Fatalf("fired after cancel for %s", got)
+		case <-time.After(3 * grace):
+		}
+	})
+
+	t.Run("Rescheduling restarts the grace period and fires once", func(t *testing.T) {
+		timers, fired := newTimers(grace)
+		userID := uuid.New()
+		timers.Schedule(userID)
+		time.Sleep(grace / 2)
+		timers.Schedule(userID)
+
+		select {
+		case <-fired:
+		case <-time.After(time.Second):
+			t.Fatal("did not fire")
+		}
+		select {
+		case <-fired:
+			t.Fatal("fired twice")
+		case <-time.After(3 * grace):
+		}
+	})
+
+	t.Run("Zero grace fires at once", func(t *testing.T) {
+		timers, fired := newTimers(0)
+		userID := uuid.New()
+		timers.Schedule(userID)
+
+		select {
+		case got := <-fired:
+			assert.Equal(t, userID, got)
+		case <-time.After(time.Second):
+			t.Fatal("did not fire")
+		}
+		assert.False(t, timers.Cancel(userID))
+	})
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jaydenbeard/messaging-app/internal/security"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRealIPTrustedProxies(t *testing.T) {
+	newRequest := func(remoteAddr, xff, xri string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		if xff != "" {
+			r.Header.Set("X-Forwarded-For", xff)
+		}
+		if xri != "" {
+			r.Header.Set("X-Real-IP", xri)
+		}
+		return r
+	}
+
+	t.Run("Untrusted peer cannot spoof headers", func(t *testing.T) {
+		r := newRequest("203.0.113.9:4321", "198.51.100.1", "198.51.100.2")
+		assert.Equal(t, "203.0.113.9", security.GetRealIP(r))
+	})
+
+	t.Run("Trusted proxy forwards client IP", func(t *testing.T) {
+		r := newRequest("10.0.0.5:4321", "198.51.100.1", "")
+		assert.Equal(t, "198.51.100.1", security.GetRealIP(r))
+	})
+
+	t.Run("Spoofed leftmost hop is skipped", func(t *testing.T) {
+		r := newRequest("10.0.0.5:4321", "1.1.1.1, 198.51.100.1, 10.0.0.7", "")
+		assert.Equal(t, "198.51.100.1", security.GetRealIP(r))
+	})
+
+	t.Run("X-Real-IP from trusted proxy", func(t *testing.T) {
+		r := newRequest("127.0.0.1:4321", "", "198.51.100.3")
+		assert.Equal(t, "198.51.100.3", security.GetRealIP(r))
+	})
+
+	t.Run("Custom trusted set", func(t *testing.T) {
+		require.NoError(t, security.SetTrustedProxies([]string{"203.0.113.0/24"}))
+		defer func() {
+			require.NoError(t, security.SetTrustedProxies(security.DefaultTrustedProxyCIDRs))
+		}()
+
+		assert.Equal(t, "198.51.100.1", security.GetRealIP(newRequest("203.0.113.9:1", "198.51.100.1", "")))
+		assert.Equal(t, "10.0.0.5", security.GetRealIP(newRequest("10.0.0.5:1", "198.51.100.1", "")))
+		assert.Error(t, security.SetTrustedProxies([]string{"not-a-cidr"}))
+	})
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jaydenbeard/messaging-app/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveMessageIdempotency(t *testing.T) {
+	database, raw := openTestDB(t)
+	ctx := context.Background()
+	senderID, otherID, recipientID := createTestUser(t, raw), createTestUser(t, raw), createTestUser(t, raw)
+
+	newMessage := func(messageID, senderID uuid.UUID, timestamp time.Time) *db.Message {
+		return &db.Message{
+			MessageID:   messageID,
+			SenderID:    senderID,
+			ReceiverID:  &recipientID,
+			Ciphertext:  []byte("ciphertext"),
+			MessageType: "text",
+			Timestamp:   timestamp,
+			Status:      "sent",
+		}
+	}
+
+	messageID := uuid.New()
+	first := time.Now().UTC().Add(-time.Minute).Truncate(time.Microsecond)
+	require.NoError(t, database.SaveMessage(ctx, newMessage(messageID, senderID, first)))
+
+	retry := newMessage(messageID, senderID, time.Now().UTC())
+	assert.ErrorIs(t, database.SaveMessage(ctx, retry), db.ErrDuplicateMessage)
+	assert.True(t, first.Equal(retry.Timestamp), "a retry gets the stored timestamp")
+
+	taken := newMessage(messageID, otherID, time.Now().UTC())
+	assert.ErrorIs(t, database.SaveMessage(ctx, taken), db.ErrMessageIDTaken)
+
+	stored, err := database.GetMessage(messageID)
+	require.NoError(t, err)
+	assert.Equal(t, senderID, stored.SenderID)
+
+	var count int
+	require.NoError(t, raw.QueryRow(`SELECT COUNT(*) FROM messages WHERE message_id = $1`, messageID).Scan(&count))
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnreadCounts(t *testing.T) {
+	database, raw := openTestDB(t)
+
+	reader := createTestUser(t, raw)
+	friend := createTestUser(t, raw)
+	other := createTestUser(t, raw)
+
+	insert := func(sender uuid.UUID, receiver, group *uuid.UUID, status string, deleted bool, at time.Time) uuid.UUID {
+		messageID := uuid.New()
+		_, err := raw.Exec(`
+			INSERT INTO messages (message_id, sender_id, receiver_id, group_id, ciphertext, message_type, status, is_deleted, timestamp)
+			VALUES ($1, $2, $3, $4, 'x', 'text', $5, $6, $7)`,
+			messageID, sender, receiver, group, status, deleted, at)
+		require.NoError(t, err)
+		return messageID
+	}
+	now := time.Now()
+
+	// Direct messages: unread until read; deleted and outgoing ones don't count
+	insert(friend, &reader, nil, "sent", false, now)
+	insert(friend, &reader, nil, "delivered", false, now)
+	insert(friend, &reader, nil, "read", false, now)
+	insert(friend, &reader, nil, "sent", true, now)
+	insert(reader, &friend, nil, "sent", false, now)
+
+	// Group messages: unread until the reader has a receipt row; messages
+	// from before they joined and their own don't count
+	groupID := uuid.New()
+	_, err := raw.Exec(`INSERT INTO groups (group_id, name, created_by) VALUES ($1, 'test', $2)`, groupID, other)
+	require.NoError(t, err)
+	joinedAt := now.Add(-time.Hour)
+	for _, member := range []uuid.UUID{reader, other} {
+		_, err := raw.Exec(`
+			INSERT INTO group_members (group_id, user_id, encrypted_group_key, joined_at)
+			VALUES ($1, $2, 'key', $3)`, groupID, member, joinedAt)
+		require.NoError(t, err)
+	}
+	insert(other, nil, &groupID, "sent", false, joinedAt.Add(-time.Minute))
+	insert(other, nil, &groupID, "sent", false, now)
+	read := insert(other, nil, &groupID, "sent", false, now)
+	insert(reader, nil, &groupID, "sent", false, now)
+	require.NoError(t, database.MarkGroupMessageRead(read, reader, now))
+
+	counts, err := database.GetUnreadCounts(reader)
+	require.NoError(t, err)
+	assert.Equal(t, 3, counts.Total)
+	assert.Equal(t, map[uuid.UUID]int{friend: 2, groupID: 1}, counts.Conversations)
+
+	// Nothing incoming: no conversations at all
+	counts, err = database.GetUnreadCounts(other)
+	require.NoError(t, err)
+	assert.Zero(t, counts.Total)
+	assert.Empty(t, counts.Conversations)
+
+}
@@ -211,31 +211,13 @@ function ProfileSection({ onBack }: { onBack: () => void }) {
     try {
       const token = useAuthStore.getState().token;
 
-      // 1. Get upload URL from backend
-      const uploadUrlResponse = await fetch('/api/v1/media/upload', {
+      // 1. Upload the image; the server checks it, stores it and sets it as
+      // the profile avatar
+      const uploadResponse = await fetch('/api/v1/users/me/avatar', {
         method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-          Authorization: `Bearer ${token}`,
-        },
-        body: JSON.stringify({
-          file_name: file.name,
-          content_type: file.type,
-          file_size: file.size,
-        }),
-      });
-
-      if (!uploadUrlResponse.ok) {
-        throw new Error('Failed to get upload URL');
-      }
-
-      const { uploadUrl, downloadUrl } = await uploadUrlResponse.json();
-
-      // 2. Upload file to MinIO
-      const uploadResponse = await fetch(uploadUrl, {
-        method: 'PUT',
         headers: {
           'Content-Type': file.type,
+          Authorization: `Bearer ${token}`,
         },
         body: file,
       });
@@ -244,22 +226,10 @@ function ProfileSection({ onBack }: { onBack: () => void }) {
         throw new Error('Failed to upload image');
       }
 
-      // 3. Update user profile with new avatar URL
-      const updateResponse = await fetch('/api/v1/users/me', {
-        method: 'PATCH',
-        headers: {
-          'Content-Type': 'application/json',
-          Authorization: `Bearer ${token}`,
-        },
-        body: JSON.stringify({ avatar_url: downloadUrl }),
-      });
-
-      if (!updateResponse.ok) {
-        throw new Error('Failed to update profile');
-      }
+      const { avatar_url: avatarUrl } = await uploadResponse.json();
 
-      // 4. Update local state
-      updateUser({ avatar: downloadUrl });
+      // 2. Update local state
+      updateUser({ avatar: avatarUrl });
       setAvatarPreview(null); // Clear preview, use actual URL now
 
     } catch (error) {
@@ -771,13 +741,22 @@ function BlockedUsersSection({ onBack }: { onBack: () => void }) {
     const fetchBlockedUsers = async () => {
       try {
         const token = useAuthStore.getState().token;
-        const response = await fetch('/api/v1/users/blocked', {
-          headers: { Authorization: `Bearer ${token}` },
-        });
-        if (response.ok) {
-          const users = await response.json();
-          setBlockedUsers(users || []);
-        }
+        // The list is paged; follow X-Next-Cursor until the last page
+        const users: BlockedUser[] = [];
+        let cursor: string | null = null;
+        do {
+          const url: string = cursor
+            ? `/api/v1/users/blocked?cursor=${encodeURIComponent(cursor)}`
+            : '/api/v1/users/blocked';
+          const response = await fetch(url, {
+            headers: { Authorization: `Bearer ${token}` },
+          });
+          if (!response.ok) break;
+          const page: BlockedUser[] | null = await response.json();
+          users.push(...(page || []));
+          cursor = response.headers.get('X-Next-Cursor');
+        } while (cursor);
+        setBlockedUsers(users);
       } catch (error) {
         console.error('Failed to fetch blocked users:', error);
       } finally {
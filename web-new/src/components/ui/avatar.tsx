@@ -1,6 +1,51 @@
 import * as React from 'react';
 import * as AvatarPrimitive from '@radix-ui/react-avatar';
 import { cn } from '@/lib/utils';
+import { useAuthStore } from '@/core/store/authStore';
+
+// Uploaded avatars are served behind Bearer auth, which an <img> can't send,
+// so they are fetched and shown as object URLs. Each avatar URL's content
+// never changes, so one fetch per URL is enough for the session.
+const AVATAR_PATH_PREFIX = '/api/v1/avatars/';
+const avatarObjectURLs = new Map<string, Promise<string | undefined>>();
+
+function fetchAvatar(src: string): Promise<string | undefined> {
+  let pending = avatarObjectURLs.get(src);
+  if (!pending) {
+    const token = useAuthStore.getState().token;
+    pending = fetch(src, { headers: { Authorization: `Bearer ${token}` } })
+      .then(async (response) => {
+        if (!response.ok) throw new Error(`avatar fetch failed: ${response.status}`);
+        return URL.createObjectURL(await response.blob());
+      })
+      .catch(() => {
+        // Let a later render retry, e.g. after signing in again
+        avatarObjectURLs.delete(src);
+        return undefined;
+      });
+    avatarObjectURLs.set(src, pending);
+  }
+  return pending;
+}
+
+function useAvatarSrc(src: string | undefined): string | undefined {
+  const isProtected = !!src && src.startsWith(AVATAR_PATH_PREFIX);
+  const [resolved, setResolved] = React.useState<string | undefined>(undefined);
+
+  React.useEffect(() => {
+    if (!isProtected || !src) return;
+    let cancelled = false;
+    setResolved(undefined);
+    fetchAvatar(src).then((url) => {
+      if (!cancelled) setResolved(url);
+    });
+    return () => {
+      cancelled = true;
+    };
+  }, [src, isProtected]);
+
+  return isProtected ? resolved : src;
+}
 
 const Avatar = React.forwardRef<
   React.ElementRef<typeof AvatarPrimitive.Root>,
@@ -20,13 +65,17 @@ Avatar.displayName = AvatarPrimitive.Root.displayName;
 const AvatarImage = React.forwardRef<
   React.ElementRef<typeof AvatarPrimitive.Image>,
   React.ComponentPropsWithoutRef<typeof AvatarPrimitive.Image>
->(({ className, ...props }, ref) => (
-  <AvatarPrimitive.Image
-    ref={ref}
-    className={cn('aspect-square h-full w-full', className)}
-    {...props}
-  />
-));
+>(({ className, src, ...props }, ref) => {
+  const resolvedSrc = useAvatarSrc(src);
+  return (
+    <AvatarPrimitive.Image
+      ref={ref}
+      className={cn('aspect-square h-full w-full', className)}
+      src={resolvedSrc}
+      {...props}
+    />
+  );
+});
 AvatarImage.displayName = AvatarPrimitive.Image.displayName;
 
 const AvatarFallback = React.forwardRef<
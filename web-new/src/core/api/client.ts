@@ -97,7 +97,7 @@ async function request<T>(endpoint: string, options: RequestOptions = {}): Promi
 // Auth endpoints
 export const auth = {
   sendCode: (phoneNumber: string) =>
-    request<{ message: string; code?: string }>('/auth/request-code', {
+    request<{ message: string; code?: string; next_allowed_at?: string | null }>('/auth/request-code', {
       method: 'POST',
       body: { phone_number: phoneNumber },
       skipAuth: true,
@@ -17,6 +17,24 @@ import type {
 
 type MessageHandler<T = unknown> = (payload: T, message: WSMessage<T>) => void;
 
+/**
+ * Server close codes (see internal/websocket/close.go)
+ */
+const CLOSE_NO_RECONNECT = new Set([
+  4001, // invalid signature - sign in again
+  4003, // signed out - sign in again
+  4009, // replaced by a newer connection for this device
+]);
+const CLOSE_BACK_OFF = new Set([
+  1013, // server at capacity
+  4029, // too many connections for this user
+]);
+
+interface RebalanceHintPayload {
+  reason: string;
+  reconnect_after_ms: number;
+}
+
 interface WebSocketConfig {
   url: string;
   token: string;
@@ -59,9 +77,17 @@ export class WebSocketService {
         this.handleMessage(event.data);
       };
 
-      this.ws.onclose = () => {
+      this.ws.onclose = (event) => {
         this.stopHeartbeat();
         this.config.onDisconnect?.();
+        if (CLOSE_NO_RECONNECT.has(event.code)) {
+          console.warn(`WebSocket closed by server: ${event.reason || event.code}`);
+          return;
+        }
+        if (CLOSE_BACK_OFF.has(event.code)) {
+          // Skip the fast retries; start from a longer delay
+          this.reconnectAttempts = Math.max(this.reconnectAttempts, 3);
+        }
         this.attemptReconnect();
       };
 
@@ -229,6 +255,14 @@ export class WebSocketService {
     }
   }
 
+  /**
+   * Acknowledge receipt of a message without telling the sender it was delivered.
+   * Stops the server redelivering messages we deliberately don't send a receipt for.
+   */
+  async sendSilentAck(messageId: string): Promise<void> {
+    await this.send('delivery_ack', { message_id: messageId, silent: true }, messageId);
+  }
+
   /**
    * Check if connected
    */
@@ -264,6 +298,11 @@ export class WebSocketService {
         return;
       }
 
+      if (message.type === 'rebalance_hint') {
+        this.handleRebalanceHint(message.payload as RebalanceHintPayload);
+        return;
+      }
+
       // Dispatch to handlers
       const handlers = this.handlers.get(message.type);
       if (handlers) {
@@ -282,6 +321,19 @@ export class WebSocketService {
     }
   }
 
+  /**
+   * The server is busier than the rest of the cluster: reconnect after the
+   * suggested delay so the load balancer can route us to another server
+   */
+  private handleRebalanceHint(payload: RebalanceHintPayload): void {
+    const delay = Math.min(Math.max(payload?.reconnect_after_ms ?? 0, 0), 30000);
+    setTimeout(() => {
+      if (this.ws?.readyState === WebSocket.OPEN) {
+        this.ws.close(1000, 'rebalance');
+      }
+    }, delay);
+  }
+
   private startHeartbeat(): void {
     this.heartbeatInterval = window.setInterval(() => {
       if (this.ws?.readyState === WebSocket.OPEN) {
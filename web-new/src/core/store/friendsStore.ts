@@ -58,16 +58,26 @@ export const useFriendsStore = create<FriendsStore>((set, get) => ({
         if (!token) return; // Don't fetch if not authenticated
 
         try {
-            const response = await fetch('/api/v1/friends', {
-                headers: getAuthHeaders(),
-            });
-            if (!response.ok) {
-                // Gracefully handle API errors (e.g., table doesn't exist yet)
-                console.warn('Friends API not available');
-                return;
-            }
-            const friends = await response.json();
-            set({ friends: friends || [] });
+            // The list is paged; follow X-Next-Cursor until the last page
+            const friends: Friend[] = [];
+            let cursor: string | null = null;
+            do {
+                const url: string = cursor
+                    ? `/api/v1/friends?cursor=${encodeURIComponent(cursor)}`
+                    : '/api/v1/friends';
+                const response = await fetch(url, {
+                    headers: getAuthHeaders(),
+                });
+                if (!response.ok) {
+                    // Gracefully handle API errors (e.g., table doesn't exist yet)
+                    console.warn('Friends API not available');
+                    return;
+                }
+                const page: Friend[] | null = await response.json();
+                friends.push(...(page || []));
+                cursor = response.headers.get('X-Next-Cursor');
+            } while (cursor);
+            set({ friends });
         } catch (error) {
             console.error('Failed to fetch friends:', error);
             // Don't set error state to avoid breaking the UI
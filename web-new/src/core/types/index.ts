@@ -96,7 +96,10 @@ export type WSMessageType =
   | 'delivery_ack'  // For marking messages as delivered (separate from read_receipt)
   | 'user_online'
   | 'user_offline'
-  | 'user_blocked'
+  | 'presence_status'
+  | 'contacts_changed'
+  | 'friend_request'
+  | 'friend_request_accepted'
   | 'call_offer'
   | 'call_answer'
   | 'call_reject'   // Reject incoming call
@@ -108,6 +111,7 @@ export type WSMessageType =
   | 'sync_ack'
   | 'identity_key_changed'
   | 'media_key'
+  | 'rebalance_hint' // Server overloaded; reconnect to be placed elsewhere
   | 'heartbeat';
 
 export interface WSMessage<T = unknown> {
@@ -149,6 +153,7 @@ export interface PresencePayload {
 export interface StatusUpdatePayload {
   messageId: string;
   status: MessageStatus;
+  server_timestamp?: string; // On sent_ack: canonical send time
 }
 
 // Call types
@@ -10,6 +10,7 @@ import { useAuthStore } from '@/core/store/authStore';
 import { useChatStore } from '@/core/store/chatStore';
 import { useCallStore } from '@/core/store/callStore';
 import { useSettingsStore } from '@/core/store/settingsStore';
+import { useFriendsStore } from '@/core/store/friendsStore';
 import { WebSocketService } from '@/core/services/websocket';
 import { signalProtocol } from '@/core/crypto/signal';
 import { webrtcService, type CallSignal } from '@/core/services/webrtc';
@@ -129,10 +130,18 @@ export function useWebSocket() {
         const conversation = useChatStore.getState().conversations[senderId];
         if (conversation?.status === 'accepted') {
           wsRef.current?.sendReadReceipt(message.id, message.conversationId, 'delivered');
+        } else {
+          // Confirm receipt to the server only, so it stops redelivering
+          wsRef.current?.sendSilentAck(message.id);
         }
       } catch (error) {
         console.error('Failed to decrypt message:', error);
 
+        // Redelivering won't make it decryptable; confirm receipt without a receipt to the sender
+        if (wsMessage.messageId) {
+          wsRef.current?.sendSilentAck(wsMessage.messageId);
+        }
+
         // Check if this is a key mismatch error
         const errorMsg = String(error);
         if (errorMsg.includes('BAD_MESSAGE_KEY_ID') || errorMsg.includes('BAD_MESSAGE_FORMAT')) {
@@ -211,12 +220,10 @@ export function useWebSocket() {
   );
 
   // Handle being blocked by another user
-  const handleUserBlocked = useCallback(
-    (payload: { blocker_id: string }) => {
-      // When someone blocks us, remove the conversation from our view
-      if (payload.blocker_id) {
-        useChatStore.getState().removeConversation(payload.blocker_id);
-      }
+  const handleContactsChanged = useCallback(
+    () => {
+      // A friendship or friend request changed on the server - refetch them
+      useFriendsStore.getState().refreshAll();
     },
     [] // No dependencies - uses getState() for stability
   );
@@ -231,6 +238,14 @@ export function useWebSocket() {
       if (messageId && status) {
         useChatStore.getState().updateMessageStatus(messageId, status as 'delivered' | 'read');
       }
+
+      // The server's timestamp is canonical; adopt it for display and ordering
+      if (messageId && payload.server_timestamp) {
+        const serverTime = Date.parse(payload.server_timestamp);
+        if (!Number.isNaN(serverTime)) {
+          useChatStore.getState().updateMessage(messageId, { timestamp: serverTime });
+        }
+      }
     },
     [] // No dependencies - uses getState() for stability
   );
@@ -474,7 +489,9 @@ export function useWebSocket() {
     ws.on('read_receipt', handleReadReceipt);
     ws.on('user_online', (payload: PresencePayload) => handlePresence({ ...payload, isOnline: true }));
     ws.on('user_offline', (payload: PresencePayload) => handlePresence({ ...payload, isOnline: false }));
-    ws.on('user_blocked', handleUserBlocked);
+    ws.on('contacts_changed', handleContactsChanged);
+    ws.on('friend_request', handleContactsChanged);
+    ws.on('friend_request_accepted', handleContactsChanged);
     ws.on('status_update', handleStatusUpdate);
     ws.on('sent_ack', handleStatusUpdate);
 
@@ -508,7 +525,7 @@ export function useWebSocket() {
     handleTyping,
     handleReadReceipt,
     handlePresence,
-    handleUserBlocked,
+    handleContactsChanged,
     handleStatusUpdate,
     handleCallOffer,
     handleCallAnswer,